@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/geostore"
 	"github.com/rchapin/go-geocache-api/model"
 	"github.com/rchapin/go-geocache-api/service"
 	log "github.com/rchapin/rlog"
@@ -18,6 +20,15 @@ import (
 
 const apiVersion = "1"
 
+// distanceUnits maps the supported values of the 'units' query arg to the number of meters in one
+// of that unit.
+var distanceUnits = map[string]float64{
+	"m":   1,
+	"km":  1000,
+	"mi":  1609.344,
+	"nmi": 1852,
+}
+
 type RequestPostCache struct {
 	Name string   `json:"name"`
 	Lat  float64  `json:"lat"`
@@ -34,6 +45,14 @@ type RequestPutCache struct {
 type ResponseCache struct {
 	Id uint64 `json:"id"`
 	RequestPostCache
+	// Geohash is only included when requested with the 'geohashprecision' query arg.
+	Geohash string `json:"geohash,omitempty"`
+}
+
+type ResponseNearbyCache struct {
+	ResponseCache
+	Distance float64 `json:"distance"`
+	Bearing  float64 `json:"bearing"`
 }
 
 type ResponseIds struct {
@@ -75,21 +94,63 @@ func NewController(
 // error to the caller.
 func parseJSON[T any](c *gin.Context, ptr any) error {
 	if err := c.ShouldBindJSON(&ptr); err != nil {
-		c.String(http.StatusBadRequest, err.Error())
+		writeBadRequest(c, err.Error())
 		return err
 	}
 	return nil
 }
 
-func cacheModelsToResponseCaches(caches []model.Cache) []ResponseCache {
+// parseQueryArg will parse the value of the named query arg with the provided parse func.  If it is
+// unable to parse the value it will set the proper response headers and error and then return the
+// error to the caller.
+func parseQueryArg[T any](
+	c *gin.Context,
+	name, value string,
+	parse func(string) (T, error),
+) (T, error) {
+	retval, err := parse(value)
+	if err != nil {
+		writeBadRequest(
+			c, fmt.Sprintf("invalid value for query arg; %s=%s, err=%s", name, value, err))
+	}
+	return retval, err
+}
+
+// parseTagQuery parses the 'tags' query arg with model.ParseTagQuery, and writes a 400 if it is
+// not a valid tag query.
+func parseTagQuery(c *gin.Context, value string) (*model.TagQuery, error) {
+	query, err := model.ParseTagQuery(value)
+	if err != nil {
+		writeBadRequest(c, err.Error())
+		return nil, err
+	}
+	return query, nil
+}
+
+func parseFloat(value string) (float64, error) {
+	return strconv.ParseFloat(value, 64)
+}
+
+// normalizeLongitude returns the longitude converted from (180, 360] into [-180, 180] if requested
+// with the 'normalize=true' query arg, and otherwise returns it unchanged.
+func normalizeLongitude(c *gin.Context, long float64) float64 {
+	if c.DefaultQuery("normalize", "") == "true" {
+		return model.NormalizeLongitude(long)
+	}
+	return long
+}
+
+func cacheModelsToResponseCaches(caches []model.Cache, geohashPrecision int) []ResponseCache {
 	var retval []ResponseCache
 	for _, cache := range caches {
-		retval = append(retval, cacheModelToResponseCache(cache))
+		retval = append(retval, cacheModelToResponseCache(cache, geohashPrecision))
 	}
 	return retval
 }
 
-func cacheModelToResponseCache(cache model.Cache) ResponseCache {
+// cacheModelToResponseCache converts the cache, including its geohash with the provided number of
+// characters if geohashPrecision is greater than 0.
+func cacheModelToResponseCache(cache model.Cache, geohashPrecision int) ResponseCache {
 	var tags []string = nil
 	tagsCount := len(cache.Tags)
 	if tagsCount > 0 {
@@ -107,104 +168,403 @@ func cacheModelToResponseCache(cache model.Cache) ResponseCache {
 		Long: cache.Long,
 		Tags: tags,
 	}
-	return ResponseCache{
-		Id:                cache.Id,
+	retval := ResponseCache{
+		Id:               cache.Id,
 		RequestPostCache: r,
 	}
+	if geohashPrecision > 0 {
+		retval.Geohash = geostore.EncodeGeohash(cache.Lat, cache.Long, geohashPrecision)
+	}
+	return retval
 }
 
+// nearbyCacheModelsToResponseNearbyCaches converts the caches, preserving their order, and converts
+// their distances from meters into the requested units.
+func nearbyCacheModelsToResponseNearbyCaches(
+	caches []model.NearbyCache,
+	metersPerUnit float64,
+	geohashPrecision int,
+) []ResponseNearbyCache {
+	var retval []ResponseNearbyCache
+	for _, cache := range caches {
+		retval = append(retval, ResponseNearbyCache{
+			ResponseCache: cacheModelToResponseCache(cache.Cache, geohashPrecision),
+			Distance:      cache.Distance / metersPerUnit,
+			Bearing:       cache.Bearing,
+		})
+	}
+	return retval
+}
+
+// createCacheHandler creates a cache.  Names are unique, so if there is already a cache with the
+// same name it is a conflict, unless the 'upsert=true' query arg is provided, in which case the
+// existing cache is updated instead.
 func (s *Controller) createCacheHandler(c *gin.Context) {
 	var rs RequestPostCache
 	if err := parseJSON[RequestPostCache](c, &rs); err != nil {
 		return
 	}
+	long := normalizeLongitude(c, rs.Long)
+
+	if c.DefaultQuery("upsert", "") == "true" {
+		id, created, err := s.service.Upsert(rs.Name, rs.Lat, long, rs.Tags)
+		if err != nil {
+			writeErr(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "created": created})
+		return
+	}
 
-	id, err := s.service.Create(rs.Name, rs.Lat, rs.Long, rs.Tags)
+	id, err := s.service.Create(rs.Name, rs.Lat, long, rs.Tags)
 	if err != nil {
-		c.String(http.StatusBadRequest, err.Error())
+		writeErr(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"id": id})
 }
 
-func (s *Controller) getCachesHandler(c *gin.Context) {
-	var caches []model.Cache
-	var err error
+// cacheFilters are the filters of the caches that both the list and the GPX export endpoints
+// accept, of which at most one may be provided.
+type cacheFilters struct {
+	tags      *model.TagQuery
+	geohash   string
+	nameQuery string
+}
 
+// parseCacheFilters will parse the 'tags', 'geohash' and 'q' query args.  If they are invalid it
+// will set the proper response headers and error and then return the error to the caller.
+func parseCacheFilters(c *gin.Context) (cacheFilters, error) {
+	var retval cacheFilters
 	queryStringTags := c.DefaultQuery("tags", "")
-	if queryStringTags != "" {
-		tags := strings.Split(queryStringTags, ",")
-		caches, err = s.service.GetByTags(tags)
-		if err != nil {
-			c.String(http.StatusNotFound, err.Error())
-			return
+	retval.geohash = c.DefaultQuery("geohash", "")
+	retval.nameQuery = c.DefaultQuery("q", "")
+	if countNonEmpty(queryStringTags, retval.geohash, retval.nameQuery) > 1 {
+		err := errors.New("only one of the tags, q and geohash query args may be provided")
+		writeBadRequest(c, err.Error())
+		return retval, err
+	}
+	if retval.geohash != "" {
+		if err := validateGeohash(retval.geohash); err != nil {
+			writeBadRequest(c, err.Error())
+			return retval, err
 		}
-	} else {
-		caches, err = s.service.GetAll()
-		if err != nil {
-			c.String(http.StatusNotFound, err.Error())
-			return
+	}
+	if queryStringTags != "" {
+		var err error
+		if retval.tags, err = parseTagQuery(c, queryStringTags); err != nil {
+			return retval, err
 		}
 	}
+	return retval, nil
+}
 
-	requestCaches := cacheModelsToResponseCaches(caches)
+// getCachesHandler returns the caches that match the 'tags' query, the caches whose names match
+// the 'q' name search, a page of the caches within the cell of the provided 'geohash', or a page
+// of all of the caches if there are none of them.  Responses are GeoJSON if requested via the
+// 'format' query arg or the Accept header.
+func (s *Controller) getCachesHandler(c *gin.Context) {
+	asGeoJSON, err := wantsGeoJSON(c)
+	if err != nil {
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
+		return
+	}
+	filters, err := parseCacheFilters(c)
+	if err != nil {
+		return
+	}
+	switch {
+	case filters.nameQuery != "":
+		s.getCachesByNameQueryHandler(c, filters.nameQuery, asGeoJSON, geohashPrecision)
+		return
+	case filters.geohash != "":
+		s.getCachesInGeohashHandler(c, filters.geohash, asGeoJSON, geohashPrecision)
+		return
+	case filters.tags == nil:
+		s.getAllCachesHandler(c, asGeoJSON, geohashPrecision)
+		return
+	}
+
+	caches, err := s.service.FindByTagQuery(filters.tags)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	if asGeoJSON {
+		writeGeoJSON(c, http.StatusOK, cacheModelsToFeatureCollection(caches, geohashPrecision))
+		return
+	}
+	requestCaches := cacheModelsToResponseCaches(caches, geohashPrecision)
 	c.JSON(http.StatusOK, requestCaches)
 }
 
-func (s *Controller) getNearestCachesHandler(c *gin.Context) {
+// countNonEmpty returns the number of the values that are not empty.
+func countNonEmpty(values ...string) int {
+	retval := 0
+	for _, v := range values {
+		if v != "" {
+			retval++
+		}
+	}
+	return retval
+}
+
+// getAllCachesHandler returns a page of all of the caches, in ascending id order.
+func (s *Controller) getAllCachesHandler(c *gin.Context, asGeoJSON bool, geohashPrecision int) {
+	afterId, limit, err := parsePageArgs(c)
+	if err != nil {
+		return
+	}
+
+	caches, err := s.service.GetAll(afterId, limit+1)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	writeCachePage(c, caches, limit, asGeoJSON, geohashPrecision)
+}
+
+// nearestArgs are the parsed query args for a nearest neighbor search.  The maxDistance is in
+// meters.
+type nearestArgs struct {
+	lat, long, maxDistance, metersPerUnit float64
+	limit                                 int
+}
+
+// parseNearestArgs will parse the query args for a nearest neighbor search.  If it is unable to
+// parse them it will set the proper response headers and error and then return the error to the
+// caller.
+func parseNearestArgs(c *gin.Context) (nearestArgs, error) {
 	latStr := c.DefaultQuery("lat", "")
 	longStr := c.DefaultQuery("long", "")
 	maxDistanceStr := c.DefaultQuery("maxdistance", "")
 	limitStr := c.DefaultQuery("limit", "")
 
 	if latStr == "" || longStr == "" || maxDistanceStr == "" || limitStr == "" {
-		c.String(
-			http.StatusBadRequest,
-			fmt.Sprintf(
-				"missing required query args; "+
-					"lat=%s, long=%s, maxdistance=%s, limit=%s",
-				latStr, longStr, maxDistanceStr, limitStr))
+		err := fmt.Errorf(
+			"missing required query args; "+
+				"lat=%s, long=%s, maxdistance=%s, limit=%s",
+			latStr, longStr, maxDistanceStr, limitStr)
+		writeBadRequest(c, err.Error())
+		return nearestArgs{}, err
+	}
+
+	units := c.DefaultQuery("units", "m")
+	metersPerUnit, ok := distanceUnits[units]
+	if !ok {
+		err := fmt.Errorf("invalid units; units=%s, valid units are m, km, mi and nmi", units)
+		writeBadRequest(c, err.Error())
+		return nearestArgs{}, err
+	}
+
+	lat, err := parseQueryArg(c, "lat", latStr, parseFloat)
+	if err != nil {
+		return nearestArgs{}, err
+	}
+	long, err := parseQueryArg(c, "long", longStr, parseFloat)
+	if err != nil {
+		return nearestArgs{}, err
+	}
+	maxDistance, err := parseQueryArg(c, "maxdistance", maxDistanceStr, parseFloat)
+	if err != nil {
+		return nearestArgs{}, err
+	}
+	limit, err := parseQueryArg(c, "limit", limitStr, strconv.Atoi)
+	if err != nil {
+		return nearestArgs{}, err
+	}
+
+	// The maxdistance is provided in the requested units, and the service expects meters.
+	return nearestArgs{
+		lat:           lat,
+		long:          long,
+		maxDistance:   maxDistance * metersPerUnit,
+		metersPerUnit: metersPerUnit,
+		limit:         limit,
+	}, nil
+}
+
+func (s *Controller) getNearestCachesHandler(c *gin.Context) {
+	asGeoJSON, err := wantsGeoJSON(c)
+	if err != nil {
+		return
+	}
+	args, err := parseNearestArgs(c)
+	if err != nil {
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
 		return
 	}
 
-	// TODO: Actually do error checking
-	lat, _ := strconv.ParseFloat(latStr, 64)
-	long, _ := strconv.ParseFloat(longStr, 64)
-	maxDistance, _ := strconv.ParseFloat(maxDistanceStr, 64)
-	limit, _ := strconv.Atoi(limitStr)
+	caches, err := s.service.FindNearest(args.lat, args.long, args.maxDistance, args.limit)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	if asGeoJSON {
+		writeGeoJSON(c, http.StatusOK,
+			nearbyCacheModelsToFeatureCollection(caches, args.metersPerUnit, geohashPrecision))
+		return
+	}
+	c.JSON(http.StatusOK,
+		nearbyCacheModelsToResponseNearbyCaches(caches, args.metersPerUnit, geohashPrecision))
+}
+
+// getCachesWithinHandler returns a page of the caches within a bounding box, in ascending id order.
+// The bounding box is provided in the 'bbox' query arg in the same order as a GeoJSON bbox:
+// minLong,minLat,maxLong,maxLat.  A minLong greater than maxLong denotes a box that crosses the
+// antimeridian.
+func (s *Controller) getCachesWithinHandler(c *gin.Context) {
+	asGeoJSON, err := wantsGeoJSON(c)
+	if err != nil {
+		return
+	}
+	bboxStr := c.DefaultQuery("bbox", "")
+	if bboxStr == "" {
+		writeBadRequest(c, "missing required query arg; bbox")
+		return
+	}
+	minLat, minLong, maxLat, maxLong, err := parseBbox(bboxStr)
+	if err != nil {
+		writeBadRequest(c, err.Error())
+		return
+	}
+	afterId, limit, err := parsePageArgs(c)
+	if err != nil {
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
+		return
+	}
 
-	caches, err := s.service.FindNearest(lat, long, maxDistance, limit)
+	caches, err := s.service.FindInBox(minLat, minLong, maxLat, maxLong, afterId, limit+1)
 	if err != nil {
-		// FIXME: need to sort out this error checking/handling/reporting better
-		c.String(http.StatusNotFound, err.Error())
+		writeErr(c, err)
 		return
 	}
 
-	requestCaches := cacheModelsToResponseCaches(caches)
-	c.JSON(http.StatusOK, requestCaches)
+	writeCachePage(c, caches, limit, asGeoJSON, geohashPrecision)
+}
+
+// searchWithinHandler returns a page of the caches within the GeoJSON Polygon or MultiPolygon, or
+// Feature with one of them as its geometry, in the request body, in ascending id order.
+func (s *Controller) searchWithinHandler(c *gin.Context) {
+	asGeoJSON, err := wantsGeoJSON(c)
+	if err != nil {
+		return
+	}
+	var rg RequestGeoJSON
+	if err := parseJSON[RequestGeoJSON](c, &rg); err != nil {
+		return
+	}
+	polygons, err := geoJSONToPolygons(&rg)
+	if err != nil {
+		writeBadRequest(c, err.Error())
+		return
+	}
+	afterId, limit, err := parsePageArgs(c)
+	if err != nil {
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
+		return
+	}
+
+	caches, err := s.service.FindInPolygons(polygons, afterId, limit+1)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	writeCachePage(c, caches, limit, asGeoJSON, geohashPrecision)
+}
+
+// parseBbox parses a bounding box in the form minLong,minLat,maxLong,maxLat.
+func parseBbox(bboxStr string) (minLat, minLong, maxLat, maxLong float64, err error) {
+	parts := strings.Split(bboxStr, ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf(
+			"bbox must be in the form minLong,minLat,maxLong,maxLat; bbox=%s", bboxStr)
+	}
+	values := make([]float64, len(parts))
+	for i, p := range parts {
+		values[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid value in bbox; bbox=%s, err=%s", bboxStr, err)
+		}
+	}
+	return bboxFromValues(values, bboxStr)
+}
+
+// bboxFromValues validates a bounding box of the values minLong,minLat,maxLong,maxLat, in the same
+// order as a GeoJSON bbox.  The bboxStr is the bounding box as it was provided, for the errors.
+func bboxFromValues(
+	values []float64,
+	bboxStr string,
+) (minLat, minLong, maxLat, maxLong float64, err error) {
+	if len(values) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf(
+			"bbox must be in the form minLong,minLat,maxLong,maxLat; bbox=%s", bboxStr)
+	}
+	minLong, minLat, maxLong, maxLat = values[0], values[1], values[2], values[3]
+
+	if minLat < -90 || maxLat > 90 || minLat > maxLat {
+		return 0, 0, 0, 0, fmt.Errorf(
+			"bbox latitudes must be within [-90, 90] and minLat <= maxLat; bbox=%s", bboxStr)
+	}
+	if minLong < -180 || minLong > 180 || maxLong < -180 || maxLong > 180 {
+		return 0, 0, 0, 0, fmt.Errorf("bbox longitudes must be within [-180, 180]; bbox=%s", bboxStr)
+	}
+	return minLat, minLong, maxLat, maxLong, nil
 }
 
 func (s *Controller) getCacheByNameHandler(c *gin.Context) {
+	asGeoJSON, err := wantsGeoJSON(c)
+	if err != nil {
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
+		return
+	}
 	name := c.Params.ByName("name")
 	if name == "" {
-		c.String(http.StatusBadRequest, "Missing valid 'name' parameter")
+		writeBadRequest(c, "Missing valid 'name' parameter")
 		return
 	}
 
 	cache, err := s.service.GetByName(name)
 	if err != nil {
-		c.String(http.StatusNotFound, err.Error())
+		writeErr(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, cacheModelToResponseCache(cache))
+	if asGeoJSON {
+		writeGeoJSON(c, http.StatusOK, cacheModelToFeature(cache, geohashPrecision))
+		return
+	}
+	c.JSON(http.StatusOK, cacheModelToResponseCache(cache, geohashPrecision))
 }
 
 func (s *Controller) putCacheByNameHandler(c *gin.Context) {
 	name := c.Params.ByName("name")
 	if name == "" {
-		c.String(http.StatusBadRequest, "Missing valid 'name' parameter")
+		writeBadRequest(c, "Missing valid 'name' parameter")
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
 		return
 	}
 	var rs RequestPutCache
@@ -219,16 +579,83 @@ func (s *Controller) putCacheByNameHandler(c *gin.Context) {
 	updatedCache := model.Cache{
 		Name: name,
 		Lat:  rs.Lat,
-		Long: rs.Long,
+		Long: normalizeLongitude(c, rs.Long),
 		Tags: newTags,
 	}
 	cache, err := s.service.Update(name, updatedCache)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		writeErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cacheModelToResponseCache(cache, geohashPrecision))
+}
+
+// postCacheTagHandler adds a single tag to the cache, without replacing the rest of it, and returns
+// the updated cache.
+func (s *Controller) postCacheTagHandler(c *gin.Context) {
+	s.updateCacheTagHandler(c, s.service.AddTag)
+}
+
+// deleteCacheTagHandler removes a single tag from the cache, without replacing the rest of it, and
+// returns the updated cache.
+func (s *Controller) deleteCacheTagHandler(c *gin.Context) {
+	s.updateCacheTagHandler(c, s.service.RemoveTag)
+}
+
+func (s *Controller) updateCacheTagHandler(
+	c *gin.Context,
+	update func(name, tag string) (model.Cache, error),
+) {
+	name := c.Params.ByName("name")
+	tag := c.Params.ByName("tag")
+	if name == "" || tag == "" {
+		writeBadRequest(c, "Missing valid 'name' or 'tag' parameter")
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
+		return
+	}
+
+	cache, err := update(name, tag)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cacheModelToResponseCache(cache, geohashPrecision))
+}
+
+func (s *Controller) deleteCacheByNameHandler(c *gin.Context) {
+	name := c.Params.ByName("name")
+	if name == "" {
+		writeBadRequest(c, "Missing valid 'name' parameter")
+		return
+	}
+
+	if err := s.service.DeleteByName(name); err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// deleteCachesHandler deletes all of the caches.  Because it is so destructive, the caller must
+// explicitly confirm it with the 'confirm=true' query arg.
+func (s *Controller) deleteCachesHandler(c *gin.Context) {
+	if c.DefaultQuery("confirm", "") != "true" {
+		writeBadRequest(c, "Deleting all geocaches requires the 'confirm=true' query arg")
+		return
+	}
+
+	if err := s.service.DeleteAll(); err != nil {
+		writeErr(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, cacheModelToResponseCache(cache))
+	c.Status(http.StatusNoContent)
 }
 
 func (s *Controller) ruok(c *gin.Context) {
@@ -245,14 +672,25 @@ func (s *Controller) Start() {
 	// to compose in Authn and Authz and other features.
 	router := gin.New()
 	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	router.Use(gin.CustomRecovery(recoveryHandler))
+	router.NoRoute(noRouteHandler)
 
 	// Define the routes for our http server
 	router.POST(s.vPrefix+"/geocaches", s.createCacheHandler)
 	router.GET(s.vPrefix+"/geocaches", s.getCachesHandler)
 	router.GET(s.vPrefix+"/geocaches/:name", s.getCacheByNameHandler)
 	router.PUT(s.vPrefix+"/geocaches/:name", s.putCacheByNameHandler)
+	router.DELETE(s.vPrefix+"/geocaches", s.deleteCachesHandler)
+	router.DELETE(s.vPrefix+"/geocaches/:name", s.deleteCacheByNameHandler)
+	router.POST(s.vPrefix+"/geocaches/:name/tags/:tag", s.postCacheTagHandler)
+	router.DELETE(s.vPrefix+"/geocaches/:name/tags/:tag", s.deleteCacheTagHandler)
+	router.GET(s.vPrefix+"/tags", s.getTagsHandler)
 	router.GET(s.vPrefix+"/geocaches/nearest", s.getNearestCachesHandler)
+	router.GET(s.vPrefix+"/geocaches/within", s.getCachesWithinHandler)
+	router.POST(s.vPrefix+"/geocaches/search", s.searchHandler)
+	router.POST(s.vPrefix+"/geocaches/search/within", s.searchWithinHandler)
+	router.POST(s.vPrefix+"/import/gpx", s.importGpxHandler)
+	router.GET(s.vPrefix+"/export.gpx", s.exportGpxHandler)
 	router.GET(s.vPrefix+"/ruok", s.ruok)
 
 	// Instantiate an http server then initialize it in a go routine so that it will not block and
@@ -2,14 +2,18 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
 	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,3 +35,424 @@ func TestRuokRoute(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 	assert.Equal(t, "ack", w.Body.String())
 }
+
+func TestGetNearestCachesHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	// The maxdistance is provided in km, and should be passed to the service in meters, and the
+	// distances returned from the service should be converted to km.
+	nearbyCaches := []model.NearbyCache{
+		{
+			Cache:    model.Cache{Id: 2, Name: "s2", Lat: 39.5, Long: -77.5},
+			Distance: 1500,
+			Bearing:  45,
+		},
+		{
+			Cache:    model.Cache{Id: 1, Name: "s1", Lat: 39, Long: -77},
+			Distance: 2500,
+			Bearing:  270,
+		},
+	}
+	mockService.EXPECT().FindNearest(39.2, -77.1, 5000.0, 10).Return(nearbyCaches, nil)
+
+	path := "/geocaches/nearest"
+	router := gin.Default()
+	router.GET(path, server.getNearestCachesHandler)
+	req, _ := http.NewRequest(
+		"GET", path+"?lat=39.2&long=-77.1&maxdistance=5&limit=10&units=km", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var actual []ResponseNearbyCache
+	err := json.Unmarshal(w.Body.Bytes(), &actual)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(actual))
+	assert.Equal(t, uint64(2), actual[0].Id)
+	assert.Equal(t, 1.5, actual[0].Distance)
+	assert.Equal(t, 45.0, actual[0].Bearing)
+	assert.Equal(t, uint64(1), actual[1].Id)
+	assert.Equal(t, 2.5, actual[1].Distance)
+	assert.Equal(t, 270.0, actual[1].Bearing)
+}
+
+// TestHandlersRejectInvalidArgs asserts that each of the requests is rejected with a problem before
+// it makes it to the service, which does not expect any calls.
+func TestHandlersRejectInvalidArgs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	router := gin.Default()
+	router.GET("/geocaches", server.getCachesHandler)
+	router.GET("/geocaches/nearest", server.getNearestCachesHandler)
+	router.GET("/tags", server.getTagsHandler)
+	router.POST("/geocaches/search", server.searchHandler)
+	router.GET("/export.gpx", server.exportGpxHandler)
+
+	testData := []struct {
+		method string
+		path   string
+		// request is the query string of a GET, or the body of a POST
+		request string
+		// status is the status of the problem, if it is not a 400 Bad Request
+		status int
+	}{
+		{method: "GET", path: "/geocaches/nearest", request: "?lat=39.2&long=-77.1&maxdistance=5"},
+		{
+			method:  "GET",
+			path:    "/geocaches/nearest",
+			request: "?lat=39.2&long=-77.1&maxdistance=5&limit=10&units=furlongs",
+		},
+		{
+			method:  "GET",
+			path:    "/geocaches/nearest",
+			request: "?lat=north&long=-77.1&maxdistance=5&limit=10",
+		},
+		{
+			method:  "GET",
+			path:    "/geocaches/nearest",
+			request: "?lat=39.2&long=-77.1&maxdistance=5&limit=ten",
+		},
+		{method: "GET", path: "/geocaches", request: "?limit=0"},
+		{method: "GET", path: "/geocaches", request: "?limit=1001"},
+		{method: "GET", path: "/geocaches", request: "?limit=ten"},
+		{method: "GET", path: "/geocaches", request: "?cursor=not-a-cursor"},
+		{method: "GET", path: "/geocaches", request: "?geohash=c3a"},
+		{method: "GET", path: "/geocaches", request: "?geohash=c3nfkhrkujf20"},
+		{method: "GET", path: "/geocaches", request: "?geohash=c3&tags=city"},
+		{method: "GET", path: "/geocaches", request: "?geohash=c3&limit=0"},
+		{method: "GET", path: "/export.gpx", request: "?geohash=c3a"},
+		{method: "GET", path: "/export.gpx", request: "?geohash=c3&tags=city"},
+		{method: "GET", path: "/geocaches", request: "?geohashprecision=0"},
+		{method: "GET", path: "/geocaches", request: "?geohashprecision=13"},
+		{method: "GET", path: "/geocaches", request: "?geohashprecision=six"},
+		{method: "GET", path: "/geocaches", request: "?q=lake&tags=park"},
+		{method: "GET", path: "/geocaches", request: "?q=lake&geohash=c3"},
+		{method: "GET", path: "/geocaches", request: "?q=lake&limit=0"},
+		{method: "GET", path: "/export.gpx", request: "?q=lake&geohash=c3"},
+		{method: "GET", path: "/export.gpx", request: "?q=lake&limit=0"},
+		{method: "GET", path: "/tags", request: "?sort=popularity"},
+		{method: "GET", path: "/tags", request: "?limit=0"},
+		{method: "GET", path: "/tags", request: "?cursor=not-a-cursor"},
+		{
+			method:  "POST",
+			path:    "/geocaches/search",
+			request: `{"near": {"lat": 1, "long": 1}, "bbox": [0, 0, 1, 1]}`,
+		},
+		{
+			method:  "POST",
+			path:    "/geocaches/search",
+			request: `{"near": {"lat": 1, "long": 1, "units": "furlongs"}}`,
+		},
+		{
+			method:  "POST",
+			path:    "/geocaches/search",
+			request: `{"near": {"lat": 91, "long": 1}}`,
+			status:  http.StatusUnprocessableEntity,
+		},
+		{
+			method:  "POST",
+			path:    "/geocaches/search",
+			request: `{"near": {"lat": 1, "long": 1, "maxdistance": -1}}`,
+		},
+		{method: "POST", path: "/geocaches/search", request: `{"bbox": [0, 0, 1]}`},
+		{method: "POST", path: "/geocaches/search", request: `{"bbox": [0, 1, 1, 0]}`},
+		{
+			method:  "POST",
+			path:    "/geocaches/search",
+			request: `{"polygon": {"type": "Point", "coordinates": [0, 0]}}`,
+		},
+		{method: "POST", path: "/geocaches/search", request: `{"tags": "ocean AND"}`},
+		{method: "POST", path: "/geocaches/search", request: `{"sort": "distance"}`},
+		{method: "POST", path: "/geocaches/search", request: `{"sort": "popularity"}`},
+		{method: "POST", path: "/geocaches/search", request: `{"limit": 1001}`},
+		{method: "POST", path: "/geocaches/search", request: `{"limit": -1}`},
+		{method: "POST", path: "/geocaches/search", request: `{"name": 1}`},
+	}
+	for _, td := range testData {
+		var req *http.Request
+		if td.method == "POST" {
+			req, _ = http.NewRequest(td.method, td.path, strings.NewReader(td.request))
+		} else {
+			req, _ = http.NewRequest(td.method, td.path+td.request, nil)
+		}
+		status := td.status
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		problem := serveProblem(t, router, req, status)
+		assert.NotEmpty(t, problem.Detail, "%s %s", td.path, td.request)
+		if status == http.StatusBadRequest {
+			assert.Equal(t, CodeBadRequest, problem.Code, "%s %s", td.path, td.request)
+		}
+	}
+}
+
+func TestDeleteCachesHandlerRequiresConfirm(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	mockService.EXPECT().DeleteAll().Return(nil).Times(1)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.DELETE(path, server.deleteCachesHandler)
+
+	testData := []struct {
+		query        string
+		expectedCode int
+	}{
+		{query: "", expectedCode: http.StatusBadRequest},
+		{query: "?confirm=false", expectedCode: http.StatusBadRequest},
+		{query: "?confirm=true", expectedCode: http.StatusNoContent},
+	}
+	for _, td := range testData {
+		req, _ := http.NewRequest("DELETE", path+td.query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, td.expectedCode, w.Code, td.query)
+	}
+}
+
+func TestDeleteCacheByNameHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	// The cache is looked up and deleted by the store in one call, so that it cannot be replaced
+	// by another cache with the same name in between
+	mockService := mocks.NewMockService(mockCtrl)
+	gomock.InOrder(
+		mockService.EXPECT().DeleteByName("calgary").Return(nil),
+		mockService.EXPECT().DeleteByName("calgary").Return(&model.CacheNotFoundErr{}),
+	)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	router := gin.Default()
+	router.DELETE("/geocaches/:name", server.deleteCacheByNameHandler)
+	req, _ := http.NewRequest("DELETE", "/geocaches/calgary", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	req, _ = http.NewRequest("DELETE", "/geocaches/calgary", nil)
+	problem := serveProblem(t, router, req, http.StatusNotFound)
+	assert.Equal(t, CodeNotFound, problem.Code)
+}
+
+func TestParseBbox(t *testing.T) {
+	minLat, minLong, maxLat, maxLong, err := parseBbox("-120.5, 40,-100,60.25")
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{40, -120.5, 60.25, -100}, []float64{minLat, minLong, maxLat, maxLong})
+
+	// Crossing the antimeridian
+	minLat, minLong, maxLat, maxLong, err = parseBbox("175,-20,-175,0")
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{-20, 175, 0, -175}, []float64{minLat, minLong, maxLat, maxLong})
+
+	invalid := []string{
+		"",
+		"1,2,3",
+		"1,2,3,4,5",
+		"a,2,3,4",
+		"-120,60,-100,40",
+		"-120,-91,-100,40",
+		"-181,40,-100,60",
+		"-120,40,180.5,60",
+	}
+	for _, bbox := range invalid {
+		_, _, _, _, err = parseBbox(bbox)
+		assert.NotNil(t, err, bbox)
+	}
+}
+
+func TestCreateCacheHandlerValidation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	mockService.EXPECT().Create("bad", 95.0, 270.0, nil).
+		Return(uint64(0), model.ValidateLocation(95, 270))
+	// Longitudes in (180, 360] are only normalized when requested
+	mockService.EXPECT().Create("east", 10.0, -90.0, nil).Return(uint64(1), nil)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.POST(path, server.createCacheHandler)
+
+	req, _ := http.NewRequest("POST", path, strings.NewReader(`{"name":"bad","lat":95,"long":270}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var body ResponseProblem
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeValidationFailed, body.Code)
+	assert.Equal(t, []model.FieldErr{
+		{Field: "lat", Reason: "must be within [-90, 90]"},
+		{Field: "long", Reason: "must be within [-180, 180]"},
+	}, body.Fields)
+	assert.NotEmpty(t, body.Detail)
+
+	req, _ = http.NewRequest(
+		"POST", path+"?normalize=true", strings.NewReader(`{"name":"east","lat":10,"long":270}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPutCacheByNameHandlerValidation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	invalid := model.Cache{Name: "calgary", Lat: -91, Long: -114, Tags: map[string]bool{}}
+	mockService.EXPECT().Update("calgary", invalid).
+		Return(model.Cache{}, model.ValidateLocation(invalid.Lat, invalid.Long))
+
+	path := "/geocaches/:name"
+	router := gin.Default()
+	router.PUT(path, server.putCacheByNameHandler)
+
+	req, _ := http.NewRequest(
+		"PUT", "/geocaches/calgary", strings.NewReader(`{"lat":-91,"long":-114}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var body ResponseProblem
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeValidationFailed, body.Code)
+	assert.Equal(t,
+		[]model.FieldErr{{Field: "lat", Reason: "must be within [-90, 90]"}}, body.Fields)
+}
+
+func TestCreateCacheHandlerConflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	mockService.EXPECT().Create("red deer", 52.2681, -113.8112, nil).
+		Return(uint64(0), &model.ConflictErr{Reason: "taken", Id: 7, Name: "red deer"})
+	mockService.EXPECT().Upsert("red deer", 52.2681, -113.8112, nil).Return(uint64(7), false, nil)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.POST(path, server.createCacheHandler)
+	body := `{"name":"red deer","lat":52.2681,"long":-113.8112}`
+
+	// The conflict points to the existing cache
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	problem := serveProblem(t, router, req, http.StatusConflict)
+	assert.Equal(t, CodeConflict, problem.Code)
+	assert.Equal(t, "/v1/geocaches/red%20deer", problem.Existing)
+
+	// Unless it is an upsert, which updates it instead
+	req, _ = http.NewRequest("POST", path+"?upsert=true", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":7,"created":false}`, w.Body.String())
+}
+
+func TestCacheTagHandlers(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	banff := model.Cache{Id: 3, Name: "banff", Lat: 51.1784, Long: -115.5708}
+	banff.Tags = map[string]bool{"park": true, "town": true}
+	mockService.EXPECT().AddTag("banff", "town").Return(banff, nil)
+	banff.Tags = map[string]bool{"town": true}
+	mockService.EXPECT().RemoveTag("banff", "park").Return(banff, nil)
+	mockService.EXPECT().AddTag("oregon", "state").Return(model.Cache{}, &model.CacheNotFoundErr{})
+
+	path := "/geocaches/:name/tags/:tag"
+	router := gin.Default()
+	router.POST(path, server.postCacheTagHandler)
+	router.DELETE(path, server.deleteCacheTagHandler)
+
+	testData := []struct {
+		method   string
+		path     string
+		expected []string
+	}{
+		{method: "POST", path: "/geocaches/banff/tags/town", expected: []string{"park", "town"}},
+		{method: "DELETE", path: "/geocaches/banff/tags/park", expected: []string{"town"}},
+	}
+	for _, td := range testData {
+		req, _ := http.NewRequest(td.method, td.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var cache ResponseCache
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &cache))
+		assert.Equal(t, td.expected, cache.Tags)
+	}
+
+	req, _ := http.NewRequest("POST", "/geocaches/oregon/tags/state", nil)
+	problem := serveProblem(t, router, req, http.StatusNotFound)
+	assert.Equal(t, CodeNotFound, problem.Code)
+}
+
+func TestGetCachesHandlerTagQuery(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	query, _ := model.ParseTagQuery("ocean AND (flowrate OR voltage) AND NOT archived")
+	mockService.EXPECT().FindByTagQuery(query).Return([]model.Cache{
+		{Id: 1, Name: "oceanside", Tags: map[string]bool{"ocean": true, "flowrate": true}},
+	}, nil)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.GET(path, server.getCachesHandler)
+
+	req, _ := http.NewRequest("GET", path+"?tags="+
+		url.QueryEscape("ocean AND (flowrate OR voltage) AND NOT archived"), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var caches []ResponseCache
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &caches))
+	assert.Equal(t, 1, len(caches))
+	assert.Equal(t, "oceanside", caches[0].Name)
+
+	// Queries that cannot be parsed do not make it to the service
+	req, _ = http.NewRequest("GET", path+"?tags="+url.QueryEscape("ocean AND (river"), nil)
+	problem := serveProblem(t, router, req, http.StatusBadRequest)
+	assert.Equal(t, CodeBadRequest, problem.Code)
+	assert.Equal(t,
+		`invalid tag query; reason=expected ")" but found end of query, pos=16, `+
+			`query=ocean AND (river`,
+		problem.Detail)
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/geostore"
+)
+
+// parseGeohashPrecision will parse the optional 'geohashprecision' query arg, the number of
+// characters of the geohash to include with each cache in the response.  If it is not provided it
+// returns 0, and the geohashes are omitted.  If it is unable to parse it it will set the proper
+// response headers and error and then return the error to the caller.
+func parseGeohashPrecision(c *gin.Context) (int, error) {
+	precisionStr := c.DefaultQuery("geohashprecision", "")
+	if precisionStr == "" {
+		return 0, nil
+	}
+	precision, err := parseQueryArg(c, "geohashprecision", precisionStr, strconv.Atoi)
+	if err != nil {
+		return 0, err
+	}
+	if precision < 1 || precision > geostore.GeohashMaxPrecision {
+		err = fmt.Errorf("geohashprecision must be between 1 and %d; geohashprecision=%d",
+			geostore.GeohashMaxPrecision, precision)
+		writeBadRequest(c, err.Error())
+		return 0, err
+	}
+	return precision, nil
+}
+
+// validateGeohash returns an error if the geohash is not a valid geohash of between 1 and
+// GeohashMaxPrecision characters.
+func validateGeohash(geohash string) error {
+	if len(geohash) > geostore.GeohashMaxPrecision {
+		return fmt.Errorf("geohash must be at most %d characters; geohash=%s",
+			geostore.GeohashMaxPrecision, geohash)
+	}
+	_, _, _, _, err := geostore.DecodeGeohash(geohash)
+	return err
+}
+
+// getCachesInGeohashHandler returns a page of the caches within the cell of the geohash, in
+// ascending id order.  The geohash is a prefix of the geohashes of all of the caches within it, and
+// has already been validated by parseCacheFilters.
+func (s *Controller) getCachesInGeohashHandler(
+	c *gin.Context,
+	geohash string,
+	asGeoJSON bool,
+	geohashPrecision int,
+) {
+	afterId, limit, err := parsePageArgs(c)
+	if err != nil {
+		return
+	}
+
+	caches, err := s.service.FindInGeohash(geohash, afterId, limit+1)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	writeCachePage(c, caches, limit, asGeoJSON, geohashPrecision)
+}
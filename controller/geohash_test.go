@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCachesInGeohashHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	mockService.EXPECT().FindInGeohash("c3", uint64(0), 3).Return(
+		[]model.Cache{
+			{Id: 1, Name: "calgary", Lat: 51.0447, Long: -114.0719},
+			{Id: 2, Name: "edmonton", Lat: 53.5461, Long: -113.4937},
+			{Id: 3, Name: "banff", Lat: 51.1784, Long: -115.5708},
+		},
+		nil,
+	)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.GET(path, server.getCachesHandler)
+	req, _ := http.NewRequest("GET", path+"?geohash=c3&limit=2&geohashprecision=6", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var page ResponseCachePage
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 2, len(page.Items))
+	assert.Equal(t, "c3nfkh", page.Items[0].Geohash)
+	assert.Equal(t, "c3x297", page.Items[1].Geohash)
+	assert.Equal(t, encodeCursor(2), page.NextCursor)
+}
+
+func TestGetCachesHandlerGeohashPrecision(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	caches := []model.Cache{{Id: 1, Name: "calgary", Lat: 51.0447, Long: -114.0719}}
+	mockService.EXPECT().GetAll(uint64(0), defaultPageLimit+1).Return(caches, nil).Times(3)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.GET(path, server.getCachesHandler)
+
+	// The geohash is only included when it is requested
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{query: "", expected: ""},
+		{query: "?geohashprecision=1", expected: "c"},
+		{query: "?geohashprecision=12", expected: "c3nfkhrkujf2"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", path+tt.query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, tt.query)
+		var page ResponseCachePage
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, tt.expected, page.Items[0].Geohash, tt.query)
+		if tt.expected == "" {
+			assert.NotContains(t, w.Body.String(), "geohash")
+		}
+	}
+}
+
+func TestGetCachesInGeohashHandlerGeoJSON(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	mockService.EXPECT().FindInGeohash("C3N", uint64(0), defaultPageLimit+1).Return(
+		[]model.Cache{{Id: 1, Name: "calgary", Lat: 51.0447, Long: -114.0719}},
+		nil,
+	)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.GET(path, server.getCachesHandler)
+	req, _ := http.NewRequest("GET", path+"?geohash=C3N&format=geojson&geohashprecision=7", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var page ResponseFeatureCollection
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 1, len(page.Features))
+	assert.Equal(t, "c3nfkhr", page.Features[0].Properties.Geohash)
+}
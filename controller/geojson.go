@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/rchapin/go-geocache-api/model"
+)
+
+const (
+	geoJSONContentType       = "application/geo+json"
+	geoJSONFeature           = "Feature"
+	geoJSONFeatureCollection = "FeatureCollection"
+	geoJSONPoint             = "Point"
+	geoJSONPolygon           = "Polygon"
+	geoJSONMultiPolygon      = "MultiPolygon"
+
+	formatJSON    = "json"
+	formatGeoJSON = "geojson"
+)
+
+type ResponseGeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type ResponseFeatureProperties struct {
+	Id       uint64   `json:"id"`
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags"`
+	Geohash  string   `json:"geohash,omitempty"`
+	Distance *float64 `json:"distance,omitempty"`
+	Bearing  *float64 `json:"bearing,omitempty"`
+	Score    *float64 `json:"score,omitempty"`
+}
+
+// ResponseFeature is a geocache as a GeoJSON Point Feature.
+type ResponseFeature struct {
+	Type       string                    `json:"type"`
+	Id         uint64                    `json:"id"`
+	Geometry   ResponseGeoJSONPoint      `json:"geometry"`
+	Properties ResponseFeatureProperties `json:"properties"`
+}
+
+// ResponseFeatureCollection is a list of geocaches as a GeoJSON FeatureCollection.  When it is a
+// page of geocaches, NextCursor is included as a foreign member.
+type ResponseFeatureCollection struct {
+	Type       string            `json:"type"`
+	Bbox       []float64         `json:"bbox,omitempty"`
+	Features   []ResponseFeature `json:"features"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// wantsGeoJSON returns true if the client has asked for a GeoJSON response, either with the
+// 'format=geojson' query arg or by accepting application/geo+json.  If the 'format' query arg is
+// invalid it will set the proper response headers and error and then return the error to the
+// caller.
+func wantsGeoJSON(c *gin.Context) (bool, error) {
+	switch format := c.DefaultQuery("format", ""); format {
+	case formatGeoJSON:
+		return true, nil
+	case formatJSON:
+		return false, nil
+	case "":
+		return c.NegotiateFormat(gin.MIMEJSON, geoJSONContentType) == geoJSONContentType, nil
+	default:
+		err := fmt.Errorf("invalid format, must be json or geojson; format=%s", format)
+		writeBadRequest(c, err.Error())
+		return false, err
+	}
+}
+
+// writeGeoJSON serializes the given struct as JSON into the response body with the GeoJSON
+// Content-Type.
+func writeGeoJSON(c *gin.Context, code int, obj any) {
+	// gin will not override a Content-Type that has already been set
+	c.Header("Content-Type", geoJSONContentType)
+	c.JSON(code, obj)
+}
+
+func cacheModelToFeature(cache model.Cache, geohashPrecision int) ResponseFeature {
+	rc := cacheModelToResponseCache(cache, geohashPrecision)
+	return ResponseFeature{
+		Type: geoJSONFeature,
+		Id:   rc.Id,
+		Geometry: ResponseGeoJSONPoint{
+			Type:        geoJSONPoint,
+			Coordinates: [2]float64{rc.Long, rc.Lat},
+		},
+		Properties: ResponseFeatureProperties{
+			Id:      rc.Id,
+			Name:    rc.Name,
+			Tags:    rc.Tags,
+			Geohash: rc.Geohash,
+		},
+	}
+}
+
+func cacheModelsToFeatureCollection(
+	caches []model.Cache,
+	geohashPrecision int,
+) ResponseFeatureCollection {
+	features := make([]ResponseFeature, len(caches))
+	for i, cache := range caches {
+		features[i] = cacheModelToFeature(cache, geohashPrecision)
+	}
+	return newFeatureCollection(features)
+}
+
+// nearbyCacheModelsToFeatureCollection converts the caches, preserving their order, and converts
+// their distances from meters into the requested units.
+func nearbyCacheModelsToFeatureCollection(
+	caches []model.NearbyCache,
+	metersPerUnit float64,
+	geohashPrecision int,
+) ResponseFeatureCollection {
+	features := make([]ResponseFeature, len(caches))
+	for i, cache := range caches {
+		distance := cache.Distance / metersPerUnit
+		bearing := cache.Bearing
+		features[i] = cacheModelToFeature(cache.Cache, geohashPrecision)
+		features[i].Properties.Distance = &distance
+		features[i].Properties.Bearing = &bearing
+	}
+	return newFeatureCollection(features)
+}
+
+// newFeatureCollection returns a FeatureCollection of the features with a bbox that includes all of
+// them.  An empty FeatureCollection does not have a bbox.
+func newFeatureCollection(features []ResponseFeature) ResponseFeatureCollection {
+	retval := ResponseFeatureCollection{Type: geoJSONFeatureCollection, Features: features}
+	if len(features) == 0 {
+		return retval
+	}
+	minLat, maxLat := math.Inf(1), math.Inf(-1)
+	longs := make([]float64, len(features))
+	for i, f := range features {
+		longs[i] = f.Geometry.Coordinates[0]
+		minLat = math.Min(minLat, f.Geometry.Coordinates[1])
+		maxLat = math.Max(maxLat, f.Geometry.Coordinates[1])
+	}
+	west, east := longitudeBounds(longs)
+	retval.Bbox = []float64{west, minLat, east, maxLat}
+	return retval
+}
+
+// longitudeBounds returns the west and east edges of the narrowest range of longitudes that
+// includes all of the longitudes, which crosses the antimeridian, with west > east as per RFC 7946
+// section 5.2, if that is narrower.  The range is the circle of longitudes without the widest gap
+// between any two of them that are adjacent, going east.
+func longitudeBounds(longs []float64) (west, east float64) {
+	sort.Float64s(longs)
+	// The gap that crosses the antimeridian, from the most easterly to the most westerly
+	west, east = longs[0], longs[len(longs)-1]
+	widestGap := longs[0] + 360 - longs[len(longs)-1]
+	for i := 1; i < len(longs); i++ {
+		if gap := longs[i] - longs[i-1]; gap > widestGap {
+			widestGap = gap
+			west, east = longs[i], longs[i-1]
+		}
+	}
+	return west, east
+}
+
+// RequestGeoJSON is a GeoJSON geometry, or a GeoJSON Feature with a geometry, in a request body.
+type RequestGeoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *RequestGeoJSON `json:"geometry"`
+}
+
+// geoJSONToPolygons converts a GeoJSON Polygon or MultiPolygon, or a Feature with one of them as
+// its geometry, into geostore Polygons.
+func geoJSONToPolygons(g *RequestGeoJSON) ([]geostore.Polygon, error) {
+	switch g.Type {
+	case geoJSONFeature:
+		if g.Geometry == nil || g.Geometry.Type == geoJSONFeature {
+			return nil, errors.New("a Feature must have a Polygon or MultiPolygon geometry")
+		}
+		return geoJSONToPolygons(g.Geometry)
+	case geoJSONPolygon:
+		var coordinates [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coordinates); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates; err=%s", err)
+		}
+		polygon, err := coordinatesToPolygon(coordinates)
+		if err != nil {
+			return nil, err
+		}
+		return []geostore.Polygon{polygon}, nil
+	case geoJSONMultiPolygon:
+		var coordinates [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coordinates); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates; err=%s", err)
+		}
+		if len(coordinates) == 0 {
+			return nil, errors.New("a MultiPolygon must have at least one Polygon")
+		}
+		retval := make([]geostore.Polygon, len(coordinates))
+		for i, c := range coordinates {
+			polygon, err := coordinatesToPolygon(c)
+			if err != nil {
+				return nil, err
+			}
+			retval[i] = polygon
+		}
+		return retval, nil
+	default:
+		return nil, fmt.Errorf(
+			"unsupported GeoJSON type, must be a Polygon, MultiPolygon or Feature; type=%s", g.Type)
+	}
+}
+
+// coordinatesToPolygon converts the coordinates of a GeoJSON Polygon into a geostore Polygon,
+// validating that each of its rings is a closed ring of valid positions.
+func coordinatesToPolygon(coordinates [][][]float64) (geostore.Polygon, error) {
+	if len(coordinates) == 0 {
+		return nil, errors.New("a Polygon must have an exterior ring")
+	}
+	retval := make(geostore.Polygon, len(coordinates))
+	for i, positions := range coordinates {
+		if len(positions) < 4 {
+			return nil, fmt.Errorf(
+				"a Polygon ring must have at least 4 positions; positions=%d", len(positions))
+		}
+		ring := make(geostore.Ring, len(positions))
+		for j, position := range positions {
+			// A position may include an altitude, which we ignore.
+			if len(position) < 2 {
+				return nil, fmt.Errorf("a position must have a longitude and latitude; position=%v",
+					position)
+			}
+			long, lat := position[0], position[1]
+			if long < -180 || long > 180 || lat < -90 || lat > 90 {
+				return nil, fmt.Errorf("position is out of range; position=%v", position)
+			}
+			ring[j] = [2]float64{long, lat}
+		}
+		if ring[0] != ring[len(ring)-1] {
+			return nil, errors.New("a Polygon ring must be closed, its first and last positions " +
+				"must be the same")
+		}
+		retval[i] = ring
+	}
+	return retval, nil
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoJSONToPolygons(t *testing.T) {
+	square := geostore.Ring{{-120, 49}, {-110, 49}, {-110, 60}, {-120, 60}, {-120, 49}}
+	hole := geostore.Ring{{-114.5, 52}, {-113, 52}, {-113, 52.5}, {-114.5, 52.5}, {-114.5, 52}}
+	triangle := geostore.Ring{{120, -30}, {130, -30}, {125, -20}, {120, -30}}
+
+	testData := []struct {
+		body     string
+		expected []geostore.Polygon
+	}{
+		{
+			body: `{"type": "Polygon", "coordinates": [` +
+				`[[-120, 49], [-110, 49], [-110, 60], [-120, 60], [-120, 49]],` +
+				`[[-114.5, 52], [-113, 52], [-113, 52.5], [-114.5, 52.5], [-114.5, 52]]]}`,
+			expected: []geostore.Polygon{{square, hole}},
+		},
+		{
+			// Positions with an altitude
+			body: `{"type": "Polygon", "coordinates": [` +
+				`[[120, -30, 5], [130, -30, 5], [125, -20, 5], [120, -30, 5]]]}`,
+			expected: []geostore.Polygon{{triangle}},
+		},
+		{
+			body: `{"type": "MultiPolygon", "coordinates": [` +
+				`[[[-120, 49], [-110, 49], [-110, 60], [-120, 60], [-120, 49]]],` +
+				`[[[120, -30], [130, -30], [125, -20], [120, -30]]]]}`,
+			expected: []geostore.Polygon{{square}, {triangle}},
+		},
+		{
+			body: `{"type": "Feature", "properties": {"name": "park"}, "geometry": {` +
+				`"type": "Polygon", "coordinates": [[[120, -30], [130, -30], [125, -20], [120, -30]]]}}`,
+			expected: []geostore.Polygon{{triangle}},
+		},
+	}
+	for _, td := range testData {
+		var rg RequestGeoJSON
+		assert.Nil(t, json.Unmarshal([]byte(td.body), &rg))
+		actual, err := geoJSONToPolygons(&rg)
+		assert.Nil(t, err, td.body)
+		assert.Equal(t, td.expected, actual, td.body)
+	}
+}
+
+func TestGeoJSONToPolygonsInvalid(t *testing.T) {
+	invalid := []string{
+		// Unsupported type
+		`{"type": "Point", "coordinates": [1, 2]}`,
+		// Not closed
+		`{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]]]}`,
+		// Too few positions
+		`{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [0, 0]]]}`,
+		// No rings
+		`{"type": "Polygon", "coordinates": []}`,
+		// Out of range
+		`{"type": "Polygon", "coordinates": [[[0, 0], [181, 0], [1, 1], [0, 0]]]}`,
+		// Missing latitude
+		`{"type": "Polygon", "coordinates": [[[0, 0], [1], [1, 1], [0, 0]]]}`,
+		// Wrong nesting for the type
+		`{"type": "MultiPolygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}`,
+		`{"type": "MultiPolygon", "coordinates": []}`,
+		// Feature without a geometry
+		`{"type": "Feature", "properties": {}}`,
+	}
+	for _, body := range invalid {
+		var rg RequestGeoJSON
+		assert.Nil(t, json.Unmarshal([]byte(body), &rg))
+		_, err := geoJSONToPolygons(&rg)
+		assert.NotNil(t, err, body)
+	}
+}
+
+func TestCacheModelsToFeatureCollection(t *testing.T) {
+	caches := []model.Cache{
+		{
+			Id:   1,
+			Name: "calgary",
+			Lat:  51.0447,
+			Long: -114.0719,
+			Tags: map[string]bool{"b": true, "a": true},
+		},
+		{Id: 2, Name: "sydney", Lat: -33.8688, Long: 151.2093},
+	}
+	actual := cacheModelsToFeatureCollection(caches, 0)
+	assert.Equal(t, geoJSONFeatureCollection, actual.Type)
+	// The narrowest bbox crosses the antimeridian, and so its west edge is east of its east edge
+	assert.Equal(t, []float64{151.2093, -33.8688, -114.0719, 51.0447}, actual.Bbox)
+	assert.Equal(t, 2, len(actual.Features))
+	assert.Equal(t, ResponseFeature{
+		Type: geoJSONFeature,
+		Id:   1,
+		Geometry: ResponseGeoJSONPoint{
+			Type:        geoJSONPoint,
+			Coordinates: [2]float64{-114.0719, 51.0447},
+		},
+		Properties: ResponseFeatureProperties{
+			Id:   1,
+			Name: "calgary",
+			Tags: []string{"a", "b"},
+		},
+	}, actual.Features[0])
+
+	// An empty FeatureCollection has no bbox, and still serializes its features as an array
+	empty, err := json.Marshal(cacheModelsToFeatureCollection(nil, 0))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"type": "FeatureCollection", "features": []}`, string(empty))
+}
+
+func TestLongitudeBounds(t *testing.T) {
+	testData := []struct {
+		longs      []float64
+		west, east float64
+	}{
+		{longs: []float64{-114.0719}, west: -114.0719, east: -114.0719},
+		{longs: []float64{10, -10, 5}, west: -10, east: 10},
+		{longs: []float64{179, -179, 178}, west: 178, east: -179},
+		{longs: []float64{-180, 180}, west: 180, east: -180},
+		{longs: []float64{-170, 10, 170}, west: 10, east: -170},
+	}
+	for _, td := range testData {
+		west, east := longitudeBounds(td.longs)
+		assert.Equal(t, td.west, west, "%v", td.longs)
+		assert.Equal(t, td.east, east, "%v", td.longs)
+	}
+}
+
+func TestGeoJSONContentNegotiation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	cache := model.Cache{Id: 7, Name: "s7", Lat: 39, Long: -77}
+	mockService.EXPECT().GetByName("s7").Return(cache, nil).AnyTimes()
+
+	router := gin.Default()
+	router.GET("/geocaches/:name", server.getCacheByNameHandler)
+
+	testData := []struct {
+		query       string
+		accept      string
+		code        int
+		contentType string
+	}{
+		{query: "", accept: "", code: 200, contentType: gin.MIMEJSON},
+		{query: "", accept: "application/json", code: 200, contentType: gin.MIMEJSON},
+		{query: "", accept: "application/geo+json", code: 200, contentType: geoJSONContentType},
+		{query: "?format=geojson", accept: "", code: 200, contentType: geoJSONContentType},
+		// The query arg takes precedence over the Accept header
+		{
+			query:       "?format=json",
+			accept:      "application/geo+json",
+			code:        200,
+			contentType: gin.MIMEJSON,
+		},
+		{query: "?format=kml", accept: "", code: 400, contentType: problemContentType},
+	}
+	for _, td := range testData {
+		req, _ := http.NewRequest("GET", "/geocaches/s7"+td.query, nil)
+		if td.accept != "" {
+			req.Header.Set("Accept", td.accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, td.code, w.Code, td)
+		assert.Contains(t, w.Header().Get("Content-Type"), td.contentType, td)
+		if td.contentType == geoJSONContentType {
+			var actual ResponseFeature
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &actual))
+			assert.Equal(t, cacheModelToFeature(cache, 0), actual)
+		}
+	}
+}
+
+func TestGetNearestCachesHandlerGeoJSON(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	nearbyCaches := []model.NearbyCache{
+		{
+			Cache:    model.Cache{Id: 2, Name: "s2", Lat: 39.5, Long: -77.5},
+			Distance: 1500,
+			Bearing:  45,
+		},
+		{
+			Cache:    model.Cache{Id: 1, Name: "s1", Lat: 39, Long: -77},
+			Distance: 2500,
+			Bearing:  270,
+		},
+	}
+	mockService.EXPECT().FindNearest(39.2, -77.1, 5000.0, 10).Return(nearbyCaches, nil)
+
+	path := "/geocaches/nearest"
+	router := gin.Default()
+	router.GET(path, server.getNearestCachesHandler)
+	req, _ := http.NewRequest(
+		"GET", path+"?lat=39.2&long=-77.1&maxdistance=5&limit=10&units=km&format=geojson", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var actual ResponseFeatureCollection
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &actual))
+	assert.Equal(t, []float64{-77.5, 39, -77, 39.5}, actual.Bbox)
+	assert.Equal(t, 2, len(actual.Features))
+	assert.Equal(t, uint64(2), actual.Features[0].Id)
+	assert.Equal(t, 1.5, *actual.Features[0].Properties.Distance)
+	assert.Equal(t, 45.0, *actual.Features[0].Properties.Bearing)
+	assert.Equal(t, uint64(1), actual.Features[1].Id)
+	assert.Equal(t, 2.5, *actual.Features[1].Properties.Distance)
+}
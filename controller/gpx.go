@@ -0,0 +1,349 @@
+package controller
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/model"
+	log "github.com/rchapin/rlog"
+)
+
+const (
+	gpxContentType = "application/gpx+xml"
+	gpxHeader      = `<gpx version="1.1" creator="go-geocache-api" ` +
+		`xmlns="http://www.topografix.com/GPX/1/1">`
+	gpxFooter = "</gpx>\n"
+	// gpxTypeSeparator separates the components of a waypoint's <type>, for example
+	// "Geocache|Traditional Cache".  We map each component to a tag.
+	gpxTypeSeparator = "|"
+	// maxGpxImportBytes limits the size of a GPX document that can be imported in one request.
+	maxGpxImportBytes = 32 << 20
+	// gpxExportPageSize is the number of caches read from the service and written to the response
+	// at a time when exporting all of the caches.
+	gpxExportPageSize = 1000
+)
+
+// gpxWaypoint is a <wpt> element in a GPX document.  We only read and write the subset of the
+// elements that map onto a geocache.  The coordinates are strings so that we can detect and report
+// missing or malformed values rather than silently treating them as 0.
+type gpxWaypoint struct {
+	XMLName xml.Name `xml:"wpt"`
+	Lat     string   `xml:"lat,attr"`
+	Lon     string   `xml:"lon,attr"`
+	Name    string   `xml:"name,omitempty"`
+	Type    string   `xml:"type,omitempty"`
+	Sym     string   `xml:"sym,omitempty"`
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+// ResponseGpxImportCache is a geocache that was, or in a dry run would be, created from a waypoint.
+type ResponseGpxImportCache struct {
+	Id   uint64 `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// ResponseGpxImportConflict is a waypoint that was not imported and the reason why.
+type ResponseGpxImportConflict struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+type ResponseGpxImport struct {
+	DryRun    bool                        `json:"dry_run"`
+	Created   []ResponseGpxImportCache    `json:"created"`
+	Conflicts []ResponseGpxImportConflict `json:"conflicts"`
+}
+
+// waypointToRequestPostCache converts a waypoint into a cache to be created, mapping its <type>
+// components and <sym> to tags.
+func waypointToRequestPostCache(wpt gpxWaypoint) (RequestPostCache, error) {
+	name := strings.TrimSpace(wpt.Name)
+	if name == "" {
+		return RequestPostCache{}, errors.New("waypoint is missing a name")
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(wpt.Lat), 64)
+	if err != nil {
+		return RequestPostCache{}, fmt.Errorf("invalid latitude; lat=%s", wpt.Lat)
+	}
+	long, err := strconv.ParseFloat(strings.TrimSpace(wpt.Lon), 64)
+	if err != nil {
+		return RequestPostCache{}, fmt.Errorf("invalid longitude; lon=%s", wpt.Lon)
+	}
+	if err := model.ValidateLocation(lat, long); err != nil {
+		return RequestPostCache{}, err
+	}
+
+	tagSet := make(map[string]bool)
+	for _, t := range append(strings.Split(wpt.Type, gpxTypeSeparator), wpt.Sym) {
+		if t = strings.TrimSpace(t); t != "" {
+			tagSet[t] = true
+		}
+	}
+	var tags []string
+	for t := range tagSet {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+
+	return RequestPostCache{Name: name, Lat: lat, Long: long, Tags: tags}, nil
+}
+
+// cacheModelToWaypoint converts a cache into a waypoint, joining its tags into the <type>.
+func cacheModelToWaypoint(cache model.Cache) gpxWaypoint {
+	rc := cacheModelToResponseCache(cache, 0)
+	return gpxWaypoint{
+		Lat:  strconv.FormatFloat(rc.Lat, 'f', -1, 64),
+		Lon:  strconv.FormatFloat(rc.Long, 'f', -1, 64),
+		Name: rc.Name,
+		Type: strings.Join(rc.Tags, gpxTypeSeparator),
+	}
+}
+
+// importGpxHandler creates a geocache for each of the waypoints in the GPX document in the request
+// body.  Waypoints that are invalid, that have the same name as an earlier waypoint in the
+// document, or that have the same name as an existing geocache are not imported and are reported
+// as conflicts.  With the 'dry_run=true' query arg nothing is created, and the response reports
+// what would have been.
+func (s *Controller) importGpxHandler(c *gin.Context) {
+	dryRun := c.DefaultQuery("dry_run", "") == "true"
+
+	var doc gpxDocument
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxGpxImportBytes)
+	if err := xml.NewDecoder(body).Decode(&doc); err != nil {
+		writeBadRequest(c, fmt.Sprintf("unable to parse GPX; err=%s", err))
+		return
+	}
+
+	retval := ResponseGpxImport{
+		DryRun:    dryRun,
+		Created:   []ResponseGpxImportCache{},
+		Conflicts: []ResponseGpxImportConflict{},
+	}
+	// The conflict of each of the waypoints, if it has one, so that they are reported in the order
+	// of the document, and the caches for the rest with the index of their waypoints.
+	conflicts := make([]*ResponseGpxImportConflict, len(doc.Waypoints))
+	var caches []model.NewCache
+	var waypoints []int
+	seen := make(map[string]bool, len(doc.Waypoints))
+	for i, wpt := range doc.Waypoints {
+		rc, err := waypointToRequestPostCache(wpt)
+		if err != nil {
+			conflicts[i] = &ResponseGpxImportConflict{Name: wpt.Name, Reason: err.Error()}
+			continue
+		}
+		if seen[rc.Name] {
+			conflicts[i] = &ResponseGpxImportConflict{
+				Name:   rc.Name,
+				Reason: "an earlier waypoint in the document has the same name",
+			}
+			continue
+		}
+		seen[rc.Name] = true
+		caches = append(caches, model.NewCache{
+			Name: rc.Name, Lat: rc.Lat, Long: rc.Long, Tags: rc.Tags})
+		waypoints = append(waypoints, i)
+	}
+
+	var results []model.CreateResult
+	if dryRun {
+		results = make([]model.CreateResult, len(caches))
+		for i, cache := range caches {
+			existing, err := s.service.GetByName(cache.Name)
+			var notFoundErr *model.CacheNotFoundErr
+			switch {
+			case err == nil:
+				results[i].Err = &model.ConflictErr{Id: existing.Id, Name: existing.Name}
+			case !errors.As(err, &notFoundErr):
+				// Only a cache that is not found is absent; the store failing is not a conflict
+				writeErr(c, err)
+				return
+			}
+		}
+	} else {
+		// The caches are created as one batch, rather than one at a time, so that the store can
+		// add all of them to its GeoStore at once.
+		var err error
+		results, err = s.service.CreateMany(caches)
+		if err != nil {
+			writeErr(c, err)
+			return
+		}
+	}
+	for i, result := range results {
+		name := caches[i].Name
+		var conflictErr *model.ConflictErr
+		switch {
+		case errors.As(result.Err, &conflictErr):
+			conflicts[waypoints[i]] = &ResponseGpxImportConflict{
+				Name:   name,
+				Reason: "a geocache with the same name already exists",
+			}
+		case result.Err != nil:
+			conflicts[waypoints[i]] = &ResponseGpxImportConflict{
+				Name:   name,
+				Reason: result.Err.Error(),
+			}
+		default:
+			retval.Created = append(
+				retval.Created,
+				ResponseGpxImportCache{Id: result.Id, Name: name},
+			)
+		}
+	}
+	for _, conflict := range conflicts {
+		if conflict != nil {
+			retval.Conflicts = append(retval.Conflicts, *conflict)
+		}
+	}
+
+	c.JSON(http.StatusOK, retval)
+}
+
+// exportGpxHandler streams the geocaches as a GPX document.  It supports the same filters as the
+// other query endpoints: the 'tags', 'geohash' and 'q' filters of the list endpoint, the nearest
+// neighbor args 'lat', 'long', 'maxdistance' and 'limit', or a 'bbox'.  Without any filters all of
+// the geocaches are exported.
+func (s *Controller) exportGpxHandler(c *gin.Context) {
+	filters, err := parseCacheFilters(c)
+	if err != nil {
+		return
+	}
+	var next func() ([]model.Cache, error)
+
+	switch {
+	case filters.tags != nil:
+		next = singlePage(func() ([]model.Cache, error) {
+			return s.service.FindByTagQuery(filters.tags)
+		})
+	case filters.geohash != "":
+		next = pages(func(afterId uint64) ([]model.Cache, error) {
+			return s.service.FindInGeohash(filters.geohash, afterId, gpxExportPageSize)
+		})
+	case filters.nameQuery != "":
+		// The same caches as the name search lists, in the order that they were ranked
+		limit, err := parseLimitArg(c)
+		if err != nil {
+			return
+		}
+		next = singlePage(func() ([]model.Cache, error) {
+			ranked, err := s.service.SearchNames(filters.nameQuery, limit)
+			caches := make([]model.Cache, len(ranked))
+			for i, rc := range ranked {
+				caches[i] = rc.Cache
+			}
+			return caches, err
+		})
+	case c.DefaultQuery("lat", "") != "" || c.DefaultQuery("long", "") != "":
+		args, err := parseNearestArgs(c)
+		if err != nil {
+			return
+		}
+		next = singlePage(func() ([]model.Cache, error) {
+			nearbyCaches, err := s.service.FindNearest(
+				args.lat, args.long, args.maxDistance, args.limit)
+			caches := make([]model.Cache, len(nearbyCaches))
+			for i, nc := range nearbyCaches {
+				caches[i] = nc.Cache
+			}
+			return caches, err
+		})
+	case c.DefaultQuery("bbox", "") != "":
+		minLat, minLong, maxLat, maxLong, err := parseBbox(c.DefaultQuery("bbox", ""))
+		if err != nil {
+			writeBadRequest(c, err.Error())
+			return
+		}
+		next = pages(func(afterId uint64) ([]model.Cache, error) {
+			return s.service.FindInBox(minLat, minLong, maxLat, maxLong, afterId, gpxExportPageSize)
+		})
+	default:
+		next = pages(func(afterId uint64) ([]model.Cache, error) {
+			return s.service.GetAll(afterId, gpxExportPageSize)
+		})
+	}
+
+	// Read the first page before writing anything so that we can still return an error status.
+	caches, err := next()
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	c.Header("Content-Type", gpxContentType)
+	c.Header("Content-Disposition", `attachment; filename="geocaches.gpx"`)
+	c.Status(http.StatusOK)
+	if err := writeGpx(c.Writer, caches, next); err != nil {
+		// We have already sent the status and part of the document, so all that we can do is log
+		// the error and stop writing.
+		log.Errorf("error writing GPX export; err=%s", err)
+	}
+}
+
+// singlePage returns a func that returns the result of read on its first call, and nothing
+// thereafter.
+func singlePage(read func() ([]model.Cache, error)) func() ([]model.Cache, error) {
+	done := false
+	return func() ([]model.Cache, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
+		return read()
+	}
+}
+
+// pages returns a func that will return successive pages of caches, in ascending id order, from
+// the provided read func until there are none left.
+func pages(read func(afterId uint64) ([]model.Cache, error)) func() ([]model.Cache, error) {
+	var afterId uint64
+	return func() ([]model.Cache, error) {
+		caches, err := read(afterId)
+		if err != nil || len(caches) == 0 {
+			return nil, err
+		}
+		afterId = caches[len(caches)-1].Id
+		return caches, nil
+	}
+}
+
+// writeGpx writes the GPX document, starting with the provided caches and then each subsequent
+// page returned from next, flushing each page to the client as it is written.
+func writeGpx(w gin.ResponseWriter, caches []model.Cache, next func() ([]model.Cache, error)) error {
+	if _, err := io.WriteString(w, xml.Header+gpxHeader+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("  ", "  ")
+	footer := gpxFooter
+	for len(caches) > 0 {
+		// The encoder does not end the last element with a newline
+		footer = "\n" + gpxFooter
+		for _, cache := range caches {
+			if err := enc.Encode(cacheModelToWaypoint(cache)); err != nil {
+				return err
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		w.Flush()
+
+		var err error
+		if caches, err = next(); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, footer)
+	return err
+}
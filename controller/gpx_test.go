@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaypointToRequestPostCache(t *testing.T) {
+	actual, err := waypointToRequestPostCache(gpxWaypoint{
+		Lat:  " 51.0465 ",
+		Lon:  "-114.0624",
+		Name: " GC12345 ",
+		Type: "Geocache|Traditional Cache",
+		Sym:  "Geocache",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, RequestPostCache{
+		Name: "GC12345",
+		Lat:  51.0465,
+		Long: -114.0624,
+		Tags: []string{"Geocache", "Traditional Cache"},
+	}, actual)
+
+	invalid := []gpxWaypoint{
+		{Lat: "51", Lon: "-114"},
+		{Lat: "", Lon: "-114", Name: "no-lat"},
+		{Lat: "51", Lon: "west", Name: "bad-lon"},
+		{Lat: "-91", Lon: "-114", Name: "out-of-range"},
+		{Lat: "NaN", Lon: "-114", Name: "nan"},
+	}
+	for _, wpt := range invalid {
+		_, err := waypointToRequestPostCache(wpt)
+		assert.NotNil(t, err, "%+v", wpt)
+	}
+}
+
+func TestImportGpxHandlerDryRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	// In a dry run we only look up the names, and never create anything
+	mockService := mocks.NewMockService(mockCtrl)
+	mockService.EXPECT().GetByName("new").Return(model.Cache{}, &model.CacheNotFoundErr{})
+	mockService.EXPECT().GetByName("existing").Return(model.Cache{Id: 1, Name: "existing"}, nil)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	path := "/import/gpx"
+	router := gin.Default()
+	router.POST(path, server.importGpxHandler)
+	body := `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="1" lon="2"><name>new</name></wpt>
+  <wpt lat="1" lon="2"><name>existing</name></wpt>
+  <wpt lat="1" lon="2"><name>new</name></wpt>
+  <wpt lat="1"><name>no-lon</name></wpt>
+</gpx>`
+	req, _ := http.NewRequest("POST", path+"?dry_run=true", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var actual ResponseGpxImport
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &actual))
+	assert.True(t, actual.DryRun)
+	assert.Equal(t, []ResponseGpxImportCache{{Name: "new"}}, actual.Created)
+	assert.Equal(t, 3, len(actual.Conflicts))
+	assert.Equal(t, "existing", actual.Conflicts[0].Name)
+	assert.Equal(t, "new", actual.Conflicts[1].Name)
+	assert.Equal(t, "no-lon", actual.Conflicts[2].Name)
+
+	// A document that is not GPX is a bad request
+	req, _ = http.NewRequest("POST", path, strings.NewReader("not xml"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Only a cache that is not found is absent, and any other error fails the import
+	mockService.EXPECT().GetByName("new").Return(model.Cache{}, errors.New("database is locked"))
+	req, _ = http.NewRequest("POST", path+"?dry_run=true", strings.NewReader(body))
+	problem := serveProblem(t, router, req, http.StatusInternalServerError)
+	assert.Equal(t, CodeInternal, problem.Code)
+}
+
+func TestImportGpxHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	// The valid waypoints are created as one batch, and the caches that the store rejects are
+	// reported as conflicts in the order of the document
+	mockService := mocks.NewMockService(mockCtrl)
+	mockService.EXPECT().CreateMany([]model.NewCache{
+		{Name: "existing", Lat: 1, Long: 2},
+		{Name: "new", Lat: 3, Long: 4, Tags: []string{"Geocache"}},
+	}).Return([]model.CreateResult{
+		{Err: &model.ConflictErr{Id: 1, Name: "existing"}},
+		{Id: 7},
+	}, nil)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	path := "/import/gpx"
+	router := gin.Default()
+	router.POST(path, server.importGpxHandler)
+	body := `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="1" lon="2"><name>existing</name></wpt>
+  <wpt lat="1"><name>no-lon</name></wpt>
+  <wpt lat="3" lon="4"><name>new</name><type>Geocache</type></wpt>
+</gpx>`
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var actual ResponseGpxImport
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &actual))
+	assert.False(t, actual.DryRun)
+	assert.Equal(t, []ResponseGpxImportCache{{Id: 7, Name: "new"}}, actual.Created)
+	assert.Equal(t, 2, len(actual.Conflicts))
+	assert.Equal(t, "existing", actual.Conflicts[0].Name)
+	assert.Equal(t, "no-lon", actual.Conflicts[1].Name)
+}
+
+func TestExportGpxHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	// All of the caches are read a page at a time until an empty page is returned
+	mockService := mocks.NewMockService(mockCtrl)
+	gomock.InOrder(
+		mockService.EXPECT().GetAll(uint64(0), gpxExportPageSize).Return([]model.Cache{
+			{Id: 1, Name: "s1", Lat: 38.5, Long: -75.25, Tags: map[string]bool{"ocean": true}},
+			{Id: 3, Name: "s3", Lat: 37.75, Long: -122.5},
+		}, nil),
+		mockService.EXPECT().GetAll(uint64(3), gpxExportPageSize).Return([]model.Cache{
+			{
+				Id:   4,
+				Name: "s4",
+				Lat:  39.25,
+				Long: -77.75,
+				Tags: map[string]bool{"river": true, "flowrate": true},
+			},
+		}, nil),
+		mockService.EXPECT().GetAll(uint64(4), gpxExportPageSize).Return([]model.Cache{}, nil),
+	)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	path := "/export.gpx"
+	router := gin.Default()
+	router.GET(path, server.exportGpxHandler)
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, gpxContentType, w.Header().Get("Content-Type"))
+
+	var doc gpxDocument
+	assert.Nil(t, xml.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, []gpxWaypoint{
+		{Lat: "38.5", Lon: "-75.25", Name: "s1", Type: "ocean"},
+		{Lat: "37.75", Lon: "-122.5", Name: "s3"},
+		{Lat: "39.25", Lon: "-77.75", Name: "s4", Type: "flowrate|river"},
+	}, stripXMLNames(doc.Waypoints))
+}
+
+func TestExportGpxHandlerFilters(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	// The geohash filter is read a page at a time, and the name search is ranked as it is listed
+	mockService := mocks.NewMockService(mockCtrl)
+	calgary := model.Cache{Id: 2, Name: "calgary", Lat: 51.0447, Long: -114.0719}
+	gomock.InOrder(
+		mockService.EXPECT().FindInGeohash("c3nf", uint64(0), gpxExportPageSize).
+			Return([]model.Cache{calgary}, nil),
+		mockService.EXPECT().FindInGeohash("c3nf", uint64(2), gpxExportPageSize).
+			Return([]model.Cache{}, nil),
+		mockService.EXPECT().SearchNames("calgray", 1).
+			Return([]model.RankedCache{{Cache: calgary, Score: 0.6}}, nil),
+	)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	path := "/export.gpx"
+	router := gin.Default()
+	router.GET(path, server.exportGpxHandler)
+	for _, query := range []string{"?geohash=c3nf", "?q=calgray&limit=1"} {
+		req, _ := http.NewRequest("GET", path+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, query)
+
+		var doc gpxDocument
+		assert.Nil(t, xml.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, []gpxWaypoint{{Lat: "51.0447", Lon: "-114.0719", Name: "calgary"}},
+			stripXMLNames(doc.Waypoints), query)
+	}
+}
+
+func stripXMLNames(waypoints []gpxWaypoint) []gpxWaypoint {
+	for i := range waypoints {
+		waypoints[i].XMLName = xml.Name{}
+	}
+	return waypoints
+}
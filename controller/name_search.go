@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/model"
+)
+
+// ResponseRankedCache is a cache that matched a name search, with the relevance of its name to the
+// search as a score between 0 and 1.
+type ResponseRankedCache struct {
+	ResponseCache
+	Score float64 `json:"score"`
+}
+
+// rankedCacheModelsToResponseRankedCaches converts the caches, preserving their order.
+func rankedCacheModelsToResponseRankedCaches(
+	caches []model.RankedCache,
+	geohashPrecision int,
+) []ResponseRankedCache {
+	retval := []ResponseRankedCache{}
+	for _, cache := range caches {
+		retval = append(retval, ResponseRankedCache{
+			ResponseCache: cacheModelToResponseCache(cache.Cache, geohashPrecision),
+			Score:         cache.Score,
+		})
+	}
+	return retval
+}
+
+// rankedCacheModelsToFeatureCollection converts the caches, preserving their order, with their
+// scores as a property.
+func rankedCacheModelsToFeatureCollection(
+	caches []model.RankedCache,
+	geohashPrecision int,
+) ResponseFeatureCollection {
+	features := make([]ResponseFeature, len(caches))
+	for i, cache := range caches {
+		score := cache.Score
+		features[i] = cacheModelToFeature(cache.Cache, geohashPrecision)
+		features[i].Properties.Score = &score
+	}
+	return newFeatureCollection(features)
+}
+
+// getCachesByNameQueryHandler returns up to 'limit' of the caches whose names match the name
+// search, ranked by relevance.  Unlike getCacheByNameHandler the name does not need to be exact:
+// the words of the search match the words of the names ignoring case and accents, as prefixes and
+// with a few typos.
+func (s *Controller) getCachesByNameQueryHandler(
+	c *gin.Context,
+	nameQuery string,
+	asGeoJSON bool,
+	geohashPrecision int,
+) {
+	limit, err := parseLimitArg(c)
+	if err != nil {
+		return
+	}
+
+	caches, err := s.service.SearchNames(nameQuery, limit)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	if asGeoJSON {
+		writeGeoJSON(c, http.StatusOK,
+			rankedCacheModelsToFeatureCollection(caches, geohashPrecision))
+		return
+	}
+	c.JSON(http.StatusOK, rankedCacheModelsToResponseRankedCaches(caches, geohashPrecision))
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCachesHandlerNameQuery(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	ranked := []model.RankedCache{
+		{Cache: model.Cache{Id: 2, Name: "Lake Louise", Lat: 51.4, Long: -116.2}, Score: 0.725},
+		{Cache: model.Cache{Id: 5, Name: "Lake Minnewanka", Lat: 51.3, Long: -115.4}, Score: 0.5},
+	}
+	gomock.InOrder(
+		mockService.EXPECT().SearchNames("lake lou", defaultPageLimit).Return(ranked, nil),
+		mockService.EXPECT().SearchNames("lake lou", 1).Return(ranked[:1], nil),
+		mockService.EXPECT().SearchNames("volcano", defaultPageLimit).
+			Return([]model.RankedCache{}, nil),
+	)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.GET(path, server.getCachesHandler)
+
+	// The caches are in the order that they were ranked, with their scores
+	req, _ := http.NewRequest("GET", path+"?q="+url.QueryEscape("lake lou"), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var caches []ResponseRankedCache
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &caches))
+	assert.Equal(t, 2, len(caches))
+	assert.Equal(t, "Lake Louise", caches[0].Name)
+	assert.Equal(t, 0.725, caches[0].Score)
+	assert.Equal(t, "Lake Minnewanka", caches[1].Name)
+
+	req, _ = http.NewRequest("GET", path+"?format=geojson&limit=1&q="+url.QueryEscape("lake lou"),
+		nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var fc ResponseFeatureCollection
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &fc))
+	assert.Equal(t, 1, len(fc.Features))
+	assert.Equal(t, 0.725, *fc.Features[0].Properties.Score)
+
+	req, _ = http.NewRequest("GET", path+"?q=volcano", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/model"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+	cursorPrefix     = "id:"
+)
+
+// ResponseCachePage is the envelope for a page of caches.  If there are more caches to be read,
+// NextCursor is set to the opaque cursor that should be passed as the 'cursor' query arg to read
+// the next page.
+type ResponseCachePage struct {
+	Items      []ResponseCache `json:"items"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor returns an opaque cursor that will resume reading a page after the provided id.
+func encodeCursor(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatUint(id, 10)))
+}
+
+// decodeCursor returns the id after which the page should resume.
+func decodeCursor(cursor string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	idStr, found := strings.CutPrefix(string(b), cursorPrefix)
+	if !found {
+		return 0, errors.New("malformed cursor")
+	}
+	return strconv.ParseUint(idStr, 10, 64)
+}
+
+// parsePageArgs will parse the 'cursor' and 'limit' query args.  If it is unable to parse them it
+// will set the proper response headers and error and then return the error to the caller.
+func parsePageArgs(c *gin.Context) (afterId uint64, limit int, err error) {
+	if limit, err = parseLimitArg(c); err != nil {
+		return 0, 0, err
+	}
+
+	if cursor := c.DefaultQuery("cursor", ""); cursor != "" {
+		afterId, err = decodeCursor(cursor)
+		if err != nil {
+			writeBadRequest(c, fmt.Sprintf("invalid cursor; cursor=%s", cursor))
+			return 0, 0, err
+		}
+	}
+	return afterId, limit, nil
+}
+
+// parseLimitArg will parse the 'limit' query arg of a page, which defaults to defaultPageLimit.  If
+// it is unable to parse it it will set the proper response headers and error and then return the
+// error to the caller.
+func parseLimitArg(c *gin.Context) (int, error) {
+	limitStr := c.DefaultQuery("limit", "")
+	if limitStr == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := parseQueryArg(c, "limit", limitStr, strconv.Atoi)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || limit > maxPageLimit {
+		err = fmt.Errorf("limit must be between 1 and %d; limit=%d", maxPageLimit, limit)
+		writeBadRequest(c, err.Error())
+		return 0, err
+	}
+	return limit, nil
+}
+
+// newResponseCachePage builds a page from caches that were read with a limit of one more than the
+// page limit, so that we know whether there is another page to be read without an extra query.
+func newResponseCachePage(
+	caches []model.Cache,
+	limit int,
+	geohashPrecision int,
+) ResponseCachePage {
+	caches, nextCursor := trimPage(caches, limit)
+	retval := ResponseCachePage{Items: []ResponseCache{}, NextCursor: nextCursor}
+	for _, cache := range caches {
+		retval.Items = append(retval.Items, cacheModelToResponseCache(cache, geohashPrecision))
+	}
+	return retval
+}
+
+// newFeatureCollectionPage builds a page as a GeoJSON FeatureCollection, in the same way as
+// newResponseCachePage.
+func newFeatureCollectionPage(
+	caches []model.Cache,
+	limit int,
+	geohashPrecision int,
+) ResponseFeatureCollection {
+	caches, nextCursor := trimPage(caches, limit)
+	retval := cacheModelsToFeatureCollection(caches, geohashPrecision)
+	retval.NextCursor = nextCursor
+	return retval
+}
+
+// trimPage trims caches that were read with a limit of one more than the page limit down to the
+// page limit, and returns the cursor for the next page if there is one.
+func trimPage(caches []model.Cache, limit int) ([]model.Cache, string) {
+	if len(caches) <= limit {
+		return caches, ""
+	}
+	caches = caches[:limit]
+	return caches, encodeCursor(caches[limit-1].Id)
+}
+
+// writeCachePage writes the page of caches either as a ResponseCachePage or, if asGeoJSON, as a
+// GeoJSON FeatureCollection.
+func writeCachePage(
+	c *gin.Context,
+	caches []model.Cache,
+	limit int,
+	asGeoJSON bool,
+	geohashPrecision int,
+) {
+	if asGeoJSON {
+		writeGeoJSON(c, http.StatusOK, newFeatureCollectionPage(caches, limit, geohashPrecision))
+		return
+	}
+	c.JSON(http.StatusOK, newResponseCachePage(caches, limit, geohashPrecision))
+}
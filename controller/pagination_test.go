@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, id := range []uint64{0, 1, 42, 18446744073709551615} {
+		actual, err := decodeCursor(encodeCursor(id))
+		assert.Nil(t, err)
+		assert.Equal(t, id, actual)
+	}
+
+	for _, cursor := range []string{"!!!", "NDI", encodeCursor(1)[1:]} {
+		_, err := decodeCursor(cursor)
+		assert.NotNil(t, err, cursor)
+	}
+}
+
+func TestGetAllCachesHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	// The handler should request one more cache than the limit to determine whether there is a
+	// next page.
+	gomock.InOrder(
+		mockService.EXPECT().GetAll(uint64(0), 3).Return(
+			[]model.Cache{{Id: 1, Name: "s1"}, {Id: 2, Name: "s2"}, {Id: 5, Name: "s5"}},
+			nil,
+		),
+		mockService.EXPECT().GetAll(uint64(2), 3).Return(
+			[]model.Cache{{Id: 5, Name: "s5"}},
+			nil,
+		),
+	)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.GET(path, server.getCachesHandler)
+
+	req, _ := http.NewRequest("GET", path+"?limit=2", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	var page ResponseCachePage
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 2, len(page.Items))
+	assert.Equal(t, encodeCursor(2), page.NextCursor)
+
+	req, _ = http.NewRequest("GET", path+"?limit=2&cursor="+page.NextCursor, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	page = ResponseCachePage{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 1, len(page.Items))
+	assert.Equal(t, uint64(5), page.Items[0].Id)
+	assert.Equal(t, "", page.NextCursor)
+}
+
+func TestGetAllCachesHandlerGeoJSON(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	mockService.EXPECT().GetAll(uint64(0), 3).Return(
+		[]model.Cache{
+			{Id: 1, Name: "s1", Lat: 10, Long: 20},
+			{Id: 2, Name: "s2", Lat: -10, Long: 30},
+			{Id: 5, Name: "s5", Lat: 80, Long: 80},
+		},
+		nil,
+	)
+
+	path := "/geocaches"
+	router := gin.Default()
+	router.GET(path, server.getCachesHandler)
+	req, _ := http.NewRequest("GET", path+"?limit=2", nil)
+	req.Header.Set("Accept", geoJSONContentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), geoJSONContentType)
+
+	// The bbox only covers the features in the page
+	var page ResponseFeatureCollection
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, geoJSONFeatureCollection, page.Type)
+	assert.Equal(t, []float64{20, -10, 30, 10}, page.Bbox)
+	assert.Equal(t, 2, len(page.Features))
+	assert.Equal(t, encodeCursor(2), page.NextCursor)
+}
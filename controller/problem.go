@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/rchapin/go-geocache-api/service"
+	log "github.com/rchapin/rlog"
+)
+
+const problemContentType = "application/problem+json"
+
+// The codes of each type of problem.  Unlike the detail, which is meant to be read by people and
+// may change, they are stable so that clients can branch on them.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidationFailed = "validation_failed"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeUnauthorized     = "unauthorized"
+	CodeInternal         = "internal"
+)
+
+// ResponseProblem is an RFC 7807 problem details body, extended with the machine readable code of
+// the problem and, for a CodeValidationFailed problem, the details of each of the invalid fields.
+// For a CodeConflict problem with an existing cache, Existing is the path of that cache.
+type ResponseProblem struct {
+	Type     string           `json:"type"`
+	Title    string           `json:"title"`
+	Status   int              `json:"status"`
+	Detail   string           `json:"detail,omitempty"`
+	Instance string           `json:"instance,omitempty"`
+	Code     string           `json:"code"`
+	Fields   []model.FieldErr `json:"fields,omitempty"`
+	Existing string           `json:"existing,omitempty"`
+}
+
+// newProblem returns a problem whose type is only described by its status and code, and so, per
+// RFC 7807, is "about:blank" with the title of the status.
+func newProblem(status int, code, detail string) ResponseProblem {
+	return ResponseProblem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// errToProblem maps an error returned by the service to a problem.  Any error that is not one of
+// the typed errors of the model or service is an internal error, and because it may expose the
+// internals of the server its detail is not included.
+func errToProblem(err error) ResponseProblem {
+	var (
+		validationErr   *model.ValidationErr
+		notFoundErr     *model.CacheNotFoundErr
+		conflictErr     *model.ConflictErr
+		unauthorizedErr *service.UnauthorizedErr
+	)
+	switch {
+	case errors.As(err, &validationErr):
+		problem := newProblem(http.StatusUnprocessableEntity, CodeValidationFailed, err.Error())
+		problem.Fields = validationErr.Fields
+		return problem
+	case errors.As(err, &notFoundErr):
+		return newProblem(http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.As(err, &conflictErr):
+		problem := newProblem(http.StatusConflict, CodeConflict, err.Error())
+		if conflictErr.Name != "" {
+			problem.Existing = "/v" + apiVersion + "/geocaches/" + url.PathEscape(conflictErr.Name)
+		}
+		return problem
+	case errors.As(err, &unauthorizedErr):
+		return newProblem(http.StatusUnauthorized, CodeUnauthorized, err.Error())
+	default:
+		return newProblem(
+			http.StatusInternalServerError, CodeInternal, "an internal error occurred")
+	}
+}
+
+// writeProblem writes the problem, as application/problem+json, and aborts the request.
+func writeProblem(c *gin.Context, problem ResponseProblem) {
+	problem.Instance = c.Request.URL.Path
+	if problem.Existing != "" {
+		c.Header("Location", problem.Existing)
+	}
+	// gin will not override a Content-Type that has already been set
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// writeBadRequest writes a CodeBadRequest problem with the provided detail.
+func writeBadRequest(c *gin.Context, detail string) {
+	writeProblem(c, newProblem(http.StatusBadRequest, CodeBadRequest, detail))
+}
+
+// writeErr writes the problem that the error returned by the service maps to.
+func writeErr(c *gin.Context, err error) {
+	problem := errToProblem(err)
+	if problem.Code == CodeInternal {
+		log.Errorf("internal error handling request; path=%s, err=%s", c.Request.URL.Path, err)
+	}
+	writeProblem(c, problem)
+}
+
+// noRouteHandler writes a CodeNotFound problem for requests that do not match any route.
+func noRouteHandler(c *gin.Context) {
+	writeProblem(c, newProblem(http.StatusNotFound, CodeNotFound, "no such route"))
+}
+
+// recoveryHandler writes a CodeInternal problem for a request whose handler panicked.
+func recoveryHandler(c *gin.Context, recovered any) {
+	writeProblem(c, newProblem(
+		http.StatusInternalServerError, CodeInternal, "an internal error occurred"))
+}
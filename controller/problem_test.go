@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/rchapin/go-geocache-api/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrToProblem(t *testing.T) {
+	testData := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{err: model.ValidateLocation(91, 0), status: 422, code: CodeValidationFailed},
+		{err: &model.CacheNotFoundErr{}, status: 404, code: CodeNotFound},
+		{err: &model.ConflictErr{Reason: "taken"}, status: 409, code: CodeConflict},
+		{err: &service.UnauthorizedErr{Reason: "nope"}, status: 401, code: CodeUnauthorized},
+		// Wrapped errors are mapped by the type of the error that they wrap
+		{
+			err:    fmt.Errorf("updating; err=%w", &model.CacheNotFoundErr{}),
+			status: 404,
+			code:   CodeNotFound,
+		},
+		{err: errors.New("disk full"), status: 500, code: CodeInternal},
+	}
+	for _, td := range testData {
+		problem := errToProblem(td.err)
+		assert.Equal(t, td.status, problem.Status, td.err.Error())
+		assert.Equal(t, td.code, problem.Code, td.err.Error())
+		assert.Equal(t, "about:blank", problem.Type)
+		assert.Equal(t, http.StatusText(td.status), problem.Title)
+	}
+
+	// The details of internal errors are not exposed
+	assert.NotContains(t, errToProblem(errors.New("disk full")).Detail, "disk full")
+}
+
+// serveProblem serves the request and returns the problem in the response, after asserting that
+// it is application/problem+json with the expected status.
+func serveProblem(
+	t *testing.T,
+	router *gin.Engine,
+	req *http.Request,
+	expectedStatus int,
+) ResponseProblem {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, expectedStatus, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	var problem ResponseProblem
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, expectedStatus, problem.Status)
+	assert.Equal(t, req.URL.Path, problem.Instance)
+	assert.Equal(t, problem.Existing, w.Header().Get("Location"))
+	return problem
+}
+
+func TestHandlerProblems(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	mockService.EXPECT().Update("oregon", gomock.Any()).
+		Return(model.Cache{}, &model.CacheNotFoundErr{})
+	mockService.EXPECT().GetAll(uint64(0), defaultPageLimit+1).
+		Return(nil, errors.New("database is locked"))
+
+	router := gin.New()
+	router.Use(gin.CustomRecovery(recoveryHandler))
+	router.NoRoute(noRouteHandler)
+	router.GET("/geocaches", server.getCachesHandler)
+	router.PUT("/geocaches/:name", server.putCacheByNameHandler)
+	router.GET("/panic", func(c *gin.Context) { panic("oops") })
+
+	// Updating a cache that does not exist is not found, rather than an internal error
+	req, _ := http.NewRequest("PUT", "/geocaches/oregon", strings.NewReader(`{"lat":1,"long":1}`))
+	problem := serveProblem(t, router, req, http.StatusNotFound)
+	assert.Equal(t, CodeNotFound, problem.Code)
+
+	req, _ = http.NewRequest("GET", "/geocaches", nil)
+	problem = serveProblem(t, router, req, http.StatusInternalServerError)
+	assert.Equal(t, CodeInternal, problem.Code)
+	assert.NotContains(t, problem.Detail, "locked")
+
+	req, _ = http.NewRequest("GET", "/geocaches?limit=0", nil)
+	problem = serveProblem(t, router, req, http.StatusBadRequest)
+	assert.Equal(t, CodeBadRequest, problem.Code)
+	assert.Equal(t, "limit must be between 1 and 1000; limit=0", problem.Detail)
+
+	req, _ = http.NewRequest("GET", "/nowhere", nil)
+	problem = serveProblem(t, router, req, http.StatusNotFound)
+	assert.Equal(t, CodeNotFound, problem.Code)
+
+	req, _ = http.NewRequest("GET", "/panic", nil)
+	problem = serveProblem(t, router, req, http.StatusInternalServerError)
+	assert.Equal(t, CodeInternal, problem.Code)
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/rchapin/go-geocache-api/model"
+)
+
+// RequestSearchNear is the near filter of a search.  The maxdistance, and the distances in the
+// response, are in the units, which default to meters.  A maxdistance of 0 does not filter the
+// caches, so that they can be sorted by distance.
+type RequestSearchNear struct {
+	Lat         float64 `json:"lat"`
+	Long        float64 `json:"long"`
+	MaxDistance float64 `json:"maxdistance"`
+	Units       string  `json:"units"`
+}
+
+// RequestSearch is a combination of filters, all of which a cache must match.  At most one of the
+// geometry filters, Near, Bbox and Polygon, may be provided.  The Bbox is in the same order as a
+// GeoJSON bbox, and the Polygon is a GeoJSON Polygon, MultiPolygon or Feature with one of them as
+// its geometry.  Tags is a tag query, in the same language as the 'tags' query arg.
+type RequestSearch struct {
+	Near    *RequestSearchNear `json:"near"`
+	Bbox    []float64          `json:"bbox"`
+	Polygon *RequestGeoJSON    `json:"polygon"`
+	Tags    string             `json:"tags"`
+	Name    string             `json:"name"`
+	Sort    string             `json:"sort"`
+	Limit   int                `json:"limit"`
+}
+
+// ResponseSearchCache is a cache that matched a search.  The Distance and Bearing are only included
+// if the search has the near filter.
+type ResponseSearchCache struct {
+	ResponseCache
+	Distance *float64 `json:"distance,omitempty"`
+	Bearing  *float64 `json:"bearing,omitempty"`
+}
+
+// ResponseSearch is the caches that matched a search, and the index with which they were found.
+type ResponseSearch struct {
+	Items []ResponseSearchCache `json:"items"`
+	Index string                `json:"index"`
+}
+
+// searchRequestToQuery converts the request into a model.SearchQuery, and returns the number of
+// meters in the units of its distances.  If the request is invalid it will set the proper
+// response headers and error and then return the error to the caller.
+func searchRequestToQuery(
+	c *gin.Context,
+	rs *RequestSearch,
+) (query model.SearchQuery, metersPerUnit float64, err error) {
+	metersPerUnit = 1
+	if rs.Near != nil {
+		units := rs.Near.Units
+		if units == "" {
+			units = "m"
+		}
+		var ok bool
+		if metersPerUnit, ok = distanceUnits[units]; !ok {
+			err = fmt.Errorf("invalid units; units=%s, valid units are m, km, mi and nmi", units)
+			writeBadRequest(c, err.Error())
+			return model.SearchQuery{}, 0, err
+		}
+		if err = model.ValidateLocation(rs.Near.Lat, rs.Near.Long); err != nil {
+			writeErr(c, err)
+			return model.SearchQuery{}, 0, err
+		}
+		query.Near = &model.SearchNear{
+			Lat:         rs.Near.Lat,
+			Long:        rs.Near.Long,
+			MaxDistance: rs.Near.MaxDistance * metersPerUnit,
+		}
+	}
+	if rs.Bbox != nil {
+		var b geostore.Box
+		b.MinLat, b.MinLong, b.MaxLat, b.MaxLong, err = bboxFromValues(
+			rs.Bbox, fmt.Sprint(rs.Bbox))
+		if err != nil {
+			writeBadRequest(c, err.Error())
+			return model.SearchQuery{}, 0, err
+		}
+		query.Box = &b
+	}
+	if rs.Polygon != nil {
+		if query.Polygons, err = geoJSONToPolygons(rs.Polygon); err != nil {
+			writeBadRequest(c, err.Error())
+			return model.SearchQuery{}, 0, err
+		}
+	}
+	if rs.Tags != "" {
+		if query.Tags, err = parseTagQuery(c, rs.Tags); err != nil {
+			return model.SearchQuery{}, 0, err
+		}
+	}
+	query.Name = rs.Name
+	query.Sort = model.SearchSort(rs.Sort)
+
+	query.Limit = rs.Limit
+	if query.Limit == 0 {
+		query.Limit = defaultPageLimit
+	}
+	if query.Limit < 1 || query.Limit > maxPageLimit {
+		err = fmt.Errorf("limit must be between 1 and %d; limit=%d", maxPageLimit, query.Limit)
+		writeBadRequest(c, err.Error())
+		return model.SearchQuery{}, 0, err
+	}
+
+	if err = query.Validate(); err != nil {
+		writeBadRequest(c, err.Error())
+		return model.SearchQuery{}, 0, err
+	}
+	return query, metersPerUnit, nil
+}
+
+// searchHandler returns the caches that match every filter of the search in the request body, in
+// a single request.  The caches are sorted by id, name or, with the near filter, distance, and the
+// response includes the index that was used to find them.
+func (s *Controller) searchHandler(c *gin.Context) {
+	var rs RequestSearch
+	if err := parseJSON[RequestSearch](c, &rs); err != nil {
+		return
+	}
+	query, metersPerUnit, err := searchRequestToQuery(c, &rs)
+	if err != nil {
+		return
+	}
+	geohashPrecision, err := parseGeohashPrecision(c)
+	if err != nil {
+		return
+	}
+
+	result, err := s.service.Search(query)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	retval := ResponseSearch{Items: []ResponseSearchCache{}, Index: result.Index}
+	for _, cache := range result.Caches {
+		item := ResponseSearchCache{
+			ResponseCache: cacheModelToResponseCache(cache.Cache, geohashPrecision),
+		}
+		if query.Near != nil {
+			distance, bearing := cache.Distance/metersPerUnit, cache.Bearing
+			item.Distance, item.Bearing = &distance, &bearing
+		}
+		retval.Items = append(retval.Items, item)
+	}
+	c.JSON(http.StatusOK, retval)
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+	tags, _ := model.ParseTagQuery("ocean AND NOT archived")
+	cove := model.Cache{Id: 3, Name: "cove", Lat: 38.4, Long: -75.1}
+	cove.Tags = map[string]bool{"ocean": true}
+	gomock.InOrder(
+		// The maxdistance and distances are converted from and to the units
+		mockService.EXPECT().Search(model.SearchQuery{
+			Near:  &model.SearchNear{Lat: 38.39, Long: -75.06, MaxDistance: 5000},
+			Tags:  tags,
+			Name:  "cove",
+			Sort:  model.SearchSortDistance,
+			Limit: 10,
+		}).Return(model.SearchResult{
+			Caches: []model.NearbyCache{{Cache: cove, Distance: 3500, Bearing: 270}},
+			Index:  model.SearchIndexTags,
+		}, nil),
+		mockService.EXPECT().Search(model.SearchQuery{
+			Box:   &geostore.Box{MinLat: 38, MinLong: -76, MaxLat: 39, MaxLong: -75},
+			Limit: defaultPageLimit,
+		}).Return(model.SearchResult{
+			Caches: []model.NearbyCache{{Cache: cove}},
+			Index:  model.SearchIndexGeometry,
+		}, nil),
+	)
+
+	path := "/geocaches/search"
+	router := gin.Default()
+	router.POST(path, server.searchHandler)
+
+	body := `{
+		"near": {"lat": 38.39, "long": -75.06, "maxdistance": 5, "units": "km"},
+		"tags": "ocean AND NOT archived",
+		"name": "cove",
+		"sort": "distance",
+		"limit": 10
+	}`
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"items": [{
+			"id": 3, "name": "cove", "lat": 38.4, "long": -75.1, "tags": ["ocean"],
+			"distance": 3.5, "bearing": 270
+		}],
+		"index": "tags"
+	}`, w.Body.String())
+
+	// Distances are only included with the near filter
+	req, _ = http.NewRequest("POST", path, strings.NewReader(`{"bbox": [-76, 38, -75, 39]}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Items []map[string]any `json:"items"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 1, len(response.Items))
+	assert.NotContains(t, response.Items[0], "distance")
+}
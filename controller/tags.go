@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rchapin/go-geocache-api/model"
+)
+
+const tagCursorPrefix = "tag:"
+
+// ResponseTagPage is the envelope for a page of tags, with the number of caches with each of them.
+// If there are more tags to be read, NextCursor is set to the opaque cursor that should be passed
+// as the 'cursor' query arg to read the next page.
+type ResponseTagPage struct {
+	Items      []model.TagCount `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// encodeTagCursor returns an opaque cursor that will resume reading a page of tags after the
+// provided tag.  It includes the count so that it can resume a page in either order.
+func encodeTagCursor(after model.TagCount) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(tagCursorPrefix + strconv.Itoa(after.Count) + ":" + after.Tag))
+}
+
+// decodeTagCursor returns the tag after which the page should resume.
+func decodeTagCursor(cursor string) (model.TagCount, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return model.TagCount{}, err
+	}
+	countAndTag, found := strings.CutPrefix(string(b), tagCursorPrefix)
+	if !found {
+		return model.TagCount{}, errors.New("malformed cursor")
+	}
+	countStr, tag, found := strings.Cut(countAndTag, ":")
+	if !found || tag == "" {
+		return model.TagCount{}, errors.New("malformed cursor")
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil {
+		return model.TagCount{}, err
+	}
+	return model.TagCount{Tag: tag, Count: count}, nil
+}
+
+// parseTagPageArgs will parse the 'sort', 'cursor' and 'limit' query args of a page of tags.  If
+// it is unable to parse them it will set the proper response headers and error and then return
+// the error to the caller.
+func parseTagPageArgs(
+	c *gin.Context,
+) (order model.TagSort, after model.TagCount, limit int, err error) {
+	order = model.TagSort(c.DefaultQuery("sort", string(model.TagSortCount)))
+	if order != model.TagSortCount && order != model.TagSortName {
+		err = fmt.Errorf("sort must be one of %s or %s; sort=%s",
+			model.TagSortCount, model.TagSortName, order)
+		writeBadRequest(c, err.Error())
+		return "", model.TagCount{}, 0, err
+	}
+	if limit, err = parseLimitArg(c); err != nil {
+		return "", model.TagCount{}, 0, err
+	}
+	if cursor := c.DefaultQuery("cursor", ""); cursor != "" {
+		after, err = decodeTagCursor(cursor)
+		if err != nil {
+			writeBadRequest(c, fmt.Sprintf("invalid cursor; cursor=%s", cursor))
+			return "", model.TagCount{}, 0, err
+		}
+	}
+	return order, after, limit, nil
+}
+
+// getTagsHandler returns a page of the tags, with the number of caches with each of them.  Only
+// the tags that start with the optional 'prefix' are returned, for autocompletion.  They are
+// sorted by descending count, or by name with 'sort=name'.
+func (s *Controller) getTagsHandler(c *gin.Context) {
+	order, after, limit, err := parseTagPageArgs(c)
+	if err != nil {
+		return
+	}
+
+	tags, err := s.service.ListTags(c.DefaultQuery("prefix", ""), order, after, limit+1)
+	if err != nil {
+		writeErr(c, err)
+		return
+	}
+
+	retval := ResponseTagPage{Items: tags}
+	if len(tags) > limit {
+		retval.Items = tags[:limit]
+		retval.NextCursor = encodeTagCursor(tags[limit-1])
+	}
+	c.JSON(http.StatusOK, retval)
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/rchapin/go-geocache-api/mocks"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagCursorRoundTrip(t *testing.T) {
+	tags := []model.TagCount{
+		{Tag: "ocean", Count: 3}, {Tag: "Traditional Cache", Count: 1}, {Tag: "a:b", Count: 10},
+	}
+	for _, tag := range tags {
+		actual, err := decodeTagCursor(encodeTagCursor(tag))
+		assert.Nil(t, err)
+		assert.Equal(t, tag, actual)
+	}
+
+	for _, cursor := range []string{"!!!", encodeCursor(1), encodeTagCursor(tags[0])[1:]} {
+		_, err := decodeTagCursor(cursor)
+		assert.NotNil(t, err, cursor)
+	}
+}
+
+func TestGetTagsHandler(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	mockService := mocks.NewMockService(mockCtrl)
+	server := NewController(ctx, cancel, wg, mockService, "8080")
+
+	// The handler should request one more tag than the limit to determine whether there is a next
+	// page, and tags are sorted by count unless requested otherwise.
+	ocean := model.TagCount{Tag: "ocean", Count: 3}
+	gomock.InOrder(
+		mockService.EXPECT().ListTags("oce", model.TagSortCount, model.TagCount{}, 2).Return(
+			[]model.TagCount{ocean, {Tag: "oceanic", Count: 1}}, nil),
+		mockService.EXPECT().ListTags("oce", model.TagSortCount, ocean, 2).Return(
+			[]model.TagCount{{Tag: "oceanic", Count: 1}}, nil),
+		mockService.EXPECT().ListTags("", model.TagSortName, model.TagCount{}, defaultPageLimit+1).
+			Return([]model.TagCount{}, nil),
+	)
+
+	path := "/tags"
+	router := gin.Default()
+	router.GET(path, server.getTagsHandler)
+
+	req, _ := http.NewRequest("GET", path+"?prefix=oce&limit=1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page ResponseTagPage
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, []model.TagCount{ocean}, page.Items)
+	assert.Equal(t, encodeTagCursor(ocean), page.NextCursor)
+
+	req, _ = http.NewRequest("GET", path+"?prefix=oce&limit=1&cursor="+page.NextCursor, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	page = ResponseTagPage{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, []model.TagCount{{Tag: "oceanic", Count: 1}}, page.Items)
+	assert.Equal(t, "", page.NextCursor)
+
+	req, _ = http.NewRequest("GET", path+"?sort=name", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"items":[]}`, w.Body.String())
+}
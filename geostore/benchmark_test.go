@@ -0,0 +1,121 @@
+package geostore_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+)
+
+// benchmarkGeoStores are the implementations compared by each of the benchmarks.
+var benchmarkGeoStores = []struct {
+	name    string
+	factory func() geostore.GeoStore
+}{
+	{
+		name: "quadtree",
+		factory: func() geostore.GeoStore {
+			quadrant := geostore.NewQuadrant(-180, -90, 180, 90, true)
+			qt := geostore.NewQuadTree(1, quadrant, 4)
+			return geostore.NewGeoStoreInMem(qt, geostore.Haversine)
+		},
+	},
+	{
+		name: "geohash",
+		factory: func() geostore.GeoStore {
+			return geostore.NewGeohashGeoStore(geostore.Haversine)
+		},
+	},
+	{
+		name: "rtree",
+		factory: func() geostore.GeoStore {
+			return geostore.NewRTreeGeoStore(geostore.Haversine)
+		},
+	},
+}
+
+// benchmarkSizes are the number of Nodes in the GeoStore for the query benchmarks.
+var benchmarkSizes = []int{1000, 100000}
+
+// randomNodes returns n Nodes, with ids starting at 1, that are uniformly distributed in latitude
+// and longitude.
+func randomNodes(r *rand.Rand, n int) []*geostore.Node {
+	retval := make([]*geostore.Node, n)
+	for i := range retval {
+		retval[i] = geostore.NewNode(r.Float64()*360-180, r.Float64()*180-90, uint64(i+1))
+	}
+	return retval
+}
+
+// runQueryBenchmark runs query against a GeoStore, of each implementation and size, that has been
+// loaded with random Nodes by a single InsertMany.
+func runQueryBenchmark(b *testing.B, query func(g geostore.GeoStore, r *rand.Rand)) {
+	for _, size := range benchmarkSizes {
+		nodes := randomNodes(rand.New(rand.NewSource(42)), size)
+		for _, bg := range benchmarkGeoStores {
+			g := bg.factory()
+			g.InsertMany(nodes)
+			b.Run(fmt.Sprintf("%s/nodes=%d", bg.name, size), func(b *testing.B) {
+				r := rand.New(rand.NewSource(7))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					query(g, r)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	for _, bg := range benchmarkGeoStores {
+		b.Run(bg.name, func(b *testing.B) {
+			nodes := randomNodes(rand.New(rand.NewSource(42)), b.N)
+			g := bg.factory()
+			b.ResetTimer()
+			for _, n := range nodes {
+				g.Insert(n)
+			}
+		})
+	}
+}
+
+// BenchmarkInsertMany loads each GeoStore, of each size, with a single InsertMany.
+func BenchmarkInsertMany(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		nodes := randomNodes(rand.New(rand.NewSource(42)), size)
+		for _, bg := range benchmarkGeoStores {
+			b.Run(fmt.Sprintf("%s/nodes=%d", bg.name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					bg.factory().InsertMany(nodes)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMove(b *testing.B) {
+	runQueryBenchmark(b, func(g geostore.GeoStore, r *rand.Rand) {
+		id := uint64(r.Intn(benchmarkSizes[0]) + 1)
+		_ = g.Move(id, r.Float64()*180-90, r.Float64()*360-180)
+	})
+}
+
+func BenchmarkFindNearest(b *testing.B) {
+	runQueryBenchmark(b, func(g geostore.GeoStore, r *rand.Rand) {
+		g.FindNearest(r.Float64()*180-90, r.Float64()*360-180, 0, 10)
+	})
+}
+
+func BenchmarkFindNearestRadius(b *testing.B) {
+	runQueryBenchmark(b, func(g geostore.GeoStore, r *rand.Rand) {
+		g.FindNearest(r.Float64()*180-90, r.Float64()*360-180, 500000, 0)
+	})
+}
+
+func BenchmarkFindInBox(b *testing.B) {
+	runQueryBenchmark(b, func(g geostore.GeoStore, r *rand.Rand) {
+		lat, long := r.Float64()*170-85, r.Float64()*350-175
+		g.FindInBox(lat-5, long-5, lat+5, long+5)
+	})
+}
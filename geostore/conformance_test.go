@@ -0,0 +1,35 @@
+package geostore_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/rchapin/go-geocache-api/geostore/geostoretest"
+)
+
+func TestInMemGeoStoreConformance(t *testing.T) {
+	// A max capacity of 1 exercises deeply subdivided QuadTrees
+	for _, maxCapacity := range []int{1, 4, 16} {
+		maxCapacity := maxCapacity
+		t.Run(fmt.Sprintf("maxCapacity=%d", maxCapacity), func(t *testing.T) {
+			geostoretest.Run(t, func(t *testing.T) geostore.GeoStore {
+				quadrant := geostore.NewQuadrant(-180, -90, 180, 90, true)
+				qt := geostore.NewQuadTree(1, quadrant, maxCapacity)
+				return geostore.NewGeoStoreInMem(qt, geostore.Haversine)
+			})
+		})
+	}
+}
+
+func TestGeohashGeoStoreConformance(t *testing.T) {
+	geostoretest.Run(t, func(t *testing.T) geostore.GeoStore {
+		return geostore.NewGeohashGeoStore(geostore.Haversine)
+	})
+}
+
+func TestRTreeGeoStoreConformance(t *testing.T) {
+	geostoretest.Run(t, func(t *testing.T) geostore.GeoStore {
+		return geostore.NewRTreeGeoStore(geostore.Haversine)
+	})
+}
@@ -0,0 +1,237 @@
+package geostore
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// EarthRadiusMeters is the mean radius of the Earth as defined by the IUGG.
+	EarthRadiusMeters = 6371008.8
+
+	// WGS84 ellipsoid parameters used for the Vincenty distance calculation.
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+
+	vincentyMaxIterations = 200
+	vincentyTolerance     = 1e-12
+
+	// quadrantBoundSlack scales the spherical lower bound of the distance to a Quadrant so that it
+	// remains a lower bound for the ellipsoidal distance, which can differ from the spherical one
+	// by up to ~0.5%.
+	quadrantBoundSlack = 0.99
+)
+
+const (
+	DistanceModelHaversine = "haversine"
+	DistanceModelVincenty  = "vincenty"
+)
+
+// DistanceFunc returns the distance, in meters, between two gps coordinates.
+type DistanceFunc func(lat1, long1, lat2, long2 float64) float64
+
+// NewDistanceFunc returns the DistanceFunc for the named distance model.
+func NewDistanceFunc(model string) (DistanceFunc, error) {
+	switch model {
+	case DistanceModelHaversine:
+		return Haversine, nil
+	case DistanceModelVincenty:
+		return Vincenty, nil
+	default:
+		return nil, fmt.Errorf("unknown distance model; model=%s", model)
+	}
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func toDegrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// Haversine returns the great-circle distance, in meters, between two gps coordinates on a sphere
+// with the mean radius of the Earth.
+func Haversine(lat1, long1, lat2, long2 float64) float64 {
+	return EarthRadiusMeters * centralAngle(lat1, long1, lat2, long2)
+}
+
+// centralAngle returns the angle, in radians, between two gps coordinates using the haversine
+// formula.
+func centralAngle(lat1, long1, lat2, long2 float64) float64 {
+	phi1 := toRadians(lat1)
+	phi2 := toRadians(lat2)
+	dPhi := toRadians(lat2 - lat1)
+	dLambda := toRadians(wrapLongitude(long2 - long1))
+
+	sinDPhi := math.Sin(dPhi / 2)
+	sinDLambda := math.Sin(dLambda / 2)
+	a := sinDPhi*sinDPhi + math.Cos(phi1)*math.Cos(phi2)*sinDLambda*sinDLambda
+	// Guard against floating point error pushing a out of the domain of asin.
+	return 2 * math.Asin(math.Sqrt(math.Min(1, a)))
+}
+
+// Vincenty returns the distance, in meters, between two gps coordinates on the WGS84 ellipsoid
+// using Vincenty's inverse formula.  The formula does not converge for nearly antipodal points, in
+// which case we fall back to the Haversine distance.
+func Vincenty(lat1, long1, lat2, long2 float64) float64 {
+	dLong := wrapLongitude(long2 - long1)
+	if lat1 == lat2 && dLong == 0 {
+		return 0
+	}
+
+	l := toRadians(dLong)
+	u1 := math.Atan((1 - wgs84F) * math.Tan(toRadians(lat1)))
+	u2 := math.Atan((1 - wgs84F) * math.Tan(toRadians(lat2)))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := l
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+	converged := false
+	for i := 0; i < vincentyMaxIterations; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Sqrt(
+			(cosU2*sinLambda)*(cosU2*sinLambda) +
+				(cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda),
+		)
+		if sinSigma == 0 {
+			// Coincident points
+			return 0
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		} else {
+			// Both points are on the equator
+			cos2SigmaM = 0
+		}
+		c := wgs84F / 16 * cosSqAlpha * (4 + wgs84F*(4-3*cosSqAlpha))
+		prevLambda := lambda
+		lambda = l + (1-c)*wgs84F*sinAlpha*
+			(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prevLambda) < vincentyTolerance {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return Haversine(lat1, long1, lat2, long2)
+	}
+
+	uSq := cosSqAlpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	a := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	b := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := b * sinSigma * (cos2SigmaM + b/4*
+		(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			b/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+	return wgs84B * a * (sigma - deltaSigma)
+}
+
+// InitialBearing returns the initial compass bearing, in degrees in the range [0, 360), of the
+// great-circle path from the first gps coordinate to the second.  0 is due North and 90 is due
+// East.
+func InitialBearing(lat1, long1, lat2, long2 float64) float64 {
+	phi1 := toRadians(lat1)
+	phi2 := toRadians(lat2)
+	dLambda := toRadians(long2 - long1)
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	return math.Mod(toDegrees(math.Atan2(y, x))+360, 360)
+}
+
+// wrapLongitude returns the longitude, or difference between two longitudes, in degrees wrapped
+// into the range [-180, 180], so that a difference that crosses the antimeridian is the short way
+// around the globe rather than nearly 360 degrees.
+func wrapLongitude(long float64) float64 {
+	if long >= -180 && long <= 180 {
+		return long
+	}
+	return math.Mod(math.Mod(long+180, 360)+360, 360) - 180
+}
+
+// longitudeDelta returns the absolute difference, in degrees, between two longitudes taking the
+// shortest way around the globe, such that the result is in [0, 180].
+func longitudeDelta(long1, long2 float64) float64 {
+	d := math.Mod(math.Abs(long1-long2), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+// minDistanceToQuadrant returns a lower bound, in meters, of the distance between the gps
+// coordinate and any point within the Quadrant.  It is computed on a sphere and then scaled by
+// quadrantBoundSlack so that it is a valid bound for any of our DistanceFuncs.
+func minDistanceToQuadrant(lat, long float64, q *Quadrant) float64 {
+	longMin, latMin := AbsoluteToGps(q.XMin, q.YMin)
+	longMax, latMax := AbsoluteToGps(q.XMax, q.YMax)
+
+	if long >= longMin && long <= longMax {
+		// The closest point in the Quadrant is directly North or South along the meridian, unless
+		// we are within the Quadrant.
+		clampedLat := math.Max(latMin, math.Min(latMax, lat))
+		return quadrantBoundSlack * EarthRadiusMeters * toRadians(math.Abs(lat-clampedLat))
+	}
+
+	// Otherwise, the closest point is on one of the meridian edges of the Quadrant.
+	return quadrantBoundSlack * math.Min(
+		minDistanceToMeridian(lat, long, longMin, latMin, latMax),
+		minDistanceToMeridian(lat, long, longMax, latMin, latMax),
+	)
+}
+
+// minDistanceToMeridian returns the spherical distance, in meters, between the gps coordinate and
+// the closest point on the segment of the meridian at meridianLong between latMin and latMax.
+func minDistanceToMeridian(lat, long, meridianLong, latMin, latMax float64) float64 {
+	dLong := longitudeDelta(long, meridianLong)
+	if dLong >= 90 {
+		// The closest point on the meridian is the pole in our hemisphere.  Moving away from it,
+		// the distance increases up to the antipode of the closest point on the opposite meridian
+		// and then decreases again towards the other pole, so the closest point in the segment is
+		// one of its ends.
+		return math.Min(
+			Haversine(lat, long, latMin, meridianLong),
+			Haversine(lat, long, latMax, meridianLong),
+		)
+	}
+
+	// Find the latitude of the point on the meridian closest to our coordinate.  The distance
+	// increases monotonically moving away from it along the meridian, so the closest point in the
+	// segment is the closest point clamped to the segment.
+	closestLat := toDegrees(math.Atan(math.Tan(toRadians(lat)) / math.Cos(toRadians(dLong))))
+	closestLat = math.Max(latMin, math.Min(latMax, closestLat))
+	return Haversine(lat, long, closestLat, meridianLong)
+}
+
+// BoundingBox returns a box that contains every gps coordinate within radius meters of the provided
+// coordinate for any of our DistanceFuncs.  If the box crosses the antimeridian minLong will be
+// greater than maxLong, and if it includes a pole it spans all longitudes.
+func BoundingBox(lat, long, radius float64) (minLat, minLong, maxLat, maxLong float64) {
+	// The angular radius on a sphere, enlarged by quadrantBoundSlack so that it also bounds the
+	// ellipsoidal distance.
+	delta := toDegrees(radius / (quadrantBoundSlack * EarthRadiusMeters))
+	minLat, maxLat = lat-delta, lat+delta
+	if minLat <= -90 || maxLat >= 90 {
+		return math.Max(minLat, -90), -180, math.Min(maxLat, 90), 180
+	}
+
+	// The greatest difference in longitude of any point within the angular radius.
+	sinDLong := math.Sin(toRadians(delta)) / math.Cos(toRadians(lat))
+	if sinDLong >= 1 {
+		return minLat, -180, maxLat, 180
+	}
+	dLong := toDegrees(math.Asin(sinDLong))
+	minLong, maxLong = long-dLong, long+dLong
+	if minLong < -180 {
+		minLong += 360
+	}
+	if maxLong > 180 {
+		maxLong -= 360
+	}
+	return minLat, minLong, maxLat, maxLong
+}
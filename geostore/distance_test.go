@@ -0,0 +1,172 @@
+package geostore
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHaversine(t *testing.T) {
+	testData := []struct {
+		lat1, long1, lat2, long2, expected float64
+	}{
+		// Same point
+		{lat1: 53.6, long1: -106.7, lat2: 53.6, long2: -106.7, expected: 0},
+		// London to New York (mirrored longitudes, as commonly used to check the formula)
+		{lat1: 51.5007, long1: 0.1246, lat2: 40.6892, long2: 74.0445, expected: 5574848},
+		// One degree of longitude at the equator
+		{lat1: 0, long1: 0, lat2: 0, long2: 1, expected: 111195},
+		// Across the antimeridian
+		{lat1: 0, long1: 179.5, lat2: 0, long2: -179.5, expected: 111195},
+	}
+	for _, td := range testData {
+		actual := Haversine(td.lat1, td.long1, td.lat2, td.long2)
+		assert.InDelta(t, td.expected, actual, 1, "%+v", td)
+	}
+}
+
+func TestVincenty(t *testing.T) {
+	testData := []struct {
+		lat1, long1, lat2, long2, expected float64
+	}{
+		// Same point
+		{lat1: 53.6, long1: -106.7, lat2: 53.6, long2: -106.7, expected: 0},
+		// Flinders Peak to Buninyong, the example from Vincenty's original paper
+		{
+			lat1:     -37.95103341666667,
+			long1:    144.42486788888888,
+			lat2:     -37.65282113888889,
+			long2:    143.92649552777777,
+			expected: 54972.271,
+		},
+		// One degree of longitude at the equator on the WGS84 ellipsoid
+		{lat1: 0, long1: 0, lat2: 0, long2: 1, expected: 111319.491},
+	}
+	for _, td := range testData {
+		actual := Vincenty(td.lat1, td.long1, td.lat2, td.long2)
+		assert.InDelta(t, td.expected, actual, 0.001, "%+v", td)
+	}
+
+	// Nearly antipodal points do not converge and fall back to the haversine distance
+	assert.InDelta(t, Haversine(0, 0, 0.5, 179.7), Vincenty(0, 0, 0.5, 179.7), 1)
+}
+
+func TestDistanceAcrossAntimeridian(t *testing.T) {
+	for _, distance := range []DistanceFunc{Haversine, Vincenty} {
+		// The antimeridian is at both 180 and -180
+		assert.Equal(t, 0.0, distance(0, 180, 0, -180))
+		assert.Equal(t, 0.0, distance(-45, -180, -45, 180))
+		// The difference in longitude is the short way around the globe
+		assert.InDelta(t, distance(-17.8, -0.1, -17.8, 0.1), distance(-17.8, 179.9, -17.8, -179.9),
+			1e-6)
+		assert.InDelta(t, distance(60, 170, 61, 175), distance(60, -190, 61, -185), 1e-6)
+	}
+}
+
+func TestWrapLongitude(t *testing.T) {
+	testData := [][2]float64{
+		{0, 0}, {180, 180}, {-180, -180}, {359.8, -0.2}, {-359.8, 0.2}, {190, -170}, {-190, 170},
+		{360, 0}, {-360, 0}, {720.5, 0.5},
+	}
+	for _, td := range testData {
+		assert.InDelta(t, td[1], wrapLongitude(td[0]), 1e-9, "%+v", td)
+	}
+}
+
+func TestNewDistanceFunc(t *testing.T) {
+	for _, model := range []string{DistanceModelHaversine, DistanceModelVincenty} {
+		f, err := NewDistanceFunc(model)
+		assert.Nil(t, err)
+		assert.NotNil(t, f)
+	}
+	_, err := NewDistanceFunc("flat-earth")
+	assert.NotNil(t, err)
+}
+
+func TestMinDistanceToQuadrant(t *testing.T) {
+	quadrant := NewQuadrant(-10, -10, 10, 10, true)
+	// Within the Quadrant
+	assert.Equal(t, 0.0, minDistanceToQuadrant(5, 5, quadrant))
+
+	// The bound must never be greater than the distance to any point in the Quadrant.
+	points := [][2]float64{{40, 0}, {70, 60}, {-80, 170}, {0, -179}, {5, 100}, {89, -45}}
+	for _, p := range points {
+		bound := minDistanceToQuadrant(p[0], p[1], quadrant)
+		for lat := -10.0; lat <= 10; lat += 0.5 {
+			for long := -10.0; long <= 10; long += 0.5 {
+				assert.LessOrEqual(t, bound, Vincenty(p[0], p[1], lat, long), "%+v", p)
+			}
+		}
+	}
+}
+
+func TestMinDistanceToQuadrantOppositeSide(t *testing.T) {
+	// A Quadrant at the South pole on the opposite side of the globe, for which the closest point
+	// is the pole rather than the corner nearest to the North pole.
+	quadrant := NewQuadrant(170, -90, 180, -60, true)
+	for _, p := range [][2]float64{{45, 0}, {10, -20}, {-30, 10}} {
+		bound := minDistanceToQuadrant(p[0], p[1], quadrant)
+		for lat := -90.0; lat <= -60; lat += 0.5 {
+			for long := 170.0; long <= 180; long += 0.5 {
+				assert.LessOrEqual(t, bound, Vincenty(p[0], p[1], lat, long), "%+v", p)
+			}
+		}
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	// A box that crosses the antimeridian
+	minLat, minLong, maxLat, maxLong := BoundingBox(0, 179.5, 200000)
+	assert.Greater(t, minLong, maxLong)
+	assert.InDelta(t, -1.8, minLat, 0.1)
+	assert.InDelta(t, 1.8, maxLat, 0.1)
+
+	// A box that includes the North pole
+	minLat, minLong, maxLat, maxLong = BoundingBox(89, 0, 200000)
+	assert.Equal(t, []float64{-180, 90, 180}, []float64{minLong, maxLat, maxLong})
+	assert.Less(t, minLat, 89.0)
+
+	// Every coordinate within the radius must be within the box.
+	points := [][2]float64{{0, 0}, {51, -114}, {-33.9, 151.2}, {75, 179}, {-60, -179.9}}
+	for _, p := range points {
+		for _, radius := range []float64{1000, 50000, 500000, 3000000} {
+			minLat, minLong, maxLat, maxLong := BoundingBox(p[0], p[1], radius)
+			// Only scan the latitudes that could be within the radius.
+			latDelta := toDegrees(radius/EarthRadiusMeters) + 1
+			minScanLat, maxScanLat := math.Max(-90, p[0]-latDelta), math.Min(90, p[0]+latDelta)
+			for lat := minScanLat; lat <= maxScanLat; lat += 0.25 {
+				for long := -180.0; long <= 180; long += 0.25 {
+					if Vincenty(p[0], p[1], lat, long) > radius {
+						continue
+					}
+					inLong := long >= minLong && long <= maxLong
+					if minLong > maxLong {
+						inLong = long >= minLong || long <= maxLong
+					}
+					assert.True(t, lat >= minLat && lat <= maxLat && inLong,
+						"point=%v, radius=%f, lat=%f, long=%f", p, radius, lat, long)
+				}
+			}
+		}
+	}
+}
+
+func TestInitialBearing(t *testing.T) {
+	testData := []struct {
+		lat1, long1, lat2, long2, expected float64
+	}{
+		{lat1: 0, long1: 0, lat2: 1, long2: 0, expected: 0},
+		{lat1: 0, long1: 0, lat2: 0, long2: 1, expected: 90},
+		{lat1: 1, long1: 0, lat2: 0, long2: 0, expected: 180},
+		{lat1: 0, long1: 1, lat2: 0, long2: 0, expected: 270},
+		// Across the antimeridian the shortest path is East
+		{lat1: 0, long1: 179.5, lat2: 0, long2: -179.5, expected: 90},
+		// Calgary to Edmonton is just East of North
+		{lat1: 51.0465, long1: -114.0624, lat2: 53.6789, long2: -113.3717, expected: 8.83},
+	}
+	for _, td := range testData {
+		actual := InitialBearing(td.lat1, td.long1, td.lat2, td.long2)
+		assert.InDelta(t, td.expected, actual, 0.01, "%+v", td)
+	}
+}
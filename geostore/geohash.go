@@ -0,0 +1,142 @@
+package geostore
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// geohashBase32 is the alphabet of geohashes, in which each character encodes 5 bits.
+	geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+	// GeohashMaxPrecision is the number of characters in a full precision geohash, a cell of
+	// roughly 37mm by 19mm at the equator.
+	GeohashMaxPrecision = 12
+)
+
+// geohashIndices maps each character of the geohash alphabet to its 5 bit value, and every other
+// byte to -1.
+var geohashIndices = func() [256]int8 {
+	var retval [256]int8
+	for i := range retval {
+		retval[i] = -1
+	}
+	for i := 0; i < len(geohashBase32); i++ {
+		retval[geohashBase32[i]] = int8(i)
+	}
+	return retval
+}()
+
+// EncodeGeohash returns the geohash of the gps coordinates with the provided number of characters,
+// up to GeohashMaxPrecision.
+func EncodeGeohash(lat, long float64, precision int) string {
+	x, y := GpsToAbsolute(long, lat)
+	return geohashAbsolute(x, y, precision)
+}
+
+// geohashAbsolute returns the geohash of the absolute coordinates.  Working with the absolute
+// coordinates means that a Node is always within the Quadrant of each of the cells of its geohash,
+// because the midpoints of the cells are exactly representable.
+func geohashAbsolute(x, y float64, precision int) string {
+	if precision > GeohashMaxPrecision {
+		precision = GeohashMaxPrecision
+	}
+	q := geohashRootQuadrant()
+	buf := make([]byte, precision)
+	for i := range buf {
+		c := 0
+		for bit := 0; bit < 5; bit++ {
+			// Even bits, counting from the start of the geohash, divide the longitude and odd bits
+			// the latitude.
+			c <<= 1
+			if (i*5+bit)%2 == 0 {
+				mid := (q.XMin + q.XMax) / 2
+				if x >= mid {
+					c |= 1
+					q.XMin = mid
+				} else {
+					q.XMax = mid
+				}
+			} else {
+				mid := (q.YMin + q.YMax) / 2
+				if y >= mid {
+					c |= 1
+					q.YMin = mid
+				} else {
+					q.YMax = mid
+				}
+			}
+		}
+		buf[i] = geohashBase32[c]
+	}
+	return string(buf)
+}
+
+// DecodeGeohash returns the bounding box, in gps coordinates, of the cell of the geohash.
+func DecodeGeohash(hash string) (minLat, minLong, maxLat, maxLong float64, err error) {
+	q, err := geohashQuadrant(strings.ToLower(hash))
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	minLong, minLat = AbsoluteToGps(q.XMin, q.YMin)
+	maxLong, maxLat = AbsoluteToGps(q.XMax, q.YMax)
+	return minLat, minLong, maxLat, maxLong, nil
+}
+
+// geohashQuadrant returns the Quadrant of the cell of the lower case geohash.
+func geohashQuadrant(hash string) (Quadrant, error) {
+	q := geohashRootQuadrant()
+	for i := 0; i < len(hash); i++ {
+		c := geohashIndices[hash[i]]
+		if c < 0 {
+			return Quadrant{}, fmt.Errorf("invalid geohash; geohash=%s", hash)
+		}
+		q = geohashChildQuadrant(q, i, int(c))
+	}
+	return q, nil
+}
+
+// findInGeohashCell returns the ids, in ascending order, of the Nodes in the cell of the lower case
+// geohash with findInQuadrant, for the GeoStores that do not index their Nodes by geohash.  The
+// Quadrant of the cell includes its edges, which are shared with the neighboring cells, so we only
+// match the Nodes whose geohashes start with it.
+func findInGeohashCell(
+	hash string,
+	findInQuadrant func(box *Quadrant, match func(*Node) bool) []uint64,
+) ([]uint64, error) {
+	q, err := geohashQuadrant(hash)
+	if err != nil {
+		return nil, err
+	}
+	return sortedUniqueIds(findInQuadrant(&q, func(n *Node) bool {
+		return geohashAbsolute(n.X, n.Y, len(hash)) == hash
+	})), nil
+}
+
+// geohashRootQuadrant returns the Quadrant of the empty geohash, the whole globe.
+func geohashRootQuadrant() Quadrant {
+	return Quadrant{XMin: 0, YMin: 0, XMax: 360, YMax: 180}
+}
+
+// geohashChildQuadrant returns the Quadrant of the cell that is the character with the 5 bit value
+// c at index i of a geohash, within the Quadrant of the cell of the characters before it.
+func geohashChildQuadrant(q Quadrant, i, c int) Quadrant {
+	for bit := 0; bit < 5; bit++ {
+		set := c&(1<<(4-bit)) != 0
+		if (i*5+bit)%2 == 0 {
+			mid := (q.XMin + q.XMax) / 2
+			if set {
+				q.XMin = mid
+			} else {
+				q.XMax = mid
+			}
+		} else {
+			mid := (q.YMin + q.YMax) / 2
+			if set {
+				q.YMin = mid
+			} else {
+				q.YMax = mid
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,342 @@
+package geostore
+
+import (
+	"strings"
+	"sync"
+)
+
+// geohashLeafSize is the most Nodes that a cell of a GeohashGeoStore holds before it is split into
+// a cell for each of the next characters of their geohashes.
+const geohashLeafSize = 16
+
+// geohashEntry is a Node stored in a GeohashGeoStore along with its full precision geohash.
+type geohashEntry struct {
+	node *Node
+	hash string
+}
+
+// geohashCell is the set of Nodes whose geohashes share the prefix of the cell.  A cell either
+// holds its entries directly or, once it has more than geohashLeafSize of them, has a child cell for
+// each character that follows its prefix in their geohashes.
+type geohashCell struct {
+	// count is the number of Nodes in this cell, including those in all of its descendants.
+	count    int
+	entries  []geohashEntry
+	children *[32]*geohashCell
+}
+
+func (c *geohashCell) insert(entry geohashEntry, depth int) {
+	c.count++
+	if c.children != nil {
+		c.child(entry.hash, depth, true).insert(entry, depth+1)
+		return
+	}
+	c.entries = append(c.entries, entry)
+	// A cell at the full precision holds all of its entries, however many there are, because they
+	// are at the same coordinates as far as the geohash can tell.
+	if len(c.entries) > geohashLeafSize && depth < GeohashMaxPrecision {
+		c.children = &[32]*geohashCell{}
+		for _, e := range c.entries {
+			c.child(e.hash, depth, true).insert(e, depth+1)
+		}
+		c.entries = nil
+	}
+}
+
+// child returns the child cell for the character of the geohash at index depth, creating it if
+// create is true and it does not yet exist.
+func (c *geohashCell) child(hash string, depth int, create bool) *geohashCell {
+	i := geohashIndices[hash[depth]]
+	if c.children[i] == nil && create {
+		c.children[i] = &geohashCell{}
+	}
+	return c.children[i]
+}
+
+// remove removes the entry from this cell, or one of its descendants, returning true if it was
+// found.  Once a cell's descendants have no more than geohashLeafSize entries between them, they
+// are collapsed back into it.
+func (c *geohashCell) remove(entry geohashEntry, depth int) bool {
+	if c.children == nil {
+		for i, e := range c.entries {
+			if e.node.Id == entry.node.Id {
+				c.entries = append(c.entries[:i], c.entries[i+1:]...)
+				c.count--
+				return true
+			}
+		}
+		return false
+	}
+
+	child := c.child(entry.hash, depth, false)
+	if child == nil || !child.remove(entry, depth+1) {
+		return false
+	}
+	c.count--
+	if child.count == 0 {
+		c.children[geohashIndices[entry.hash[depth]]] = nil
+	}
+	if c.count <= geohashLeafSize {
+		c.entries = c.collect(make([]geohashEntry, 0, c.count))
+		c.children = nil
+	}
+	return true
+}
+
+// collect appends all of the entries in this cell and its descendants.
+func (c *geohashCell) collect(entries []geohashEntry) []geohashEntry {
+	if c.children == nil {
+		return append(entries, c.entries...)
+	}
+	for _, child := range c.children {
+		if child != nil {
+			entries = child.collect(entries)
+		}
+	}
+	return entries
+}
+
+// GeohashGeoStore is a GeoStore that indexes its Nodes by their geohashes.  The index is a prefix
+// tree in which each cell is a geohash prefix, which is subdivided into the 32 cells of the next
+// character of the geohash only once it holds more than geohashLeafSize Nodes, so that sparse
+// areas are not subdivided any more than needed.
+type GeohashGeoStore struct {
+	root *geohashCell
+	// entries indexes each of the Nodes by id so that we can find their geohashes, and thus the
+	// cells in which they are stored, when removing or moving them.
+	entries  map[uint64]geohashEntry
+	distance DistanceFunc
+	mux      *sync.RWMutex
+}
+
+func NewGeohashGeoStore(distance DistanceFunc) *GeohashGeoStore {
+	return &GeohashGeoStore{
+		root:     &geohashCell{},
+		entries:  make(map[uint64]geohashEntry),
+		distance: distance,
+		mux:      &sync.RWMutex{},
+	}
+}
+
+func (g *GeohashGeoStore) Distance(lat1, long1, lat2, long2 float64) float64 {
+	return g.distance(lat1, long1, lat2, long2)
+}
+
+// Find returns the id of a Node at exactly the provided lat/long coordinates, or 0 if there is not
+// one.
+func (g *GeohashGeoStore) Find(lat, long float64) uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	node := NewNode(long, lat, 0)
+	hash := geohashAbsolute(node.X, node.Y, GeohashMaxPrecision)
+	cell := g.root
+	for depth := 0; cell != nil && cell.children != nil; depth++ {
+		cell = cell.child(hash, depth, false)
+	}
+	if cell == nil {
+		return 0
+	}
+	for _, e := range cell.entries {
+		if e.node.X == node.X && e.node.Y == node.Y {
+			return e.node.Id
+		}
+	}
+	return 0
+}
+
+// geohashFrame is a cell of a GeohashGeoStore with the Quadrant of its prefix, and the depth of
+// the cell, which is the length of its prefix.
+type geohashFrame struct {
+	cell     *geohashCell
+	quadrant Quadrant
+	depth    int
+}
+
+func (g *GeohashGeoStore) rootFrame() geohashFrame {
+	return geohashFrame{cell: g.root, quadrant: geohashRootQuadrant()}
+}
+
+func geohashFrameBounds(f geohashFrame) Quadrant {
+	return f.quadrant
+}
+
+// expandGeohashFrame visits the child cells of the cell if it is split, or else its Nodes.
+func expandGeohashFrame(
+	f geohashFrame,
+	visitCell func(geohashFrame),
+	visitNode func(*Node),
+) {
+	if f.cell.children == nil {
+		for _, e := range f.cell.entries {
+			visitNode(e.node)
+		}
+		return
+	}
+	for i, child := range f.cell.children {
+		if child != nil {
+			visitCell(geohashFrame{
+				cell:     child,
+				quadrant: geohashChildQuadrant(f.quadrant, f.depth, i),
+				depth:    f.depth + 1,
+			})
+		}
+	}
+}
+
+// FindNearest will return a slice of the ids and distances of the nodes that are nearest to the
+// provided lat/long coordinates, ordered by ascending distance, with the same semantics for the
+// maxDistance and limit as InMemGeoStore.FindNearest.
+//
+// The search expands outwards from the cell containing the coordinates to its neighboring cells in
+// order of their distance, see findNearest.
+func (g *GeohashGeoStore) FindNearest(
+	lat, long, maxDistance float64,
+	limit int,
+) []Neighbor {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	return findNearest(lat, long, maxDistance, limit, g.distance, g.rootFrame(),
+		geohashFrameBounds, expandGeohashFrame)
+}
+
+// FindInBox will return the ids, in ascending order, of all of the nodes within the bounding box
+// defined by the provided gps coordinates, inclusive of its edges.  If minLong is greater than
+// maxLong the box is treated as crossing the antimeridian.
+func (g *GeohashGeoStore) FindInBox(minLat, minLong, maxLat, maxLong float64) []uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	var retval []uint64
+	for _, box := range boxToQuadrants(minLat, minLong, maxLat, maxLong) {
+		retval = append(retval, g.findInQuadrant(box, nil)...)
+	}
+	// A node exactly on the antimeridian can be in both halves of a box that crosses it.
+	return sortedUniqueIds(retval)
+}
+
+// FindInPolygons will return the ids, in ascending order, of all of the nodes within any of the
+// provided Polygons.  We first prune the cells down to those that intersect the bounding box of
+// each Polygon, and only then test whether each Node is within the Polygon.
+func (g *GeohashGeoStore) FindInPolygons(polygons []Polygon) []uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	var retval []uint64
+	for _, polygon := range polygons {
+		if len(polygon) == 0 || len(polygon[0]) == 0 {
+			continue
+		}
+		retval = append(retval, g.findInQuadrant(polygon.quadrant(), func(n *Node) bool {
+			lat, long := n.gps()
+			return polygon.Contains(lat, long)
+		})...)
+	}
+	// The Polygons of a MultiPolygon could overlap
+	return sortedUniqueIds(retval)
+}
+
+// FindInGeohash will return the ids, in ascending order, of all of the nodes within the cell of the
+// geohash.  Rather than searching the bounds of the cell, we descend the prefix tree to the cell of
+// the geohash, or the leaf that holds all of the nodes with it, and keep those with the prefix.
+func (g *GeohashGeoStore) FindInGeohash(geohash string) ([]uint64, error) {
+	geohash = strings.ToLower(geohash)
+	if _, err := geohashQuadrant(geohash); err != nil {
+		return nil, err
+	}
+
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	cell := g.root
+	for depth := 0; depth < len(geohash) && cell.children != nil; depth++ {
+		if cell = cell.child(geohash, depth, false); cell == nil {
+			return nil, nil
+		}
+	}
+	var retval []uint64
+	for _, e := range cell.collect(nil) {
+		if strings.HasPrefix(e.hash, geohash) {
+			retval = append(retval, e.node.Id)
+		}
+	}
+	return sortedUniqueIds(retval), nil
+}
+
+func (g *GeohashGeoStore) findInQuadrant(box *Quadrant, match func(*Node) bool) []uint64 {
+	return findInQuadrant(g.rootFrame(), box, match, geohashFrameBounds, expandGeohashFrame)
+}
+
+// Insert will insert the Node into the GeoStore, replacing any existing Node with the same id.
+// Nodes that are outside of the bounds of the globe are ignored.
+func (g *GeohashGeoStore) Insert(node *Node) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.insert(node)
+}
+
+// InsertMany inserts all of the Nodes, with the same semantics as calling Insert with each of them
+// in order, while only taking the lock once.
+func (g *GeohashGeoStore) InsertMany(nodes []*Node) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	for _, node := range nodes {
+		g.insert(node)
+	}
+}
+
+func (g *GeohashGeoStore) insert(node *Node) {
+	if existing, ok := g.entries[node.Id]; ok {
+		g.root.remove(existing, 0)
+		delete(g.entries, node.Id)
+	}
+	root := geohashRootQuadrant()
+	if !root.inQuadrant(node) {
+		return
+	}
+	entry := geohashEntry{node: node, hash: geohashAbsolute(node.X, node.Y, GeohashMaxPrecision)}
+	g.root.insert(entry, 0)
+	g.entries[node.Id] = entry
+}
+
+// Move will move the Node with the provided id to the new lat/long coordinates.  If the new
+// coordinates are out of the bounds of the globe the Node is left where it was.
+func (g *GeohashGeoStore) Move(id uint64, lat, long float64) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	existing, ok := g.entries[id]
+	if !ok {
+		return &NodeNotFoundErr{Id: id}
+	}
+	node := NewNode(long, lat, id)
+	root := geohashRootQuadrant()
+	if !root.inQuadrant(node) {
+		return &OutOfBoundsErr{Lat: lat, Long: long}
+	}
+
+	g.root.remove(existing, 0)
+	entry := geohashEntry{node: node, hash: geohashAbsolute(node.X, node.Y, GeohashMaxPrecision)}
+	g.root.insert(entry, 0)
+	g.entries[id] = entry
+	return nil
+}
+
+// Remove will remove the Node with the provided id from the GeoStore.
+func (g *GeohashGeoStore) Remove(id uint64) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	existing, ok := g.entries[id]
+	if !ok {
+		return &NodeNotFoundErr{Id: id}
+	}
+	g.root.remove(existing, 0)
+	delete(g.entries, id)
+	return nil
+}
+
+func (g *GeohashGeoStore) Shutdown() error {
+	return nil
+}
@@ -0,0 +1,64 @@
+package geostore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeGeohash(t *testing.T) {
+	testData := []struct {
+		lat, long float64
+		precision int
+		expected  string
+	}{
+		{lat: 42.6, long: -5.6, precision: 5, expected: "ezs42"},
+		{lat: 57.64911, long: 10.40744, precision: 11, expected: "u4pruydqqvj"},
+		{lat: 51.0447, long: -114.0719, precision: 7, expected: "c3nfkhr"},
+		{lat: -90, long: -180, precision: 3, expected: "000"},
+		{lat: 90, long: 180, precision: 3, expected: "zzz"},
+		// Precision is capped at the maximum
+		{lat: 0, long: 0, precision: 20, expected: "s00000000000"},
+	}
+	for _, td := range testData {
+		assert.Equal(t, td.expected, EncodeGeohash(td.lat, td.long, td.precision), "%+v", td)
+	}
+}
+
+func TestDecodeGeohash(t *testing.T) {
+	minLat, minLong, maxLat, maxLong, err := DecodeGeohash("ezs42")
+	assert.Nil(t, err)
+	assert.InDelta(t, 42.583, minLat, 0.001)
+	assert.InDelta(t, -5.625, minLong, 0.001)
+	assert.InDelta(t, 42.627, maxLat, 0.001)
+	assert.InDelta(t, -5.581, maxLong, 0.001)
+
+	// Upper case characters are accepted
+	_, _, _, _, err = DecodeGeohash("EZS42")
+	assert.Nil(t, err)
+
+	// The empty geohash is the whole globe
+	minLat, minLong, maxLat, maxLong, err = DecodeGeohash("")
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{-90, -180, 90, 180}, []float64{minLat, minLong, maxLat, maxLong})
+
+	// "a", "i", "l" and "o" are not in the alphabet
+	_, _, _, _, err = DecodeGeohash("ezs4a")
+	assert.NotNil(t, err)
+}
+
+func TestGeohashCellCollapses(t *testing.T) {
+	g := NewGeohashGeoStore(Haversine)
+	for i := 1; i <= geohashLeafSize+1; i++ {
+		g.Insert(NewNode(float64(i), float64(i), uint64(i)))
+	}
+	assert.NotNil(t, g.root.children)
+	assert.Equal(t, geohashLeafSize+1, g.root.count)
+
+	// Removing a single node brings the count back down to the leaf size
+	assert.Nil(t, g.Remove(1))
+	assert.Nil(t, g.root.children)
+	assert.Equal(t, geohashLeafSize, len(g.root.entries))
+	assert.Equal(t, uint64(2), g.Find(2, 2))
+	assert.Equal(t, uint64(0), g.Find(1, 1))
+}
@@ -1,12 +1,22 @@
 package geostore
 
-import "sync"
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
 
 //go:generate mockgen -destination=../mocks/mock_geostore.go -package=mocks   github.com/rchapin/go-geocache-api/geostore GeoStore
 
 // FIXME: This is not the best way to generate an id for each QuadTree, but it works for now.
 var quadTreeId uint64
 
+// maxQuadTreeLevel is the deepest that a QuadTree will be subdivided.  A QuadTree at this level
+// holds all of the Nodes inserted into it regardless of its max capacity, otherwise more than max
+// capacity Nodes at the same coordinates would be split forever.  At this level a QuadTree covering
+// the globe has subdivisions of less than a hundredth of a second of arc.
+const maxQuadTreeLevel = 30
+
 // GpsToAbsolute converts the x (longitude) and y (latitude) values of gps coordinates to absolute
 // values to be used on a grid that starts with 0,0 in the bottom-right corner, and ends with
 // xMax,yMax in the top-right corner.
@@ -14,12 +24,54 @@ func GpsToAbsolute(x, y float64) (xAbs, yAbs float64) {
 	return x + 180, y + 90
 }
 
+// AbsoluteToGps converts absolute grid values back into the x (longitude) and y (latitude) values
+// of gps coordinates.
+func AbsoluteToGps(xAbs, yAbs float64) (x, y float64) {
+	return xAbs - 180, yAbs - 90
+}
+
+const (
+	// GeoStoreQuadTree is an InMemGeoStore.
+	GeoStoreQuadTree = "quadtree"
+	// GeoStoreGeohash is a GeohashGeoStore.
+	GeoStoreGeohash = "geohash"
+	// GeoStoreRTree is an RTreeGeoStore.
+	GeoStoreRTree = "rtree"
+)
+
 type GeoStore interface {
+	// Distance returns the distance in meters between the coordinates, with the DistanceFunc that
+	// the GeoStore uses for FindNearest.
+	Distance(lat1, long1, lat2, long2 float64) float64
 	Find(lat, long float64) uint64
-	FindNearest(lat, long, maxDistance float64, limit int) []uint64
+	FindNearest(lat, long, maxDistance float64, limit int) []Neighbor
+	FindInBox(minLat, minLong, maxLat, maxLong float64) []uint64
+	FindInPolygons(polygons []Polygon) []uint64
+	// FindInGeohash returns the ids, in ascending order, of the nodes within the cell of the
+	// geohash, ignoring its case, or an error if it is not a valid geohash.
+	FindInGeohash(geohash string) ([]uint64, error)
 	Insert(node *Node)
+	InsertMany(nodes []*Node)
+	Move(id uint64, lat, long float64) error
+	Remove(id uint64) error
 	Shutdown() error
-	getRootQuadTree() *QuadTree
+}
+
+type NodeNotFoundErr struct {
+	Id uint64
+}
+
+func (e *NodeNotFoundErr) Error() string {
+	return fmt.Sprintf("Node not found; id=%d", e.Id)
+}
+
+type OutOfBoundsErr struct {
+	Lat, Long float64
+}
+
+func (e *OutOfBoundsErr) Error() string {
+	return fmt.Sprintf(
+		"Coordinates are out of the bounds of the GeoStore; lat=%f, long=%f", e.Lat, e.Long)
 }
 
 type Node struct {
@@ -36,6 +88,19 @@ func NewNode(long, lat float64, id uint64) *Node {
 	}
 }
 
+// gps returns the gps coordinates of the Node.
+func (n *Node) gps() (lat, long float64) {
+	long, lat = AbsoluteToGps(n.X, n.Y)
+	return lat, long
+}
+
+// Neighbor is a Node id returned from a nearest neighbor search along with its distance, in meters,
+// from the search coordinates.
+type Neighbor struct {
+	Id       uint64
+	Distance float64
+}
+
 type Quadrant struct {
 	XMin, YMin, XMax, YMax float64
 }
@@ -62,6 +127,64 @@ func (q *Quadrant) inQuadrant(node *Node) bool {
 	return true
 }
 
+// intersects returns true if the Quadrants overlap, including if they only share an edge.
+func (q *Quadrant) intersects(other *Quadrant) bool {
+	return q.XMin <= other.XMax && q.XMax >= other.XMin && q.YMin <= other.YMax && q.YMax >= other.YMin
+}
+
+// contains returns true if the other Quadrant is entirely within this one, including its edges.
+func (q *Quadrant) contains(other *Quadrant) bool {
+	return q.XMin <= other.XMin && q.XMax >= other.XMax &&
+		q.YMin <= other.YMin && q.YMax >= other.YMax
+}
+
+// Box is a bounding box in gps coordinates, inclusive of its edges, that does not cross the
+// antimeridian.
+type Box struct {
+	MinLat, MinLong, MaxLat, MaxLong float64
+}
+
+// SplitBox converts a gps bounding box into the Boxes that together cover it on the flat grid of
+// latitudes and longitudes.  A box with a minLong greater than its maxLong crosses the
+// antimeridian, and is split into a Box on either side of it.  Because the antimeridian is at both
+// -180 and 180, a box with an edge on it also includes the line at the other of them, and because
+// every longitude meets at the poles, a box with an edge at a pole also includes the whole of that
+// pole.  The Boxes can overlap, so the results of searching each of them must be deduplicated.
+func SplitBox(minLat, minLong, maxLat, maxLong float64) []Box {
+	var retval []Box
+	if minLong <= maxLong {
+		retval = append(retval, Box{minLat, minLong, maxLat, maxLong})
+	} else {
+		retval = append(retval,
+			Box{minLat, minLong, maxLat, 180},
+			Box{minLat, -180, maxLat, maxLong},
+		)
+	}
+	if maxLong == 180 {
+		retval = append(retval, Box{minLat, -180, maxLat, -180})
+	}
+	if minLong == -180 {
+		retval = append(retval, Box{minLat, 180, maxLat, 180})
+	}
+	if maxLat == 90 {
+		retval = append(retval, Box{90, -180, 90, 180})
+	}
+	if minLat == -90 {
+		retval = append(retval, Box{-90, -180, -90, 180})
+	}
+	return retval
+}
+
+// boxToQuadrants converts a gps bounding box into the Quadrants of each of the Boxes returned by
+// SplitBox.
+func boxToQuadrants(minLat, minLong, maxLat, maxLong float64) []*Quadrant {
+	var retval []*Quadrant
+	for _, b := range SplitBox(minLat, minLong, maxLat, maxLong) {
+		retval = append(retval, NewQuadrant(b.MinLong, b.MinLat, b.MaxLong, b.MaxLat, true))
+	}
+	return retval
+}
+
 type QuadTree struct {
 	id           uint64
 	MaxCapacity  int
@@ -106,7 +229,7 @@ func (q *QuadTree) insert(node *Node) bool {
 	} else {
 		// If it is a node that would otherwise belong in the boundaries defined in this Quadrant, have
 		// we already reached our max capacity for this QuadTree?
-		if len(q.Nodes) < q.MaxCapacity {
+		if len(q.Nodes) < q.MaxCapacity || q.Level >= maxQuadTreeLevel {
 			q.Nodes = append(q.Nodes, node)
 			return true
 		}
@@ -178,18 +301,85 @@ NodesLoop:
 	return true
 }
 
+// remove will remove the Node with the same id as the provided node from this QuadTree, or one of
+// its subdivisions, returning true if it was found.  After removing a Node from a subdivision we
+// attempt to collapse the subdivisions back into this QuadTree so that removing Nodes does not
+// leave behind a tree of empty or under-filled QuadTrees.
+func (q *QuadTree) remove(node *Node) bool {
+	if !q.Quadrant.inQuadrant(node) {
+		return false
+	}
+
+	if !q.isSubdivided {
+		for i, n := range q.Nodes {
+			if n.Id == node.Id {
+				q.Nodes = append(q.Nodes[:i], q.Nodes[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+
+	// A Node that sits on the boundary between subdivisions could be in any one of the
+	// subdivisions whose Quadrant includes it, so we try each of them in turn.
+	for _, qt := range q.QuadTrees {
+		if qt.remove(node) {
+			q.collapse()
+			return true
+		}
+	}
+	return false
+}
+
+// collapse will merge the subdivisions of this QuadTree back into it if none of them are
+// themselves subdivided and, between them, they contain no more Nodes than our max capacity.
+func (q *QuadTree) collapse() {
+	count := 0
+	for _, qt := range q.QuadTrees {
+		if qt.isSubdivided {
+			return
+		}
+		count += len(qt.Nodes)
+	}
+	if count > q.MaxCapacity {
+		return
+	}
+
+	nodes := make([]*Node, 0, count)
+	for _, qt := range q.QuadTrees {
+		nodes = append(nodes, qt.Nodes...)
+	}
+	q.Nodes = nodes
+	q.NW = nil
+	q.NE = nil
+	q.SW = nil
+	q.SE = nil
+	q.QuadTrees = nil
+	q.isSubdivided = false
+}
+
 type InMemGeoStore struct {
 	Root *QuadTree
-	mux  *sync.RWMutex
+	// nodes indexes each of the Nodes in the QuadTree by id so that we can find their coordinates,
+	// and thus the QuadTree in which they are stored, when removing or moving them.
+	nodes    map[uint64]*Node
+	distance DistanceFunc
+	mux      *sync.RWMutex
 }
 
-func NewGeoStoreInMem(root *QuadTree) *InMemGeoStore {
+func NewGeoStoreInMem(root *QuadTree, distance DistanceFunc) *InMemGeoStore {
 	return &InMemGeoStore{
-		Root: root,
-		mux:  &sync.RWMutex{},
+		Root:     root,
+		nodes:    make(map[uint64]*Node),
+		distance: distance,
+		mux:      &sync.RWMutex{},
 	}
 }
 
+func (g *InMemGeoStore) Distance(lat1, long1, lat2, long2 float64) float64 {
+	return g.distance(lat1, long1, lat2, long2)
+}
+
 func (g *InMemGeoStore) Find(lat, long float64) uint64 {
 	g.mux.Lock()
 	defer g.mux.Unlock()
@@ -198,65 +388,155 @@ func (g *InMemGeoStore) Find(lat, long float64) uint64 {
 	return 0
 }
 
-// Find will return a slice of nodes that are nearest to the provided lat/long coordinates.
-// Currently, we are just going to return the nodes in the same quadrant in which this node would
-// otherwise be inserted and will leave implementing max distance and limit for later.
+// FindNearest will return a slice of the ids and distances of the nodes that are nearest to the provided lat/long
+// coordinates, ordered by ascending distance as computed by the GeoStore's DistanceFunc.  The
+// maxDistance is in meters; a maxDistance <= 0 does not bound the search by distance and a
+// limit <= 0 does not bound the number of results returned.  As such, calling FindNearest with a
+// maxDistance and no limit is a radius query.
+//
+// The search is a best-first, k-nearest-neighbor traversal of the QuadTree, see findNearest.
 func (g *InMemGeoStore) FindNearest(
 	lat, long, maxDistance float64,
 	limit int,
-) []uint64 {
-	g.mux.Lock()
-	defer g.mux.Unlock()
+) []Neighbor {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	return findNearest(lat, long, maxDistance, limit, g.distance, g.Root,
+		quadTreeBounds, expandQuadTree)
+}
 
-	// Find the QuadTree in which this lat/long would be placed if it were inserted
-	node := NewNode(long, lat, 1)
-	q := findQuadTree(node, g.Root)
+func quadTreeBounds(q *QuadTree) Quadrant {
+	return *q.Quadrant
+}
 
-	// Now that we have the right QuadTree, just return a slice of the ids for each of the nodes
-	retval := make([]uint64, len(q.Nodes))
-	i := 0
-	for _, node := range q.Nodes {
-		retval[i] = node.Id
-		i++
+// expandQuadTree visits the subdivisions of the QuadTree if it is subdivided, or else its Nodes.
+func expandQuadTree(q *QuadTree, visitCell func(*QuadTree), visitNode func(*Node)) {
+	if q.isSubdivided {
+		for _, qt := range q.QuadTrees {
+			visitCell(qt)
+		}
+		return
+	}
+	for _, n := range q.Nodes {
+		visitNode(n)
 	}
-	return retval
 }
 
-func findQuadTree(node *Node, root *QuadTree) *QuadTree {
-	// Execute a BFS looking for the quadrant in which we would add this node if this was an insert
-	// operation.  We will start by adding the root QuadTree to the queue.
-	queue := make(chan *QuadTree, 128)
-	queue <- root
-	for q := range queue {
-		if q.isSubdivided {
-			// Find the child QuadTree in which this node belongs and add it to the queue
-			for _, qt := range q.QuadTrees {
-				if qt.Quadrant.inQuadrant(node) {
-					queue <- qt
-					break
-				}
-			}
+// FindInBox will return the ids, in ascending order, of all of the nodes within the bounding box
+// defined by the provided gps coordinates, inclusive of its edges.  If minLong is greater than
+// maxLong the box is treated as crossing the antimeridian.
+func (g *InMemGeoStore) FindInBox(minLat, minLong, maxLat, maxLong float64) []uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	var retval []uint64
+	for _, box := range boxToQuadrants(minLat, minLong, maxLat, maxLong) {
+		retval = append(retval, g.findInQuadrant(box, nil)...)
+	}
+	// A node exactly on the antimeridian can be in both halves of a box that crosses it.
+	return sortedUniqueIds(retval)
+}
+
+// FindInPolygons will return the ids, in ascending order, of all of the nodes within any of the
+// provided Polygons.  We first prune the QuadTree down to the subdivisions that intersect the
+// bounding box of each Polygon, and only then test whether each Node is within the Polygon.
+func (g *InMemGeoStore) FindInPolygons(polygons []Polygon) []uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	var retval []uint64
+	for _, polygon := range polygons {
+		if len(polygon) == 0 || len(polygon[0]) == 0 {
 			continue
-		} else {
-			// Would this node fit within the boundaries of this
-			if q.Quadrant.inQuadrant(node) {
-				return q
-			}
 		}
+		retval = append(retval, g.findInQuadrant(polygon.quadrant(), func(n *Node) bool {
+			lat, long := n.gps()
+			return polygon.Contains(lat, long)
+		})...)
 	}
-	return nil
+	// The Polygons of a MultiPolygon could overlap
+	return sortedUniqueIds(retval)
+}
+
+// FindInGeohash will return the ids, in ascending order, of all of the nodes within the cell of the
+// geohash.
+func (g *InMemGeoStore) FindInGeohash(geohash string) ([]uint64, error) {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	return findInGeohashCell(strings.ToLower(geohash), g.findInQuadrant)
+}
+
+func (g *InMemGeoStore) findInQuadrant(box *Quadrant, match func(*Node) bool) []uint64 {
+	return findInQuadrant(g.Root, box, match, quadTreeBounds, expandQuadTree)
 }
 
+// Insert will insert the Node into the GeoStore, replacing any existing Node with the same id.
+// Nodes that are outside of the bounds of the root QuadTree are ignored.
 func (g *InMemGeoStore) Insert(node *Node) {
 	g.mux.Lock()
 	defer g.mux.Unlock()
+	g.insert(node)
+}
+
+// InsertMany inserts all of the Nodes, with the same semantics as calling Insert with each of them
+// in order, while only taking the lock once.
+func (g *InMemGeoStore) InsertMany(nodes []*Node) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	for _, node := range nodes {
+		g.insert(node)
+	}
+}
+
+func (g *InMemGeoStore) insert(node *Node) bool {
+	if existing, ok := g.nodes[node.Id]; ok {
+		g.Root.remove(existing)
+		delete(g.nodes, node.Id)
+	}
+	if !g.Root.insert(node) {
+		return false
+	}
+	g.nodes[node.Id] = node
+	return true
+}
+
+// Move will move the Node with the provided id to the new lat/long coordinates.  If the new
+// coordinates are out of the bounds of the GeoStore the Node is left where it was.
+func (g *InMemGeoStore) Move(id uint64, lat, long float64) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	existing, ok := g.nodes[id]
+	if !ok {
+		return &NodeNotFoundErr{Id: id}
+	}
+	node := NewNode(long, lat, id)
+	if !g.Root.Quadrant.inQuadrant(node) {
+		return &OutOfBoundsErr{Lat: lat, Long: long}
+	}
+
+	g.Root.remove(existing)
 	g.Root.insert(node)
+	g.nodes[id] = node
+	return nil
 }
 
-func (g *InMemGeoStore) Shutdown() error {
+// Remove will remove the Node with the provided id from the GeoStore.
+func (g *InMemGeoStore) Remove(id uint64) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	existing, ok := g.nodes[id]
+	if !ok {
+		return &NodeNotFoundErr{Id: id}
+	}
+	g.Root.remove(existing)
+	delete(g.nodes, id)
 	return nil
 }
 
-func (g *InMemGeoStore) getRootQuadTree() *QuadTree {
-	return g.Root
+func (g *InMemGeoStore) Shutdown() error {
+	return nil
 }
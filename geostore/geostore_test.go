@@ -2,6 +2,7 @@ package geostore
 
 import (
 	"fmt"
+	"math/rand"
 	"reflect"
 	"testing"
 
@@ -18,6 +19,7 @@ var (
 	australiaNode       *Node = NewNode(124.42913747263096, -23.605766549164937, 3)
 	mongoliaNode        *Node = NewNode(97.00726436805842, 46.88910832340091, 4)
 	oregonNode          *Node = NewNode(-120.54074440145642, 43.38552157601114, 5)
+	calgaryId                 = canadaCalgary.Id
 	testNodes                 = []*Node{
 		canadaSaskatchewan,
 		canadaEdmonton,
@@ -31,20 +33,23 @@ var (
 	}
 )
 
-func getTestGeoStore(maxCapacity int) GeoStore {
+func getTestGeoStore(maxCapacity int) *InMemGeoStore {
+	return getTestGeoStoreWithDistance(maxCapacity, Haversine)
+}
+
+func getTestGeoStoreWithDistance(maxCapacity int, distance DistanceFunc) *InMemGeoStore {
 	// The whole globe
 	quadrant := NewQuadrant(-180, -90, 180, 90, true)
 	q := NewQuadTree(1, quadrant, maxCapacity)
-	return NewGeoStoreInMem(q)
+	return NewGeoStoreInMem(q, distance)
 }
 
 func TestFindNearest(t *testing.T) {
 	g := getTestGeoStore(4)
 
 	// Add all of the nodes that we have predefined.  The predominant number of nodes are in Canada.
-	// Once added we will look for a nodes that are near one that is in relative close proximity to
-	// the Western-most Canadian nodes and expect to find ones that are in the same quadrant based
-	// on a 4 node maximum capacity.
+	// Once added we will look for the 4 nodes that are nearest to a point in relative close
+	// proximity to the Western-most Canadian nodes.
 	for _, n := range testNodes {
 		g.Insert(n)
 	}
@@ -55,11 +60,11 @@ func TestFindNearest(t *testing.T) {
 		8: true,
 		9: true,
 	}
-	actualNearestNodes := g.FindNearest(52.58987722297317, -114.69660872375789, 0, 0)
-	// assert.Equal(t, len(expectedNearestNodes), len(actualNearestNodes))
+	actualNearestNodes := g.FindNearest(52.58987722297317, -114.69660872375789, 0, 4)
+	assert.Equal(t, len(expectedNearestNodes), len(actualNearestNodes))
 	actualNearestNodesMap := make(map[uint64]bool)
-	for _, id := range actualNearestNodes {
-		actualNearestNodesMap[id] = true
+	for _, n := range actualNearestNodes {
+		actualNearestNodesMap[n.Id] = true
 	}
 	assert.True(
 		t,
@@ -75,6 +80,107 @@ func TestFindNearest(t *testing.T) {
 	printMap(g, 5)
 }
 
+func TestFindNearestAcrossQuadrantBoundary(t *testing.T) {
+	g := getTestGeoStore(4)
+
+	// Fill the NW quadrant of the root QuadTree so that it is split, with a node that is a long way
+	// from the search coordinates, and then add a node that sits just on the other side of the
+	// boundary between the NW and NE quadrants.
+	g.Insert(NewNode(-120, 45, 1))
+	g.Insert(NewNode(-100, 60, 2))
+	g.Insert(NewNode(-80, 30, 3))
+	g.Insert(NewNode(-60, 20, 4))
+	g.Insert(NewNode(-0.4, 10, 5))
+	g.Insert(NewNode(0.00001, 10, 6))
+	rootQuadTree := g.Root
+	assert.True(t, rootQuadTree.isSubdivided)
+
+	// Searching from just West of the Prime Meridian lands in the NW quadrant, but the nearest node
+	// is in the NE quadrant.
+	assert.Equal(t, []uint64{6, 5}, neighborIds(g.FindNearest(10, -0.00001, 0, 2)))
+	// Searching exactly on the boundary should find the same nodes.
+	assert.Equal(t, []uint64{6, 5}, neighborIds(g.FindNearest(10, 0, 0, 2)))
+}
+
+func TestFindNearestOrderedByDistance(t *testing.T) {
+	g := getTestGeoStore(2)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+
+	// With no limit and no max distance all of the nodes are returned in order of distance from
+	// Calgary.
+	expected := []uint64{9, 8, 7, 6, 1, 5, 4, 2, 3}
+	lat, long := 51.04653767382061, -114.06243444911559
+	assert.Equal(t, expected, neighborIds(g.FindNearest(lat, long, 0, 0)))
+	assert.Equal(t, expected[:3], neighborIds(g.FindNearest(lat, long, 0, 3)))
+}
+
+func TestFindNearestMaxDistance(t *testing.T) {
+	g := getTestGeoStore(2)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+
+	testData := []struct {
+		maxDistance float64
+		limit       int
+		expected    []uint64
+	}{
+		// Calgary itself is at a distance of 0
+		{maxDistance: 1, limit: 0, expected: []uint64{9}},
+		// Red Deer is ~137km away, Drayton Valley ~250km, Edmonton ~296km
+		{maxDistance: 200000, limit: 0, expected: []uint64{9, 8}},
+		{maxDistance: 300000, limit: 0, expected: []uint64{9, 8, 7, 6}},
+		{maxDistance: 300000, limit: 2, expected: []uint64{9, 8}},
+	}
+	for _, td := range testData {
+		actual := neighborIds(
+			g.FindNearest(51.04653767382061, -114.06243444911559, td.maxDistance, td.limit),
+		)
+		assert.Equal(t, td.expected, actual, "maxDistance=%f, limit=%d", td.maxDistance, td.limit)
+	}
+}
+
+func TestFindNearestHighLatitude(t *testing.T) {
+	// At 80 degrees North a degree of longitude is only ~19km, so a node 5 degrees to the East is
+	// much closer than a node 2 degrees to the North, even though it is further away on a flat grid.
+	for _, distance := range []DistanceFunc{Haversine, Vincenty} {
+		g := getTestGeoStoreWithDistance(4, distance)
+		g.Insert(NewNode(-100, 82, 1))
+		g.Insert(NewNode(-95, 80, 2))
+		assert.Equal(t, []uint64{2, 1}, neighborIds(g.FindNearest(80, -100, 0, 0)))
+		assert.Equal(t, []uint64{2}, neighborIds(g.FindNearest(80, -100, 150000, 0)))
+	}
+}
+
+func TestFindNearestDistances(t *testing.T) {
+	g := getTestGeoStoreWithDistance(4, Vincenty)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+
+	nodesById := make(map[uint64]*Node, len(testNodes))
+	for _, n := range testNodes {
+		nodesById[n.Id] = n
+	}
+
+	actual := g.FindNearest(51.04653767382061, -114.06243444911559, 0, 3)
+	assert.Equal(t, 3, len(actual))
+	for i, n := range actual {
+		lat, long := nodesById[n.Id].gps()
+		assert.Equal(t, Vincenty(51.04653767382061, -114.06243444911559, lat, long), n.Distance)
+		if i > 0 {
+			assert.LessOrEqual(t, actual[i-1].Distance, n.Distance)
+		}
+	}
+}
+
+func TestFindNearestEmpty(t *testing.T) {
+	g := getTestGeoStore(4)
+	assert.Empty(t, g.FindNearest(10, 10, 0, 0))
+}
+
 func TestGpsToAbsolute(t *testing.T) {
 	testData := []struct {
 		gpsX, gpsY, expectedAbsX, expectedAbsY float64
@@ -113,7 +219,7 @@ func TestInsertNode(t *testing.T) {
 	g.Insert(mongoliaNode)
 	g.Insert(oregonNode)
 
-	rootQuadTree := g.getRootQuadTree()
+	rootQuadTree := g.Root
 	print(rootQuadTree)
 
 	// The root QuadTree should have a nil Nodes slice and should have the following breakdown of nodes
@@ -136,6 +242,252 @@ func TestInsertNode(t *testing.T) {
 	assertQTNodesContainsIds(t, rootQuadTree.SW.Nodes, seExpectedIds)
 }
 
+func TestInsertCoincidentNodes(t *testing.T) {
+	// More Nodes at the same coordinates than the max capacity cannot be split apart, so the
+	// QuadTree stops subdividing at maxQuadTreeLevel rather than splitting forever.
+	g := getTestGeoStore(4)
+	for id := uint64(1); id <= 10; id++ {
+		g.Insert(NewNode(-114.0719, 51.0447, id))
+	}
+	maxLevel := 0
+	var walk func(q *QuadTree)
+	walk = func(q *QuadTree) {
+		if q.Level > maxLevel {
+			maxLevel = q.Level
+		}
+		for _, qt := range q.QuadTrees {
+			walk(qt)
+		}
+	}
+	walk(g.Root)
+	assert.Equal(t, maxQuadTreeLevel, maxLevel)
+	assert.Equal(t, 10, len(g.FindNearest(51.0447, -114.0719, 0, 0)))
+}
+
+func TestRemoveNode(t *testing.T) {
+	g := getTestGeoStore(4)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+
+	assert.Nil(t, g.Remove(calgaryId))
+	ids := neighborIds(g.FindNearest(51.04653767382061, -114.06243444911559, 0, 0))
+	assert.NotContains(t, ids, calgaryId)
+	assert.Equal(t, len(testNodes)-1, len(ids))
+
+	// Removing it a second time should return a NodeNotFoundErr
+	err := g.Remove(calgaryId)
+	var notFoundErr *NodeNotFoundErr
+	assert.ErrorAs(t, err, &notFoundErr)
+	assert.Equal(t, calgaryId, notFoundErr.Id)
+}
+
+func TestRemoveCollapsesQuadTree(t *testing.T) {
+	g := getTestGeoStore(2)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+	rootQuadTree := g.Root
+	assert.True(t, rootQuadTree.isSubdivided)
+
+	// Remove all but two of the nodes, after which the root QuadTree should have been collapsed
+	// back into a single QuadTree with the remaining two nodes.
+	for _, n := range testNodes[2:] {
+		assert.Nil(t, g.Remove(n.Id))
+	}
+	assert.False(t, rootQuadTree.isSubdivided)
+	assert.Nil(t, rootQuadTree.QuadTrees)
+	assertQTNodesContainsIds(
+		t,
+		rootQuadTree.Nodes,
+		map[uint64]bool{testNodes[0].Id: true, testNodes[1].Id: true},
+	)
+
+	// Removing the remaining nodes leaves an empty root QuadTree
+	assert.Nil(t, g.Remove(testNodes[0].Id))
+	assert.Nil(t, g.Remove(testNodes[1].Id))
+	assert.Empty(t, rootQuadTree.Nodes)
+	assert.Empty(t, g.FindNearest(10, 10, 0, 0))
+}
+
+func TestMoveNode(t *testing.T) {
+	g := getTestGeoStore(2)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+
+	// Move Calgary to Perth, Australia, after which it should be the nearest node to the
+	// Australian node and no longer be near any of the Canadian nodes.
+	assert.Nil(t, g.Move(calgaryId, -31.95, 115.86))
+	australiaLat, australiaLong := australiaNode.gps()
+	assert.Equal(
+		t,
+		[]uint64{3, calgaryId},
+		neighborIds(g.FindNearest(australiaLat, australiaLong, 0, 2)),
+	)
+	assert.Equal(
+		t,
+		[]uint64{8, 7, 6},
+		neighborIds(g.FindNearest(51.04653767382061, -114.06243444911559, 300000, 0)),
+	)
+
+	// Moving a node that does not exist or out of bounds is an error, and leaves the node in place.
+	var notFoundErr *NodeNotFoundErr
+	assert.ErrorAs(t, g.Move(42, 10, 10), &notFoundErr)
+	var outOfBoundsErr *OutOfBoundsErr
+	assert.ErrorAs(t, g.Move(calgaryId, 10, 200), &outOfBoundsErr)
+	assert.Equal(t, []uint64{calgaryId}, neighborIds(g.FindNearest(-31.95, 115.86, 1, 0)))
+}
+
+func TestInsertReplacesExistingNode(t *testing.T) {
+	g := getTestGeoStore(4)
+	g.Insert(NewNode(10, 10, 1))
+	g.Insert(NewNode(-10, -10, 1))
+	assert.Equal(t, []uint64{1}, neighborIds(g.FindNearest(-10, -10, 0, 0)))
+	assert.Empty(t, g.FindNearest(10, 10, 1000, 0))
+}
+
+func TestFindInBox(t *testing.T) {
+	g := getTestGeoStore(2)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+
+	testData := []struct {
+		minLat, minLong, maxLat, maxLong float64
+		expected                         []uint64
+	}{
+		// Alberta
+		{minLat: 49, minLong: -120, maxLat: 60, maxLong: -110, expected: []uint64{6, 7, 8, 9}},
+		// Western Canada and the US
+		{minLat: 40, minLong: -125, maxLat: 60, maxLong: -100, expected: []uint64{1, 5, 6, 7, 8, 9}},
+		// The Southern hemisphere
+		{minLat: -90, minLong: -180, maxLat: 0, maxLong: 180, expected: []uint64{2, 3}},
+		// The middle of the Pacific
+		{minLat: -10, minLong: -170, maxLat: 10, maxLong: -150, expected: nil},
+		// The edges of the box are inclusive
+		{
+			minLat:   51.04653767382061,
+			minLong:  -114.06243444911559,
+			maxLat:   52.26871035649865,
+			maxLong:  -113.80500041394141,
+			expected: []uint64{8, 9},
+		},
+	}
+	for _, td := range testData {
+		actual := g.FindInBox(td.minLat, td.minLong, td.maxLat, td.maxLong)
+		assert.Equal(t, td.expected, actual, "%+v", td)
+	}
+}
+
+func TestFindInBoxAcrossAntimeridian(t *testing.T) {
+	g := getTestGeoStore(2)
+	fiji := NewNode(-179.9, -17.8, 1)
+	tuvalu := NewNode(179.2, -8.5, 2)
+	samoa := NewNode(-172.1, -13.8, 3)
+	newZealand := NewNode(174.8, -41.3, 4)
+	onAntimeridian := NewNode(180, -10, 5)
+	for _, n := range []*Node{fiji, tuvalu, samoa, newZealand, onAntimeridian} {
+		g.Insert(n)
+	}
+
+	// A box from 175E to 175W crosses the antimeridian
+	assert.Equal(t, []uint64{1, 2, 5}, g.FindInBox(-20, 175, 0, -175))
+	// A box from 170E to 170W that only includes the Southern most node
+	assert.Equal(t, []uint64{4}, g.FindInBox(-45, 170, -30, -170))
+	// The same longitudes not crossing the antimeridian covers the rest of the globe
+	assert.Equal(t, []uint64{3}, g.FindInBox(-20, -175, 0, 175))
+}
+
+func TestSplitBox(t *testing.T) {
+	testData := []struct {
+		minLat, minLong, maxLat, maxLong float64
+		expected                         []Box
+	}{
+		{minLat: 10, minLong: 20, maxLat: 30, maxLong: 40, expected: []Box{{10, 20, 30, 40}}},
+		// Across the antimeridian
+		{
+			minLat:   -20,
+			minLong:  175,
+			maxLat:   0,
+			maxLong:  -175,
+			expected: []Box{{-20, 175, 0, 180}, {-20, -180, 0, -175}},
+		},
+		// An edge on the antimeridian, which also includes the line at the other side of it
+		{
+			minLat:   -1,
+			minLong:  170,
+			maxLat:   1,
+			maxLong:  180,
+			expected: []Box{{-1, 170, 1, 180}, {-1, -180, 1, -180}},
+		},
+		{
+			minLat:   -1,
+			minLong:  -180,
+			maxLat:   1,
+			maxLong:  -170,
+			expected: []Box{{-1, -180, 1, -170}, {-1, 180, 1, 180}},
+		},
+		// An edge at a pole, which also includes the whole of the pole
+		{
+			minLat:   89,
+			minLong:  0,
+			maxLat:   90,
+			maxLong:  10,
+			expected: []Box{{89, 0, 90, 10}, {90, -180, 90, 180}},
+		},
+		{
+			minLat:   -90,
+			minLong:  0,
+			maxLat:   -89,
+			maxLong:  10,
+			expected: []Box{{-90, 0, -89, 10}, {-90, -180, -90, 180}},
+		},
+	}
+	for _, td := range testData {
+		actual := SplitBox(td.minLat, td.minLong, td.maxLat, td.maxLong)
+		assert.Equal(t, td.expected, actual, "%+v", td)
+	}
+}
+
+func TestFindInBoxMatchesBruteForce(t *testing.T) {
+	g := getTestGeoStore(1)
+	r := rand.New(rand.NewSource(42))
+	var nodes []*Node
+	for i := 1; i <= 500; i++ {
+		n := NewNode(r.Float64()*360-180, r.Float64()*180-90, uint64(i))
+		nodes = append(nodes, n)
+		g.Insert(n)
+	}
+
+	for i := 0; i < 50; i++ {
+		minLat := r.Float64()*180 - 90
+		maxLat := minLat + r.Float64()*(90-minLat)
+		minLong := r.Float64()*360 - 180
+		maxLong := r.Float64()*360 - 180
+		var expected []uint64
+		for _, n := range nodes {
+			lat, long := n.gps()
+			inLong := long >= minLong && long <= maxLong
+			if minLong > maxLong {
+				inLong = long >= minLong || long <= maxLong
+			}
+			if inLong && lat >= minLat && lat <= maxLat {
+				expected = append(expected, n.Id)
+			}
+		}
+		assert.Equal(t, expected, g.FindInBox(minLat, minLong, maxLat, maxLong))
+	}
+}
+
+func neighborIds(neighbors []Neighbor) []uint64 {
+	var retval []uint64
+	for _, n := range neighbors {
+		retval = append(retval, n.Id)
+	}
+	return retval
+}
+
 func assertQTNodesContainsIds(t *testing.T, nodes []*Node, expectedIds map[uint64]bool) {
 	actualIds := make(map[uint64]bool)
 	for _, n := range nodes {
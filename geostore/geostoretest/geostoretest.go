@@ -0,0 +1,674 @@
+// Package geostoretest is a conformance test suite for implementations of geostore.GeoStore, so
+// that every implementation is verified against the same expectations.
+package geostoretest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory returns a new, empty, GeoStore that covers the whole globe and calculates distances with
+// geostore.Haversine.
+type Factory func(t *testing.T) geostore.GeoStore
+
+// point is the id and gps coordinates of a Node that the tests insert into a GeoStore.
+type point struct {
+	id        uint64
+	lat, long float64
+}
+
+func (p point) node() *geostore.Node {
+	return geostore.NewNode(p.long, p.lat, p.id)
+}
+
+var (
+	calgary  = point{id: 1, lat: 51.0447, long: -114.0719}
+	edmonton = point{id: 2, lat: 53.5461, long: -113.4937}
+	banff    = point{id: 3, lat: 51.1784, long: -115.5708}
+	fiji     = point{id: 4, lat: -17.8, long: -179.9}
+	tuvalu   = point{id: 5, lat: -8.5, long: 179.2}
+	sydney   = point{id: 6, lat: -33.8688, long: 151.2093}
+	// onAntimeridian is exactly on the boundary between the Eastern and Western hemispheres.
+	onAntimeridian = point{id: 7, lat: -10, long: 180}
+	testPoints     = []point{calgary, edmonton, banff, fiji, tuvalu, sydney, onAntimeridian}
+)
+
+// Run runs all of the conformance tests, each as a subtest with a new GeoStore from the factory.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, g geostore.GeoStore)
+	}{
+		{name: "Empty", test: testEmpty},
+		{name: "FindNearest", test: testFindNearest},
+		{name: "FindNearestMatchesBruteForce", test: testFindNearestMatchesBruteForce},
+		{name: "FindInBox", test: testFindInBox},
+		{name: "FindInBoxMatchesBruteForce", test: testFindInBoxMatchesBruteForce},
+		{name: "FindInPolygons", test: testFindInPolygons},
+		{name: "FindInGeohash", test: testFindInGeohash},
+		{name: "FindInGeohashMatchesBruteForce", test: testFindInGeohashMatchesBruteForce},
+		{name: "Antimeridian", test: testAntimeridian},
+		{name: "Poles", test: testPoles},
+		{name: "Insert", test: testInsert},
+		{name: "InsertMany", test: testInsertMany},
+		{name: "Move", test: testMove},
+		{name: "Remove", test: testRemove},
+		{name: "IdenticalCoordinates", test: testIdenticalCoordinates},
+		{name: "Concurrency", test: testConcurrency},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := factory(t)
+			defer func() { assert.Nil(t, g.Shutdown()) }()
+			tt.test(t, g)
+		})
+	}
+}
+
+func insertPoints(g geostore.GeoStore, points []point) {
+	for _, p := range points {
+		g.Insert(p.node())
+	}
+}
+
+// randomPoints returns n points, with ids starting at 1, half of which are spread across the globe
+// and half clustered around Calgary so that implementations are exercised with dense data.
+func randomPoints(r *rand.Rand, n int) []point {
+	retval := make([]point, n)
+	for i := range retval {
+		p := point{id: uint64(i + 1)}
+		if i%2 == 0 {
+			p.lat, p.long = r.Float64()*180-90, r.Float64()*360-180
+		} else {
+			p.lat, p.long = calgary.lat+r.Float64()-0.5, calgary.long+r.Float64()-0.5
+		}
+		retval[i] = p
+	}
+	return retval
+}
+
+// bruteForceNearest returns the neighbors of the coordinates as FindNearest should, by calculating
+// the distance to every one of the points.
+func bruteForceNearest(
+	points []point,
+	lat, long, maxDistance float64,
+	limit int,
+) []geostore.Neighbor {
+	var retval []geostore.Neighbor
+	for _, p := range points {
+		// Compare against the coordinates as stored in the Node
+		n := p.node()
+		pLong, pLat := geostore.AbsoluteToGps(n.X, n.Y)
+		distance := geostore.Haversine(lat, long, pLat, pLong)
+		if maxDistance > 0 && distance > maxDistance {
+			continue
+		}
+		retval = append(retval, geostore.Neighbor{Id: p.id, Distance: distance})
+	}
+	sort.Slice(retval, func(i, j int) bool {
+		if retval[i].Distance != retval[j].Distance {
+			return retval[i].Distance < retval[j].Distance
+		}
+		return retval[i].Id < retval[j].Id
+	})
+	if limit > 0 && len(retval) > limit {
+		retval = retval[:limit]
+	}
+	return retval
+}
+
+// bruteForceInBox returns the ids of the points in the box as FindInBox should.
+func bruteForceInBox(points []point, minLat, minLong, maxLat, maxLong float64) []uint64 {
+	var retval []uint64
+	for _, p := range points {
+		n := p.node()
+		long, lat := geostore.AbsoluteToGps(n.X, n.Y)
+		inLong := long >= minLong && long <= maxLong
+		if minLong > maxLong {
+			inLong = long >= minLong || long <= maxLong
+		}
+		if inLong && lat >= minLat && lat <= maxLat {
+			retval = append(retval, p.id)
+		}
+	}
+	sort.Slice(retval, func(i, j int) bool { return retval[i] < retval[j] })
+	return retval
+}
+
+func neighborIds(neighbors []geostore.Neighbor) []uint64 {
+	var retval []uint64
+	for _, n := range neighbors {
+		retval = append(retval, n.Id)
+	}
+	return retval
+}
+
+func assertNeighbors(t *testing.T, expected, actual []geostore.Neighbor, msgAndArgs ...any) {
+	if !assert.Equal(t, neighborIds(expected), neighborIds(actual), msgAndArgs...) {
+		return
+	}
+	for i := range expected {
+		assert.InDelta(t, expected[i].Distance, actual[i].Distance, 1e-6, msgAndArgs...)
+	}
+}
+
+func testEmpty(t *testing.T, g geostore.GeoStore) {
+	assert.Empty(t, g.FindNearest(10, 10, 0, 0))
+	assert.Empty(t, g.FindInBox(-90, -180, 90, 180))
+	assert.Empty(t, g.FindInPolygons(nil))
+}
+
+func testFindNearest(t *testing.T, g geostore.GeoStore) {
+	insertPoints(g, testPoints)
+
+	// Unbounded, and bounded by the limit, the maxDistance or both
+	testData := []struct {
+		maxDistance float64
+		limit       int
+		expected    []uint64
+	}{
+		{maxDistance: 0, limit: 0, expected: []uint64{1, 3, 2, 5, 7, 4, 6}},
+		{maxDistance: 0, limit: 2, expected: []uint64{1, 3}},
+		// Banff is ~108km from Calgary and Edmonton ~280km
+		{maxDistance: 200000, limit: 0, expected: []uint64{1, 3}},
+		{maxDistance: 300000, limit: 0, expected: []uint64{1, 3, 2}},
+		{maxDistance: 300000, limit: 1, expected: []uint64{1}},
+		// Calgary itself is at a distance of 0
+		{maxDistance: 1, limit: 0, expected: []uint64{1}},
+	}
+	for _, td := range testData {
+		actual := g.FindNearest(calgary.lat, calgary.long, td.maxDistance, td.limit)
+		msg := fmt.Sprintf("maxDistance=%f, limit=%d", td.maxDistance, td.limit)
+		assert.Equal(t, td.expected, neighborIds(actual), msg)
+		assertNeighbors(
+			t,
+			bruteForceNearest(testPoints, calgary.lat, calgary.long, td.maxDistance, td.limit),
+			actual,
+			msg,
+		)
+	}
+
+	// The nearest nodes are found across the antimeridian
+	assert.Equal(t, []uint64{7, 5, 4}, neighborIds(g.FindNearest(-12, -179.5, 0, 3)))
+
+	// Nothing within range
+	assert.Empty(t, g.FindNearest(0, 0, 1000, 0))
+}
+
+func testFindNearestMatchesBruteForce(t *testing.T, g geostore.GeoStore) {
+	r := rand.New(rand.NewSource(42))
+	points := randomPoints(r, 500)
+	insertPoints(g, points)
+
+	for i := 0; i < 50; i++ {
+		lat, long := r.Float64()*180-90, r.Float64()*360-180
+		if i%2 == 0 {
+			lat, long = calgary.lat+r.Float64()-0.5, calgary.long+r.Float64()-0.5
+		}
+		maxDistance := float64(r.Intn(3)) * r.Float64() * 1000000
+		limit := r.Intn(20)
+		assertNeighbors(
+			t,
+			bruteForceNearest(points, lat, long, maxDistance, limit),
+			g.FindNearest(lat, long, maxDistance, limit),
+			"lat=%f, long=%f, maxDistance=%f, limit=%d", lat, long, maxDistance, limit,
+		)
+	}
+}
+
+func testFindInBox(t *testing.T, g geostore.GeoStore) {
+	insertPoints(g, testPoints)
+
+	testData := []struct {
+		minLat, minLong, maxLat, maxLong float64
+		expected                         []uint64
+	}{
+		// Alberta
+		{minLat: 49, minLong: -120, maxLat: 60, maxLong: -110, expected: []uint64{1, 2, 3}},
+		// The whole globe
+		{
+			minLat:   -90,
+			minLong:  -180,
+			maxLat:   90,
+			maxLong:  180,
+			expected: []uint64{1, 2, 3, 4, 5, 6, 7},
+		},
+		// Across the antimeridian, including the node exactly on it
+		{minLat: -20, minLong: 175, maxLat: 0, maxLong: -175, expected: []uint64{4, 5, 7}},
+		// The same longitudes not crossing the antimeridian
+		{minLat: -20, minLong: -175, maxLat: 0, maxLong: 175, expected: nil},
+		// The edges of the box are inclusive
+		{
+			minLat:   calgary.lat,
+			minLong:  calgary.long,
+			maxLat:   edmonton.lat,
+			maxLong:  edmonton.long,
+			expected: []uint64{1, 2},
+		},
+		// The middle of the Pacific
+		{minLat: -10, minLong: -170, maxLat: 10, maxLong: -150, expected: nil},
+	}
+	for _, td := range testData {
+		actual := g.FindInBox(td.minLat, td.minLong, td.maxLat, td.maxLong)
+		assert.Equal(t, td.expected, actual, "%+v", td)
+	}
+}
+
+func testFindInBoxMatchesBruteForce(t *testing.T, g geostore.GeoStore) {
+	r := rand.New(rand.NewSource(42))
+	points := randomPoints(r, 500)
+	insertPoints(g, points)
+
+	for i := 0; i < 50; i++ {
+		minLat := r.Float64()*180 - 90
+		maxLat := minLat + r.Float64()*(90-minLat)
+		minLong := r.Float64()*360 - 180
+		maxLong := r.Float64()*360 - 180
+		if i%2 == 0 {
+			minLat, maxLat = calgary.lat-r.Float64()/2, calgary.lat+r.Float64()/2
+			minLong, maxLong = calgary.long-r.Float64()/2, calgary.long+r.Float64()/2
+		}
+		assert.Equal(
+			t,
+			bruteForceInBox(points, minLat, minLong, maxLat, maxLong),
+			g.FindInBox(minLat, minLong, maxLat, maxLong),
+			"minLat=%f, minLong=%f, maxLat=%f, maxLong=%f", minLat, minLong, maxLat, maxLong,
+		)
+	}
+}
+
+func testFindInGeohash(t *testing.T, g geostore.GeoStore) {
+	insertPoints(g, testPoints)
+
+	testData := []struct {
+		geohash  string
+		expected []uint64
+	}{
+		{geohash: "c3", expected: []uint64{1, 2, 3}},
+		// Geohashes are case insensitive
+		{geohash: "C3NFKH", expected: []uint64{1}},
+		{geohash: "r", expected: []uint64{5, 6, 7}},
+		{geohash: "2hb4dr", expected: []uint64{4}},
+		// The empty geohash is the whole globe
+		{geohash: "", expected: []uint64{1, 2, 3, 4, 5, 6, 7}},
+		{geohash: "s0", expected: nil},
+		// Longer than a full precision geohash
+		{geohash: "c3nfkhzzzzzzz", expected: nil},
+	}
+	for _, td := range testData {
+		actual, err := g.FindInGeohash(td.geohash)
+		assert.Nil(t, err, td.geohash)
+		assert.Equal(t, td.expected, actual, td.geohash)
+	}
+
+	_, err := g.FindInGeohash("c3a")
+	assert.NotNil(t, err)
+}
+
+func testFindInGeohashMatchesBruteForce(t *testing.T, g geostore.GeoStore) {
+	r := rand.New(rand.NewSource(42))
+	points := randomPoints(r, 500)
+	insertPoints(g, points)
+
+	for i := 0; i < 50; i++ {
+		// Use the geohash of one of the points so that the cell is not always empty
+		p := points[r.Intn(len(points))]
+		geohash := geostore.EncodeGeohash(p.lat, p.long, 1+r.Intn(6))
+
+		var expected []uint64
+		for _, p := range points {
+			if geostore.EncodeGeohash(p.lat, p.long, len(geohash)) == geohash {
+				expected = append(expected, p.id)
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+		actual, err := g.FindInGeohash(geohash)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual, "geohash=%s", geohash)
+	}
+}
+
+func testFindInPolygons(t *testing.T, g geostore.GeoStore) {
+	insertPoints(g, testPoints)
+
+	// A rough outline of Alberta, with a hole around Calgary, a triangle around Sydney, and an
+	// overlapping Polygon around Calgary which should not result in duplicates.
+	alberta := geostore.Polygon{
+		geostore.Ring{{-120, 49}, {-110, 49}, {-110, 60}, {-120, 60}, {-120, 49}},
+		geostore.Ring{
+			{-114.5, 50.8}, {-113.8, 50.8}, {-113.8, 51.3}, {-114.5, 51.3}, {-114.5, 50.8},
+		},
+	}
+	assert.Equal(t, []uint64{2, 3}, g.FindInPolygons([]geostore.Polygon{alberta}))
+	aroundSydney := geostore.Polygon{geostore.Ring{{150, -35}, {152, -35}, {151, -33}, {150, -35}}}
+	aroundCalgary := geostore.Polygon{
+		geostore.Ring{{-115, 50}, {-113, 50}, {-113, 52}, {-115, 52}, {-115, 50}},
+	}
+	assert.Equal(
+		t,
+		[]uint64{1, 2, 3, 6},
+		g.FindInPolygons([]geostore.Polygon{alberta, aroundSydney, aroundCalgary}),
+	)
+
+	// Nothing in the middle of the Pacific, or in an empty list of Polygons
+	pacific := geostore.Polygon{geostore.Ring{{-170, -10}, {-150, -10}, {-160, 10}, {-170, -10}}}
+	assert.Empty(t, g.FindInPolygons([]geostore.Polygon{pacific}))
+	assert.Empty(t, g.FindInPolygons(nil))
+	assert.Empty(t, g.FindInPolygons([]geostore.Polygon{{}}))
+}
+
+func testAntimeridian(t *testing.T, g geostore.GeoStore) {
+	points := []point{
+		{id: 1, lat: -17.8, long: 179.9},
+		{id: 2, lat: -17.8, long: -179.9},
+		{id: 3, lat: -17.8, long: 179},
+		{id: 4, lat: -17.8, long: -178},
+		// The same place on either side of the antimeridian
+		{id: 5, lat: 0, long: 180},
+		{id: 6, lat: 0, long: -180},
+		{id: 7, lat: 0, long: 0},
+	}
+	insertPoints(g, points)
+
+	// Nearest, on either side of and exactly on the antimeridian
+	assert.Equal(t, []uint64{1, 2, 3, 4}, neighborIds(g.FindNearest(-17.8, 179.95, 0, 4)))
+	assert.Equal(t, []uint64{2, 1, 3, 4}, neighborIds(g.FindNearest(-17.8, -179.95, 0, 4)))
+	assert.Equal(t, []uint64{5, 6}, neighborIds(g.FindNearest(0, -180, 0, 2)))
+	assert.Equal(t, []uint64{5, 6}, neighborIds(g.FindNearest(0, 180, 0, 2)))
+
+	// Within a radius, where a degree of longitude is ~106km
+	assert.Equal(t, []uint64{1, 2}, neighborIds(g.FindNearest(-17.8, 179.99, 21000, 0)))
+	assert.Equal(t, []uint64{2, 1}, neighborIds(g.FindNearest(-17.8, -179.85, 30000, 0)))
+	assert.Equal(t, []uint64{2}, neighborIds(g.FindNearest(-17.8, -179.85, 20000, 0)))
+
+	for _, td := range []struct{ lat, long, maxDistance float64 }{
+		{lat: -17.8, long: 179.95},
+		{lat: -17.8, long: -180, maxDistance: 150000},
+		{lat: 0, long: 179.5, maxDistance: 100000},
+		{lat: -10, long: -179},
+	} {
+		assertNeighbors(
+			t,
+			bruteForceNearest(points, td.lat, td.long, td.maxDistance, 0),
+			g.FindNearest(td.lat, td.long, td.maxDistance, 0),
+			"%+v", td,
+		)
+	}
+
+	// Boxes that cross the antimeridian, and boxes with an edge on it, which include the nodes on
+	// it at either 180 or -180
+	testData := []struct {
+		minLat, minLong, maxLat, maxLong float64
+		expected                         []uint64
+	}{
+		{minLat: -20, minLong: 179.5, maxLat: -15, maxLong: -179.5, expected: []uint64{1, 2}},
+		{minLat: -20, minLong: 178, maxLat: 1, maxLong: -177, expected: []uint64{1, 2, 3, 4, 5, 6}},
+		{minLat: -1, minLong: 170, maxLat: 1, maxLong: 180, expected: []uint64{5, 6}},
+		{minLat: -1, minLong: -180, maxLat: 1, maxLong: -170, expected: []uint64{5, 6}},
+		{minLat: -20, minLong: -180, maxLat: -15, maxLong: -179.5, expected: []uint64{2}},
+		{minLat: -20, minLong: 179.95, maxLat: -15, maxLong: -179.95, expected: nil},
+	}
+	for _, td := range testData {
+		actual := g.FindInBox(td.minLat, td.minLong, td.maxLat, td.maxLong)
+		assert.Equal(t, td.expected, actual, "%+v", td)
+	}
+}
+
+func testPoles(t *testing.T, g geostore.GeoStore) {
+	points := []point{
+		{id: 1, lat: 89.9, long: 0},
+		{id: 2, lat: 89.9, long: 180},
+		{id: 3, lat: 89.9, long: 90},
+		{id: 4, lat: 89.9, long: -90},
+		// The North pole, which is at every longitude
+		{id: 5, lat: 90, long: 45},
+		{id: 6, lat: -89.5, long: 10},
+		{id: 7, lat: -90, long: -120},
+		{id: 8, lat: 60, long: 0},
+	}
+	insertPoints(g, points)
+
+	// The nearest to the pole are the pole itself and then the nodes around it at any longitude
+	nearest := neighborIds(g.FindNearest(90, -120, 0, 5))
+	assert.Equal(t, uint64(5), nearest[0])
+	assert.ElementsMatch(t, []uint64{1, 2, 3, 4}, nearest[1:])
+	assert.Equal(t, []uint64{7, 6}, neighborIds(g.FindNearest(-90, 0, 0, 2)))
+
+	// Across the pole, a node on the opposite side is nearer than one further South on the same
+	// meridian
+	assert.Equal(t, []uint64{1, 5, 3, 4, 2}, neighborIds(g.FindNearest(89.95, 0, 20000, 0)))
+
+	for _, td := range []struct{ lat, long, maxDistance float64 }{
+		{lat: 90, long: 0},
+		{lat: 89.99, long: 135, maxDistance: 15000},
+		{lat: 89, long: -170, maxDistance: 200000},
+		{lat: -89.9, long: 170},
+		{lat: -90, long: 0, maxDistance: 100000},
+	} {
+		assertNeighbors(
+			t,
+			bruteForceNearest(points, td.lat, td.long, td.maxDistance, 0),
+			g.FindNearest(td.lat, td.long, td.maxDistance, 0),
+			"%+v", td,
+		)
+	}
+
+	// A box with an edge at a pole includes the node at that pole whatever its longitude
+	testData := []struct {
+		minLat, minLong, maxLat, maxLong float64
+		expected                         []uint64
+	}{
+		{minLat: 89.5, minLong: -180, maxLat: 90, maxLong: 180, expected: []uint64{1, 2, 3, 4, 5}},
+		{minLat: 89.5, minLong: -10, maxLat: 90, maxLong: 10, expected: []uint64{1, 5}},
+		{minLat: 89.5, minLong: 170, maxLat: 90, maxLong: -170, expected: []uint64{2, 5}},
+		{minLat: 89.5, minLong: -10, maxLat: 89.95, maxLong: 10, expected: []uint64{1}},
+		{minLat: -90, minLong: 0, maxLat: -89, maxLong: 20, expected: []uint64{6, 7}},
+		{minLat: -90, minLong: 0, maxLat: -90, maxLong: 0, expected: []uint64{7}},
+	}
+	for _, td := range testData {
+		actual := g.FindInBox(td.minLat, td.minLong, td.maxLat, td.maxLong)
+		assert.Equal(t, td.expected, actual, "%+v", td)
+	}
+}
+
+func testInsert(t *testing.T, g geostore.GeoStore) {
+	// Inserting a Node with the same id as an existing one replaces it
+	g.Insert(geostore.NewNode(10, 10, 1))
+	g.Insert(geostore.NewNode(-10, -10, 1))
+	assert.Equal(t, []uint64{1}, neighborIds(g.FindNearest(-10, -10, 0, 0)))
+	assert.Empty(t, g.FindNearest(10, 10, 1000, 0))
+
+	// A Node that is out of bounds is ignored
+	g.Insert(geostore.NewNode(10, 95, 2))
+	assert.Equal(t, []uint64{1}, neighborIds(g.FindNearest(0, 0, 0, 0)))
+	var notFoundErr *geostore.NodeNotFoundErr
+	assert.ErrorAs(t, g.Remove(2), &notFoundErr)
+}
+
+func testInsertMany(t *testing.T, g geostore.GeoStore) {
+	r := rand.New(rand.NewSource(42))
+	points := randomPoints(r, 500)
+	g.InsertMany(pointNodes(points))
+	assertMatchesBruteForce(t, g, r, points)
+
+	// A batch smaller than the GeoStore, which replaces some of the existing Nodes, removes one by
+	// replacing it with a Node that is out of bounds, and adds new Nodes
+	var batch []point
+	for i := range points[:50] {
+		points[i].lat, points[i].long = r.Float64()*180-90, r.Float64()*360-180
+		batch = append(batch, points[i])
+	}
+	nodes := append(pointNodes(batch), geostore.NewNode(10, 95, points[50].id))
+	points = append(points[:50], points[51:]...)
+	added := randomPoints(r, 600)[500:]
+	nodes = append(nodes, pointNodes(added)...)
+	points = append(points, added...)
+	g.InsertMany(nodes)
+	assertMatchesBruteForce(t, g, r, points)
+
+	// A batch larger than the GeoStore, in which the last of the Nodes with the same id wins
+	added = randomPoints(r, 2000)[600:]
+	nodes = pointNodes(added)
+	nodes = append(nodes, geostore.NewNode(added[0].long+1, added[0].lat, added[0].id))
+	added[0].long++
+	points = append(points, added...)
+	g.InsertMany(nodes)
+	assertMatchesBruteForce(t, g, r, points)
+
+	// Removing most of the Nodes after a bulk load
+	for _, p := range points[100:] {
+		assert.Nil(t, g.Remove(p.id))
+	}
+	assertMatchesBruteForce(t, g, r, points[:100])
+	g.InsertMany(nil)
+	assertMatchesBruteForce(t, g, r, points[:100])
+}
+
+func pointNodes(points []point) []*geostore.Node {
+	retval := make([]*geostore.Node, len(points))
+	for i, p := range points {
+		retval[i] = p.node()
+	}
+	return retval
+}
+
+// assertMatchesBruteForce runs random nearest neighbor and bounding box queries against the
+// GeoStore, which should contain exactly the points, and compares them with a brute force search.
+func assertMatchesBruteForce(t *testing.T, g geostore.GeoStore, r *rand.Rand, points []point) {
+	for i := 0; i < 20; i++ {
+		lat, long := r.Float64()*180-90, r.Float64()*360-180
+		limit := r.Intn(20)
+		assertNeighbors(
+			t,
+			bruteForceNearest(points, lat, long, 0, limit),
+			g.FindNearest(lat, long, 0, limit),
+			"lat=%f, long=%f, limit=%d", lat, long, limit,
+		)
+		minLat, minLong := lat-r.Float64()*20, long-r.Float64()*20
+		assert.Equal(
+			t,
+			bruteForceInBox(points, minLat, minLong, lat, long),
+			g.FindInBox(minLat, minLong, lat, long),
+			"minLat=%f, minLong=%f, maxLat=%f, maxLong=%f", minLat, minLong, lat, long,
+		)
+	}
+}
+
+func testMove(t *testing.T, g geostore.GeoStore) {
+	insertPoints(g, testPoints)
+
+	// Move Calgary to Perth, Australia, after which it should be the nearest node to Sydney and no
+	// longer near any of the other Canadian nodes.
+	assert.Nil(t, g.Move(calgary.id, -31.95, 115.86))
+	assert.Equal(t, []uint64{6, 1}, neighborIds(g.FindNearest(sydney.lat, sydney.long, 0, 2)))
+	assert.Equal(
+		t,
+		[]uint64{3, 2},
+		neighborIds(g.FindNearest(calgary.lat, calgary.long, 300000, 0)),
+	)
+	assert.Equal(t, []uint64{2, 3}, g.FindInBox(49, -120, 60, -110))
+
+	// Moving a node that does not exist is a NodeNotFoundErr
+	var notFoundErr *geostore.NodeNotFoundErr
+	assert.ErrorAs(t, g.Move(42, 10, 10), &notFoundErr)
+	assert.Equal(t, uint64(42), notFoundErr.Id)
+
+	// Moving a node out of bounds is an OutOfBoundsErr, and leaves the node in place
+	var outOfBoundsErr *geostore.OutOfBoundsErr
+	assert.ErrorAs(t, g.Move(calgary.id, 10, 200), &outOfBoundsErr)
+	assert.Equal(t, 10.0, outOfBoundsErr.Lat)
+	assert.Equal(t, 200.0, outOfBoundsErr.Long)
+	assert.ErrorAs(t, g.Move(calgary.id, -91, 10), &outOfBoundsErr)
+	assert.Equal(t, []uint64{1}, neighborIds(g.FindNearest(-31.95, 115.86, 1, 0)))
+}
+
+func testRemove(t *testing.T, g geostore.GeoStore) {
+	insertPoints(g, testPoints)
+
+	assert.Nil(t, g.Remove(calgary.id))
+	ids := neighborIds(g.FindNearest(calgary.lat, calgary.long, 0, 0))
+	assert.NotContains(t, ids, calgary.id)
+	assert.Equal(t, len(testPoints)-1, len(ids))
+	assert.Equal(t, []uint64{2, 3}, g.FindInBox(49, -120, 60, -110))
+
+	// Removing it a second time is a NodeNotFoundErr
+	var notFoundErr *geostore.NodeNotFoundErr
+	assert.ErrorAs(t, g.Remove(calgary.id), &notFoundErr)
+	assert.Equal(t, calgary.id, notFoundErr.Id)
+
+	// Removing all of the rest leaves an empty GeoStore
+	for _, p := range testPoints[1:] {
+		assert.Nil(t, g.Remove(p.id))
+	}
+	assert.Empty(t, g.FindNearest(10, 10, 0, 0))
+	assert.Empty(t, g.FindInBox(-90, -180, 90, 180))
+}
+
+func testIdenticalCoordinates(t *testing.T, g geostore.GeoStore) {
+	// Many more Nodes at exactly the same coordinates than would fit in any one bucket, cell or
+	// leaf of an implementation.
+	var points []point
+	var ids []uint64
+	for i := 1; i <= 100; i++ {
+		points = append(points, point{id: uint64(i), lat: banff.lat, long: banff.long})
+		ids = append(ids, uint64(i))
+	}
+	insertPoints(g, points)
+
+	// Nodes at the same distance are ordered by id
+	assert.Equal(t, ids, neighborIds(g.FindNearest(banff.lat, banff.long, 0, 0)))
+	assert.Equal(t, ids[:10], neighborIds(g.FindNearest(calgary.lat, calgary.long, 0, 10)))
+	assert.Equal(t, ids, g.FindInBox(banff.lat, banff.long, banff.lat, banff.long))
+
+	for _, id := range ids[:90] {
+		assert.Nil(t, g.Remove(id))
+	}
+	assert.Equal(t, ids[90:], neighborIds(g.FindNearest(banff.lat, banff.long, 0, 0)))
+	assert.Nil(t, g.Move(ids[90], calgary.lat, calgary.long))
+	assert.Equal(t, ids[91:], g.FindInBox(banff.lat, banff.long, banff.lat, banff.long))
+}
+
+func testConcurrency(t *testing.T, g geostore.GeoStore) {
+	// Each worker inserts, moves, queries and removes its own Nodes while the others do the same,
+	// so the race detector can find any unsynchronized access.
+	const workers = 8
+	const perWorker = 50
+	r := rand.New(rand.NewSource(42))
+	points := randomPoints(r, workers*perWorker)
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(points []point) {
+			defer wg.Done()
+			for _, p := range points {
+				g.Insert(p.node())
+				g.FindNearest(p.lat, p.long, 0, 5)
+				g.FindInBox(p.lat-1, p.long-1, p.lat+1, p.long+1)
+			}
+			for i, p := range points {
+				if i%2 == 0 {
+					assert.Nil(t, g.Remove(p.id))
+				} else {
+					assert.Nil(t, g.Move(p.id, p.lat/2, p.long/2))
+				}
+			}
+		}(points[w*perWorker : (w+1)*perWorker])
+	}
+	wg.Wait()
+
+	var remaining []point
+	for i, p := range points {
+		if (i%perWorker)%2 == 1 {
+			remaining = append(remaining, point{id: p.id, lat: p.lat / 2, long: p.long / 2})
+		}
+	}
+	assert.Equal(t, bruteForceInBox(remaining, -90, -180, 90, 180), g.FindInBox(-90, -180, 90, 180))
+}
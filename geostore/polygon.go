@@ -0,0 +1,59 @@
+package geostore
+
+import "math"
+
+// Ring is a closed linear ring of positions, each of which is a [long, lat] pair in the same order
+// as GeoJSON.  The first and last positions are the same.
+type Ring [][2]float64
+
+// Polygon is a list of Rings.  The first Ring is the exterior boundary of the Polygon and any
+// subsequent Rings are holes within it.
+type Polygon []Ring
+
+// contains returns true if the gps coordinate is within the Ring, using the even-odd rule by
+// casting a ray from the coordinate due East and counting how many edges of the Ring it crosses.
+func (r Ring) contains(lat, long float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		longI, latI := r[i][0], r[i][1]
+		longJ, latJ := r[j][0], r[j][1]
+		if (latI > lat) != (latJ > lat) &&
+			long < (longJ-longI)*(lat-latI)/(latJ-latI)+longI {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Contains returns true if the gps coordinate is within the exterior Ring of the Polygon and not
+// within any of its holes.
+func (p Polygon) Contains(lat, long float64) bool {
+	if len(p) == 0 || !p[0].contains(lat, long) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.contains(lat, long) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bounds returns the bounding box of the exterior Ring of the Polygon.
+func (p Polygon) Bounds() (minLat, minLong, maxLat, maxLong float64) {
+	minLat, minLong = math.Inf(1), math.Inf(1)
+	maxLat, maxLong = math.Inf(-1), math.Inf(-1)
+	for _, position := range p[0] {
+		minLong = math.Min(minLong, position[0])
+		maxLong = math.Max(maxLong, position[0])
+		minLat = math.Min(minLat, position[1])
+		maxLat = math.Max(maxLat, position[1])
+	}
+	return minLat, minLong, maxLat, maxLong
+}
+
+// quadrant returns the bounding box of the exterior Ring of the Polygon as a Quadrant.
+func (p Polygon) quadrant() *Quadrant {
+	minLat, minLong, maxLat, maxLong := p.Bounds()
+	return NewQuadrant(minLong, minLat, maxLong, maxLat, true)
+}
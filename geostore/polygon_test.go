@@ -0,0 +1,68 @@
+package geostore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A square around Alberta, with a hole around Red Deer
+var albertaWithHole = Polygon{
+	Ring{{-120, 49}, {-110, 49}, {-110, 60}, {-120, 60}, {-120, 49}},
+	Ring{{-114.5, 52}, {-113, 52}, {-113, 52.5}, {-114.5, 52.5}, {-114.5, 52}},
+}
+
+func TestPolygonContains(t *testing.T) {
+	testData := []struct {
+		lat, long float64
+		expected  bool
+	}{
+		{lat: 51.04653767382061, long: -114.06243444911559, expected: true},
+		{lat: 53.678868921462815, long: -113.37174481536333, expected: true},
+		// Red Deer is in the hole
+		{lat: 52.26871035649865, long: -113.80500041394141, expected: false},
+		// Saskatchewan is to the East
+		{lat: 53.61760431337473, long: -106.72319029988779, expected: false},
+		// Oregon is to the South
+		{lat: 43.38552157601114, long: -120.54074440145642, expected: false},
+	}
+	for _, td := range testData {
+		assert.Equal(t, td.expected, albertaWithHole.Contains(td.lat, td.long), "%+v", td)
+	}
+}
+
+func TestPolygonContainsConcave(t *testing.T) {
+	// A "U" shape opening to the North
+	u := Polygon{
+		Ring{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3}, {0, 3}, {0, 0}},
+	}
+	assert.True(t, u.Contains(2, 0.5))
+	assert.True(t, u.Contains(2, 2.5))
+	assert.True(t, u.Contains(0.5, 1.5))
+	// Within the bounding box, but in the gap of the "U"
+	assert.False(t, u.Contains(2, 1.5))
+}
+
+func TestFindInPolygons(t *testing.T) {
+	g := getTestGeoStore(2)
+	for _, n := range testNodes {
+		g.Insert(n)
+	}
+
+	assert.Equal(t, []uint64{6, 7, 9}, g.FindInPolygons([]Polygon{albertaWithHole}))
+
+	// A MultiPolygon of Alberta and a triangle around the Australian node, along with an overlapping
+	// Polygon around Calgary which should not result in duplicates.
+	australia := Polygon{Ring{{120, -30}, {130, -30}, {125, -20}, {120, -30}}}
+	calgary := Polygon{Ring{{-115, 50}, {-113, 50}, {-113, 52}, {-115, 52}, {-115, 50}}}
+	assert.Equal(
+		t,
+		[]uint64{3, 6, 7, 9},
+		g.FindInPolygons([]Polygon{albertaWithHole, australia, calgary}),
+	)
+
+	// Nothing in the middle of the Pacific, or in an empty list of Polygons
+	pacific := Polygon{Ring{{-170, -10}, {-150, -10}, {-160, 10}, {-170, -10}}}
+	assert.Empty(t, g.FindInPolygons([]Polygon{pacific}))
+	assert.Empty(t, g.FindInPolygons(nil))
+}
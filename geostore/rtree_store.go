@@ -0,0 +1,484 @@
+package geostore
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// rtreeMaxEntries is the most Nodes, or children, that a node of an RTreeGeoStore holds before
+	// it is split in two.
+	rtreeMaxEntries = 16
+	// rtreeMinEntries is the fewest Nodes, or children, that a node other than the root holds after
+	// a removal.  A node left with fewer is removed from the tree and its Nodes are reinserted.
+	rtreeMinEntries = rtreeMaxEntries * 2 / 5
+)
+
+// rtreeNode is a node of an RTreeGeoStore.  A leaf holds Nodes, any other node holds child
+// rtreeNodes, and bounds is the smallest Quadrant that contains all of them.  All of the leaves are
+// at the same depth.
+type rtreeNode struct {
+	bounds   Quadrant
+	leaf     bool
+	children []*rtreeNode
+	nodes    []*Node
+}
+
+func (n *rtreeNode) size() int {
+	if n.leaf {
+		return len(n.nodes)
+	}
+	return len(n.children)
+}
+
+// emptyQuadrant returns a Quadrant that contains nothing, and that does not intersect any other.
+func emptyQuadrant() Quadrant {
+	return Quadrant{XMin: math.Inf(1), YMin: math.Inf(1), XMax: math.Inf(-1), YMax: math.Inf(-1)}
+}
+
+func pointQuadrant(node *Node) Quadrant {
+	return Quadrant{XMin: node.X, YMin: node.Y, XMax: node.X, YMax: node.Y}
+}
+
+// union returns the smallest Quadrant that contains both Quadrants.
+func (q Quadrant) union(other Quadrant) Quadrant {
+	return Quadrant{
+		XMin: math.Min(q.XMin, other.XMin),
+		YMin: math.Min(q.YMin, other.YMin),
+		XMax: math.Max(q.XMax, other.XMax),
+		YMax: math.Max(q.YMax, other.YMax),
+	}
+}
+
+func (q Quadrant) area() float64 {
+	return (q.XMax - q.XMin) * (q.YMax - q.YMin)
+}
+
+func (q Quadrant) center() (x, y float64) {
+	return (q.XMin + q.XMax) / 2, (q.YMin + q.YMax) / 2
+}
+
+// updateBounds recomputes the bounds of the node from its Nodes or children.
+func (n *rtreeNode) updateBounds() {
+	n.bounds = emptyQuadrant()
+	for _, node := range n.nodes {
+		n.bounds = n.bounds.union(pointQuadrant(node))
+	}
+	for _, child := range n.children {
+		n.bounds = n.bounds.union(child.bounds)
+	}
+}
+
+// insert inserts the Node into this subtree.  If this node then has more than rtreeMaxEntries
+// entries it is split, and the new sibling, which the caller must add to the parent, is returned.
+func (n *rtreeNode) insert(node *Node) *rtreeNode {
+	n.bounds = n.bounds.union(pointQuadrant(node))
+	if n.leaf {
+		n.nodes = append(n.nodes, node)
+	} else if sibling := n.chooseChild(node).insert(node); sibling != nil {
+		n.children = append(n.children, sibling)
+	}
+	if n.size() <= rtreeMaxEntries {
+		return nil
+	}
+	return n.split()
+}
+
+// chooseChild returns the child whose bounds need the least enlargement to contain the Node,
+// preferring the smallest of the children that need the same enlargement.
+func (n *rtreeNode) chooseChild(node *Node) *rtreeNode {
+	point := pointQuadrant(node)
+	var retval *rtreeNode
+	bestEnlargement, bestArea := math.Inf(1), math.Inf(1)
+	for _, child := range n.children {
+		area := child.bounds.area()
+		enlargement := child.bounds.union(point).area() - area
+		if enlargement < bestEnlargement || (enlargement == bestEnlargement && area < bestArea) {
+			retval, bestEnlargement, bestArea = child, enlargement, area
+		}
+	}
+	return retval
+}
+
+// split moves half of the entries of this node into a new sibling, which is returned.  The entries
+// are divided by their centers along the longer side of the bounds of this node.
+func (n *rtreeNode) split() *rtreeNode {
+	byX := n.bounds.XMax-n.bounds.XMin >= n.bounds.YMax-n.bounds.YMin
+	half := n.size() / 2
+	sibling := &rtreeNode{leaf: n.leaf}
+	if n.leaf {
+		sortByCenter(n.nodes, byX, pointQuadrant)
+		sibling.nodes = append([]*Node(nil), n.nodes[half:]...)
+		n.nodes = n.nodes[:half:half]
+	} else {
+		sortByCenter(n.children, byX, func(c *rtreeNode) Quadrant { return c.bounds })
+		sibling.children = append([]*rtreeNode(nil), n.children[half:]...)
+		n.children = n.children[:half:half]
+	}
+	n.updateBounds()
+	sibling.updateBounds()
+	return sibling
+}
+
+func sortByCenter[T any](items []T, byX bool, bounds func(T) Quadrant) {
+	sort.Slice(items, func(i, j int) bool {
+		xi, yi := bounds(items[i]).center()
+		xj, yj := bounds(items[j]).center()
+		if byX {
+			return xi < xj
+		}
+		return yi < yj
+	})
+}
+
+// remove removes the Node from this subtree, returning true if it was found.  Any child that is
+// left with fewer than rtreeMinEntries entries is removed, and all of the Nodes within it are
+// appended to orphans so that the caller can reinsert them.
+func (n *rtreeNode) remove(node *Node, orphans *[]*Node) bool {
+	if n.leaf {
+		for i, e := range n.nodes {
+			if e.Id == node.Id {
+				n.nodes = append(n.nodes[:i], n.nodes[i+1:]...)
+				n.updateBounds()
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, child := range n.children {
+		if !child.bounds.inQuadrant(node) || !child.remove(node, orphans) {
+			continue
+		}
+		if child.size() < rtreeMinEntries {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			*orphans = child.collect(*orphans)
+		}
+		n.updateBounds()
+		return true
+	}
+	return false
+}
+
+// collect appends all of the Nodes in this subtree.
+func (n *rtreeNode) collect(nodes []*Node) []*Node {
+	if n.leaf {
+		return append(nodes, n.nodes...)
+	}
+	for _, child := range n.children {
+		nodes = child.collect(nodes)
+	}
+	return nodes
+}
+
+// bulkLoadRTree builds a tree of the Nodes with the Sort-Tile-Recursive algorithm.  The Nodes are
+// packed into full leaves of nearby Nodes, and then each level of the tree is packed in the same
+// way from the bounds of the level below it, until there is a single root.
+func bulkLoadRTree(nodes []*Node) *rtreeNode {
+	level := []*rtreeNode{}
+	for _, group := range strPack(nodes, pointQuadrant) {
+		leaf := &rtreeNode{leaf: true, nodes: group}
+		leaf.updateBounds()
+		level = append(level, leaf)
+	}
+	if len(level) == 0 {
+		return &rtreeNode{leaf: true, bounds: emptyQuadrant()}
+	}
+	for len(level) > 1 {
+		var parents []*rtreeNode
+		for _, group := range strPack(level, func(c *rtreeNode) Quadrant { return c.bounds }) {
+			parent := &rtreeNode{children: group}
+			parent.updateBounds()
+			parents = append(parents, parent)
+		}
+		level = parents
+	}
+	return level[0]
+}
+
+// strPack partitions the items into groups of rtreeMaxEntries items that are near each other.  The
+// items are sorted by the x of their centers and cut into vertical slices of roughly the square
+// root of the number of groups, each of which is then sorted by y and cut into groups.
+func strPack[T any](items []T, bounds func(T) Quadrant) [][]T {
+	groupCount := (len(items) + rtreeMaxEntries - 1) / rtreeMaxEntries
+	sliceSize := int(math.Ceil(math.Sqrt(float64(groupCount)))) * rtreeMaxEntries
+
+	var retval [][]T
+	sortByCenter(items, true, bounds)
+	for start := 0; start < len(items); start += sliceSize {
+		slice := items[start:minInt(start+sliceSize, len(items))]
+		sortByCenter(slice, false, bounds)
+		for s := 0; s < len(slice); s += rtreeMaxEntries {
+			group := slice[s:minInt(s+rtreeMaxEntries, len(slice))]
+			retval = append(retval, append([]T(nil), group...))
+		}
+	}
+	return retval
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// RTreeGeoStore is a GeoStore that indexes its Nodes in an R-tree, in which each node of the tree
+// is the bounding box of the Nodes beneath it.  Unlike the QuadTree, the tree is always balanced,
+// and a large batch of Nodes inserted with InsertMany is bulk loaded into a densely packed tree,
+// making it well suited to large datasets that are loaded all at once and are mostly read.
+type RTreeGeoStore struct {
+	root *rtreeNode
+	// nodes indexes each of the Nodes by id so that we can find their coordinates, and thus the
+	// leaves in which they are stored, when removing or moving them.
+	nodes    map[uint64]*Node
+	distance DistanceFunc
+	mux      *sync.RWMutex
+}
+
+func NewRTreeGeoStore(distance DistanceFunc) *RTreeGeoStore {
+	return &RTreeGeoStore{
+		root:     &rtreeNode{leaf: true, bounds: emptyQuadrant()},
+		nodes:    make(map[uint64]*Node),
+		distance: distance,
+		mux:      &sync.RWMutex{},
+	}
+}
+
+// rtreeGlobe returns the Quadrant of the whole globe, outside of which Nodes are ignored.
+func rtreeGlobe() Quadrant {
+	return Quadrant{XMin: 0, YMin: 0, XMax: 360, YMax: 180}
+}
+
+func (g *RTreeGeoStore) Distance(lat1, long1, lat2, long2 float64) float64 {
+	return g.distance(lat1, long1, lat2, long2)
+}
+
+// Find returns the id of a Node at exactly the provided lat/long coordinates, or 0 if there is not
+// one.
+func (g *RTreeGeoStore) Find(lat, long float64) uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	node := NewNode(long, lat, 0)
+	stack := &Stack[rtreeNode]{}
+	stack.Push(g.root)
+	for !stack.IsEmpty() {
+		current := stack.Pop()
+		if !current.bounds.inQuadrant(node) {
+			continue
+		}
+		for _, n := range current.nodes {
+			if n.X == node.X && n.Y == node.Y {
+				return n.Id
+			}
+		}
+		for _, child := range current.children {
+			stack.Push(child)
+		}
+	}
+	return 0
+}
+
+func rtreeNodeBounds(n *rtreeNode) Quadrant {
+	return n.bounds
+}
+
+// expandRTreeNode visits the children of the tree node, or its Nodes if it is a leaf.
+func expandRTreeNode(n *rtreeNode, visitCell func(*rtreeNode), visitNode func(*Node)) {
+	for _, child := range n.children {
+		visitCell(child)
+	}
+	for _, node := range n.nodes {
+		visitNode(node)
+	}
+}
+
+// FindNearest will return a slice of the ids and distances of the nodes that are nearest to the
+// provided lat/long coordinates, ordered by ascending distance, with the same semantics for the
+// maxDistance and limit as InMemGeoStore.FindNearest.
+//
+// The search is a best-first traversal of the tree, in which tree nodes are keyed by the lower
+// bound of the distance to their bounds, see findNearest.
+func (g *RTreeGeoStore) FindNearest(
+	lat, long, maxDistance float64,
+	limit int,
+) []Neighbor {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	if g.root.size() == 0 {
+		return nil
+	}
+	return findNearest(lat, long, maxDistance, limit, g.distance, g.root,
+		rtreeNodeBounds, expandRTreeNode)
+}
+
+// FindInBox will return the ids, in ascending order, of all of the nodes within the bounding box
+// defined by the provided gps coordinates, inclusive of its edges.  If minLong is greater than
+// maxLong the box is treated as crossing the antimeridian.
+func (g *RTreeGeoStore) FindInBox(minLat, minLong, maxLat, maxLong float64) []uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	var retval []uint64
+	for _, box := range boxToQuadrants(minLat, minLong, maxLat, maxLong) {
+		retval = append(retval, g.findInQuadrant(box, nil)...)
+	}
+	// A node exactly on the antimeridian can be in both halves of a box that crosses it.
+	return sortedUniqueIds(retval)
+}
+
+// FindInPolygons will return the ids, in ascending order, of all of the nodes within any of the
+// provided Polygons.  We first prune the tree down to the nodes that intersect the bounding box of
+// each Polygon, and only then test whether each Node is within the Polygon.
+func (g *RTreeGeoStore) FindInPolygons(polygons []Polygon) []uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	var retval []uint64
+	for _, polygon := range polygons {
+		if len(polygon) == 0 || len(polygon[0]) == 0 {
+			continue
+		}
+		retval = append(retval, g.findInQuadrant(polygon.quadrant(), func(n *Node) bool {
+			lat, long := n.gps()
+			return polygon.Contains(lat, long)
+		})...)
+	}
+	// The Polygons of a MultiPolygon could overlap
+	return sortedUniqueIds(retval)
+}
+
+// FindInGeohash will return the ids, in ascending order, of all of the nodes within the cell of the
+// geohash.
+func (g *RTreeGeoStore) FindInGeohash(geohash string) ([]uint64, error) {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	return findInGeohashCell(strings.ToLower(geohash), g.findInQuadrant)
+}
+
+func (g *RTreeGeoStore) findInQuadrant(box *Quadrant, match func(*Node) bool) []uint64 {
+	return findInQuadrant(g.root, box, match, rtreeNodeBounds, expandRTreeNode)
+}
+
+// Insert will insert the Node into the GeoStore, replacing any existing Node with the same id.
+// Nodes that are outside of the bounds of the globe are ignored.
+func (g *RTreeGeoStore) Insert(node *Node) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.insert(node)
+}
+
+// InsertMany inserts all of the Nodes, with the same semantics as calling Insert with each of them
+// in order, while only taking the lock once.  If there are at least as many Nodes in the batch as
+// there are in the GeoStore, the whole tree is rebuilt with a bulk load, which is both much faster
+// than inserting the Nodes one at a time and results in a better packed tree.
+func (g *RTreeGeoStore) InsertMany(nodes []*Node) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if len(nodes) < len(g.nodes) {
+		for _, node := range nodes {
+			g.insert(node)
+		}
+		return
+	}
+
+	globe := rtreeGlobe()
+	for _, node := range nodes {
+		if globe.inQuadrant(node) {
+			g.nodes[node.Id] = node
+		} else {
+			delete(g.nodes, node.Id)
+		}
+	}
+	all := make([]*Node, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		all = append(all, node)
+	}
+	g.root = bulkLoadRTree(all)
+}
+
+func (g *RTreeGeoStore) insert(node *Node) {
+	if existing, ok := g.nodes[node.Id]; ok {
+		g.remove(existing)
+		delete(g.nodes, node.Id)
+	}
+	globe := rtreeGlobe()
+	if !globe.inQuadrant(node) {
+		return
+	}
+	g.insertIntoTree(node)
+	g.nodes[node.Id] = node
+}
+
+// insertIntoTree inserts the Node into the tree, growing a new root if the current one is split.
+func (g *RTreeGeoStore) insertIntoTree(node *Node) {
+	sibling := g.root.insert(node)
+	if sibling == nil {
+		return
+	}
+	root := &rtreeNode{children: []*rtreeNode{g.root, sibling}}
+	root.updateBounds()
+	g.root = root
+}
+
+// remove removes the Node from the tree, reinserting the Nodes of any tree nodes that were left
+// with too few entries, and then shrinks the tree while its root has a single child.
+func (g *RTreeGeoStore) remove(node *Node) {
+	var orphans []*Node
+	g.root.remove(node, &orphans)
+	for !g.root.leaf && len(g.root.children) == 1 {
+		g.root = g.root.children[0]
+	}
+	if !g.root.leaf && len(g.root.children) == 0 {
+		g.root = &rtreeNode{leaf: true, bounds: emptyQuadrant()}
+	}
+	for _, orphan := range orphans {
+		g.insertIntoTree(orphan)
+	}
+}
+
+// Move will move the Node with the provided id to the new lat/long coordinates.  If the new
+// coordinates are out of the bounds of the globe the Node is left where it was.
+func (g *RTreeGeoStore) Move(id uint64, lat, long float64) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	existing, ok := g.nodes[id]
+	if !ok {
+		return &NodeNotFoundErr{Id: id}
+	}
+	node := NewNode(long, lat, id)
+	globe := rtreeGlobe()
+	if !globe.inQuadrant(node) {
+		return &OutOfBoundsErr{Lat: lat, Long: long}
+	}
+
+	g.remove(existing)
+	g.insertIntoTree(node)
+	g.nodes[id] = node
+	return nil
+}
+
+// Remove will remove the Node with the provided id from the GeoStore.
+func (g *RTreeGeoStore) Remove(id uint64) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	existing, ok := g.nodes[id]
+	if !ok {
+		return &NodeNotFoundErr{Id: id}
+	}
+	g.remove(existing)
+	delete(g.nodes, id)
+	return nil
+}
+
+func (g *RTreeGeoStore) Shutdown() error {
+	return nil
+}
@@ -0,0 +1,85 @@
+package geostore
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertRTreeInvariants asserts that all of the leaves of the tree are at the same depth, that no
+// node has more than rtreeMaxEntries entries, that the bounds of every node are exactly those of
+// its entries, and that the tree holds exactly the Nodes in the GeoStore.
+func assertRTreeInvariants(t *testing.T, g *RTreeGeoStore) {
+	leafDepth := -1
+	count := 0
+	var walk func(n *rtreeNode, depth int)
+	walk = func(n *rtreeNode, depth int) {
+		assert.LessOrEqual(t, n.size(), rtreeMaxEntries)
+		expected := n.bounds
+		n.updateBounds()
+		assert.Equal(t, n.bounds, expected)
+		if n.leaf {
+			if leafDepth < 0 {
+				leafDepth = depth
+			}
+			assert.Equal(t, leafDepth, depth)
+			for _, node := range n.nodes {
+				assert.Same(t, g.nodes[node.Id], node)
+			}
+			count += len(n.nodes)
+			return
+		}
+		assert.NotEmpty(t, n.children)
+		for _, child := range n.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(g.root, 0)
+	assert.Equal(t, len(g.nodes), count)
+}
+
+func TestRTreeBulkLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	nodes := make([]*Node, 10000)
+	for i := range nodes {
+		nodes[i] = NewNode(r.Float64()*360-180, r.Float64()*180-90, uint64(i+1))
+	}
+	g := NewRTreeGeoStore(Haversine)
+	g.InsertMany(nodes)
+	assertRTreeInvariants(t, g)
+
+	// A bulk loaded tree is packed into full leaves, and so is no deeper than it needs to be:
+	// 10000 Nodes fit in 625 leaves under 40 nodes under 3 nodes under the root.
+	depth := 0
+	for n := g.root; !n.leaf; n = n.children[0] {
+		depth++
+	}
+	assert.Equal(t, 3, depth)
+
+	for _, node := range nodes[:5000] {
+		g.Insert(NewNode(r.Float64()*360-180, r.Float64()*180-90, node.Id))
+	}
+	assertRTreeInvariants(t, g)
+	for _, node := range nodes[2000:] {
+		assert.Nil(t, g.Remove(node.Id))
+	}
+	assertRTreeInvariants(t, g)
+	assert.Equal(t, 2000, len(g.nodes))
+
+	for _, node := range nodes[:2000] {
+		assert.Nil(t, g.Remove(node.Id))
+	}
+	assertRTreeInvariants(t, g)
+	assert.True(t, g.root.leaf)
+	assert.Empty(t, g.FindNearest(0, 0, 0, 0))
+}
+
+func TestRTreeFind(t *testing.T) {
+	g := NewRTreeGeoStore(Haversine)
+	for i := 1; i <= 100; i++ {
+		g.Insert(NewNode(float64(i), float64(i)/2, uint64(i)))
+	}
+	assert.Equal(t, uint64(42), g.Find(21, 42))
+	assert.Equal(t, uint64(0), g.Find(21, 43))
+}
@@ -0,0 +1,127 @@
+package geostore
+
+// The GeoStores each index their Nodes in a tree of cells, a QuadTree, a geohash prefix tree or an
+// R-tree, in which every cell is bounded by a Quadrant that contains all of the Nodes beneath it.
+// The searches below are shared by all of them and are parameterised on the type of the cells, C,
+// along with a function that returns the bounds of a cell and one that expands a cell, calling
+// visitCell with each of its child cells and visitNode with each of the Nodes that it holds
+// directly.
+
+// nearestItem is an element in the priority queue used by findNearest.  If node is nil the item is
+// the cell, otherwise it is the node.
+type nearestItem[C any] struct {
+	cell     C
+	node     *Node
+	distance float64
+}
+
+// nearestItemLess orders nearestItems by distance.  To keep the results deterministic, cells are
+// expanded before Nodes at the same distance, and Nodes at the same distance are ordered by id.
+func nearestItemLess[C any](a, b nearestItem[C]) bool {
+	if a.distance != b.distance {
+		return a.distance < b.distance
+	}
+	if (a.node == nil) != (b.node == nil) {
+		return a.node == nil
+	}
+	if a.node != nil {
+		return a.node.Id < b.node.Id
+	}
+	return false
+}
+
+// findNearest returns the ids and distances of the Nodes nearest to the lat/long coordinates, with
+// the semantics of InMemGeoStore.FindNearest, by a best-first traversal of the cells beneath root.
+// Both cells and Nodes are added to a priority queue keyed by their distance from the coordinates;
+// for a cell that is a lower bound of the distance to any point in its bounds, and so is never
+// greater than the distance to any Node that it contains.  As a result, Nodes are popped off of the
+// queue in order of distance, and a cell is only expanded once it is the nearest thing left in the
+// queue, so cells further away than the Nodes that we have already found are never expanded.
+func findNearest[C any](
+	lat, long, maxDistance float64,
+	limit int,
+	distance DistanceFunc,
+	root C,
+	bounds func(cell C) Quadrant,
+	expand func(cell C, visitCell func(C), visitNode func(*Node)),
+) []Neighbor {
+	queue := NewPriorityQueue(nearestItemLess[C])
+	visitCell := func(child C) {
+		childBounds := bounds(child)
+		queue.PushItem(nearestItem[C]{
+			cell:     child,
+			distance: minDistanceToQuadrant(lat, long, &childBounds),
+		})
+	}
+	visitNode := func(n *Node) {
+		nLat, nLong := n.gps()
+		queue.PushItem(nearestItem[C]{node: n, distance: distance(lat, long, nLat, nLong)})
+	}
+	visitCell(root)
+
+	var retval []Neighbor
+	for queue.Len() > 0 {
+		item := queue.PopItem()
+		if maxDistance > 0 && item.distance > maxDistance {
+			// Everything else remaining in the queue is at least this far away.
+			break
+		}
+
+		if item.node != nil {
+			retval = append(retval, Neighbor{Id: item.node.Id, Distance: item.distance})
+			if limit > 0 && len(retval) >= limit {
+				break
+			}
+			continue
+		}
+
+		expand(item.cell, visitCell, visitNode)
+	}
+	return retval
+}
+
+// findInQuadrant executes a DFS of the cells beneath root, pruning any whose bounds do not
+// intersect the provided Quadrant, and returns the ids of all of the Nodes within it for which
+// match returns true.  A nil match matches every Node within the Quadrant, in which case all of the
+// Nodes beneath a cell that is entirely within the Quadrant are matched without testing each of
+// them.
+func findInQuadrant[C any](
+	root C,
+	box *Quadrant,
+	match func(*Node) bool,
+	bounds func(cell C) Quadrant,
+	expand func(cell C, visitCell func(C), visitNode func(*Node)),
+) []uint64 {
+	type frame struct {
+		cell C
+		// inside is true if the cell is entirely within the Quadrant and every Node is matched.
+		inside bool
+	}
+
+	var retval []uint64
+	stack := []frame{{cell: root}}
+	// inside is that of the cell being expanded.
+	var inside bool
+	visitCell := func(child C) {
+		stack = append(stack, frame{cell: child, inside: inside})
+	}
+	visitNode := func(n *Node) {
+		if inside || (box.inQuadrant(n) && (match == nil || match(n))) {
+			retval = append(retval, n.Id)
+		}
+	}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		inside = current.inside
+		if !inside {
+			cellBounds := bounds(current.cell)
+			if !cellBounds.intersects(box) {
+				continue
+			}
+			inside = match == nil && box.contains(&cellBounds)
+		}
+		expand(current.cell, visitCell, visitNode)
+	}
+	return retval
+}
@@ -1,10 +1,12 @@
 package geostore
 
 import (
+	"container/heap"
 	"image"
 	"image/color"
 	"image/png"
 	"os"
+	"sort"
 )
 
 const outputPath = "/var/tmp/geocache-api-map.png"
@@ -36,13 +38,67 @@ func (s *Stack[T]) Pop() *T {
 	}
 }
 
-func printMap(g GeoStore, scale int) {
+// PriorityQueue is a min-heap of items ordered by the provided less function.  It wraps
+// container/heap so that callers do not need to implement heap.Interface for each element type.
+type PriorityQueue[T any] struct {
+	items []T
+	less  func(a, b T) bool
+}
+
+func NewPriorityQueue[T any](less func(a, b T) bool) *PriorityQueue[T] {
+	return &PriorityQueue[T]{less: less}
+}
+
+func (p *PriorityQueue[T]) Len() int {
+	return len(p.items)
+}
+
+func (p *PriorityQueue[T]) PushItem(item T) {
+	heap.Push((*pqHeap[T])(p), item)
+}
+
+func (p *PriorityQueue[T]) PopItem() T {
+	return heap.Pop((*pqHeap[T])(p)).(T)
+}
+
+// pqHeap is the heap.Interface implementation for a PriorityQueue.  It is a distinct type so that
+// the Push and Pop methods required by container/heap are not exposed on the PriorityQueue itself.
+type pqHeap[T any] PriorityQueue[T]
+
+func (h *pqHeap[T]) Len() int           { return len(h.items) }
+func (h *pqHeap[T]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *pqHeap[T]) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *pqHeap[T]) Push(x any) {
+	h.items = append(h.items, x.(T))
+}
+
+func (h *pqHeap[T]) Pop() any {
+	idx := len(h.items) - 1
+	retval := h.items[idx]
+	h.items = h.items[:idx]
+	return retval
+}
+
+// sortedUniqueIds sorts the ids in ascending order, removing any duplicates.
+func sortedUniqueIds(ids []uint64) []uint64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	retval := ids[:0]
+	for _, id := range ids {
+		if len(retval) == 0 || id != retval[len(retval)-1] {
+			retval = append(retval, id)
+		}
+	}
+	return retval
+}
+
+func printMap(g *InMemGeoStore, scale int) {
 	// In order to build a visual representation of the map we will use a 2-dimensional array
 	// (slices actually). Because grid coordinates start at 0 and go to N, we have a bit of an
 	// "off-by-one" issue and for the math to work out cleanly we will allocate a grid that is x+1
 	// and y+1 in size.
 	// Get the size of the Root map in units and allocate our grid
-	rootQT := g.getRootQuadTree()
+	rootQT := g.Root
 	rows := int(rootQT.Quadrant.YMax) * scale
 	cols := int(rootQT.Quadrant.XMax) * scale
 	grid := make([][]byte, rows+1)
@@ -3,13 +3,17 @@ package inttest
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/rchapin/go-geocache-api/geostore"
 	"github.com/rchapin/go-geocache-api/utils"
 	log "github.com/rchapin/rlog"
 	"github.com/stretchr/testify/assert"
@@ -39,17 +43,67 @@ type TestIdResponse struct {
 }
 
 type TestGetCacheResponse struct {
-	Id   uint64   `json:"id"`
-	Name string   `json:"name"`
-	Lat  float64  `json:"lat"`
-	Long float64  `json:"long"`
-	Tags []string `json:"tags"`
+	Id      uint64   `json:"id"`
+	Name    string   `json:"name"`
+	Lat     float64  `json:"lat"`
+	Long    float64  `json:"long"`
+	Tags    []string `json:"tags"`
+	Geohash string   `json:"geohash"`
 }
 
 func (t TestGetCacheResponse) GetId() uint64 {
 	return t.Id
 }
 
+type TestUpsertResponse struct {
+	Id      uint64 `json:"id"`
+	Created bool   `json:"created"`
+}
+
+type TestFieldErr struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+type TestProblemResponse struct {
+	Status   int            `json:"status"`
+	Code     string         `json:"code"`
+	Detail   string         `json:"detail"`
+	Fields   []TestFieldErr `json:"fields"`
+	Existing string         `json:"existing"`
+}
+
+type TestCachePageResponse struct {
+	Items      []TestGetCacheResponse `json:"items"`
+	NextCursor string                 `json:"next_cursor"`
+}
+
+type TestTagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+type TestTagPageResponse struct {
+	Items      []TestTagCount `json:"items"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+type TestSearchResponse struct {
+	Items []TestNearbyCacheResponse `json:"items"`
+	Index string                    `json:"index"`
+}
+
+type TestRankedCacheResponse struct {
+	TestGetCacheResponse
+	Score float64 `json:"score"`
+}
+
+type TestNearbyCacheResponse struct {
+	TestGetCacheResponse
+	Distance float64 `json:"distance"`
+	Bearing  float64 `json:"bearing"`
+}
+
 func createUrlPrefix() string {
 	return "http://localhost:" + testPort + "/v1"
 }
@@ -64,6 +118,23 @@ func putCache(name string, s TestCacheUpdate) *http.Response {
 	return sendJson(s, "PUT", url)
 }
 
+func deleteCache(name string) *http.Response {
+	return execDelete(createUrlPrefix() + "/geocaches/" + name)
+}
+
+func execDelete(url string) *http.Response {
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		panic(err)
+	}
+	client := &http.Client{}
+	retval, err := client.Do(request)
+	if err != nil {
+		panic(err)
+	}
+	return retval
+}
+
 func sendJson[T any](s T, httpVerb string, url string) *http.Response {
 	json, err := json.Marshal(s)
 	if err != nil {
@@ -92,9 +163,9 @@ func setUpSubTest() *TestRunner {
 	return NewTestRunner(rm.testRunnerCtx, rm.testRunnerCancel, rm.testRunnerWg)
 }
 
-func startServer(t *testing.T) *TestRunner {
+func startServer(t *testing.T, extraArgs ...string) *TestRunner {
 	tr := setUpSubTest()
-	if err := tr.runServer(); err != nil {
+	if err := tr.runServer(extraArgs...); err != nil {
 		assert.Fail(t, "error returned when attempting to start http server; err=%s\n", err)
 		tr.cancel()
 		panic(err)
@@ -213,9 +284,8 @@ func TestFindNearest(t *testing.T) {
 	tr := startServer(t)
 
 	// The default configurations in the run.Run method configures the GeoStore for a max number of
-	// 4 nodes in each QuadTree.  As a result we have to add 5 nodes before it will partition the
-	// GeoStore and we can then query it and have the previously entered nodes partitioned to setup
-	// a valid pre-condition for the test.  So, we will add 5 nodes.
+	// 4 nodes in each QuadTree.  We will add 5 nodes so that the GeoStore is partitioned and the
+	// search has to traverse more than one QuadTree.
 	tCaches := []TestCache{
 		{
 			Name: "canada",
@@ -268,7 +338,7 @@ func TestFindNearest(t *testing.T) {
 		Tags: nil,
 	}
 
-	url := createUrlPrefix() + "/geocaches/nearest?lat=55.87272342&long=-104.9234282&maxdistance=0&limit=-1"
+	url := createUrlPrefix() + "/geocaches/nearest?lat=55.87272342&long=-104.9234282&maxdistance=0&limit=2"
 	resp := execGet(t, url)
 	validateStatus(t, 200, resp)
 	expectedResp := []TestGetCacheResponse{expectedS1, expectedS2}
@@ -277,6 +347,71 @@ func TestFindNearest(t *testing.T) {
 	tr.shutdownServer()
 }
 
+func TestFindNearestDistanceAndBearing(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{
+			Name: "s1",
+			Lat:  38.394432064782755,
+			Long: -75.0613367366317,
+			Tags: []string{"ocean", "atlantic", "flowrate"},
+		},
+		{
+			Name: "s2",
+			Lat:  39.33030191224595,
+			Long: -77.74073236877527,
+			Tags: []string{"river", "flowrate"},
+		},
+		{
+			Name: "s3",
+			Lat:  37.79088776167161,
+			Long: -122.50578266113429,
+			Tags: []string{"ocean", "pacific"},
+		},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	// Search from a point just East of s1 for the caches within 500km.  s3 is on the other side of
+	// the country and should be excluded.
+	lat, long := 38.4, -75.0
+	url := createUrlPrefix() + "/geocaches/nearest?lat=38.4&long=-75.0&maxdistance=500&limit=10&units=km"
+	resp := execGet(t, url)
+	validateStatus(t, 200, resp)
+	actualResp := []TestNearbyCacheResponse{}
+	err := json.Unmarshal([]byte(getResponseBodyString(t, resp)), &actualResp)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, 2, len(actualResp))
+	for i, expected := range tCaches[:2] {
+		actual := actualResp[i]
+		assert.Equal(t, expected.Name, actual.Name)
+		assert.InDelta(
+			t,
+			geostore.Haversine(lat, long, expected.Lat, expected.Long)/1000,
+			actual.Distance,
+			0.001,
+		)
+		assert.InDelta(
+			t,
+			geostore.InitialBearing(lat, long, expected.Lat, expected.Long),
+			actual.Bearing,
+			0.001,
+		)
+	}
+	// Both caches are to the West of the search point
+	assert.Greater(t, actualResp[0].Bearing, 180.0)
+	assert.Greater(t, actualResp[1].Bearing, 180.0)
+
+	tr.shutdownServer()
+}
+
 func TestGetCacheByName(t *testing.T) {
 	tr := startServer(t)
 
@@ -386,6 +521,138 @@ func TestGetCacheByTags(t *testing.T) {
 	validateGetResults(t, resp, expectedResp)
 	resp.Body.Close()
 
+	// A cache that matches more than one of the tags is only returned once
+	resp = execGet(t, createUrlPrefix()+"/geocaches?tags=ocean,flowrate")
+	validateGetResults(t, resp, []TestGetCacheResponse{expectedS1, expectedS2, expectedS3})
+	resp.Body.Close()
+
+	// Boolean tag queries
+	queries := []struct {
+		query    string
+		expected []TestGetCacheResponse
+	}{
+		{query: "ocean AND flowrate", expected: []TestGetCacheResponse{expectedS1}},
+		{query: "flowrate AND NOT ocean", expected: []TestGetCacheResponse{expectedS2}},
+		{
+			query:    "ocean AND (atlantic OR pacific) AND NOT river",
+			expected: []TestGetCacheResponse{expectedS1, expectedS3},
+		},
+		{query: "NOT flowrate", expected: []TestGetCacheResponse{expectedS3}},
+	}
+	for _, q := range queries {
+		resp = execGet(t, createUrlPrefix()+"/geocaches?tags="+url.QueryEscape(q.query))
+		validateStatus(t, 200, resp)
+		validateGetResults(t, resp, q.expected)
+		resp.Body.Close()
+	}
+
+	resp = execGet(t, createUrlPrefix()+"/geocaches?tags="+url.QueryEscape("ocean AND (river"))
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestUpdateCacheTags(t *testing.T) {
+	tr := startServer(t)
+
+	resp := postCache(TestCache{Name: "s1", Lat: 38.4, Long: -75.1, Tags: []string{"ocean"}})
+	resp.Body.Close()
+	resp = postCache(TestCache{Name: "s2", Lat: 39.3, Long: -77.7, Tags: []string{"river"}})
+	resp.Body.Close()
+
+	// Replacing the tags of a cache re-indexes them
+	resp = putCache("s1", TestCacheUpdate{Lat: 38.4, Long: -75.1, Tags: []string{"bay"}})
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+	resp = execGet(t, createUrlPrefix()+"/geocaches?tags=ocean")
+	validateGetResults(t, resp, []TestGetCacheResponse{})
+	resp.Body.Close()
+
+	// Single tags can be added and removed
+	expectedS2 := TestGetCacheResponse{Id: 2, Name: "s2", Lat: 39.3, Long: -77.7}
+	resp, err := http.Post(createUrlPrefix()+"/geocaches/s2/tags/flowrate", "", nil)
+	assert.Nil(t, err)
+	expectedS2.Tags = []string{"flowrate", "river"}
+	validateGetResult(t, resp, expectedS2)
+	resp.Body.Close()
+	resp = execDelete(createUrlPrefix() + "/geocaches/s2/tags/river")
+	expectedS2.Tags = []string{"flowrate"}
+	validateGetResult(t, resp, expectedS2)
+	resp.Body.Close()
+
+	resp = execGet(t, createUrlPrefix()+"/geocaches?tags=flowrate,bay")
+	validateGetResults(t, resp, []TestGetCacheResponse{
+		{Id: 1, Name: "s1", Lat: 38.4, Long: -75.1, Tags: []string{"bay"}},
+		expectedS2,
+	})
+	resp.Body.Close()
+	resp = execGet(t, createUrlPrefix()+"/geocaches?tags=river")
+	validateGetResults(t, resp, []TestGetCacheResponse{})
+	resp.Body.Close()
+
+	resp = execDelete(createUrlPrefix() + "/geocaches/s3/tags/river")
+	validateProblem(t, 404, "not_found", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestListTags(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "s1", Lat: 38.4, Long: -75.1, Tags: []string{"ocean", "atlantic", "flowrate"}},
+		{Name: "s2", Lat: 39.3, Long: -77.7, Tags: []string{"river", "flowrate"}},
+		{Name: "s3", Lat: 37.8, Long: -122.5, Tags: []string{"ocean", "pacific"}},
+		{Name: "s4", Lat: 37.9, Long: -122.6, Tags: []string{"ocean", "oceanside"}},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	getTags := func(query string) TestTagPageResponse {
+		resp := execGet(t, createUrlPrefix()+"/tags"+query)
+		validateStatus(t, 200, resp)
+		page := TestTagPageResponse{}
+		err := json.Unmarshal([]byte(getResponseBodyString(t, resp)), &page)
+		resp.Body.Close()
+		assert.Nil(t, err)
+		return page
+	}
+
+	// Walk the tags, two at a time, by descending count
+	var actual []TestTagCount
+	query := "?limit=2"
+	for {
+		page := getTags(query)
+		actual = append(actual, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		query = "?limit=2&cursor=" + page.NextCursor
+	}
+	assert.Equal(t, []TestTagCount{
+		{Tag: "ocean", Count: 3}, {Tag: "flowrate", Count: 2}, {Tag: "atlantic", Count: 1},
+		{Tag: "oceanside", Count: 1}, {Tag: "pacific", Count: 1}, {Tag: "river", Count: 1},
+	}, actual)
+
+	// Autocomplete
+	page := getTags("?prefix=oce&sort=name")
+	assert.Equal(t, []TestTagCount{{Tag: "ocean", Count: 3}, {Tag: "oceanside", Count: 1}},
+		page.Items)
+
+	// The counts are maintained as caches are updated and deleted
+	resp := deleteCache("s4")
+	resp.Body.Close()
+	page = getTags("?prefix=oce")
+	assert.Equal(t, []TestTagCount{{Tag: "ocean", Count: 2}}, page.Items)
+
+	resp = execGet(t, createUrlPrefix()+"/tags?sort=popularity")
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+
 	tr.shutdownServer()
 }
 
@@ -428,6 +695,777 @@ func TestUpdateCacheByName(t *testing.T) {
 	tr.shutdownServer()
 }
 
+func TestUpdateCacheLocation(t *testing.T) {
+	tr := startServer(t)
+
+	// Post enough caches that the GeoStore is partitioned
+	tCaches := []TestCache{
+		{Name: "canada", Lat: 53.61760431337473, Long: -106.72319029988779},
+		{Name: "oregon", Lat: 43.38552157601114, Long: -120.54074440145642},
+		{Name: "mongolia", Lat: 46.88910832340091, Long: 97.00726436805842},
+		{Name: "peru", Lat: -36.351849320377774, Long: -72.27006768132226},
+		{Name: "australia", Lat: -23.605766549164937, Long: 124.42913747263096},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	// Move the oregon cache to the other side of the world
+	resp := putCache("oregon", TestCacheUpdate{Lat: 46.9, Long: 97.1})
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+
+	expected := TestGetCacheResponse{Id: 2, Name: "oregon", Lat: 46.9, Long: 97.1}
+
+	// It should be found near its new location
+	url := createUrlPrefix() + "/geocaches/nearest?lat=46.9&long=97.1&maxdistance=1&limit=10&units=km"
+	resp = execGet(t, url)
+	validateStatus(t, 200, resp)
+	validateGetResults(t, resp, []TestGetCacheResponse{expected})
+	resp.Body.Close()
+
+	// And no longer near its old location
+	url = createUrlPrefix() + "/geocaches/nearest?lat=43.38552157601114&long=-120.54074440145642" +
+		"&maxdistance=100&limit=10&units=km"
+	resp = execGet(t, url)
+	validateStatus(t, 200, resp)
+	validateGetResults(t, resp, []TestGetCacheResponse{})
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestInvalidCacheLocation(t *testing.T) {
+	tr := startServer(t)
+
+	resp := postCache(TestCache{Name: "calgary", Lat: 51.0447, Long: -114.0719})
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+
+	// Invalid locations are rejected with the details of each invalid field
+	resp = postCache(TestCache{Name: "nowhere", Lat: 91, Long: 245.9281})
+	body := validateProblem(t, http.StatusUnprocessableEntity, "validation_failed", resp)
+	assert.Equal(t, []TestFieldErr{
+		{Field: "lat", Reason: "must be within [-90, 90]"},
+		{Field: "long", Reason: "must be within [-180, 180]"},
+	}, body.Fields)
+	resp.Body.Close()
+	resp = putCache("calgary", TestCacheUpdate{Lat: 51.0447, Long: -181})
+	validateProblem(t, http.StatusUnprocessableEntity, "validation_failed", resp)
+	resp.Body.Close()
+	resp = putCache("nowhere", TestCacheUpdate{Lat: 51.0447, Long: -114.0719})
+	validateProblem(t, http.StatusNotFound, "not_found", resp)
+	resp.Body.Close()
+
+	resp = execGet(t, createUrlPrefix()+"/geocaches/nowhere")
+	validateProblem(t, 404, "not_found", resp)
+	resp.Body.Close()
+	resp = execGet(t, createUrlPrefix()+"/geocaches/calgary")
+	validateGetResult(t, resp, TestGetCacheResponse{
+		Id: 1, Name: "calgary", Lat: 51.0447, Long: -114.0719})
+	resp.Body.Close()
+
+	// Unless longitudes in (180, 360] are normalized
+	resp = sendJson(TestCacheUpdate{Lat: 51.0447, Long: 245.9281}, "PUT",
+		createUrlPrefix()+"/geocaches/calgary?normalize=true")
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+	resp = execGet(t, createUrlPrefix()+"/geocaches/calgary")
+	validateGetResult(t, resp, TestGetCacheResponse{
+		Id: 1, Name: "calgary", Lat: 51.0447, Long: 245.9281 - 360})
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestCreateDuplicateCache(t *testing.T) {
+	tr := startServer(t)
+
+	resp := postCache(TestCache{Name: "calgary", Lat: 51.0447, Long: -114.0719})
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+
+	// A duplicate name is a conflict, which points to the existing cache
+	resp = postCache(TestCache{Name: "calgary", Lat: 51.0486, Long: -114.0708})
+	problem := validateProblem(t, http.StatusConflict, "conflict", resp)
+	assert.Equal(t, "/v1/geocaches/calgary", problem.Existing)
+	assert.Equal(t, "/v1/geocaches/calgary", resp.Header.Get("Location"))
+	resp.Body.Close()
+
+	// Unless it is an upsert
+	upsert := func(tc TestCache) TestUpsertResponse {
+		resp := sendJson(tc, "POST", createUrlPrefix()+"/geocaches?upsert=true")
+		defer resp.Body.Close()
+		validateStatus(t, 200, resp)
+		var retval TestUpsertResponse
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&retval))
+		return retval
+	}
+	assert.Equal(t,
+		TestUpsertResponse{Id: 1, Created: false},
+		upsert(TestCache{Name: "calgary", Lat: 51.0486, Long: -114.0708, Tags: []string{"tower"}}))
+	assert.Equal(t,
+		TestUpsertResponse{Id: 2, Created: true},
+		upsert(TestCache{Name: "banff", Lat: 51.1784, Long: -115.5708}))
+
+	resp = execGet(t, createUrlPrefix()+"/geocaches/calgary")
+	validateGetResult(t, resp, TestGetCacheResponse{
+		Id: 1, Name: "calgary", Lat: 51.0486, Long: -114.0708, Tags: []string{"tower"}})
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestDeleteCacheByName(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{
+			Name: "s1",
+			Lat:  38.394432064782755,
+			Long: -75.0613367366317,
+			Tags: []string{"ocean", "atlantic", "flowrate"},
+		},
+		{
+			Name: "s2",
+			Lat:  39.33030191224595,
+			Long: -77.74073236877527,
+			Tags: []string{"river", "flowrate"},
+		},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	resp := deleteCache("s1")
+	validateStatus(t, 204, resp)
+	resp.Body.Close()
+
+	// The cache should no longer be found by name, tag or location
+	resp = execGet(t, createUrlPrefix()+"/geocaches/s1")
+	validateProblem(t, 404, "not_found", resp)
+	resp.Body.Close()
+
+	expectedS2 := TestGetCacheResponse{
+		Id:   2,
+		Name: "s2",
+		Lat:  39.33030191224595,
+		Long: -77.74073236877527,
+		Tags: []string{"flowrate", "river"},
+	}
+	resp = execGet(t, createUrlPrefix()+"/geocaches?tags=flowrate,ocean")
+	validateStatus(t, 200, resp)
+	validateGetResults(t, resp, []TestGetCacheResponse{expectedS2})
+	resp.Body.Close()
+
+	resp = execGet(t, createUrlPrefix()+"/geocaches/nearest?lat=38.4&long=-75.0&maxdistance=0&limit=10")
+	validateStatus(t, 200, resp)
+	validateGetResults(t, resp, []TestGetCacheResponse{expectedS2})
+	resp.Body.Close()
+
+	// Deleting it again, or deleting a cache that never existed, is a 404
+	resp = deleteCache("s1")
+	validateProblem(t, 404, "not_found", resp)
+	resp.Body.Close()
+	resp = deleteCache("does-not-exist")
+	validateProblem(t, 404, "not_found", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestDeleteAllCaches(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "s1", Lat: 38.394432064782755, Long: -75.0613367366317, Tags: []string{"ocean"}},
+		{Name: "s2", Lat: 39.33030191224595, Long: -77.74073236877527, Tags: []string{"river"}},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	// Without confirming, nothing is deleted
+	resp := execDelete(createUrlPrefix() + "/geocaches")
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+	resp = execGet(t, createUrlPrefix()+"/geocaches/s1")
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+
+	resp = execDelete(createUrlPrefix() + "/geocaches?confirm=true")
+	validateStatus(t, 204, resp)
+	resp.Body.Close()
+
+	for _, ts := range tCaches {
+		resp = execGet(t, createUrlPrefix()+"/geocaches/"+ts.Name)
+		validateProblem(t, 404, "not_found", resp)
+		resp.Body.Close()
+	}
+	resp = execGet(t, createUrlPrefix()+"/geocaches?tags=ocean,river")
+	validateStatus(t, 200, resp)
+	validateGetResults(t, resp, []TestGetCacheResponse{})
+	resp.Body.Close()
+	resp = execGet(t, createUrlPrefix()+"/geocaches/nearest?lat=38.4&long=-75.0&maxdistance=0&limit=10")
+	validateStatus(t, 200, resp)
+	validateGetResults(t, resp, []TestGetCacheResponse{})
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestGetAllCaches(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "canada", Lat: 53.61760431337473, Long: -106.72319029988779},
+		{Name: "oregon", Lat: 43.38552157601114, Long: -120.54074440145642},
+		{Name: "mongolia", Lat: 46.88910832340091, Long: 97.00726436805842},
+		{Name: "peru", Lat: -36.351849320377774, Long: -72.27006768132226},
+		{Name: "australia", Lat: -23.605766549164937, Long: 124.42913747263096},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+	resp := deleteCache("mongolia")
+	resp.Body.Close()
+
+	// Walk all of the caches, two at a time
+	var actualNames []string
+	url := createUrlPrefix() + "/geocaches?limit=2"
+	pages := 0
+	for {
+		resp = execGet(t, url)
+		validateStatus(t, 200, resp)
+		page := TestCachePageResponse{}
+		err := json.Unmarshal([]byte(getResponseBodyString(t, resp)), &page)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		pages++
+		for _, c := range page.Items {
+			actualNames = append(actualNames, c.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		url = createUrlPrefix() + "/geocaches?limit=2&cursor=" + page.NextCursor
+	}
+	assert.Equal(t, []string{"canada", "oregon", "peru", "australia"}, actualNames)
+	assert.Equal(t, 2, pages)
+
+	tr.shutdownServer()
+}
+
+func TestGetCachesWithinBbox(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "calgary", Lat: 51.04653767382061, Long: -114.06243444911559},
+		{Name: "fiji", Lat: -17.8, Long: -179.9},
+		{Name: "edmonton", Lat: 53.678868921462815, Long: -113.37174481536333},
+		{Name: "tuvalu", Lat: -8.5, Long: 179.2},
+		{Name: "red-deer", Lat: 52.26871035649865, Long: -113.80500041394141},
+		{Name: "oregon", Lat: 43.38552157601114, Long: -120.54074440145642},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	testData := []struct {
+		bbox          string
+		expectedNames []string
+	}{
+		{bbox: "-120,49,-110,60", expectedNames: []string{"calgary", "edmonton", "red-deer"}},
+		{bbox: "175,-20,-175,0", expectedNames: []string{"fiji", "tuvalu"}},
+		{bbox: "0,0,10,10", expectedNames: nil},
+	}
+	for _, td := range testData {
+		// Page through the results one at a time
+		var actualNames []string
+		url := createUrlPrefix() + "/geocaches/within?limit=1&bbox=" + td.bbox
+		for {
+			resp := execGet(t, url)
+			validateStatus(t, 200, resp)
+			page := TestCachePageResponse{}
+			err := json.Unmarshal([]byte(getResponseBodyString(t, resp)), &page)
+			resp.Body.Close()
+			if err != nil {
+				panic(err)
+			}
+			for _, c := range page.Items {
+				actualNames = append(actualNames, c.Name)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			url = createUrlPrefix() + "/geocaches/within?limit=1&bbox=" + td.bbox +
+				"&cursor=" + page.NextCursor
+		}
+		assert.Equal(t, td.expectedNames, actualNames, td.bbox)
+	}
+
+	resp := execGet(t, createUrlPrefix()+"/geocaches/within?bbox=1,2,3")
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestGetCachesInGeohash(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "calgary", Lat: 51.0447, Long: -114.0719},
+		{Name: "edmonton", Lat: 53.5461, Long: -113.4937},
+		{Name: "banff", Lat: 51.1784, Long: -115.5708},
+		{Name: "sydney", Lat: -33.8688, Long: 151.2093},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	testData := []struct {
+		geohash       string
+		expectedNames []string
+	}{
+		{geohash: "c3", expectedNames: []string{"calgary", "edmonton", "banff"}},
+		{geohash: "c3n", expectedNames: []string{"calgary"}},
+		{geohash: "r3gx2f", expectedNames: []string{"sydney"}},
+		{geohash: "s0", expectedNames: nil},
+	}
+	for _, td := range testData {
+		// Page through the results one at a time
+		var actualNames []string
+		url := createUrlPrefix() + "/geocaches?limit=1&geohashprecision=6&geohash=" + td.geohash
+		for {
+			resp := execGet(t, url)
+			validateStatus(t, 200, resp)
+			page := TestCachePageResponse{}
+			err := json.Unmarshal([]byte(getResponseBodyString(t, resp)), &page)
+			resp.Body.Close()
+			if err != nil {
+				panic(err)
+			}
+			for _, c := range page.Items {
+				actualNames = append(actualNames, c.Name)
+				assert.True(t, strings.HasPrefix(c.Geohash, td.geohash), c.Geohash)
+				assert.Equal(t, 6, len(c.Geohash))
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			url = createUrlPrefix() + "/geocaches?limit=1&geohashprecision=6&geohash=" +
+				td.geohash + "&cursor=" + page.NextCursor
+		}
+		assert.Equal(t, td.expectedNames, actualNames, td.geohash)
+	}
+
+	resp := execGet(t, createUrlPrefix()+"/geocaches?geohash=c3a")
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestSearchWithinPolygon(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "calgary", Lat: 51.04653767382061, Long: -114.06243444911559},
+		{Name: "red-deer", Lat: 52.26871035649865, Long: -113.80500041394141},
+		{Name: "edmonton", Lat: 53.678868921462815, Long: -113.37174481536333},
+		{Name: "oregon", Lat: 43.38552157601114, Long: -120.54074440145642},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	// A polygon around Alberta with a hole around Red Deer
+	polygon, err := os.ReadFile("test_data/search-within-alberta.json")
+	if err != nil {
+		panic(err)
+	}
+	var geometry map[string]any
+	if err := json.Unmarshal(polygon, &geometry); err != nil {
+		panic(err)
+	}
+	resp := sendJson(geometry, "POST", createUrlPrefix()+"/geocaches/search/within")
+	validateStatus(t, 200, resp)
+	page := TestCachePageResponse{}
+	err = json.Unmarshal([]byte(getResponseBodyString(t, resp)), &page)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	var actualNames []string
+	for _, c := range page.Items {
+		actualNames = append(actualNames, c.Name)
+	}
+	assert.Equal(t, []string{"calgary", "edmonton"}, actualNames)
+	assert.Equal(t, "", page.NextCursor)
+
+	// An unclosed polygon is a bad request
+	geometry = map[string]any{
+		"type":        "Polygon",
+		"coordinates": [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}},
+	}
+	resp = sendJson(geometry, "POST", createUrlPrefix()+"/geocaches/search/within")
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestSearch(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "smugglers cove", Lat: 38.40, Long: -75.06, Tags: []string{"ocean"}},
+		{Name: "cove point", Lat: 38.42, Long: -75.08, Tags: []string{"bay"}},
+		{Name: "ocean city", Lat: 38.41, Long: -75.07, Tags: []string{"ocean"}},
+		{Name: "coverdale", Lat: 38.75, Long: -75.06, Tags: []string{"ocean"}},
+		{Name: "cove beach", Lat: 38.39, Long: -75.05, Tags: []string{"ocean"}},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	search := func(body string) (*http.Response, TestSearchResponse) {
+		resp, err := http.Post(createUrlPrefix()+"/geocaches/search", "application/json",
+			strings.NewReader(body))
+		assert.Nil(t, err)
+		var result TestSearchResponse
+		if resp.StatusCode == 200 {
+			err = json.Unmarshal([]byte(getResponseBodyString(t, resp)), &result)
+			assert.Nil(t, err)
+		}
+		return resp, result
+	}
+
+	// Ocean tagged caches within 5 km whose name contains cove, nearest first
+	resp, result := search(`{
+		"near": {"lat": 38.391, "long": -75.051, "maxdistance": 5, "units": "km"},
+		"tags": "ocean",
+		"name": "cove",
+		"sort": "distance"
+	}`)
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+	var names []string
+	for _, item := range result.Items {
+		names = append(names, item.Name)
+		assert.Less(t, item.Distance, 5.0)
+	}
+	assert.Equal(t, []string{"cove beach", "smugglers cove"}, names)
+
+	resp, result = search(`{"bbox": [-76, 38, -75, 39], "tags": "bay OR NOT ocean"}`)
+	validateStatus(t, 200, resp)
+	resp.Body.Close()
+	assert.Equal(t, 1, len(result.Items))
+	assert.Equal(t, "cove point", result.Items[0].Name)
+
+	resp, _ = search(`{"near": {"lat": 38.4, "long": -75.1}, "bbox": [-76, 38, -75, 39]}`)
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestSearchNames(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "Café du Lac", Lat: 51.1, Long: -115.1},
+		{Name: "Lake Louise", Lat: 51.4, Long: -116.2},
+		{Name: "Lake Minnewanka", Lat: 51.3, Long: -115.4},
+		{Name: "Calgary Tower", Lat: 51.0, Long: -114.1},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	searchNames := func(q string) []string {
+		resp := execGet(t, createUrlPrefix()+"/geocaches?q="+url.QueryEscape(q))
+		validateStatus(t, 200, resp)
+		var caches []TestRankedCacheResponse
+		err := json.Unmarshal([]byte(getResponseBodyString(t, resp)), &caches)
+		resp.Body.Close()
+		assert.Nil(t, err)
+		var names []string
+		for _, c := range caches {
+			assert.Greater(t, c.Score, 0.0)
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	// A typo that would be a 404 when getting the cache by its name
+	resp := execGet(t, createUrlPrefix()+"/geocaches/"+url.PathEscape("Calgray Tower"))
+	validateStatus(t, 404, resp)
+	resp.Body.Close()
+	assert.Equal(t, []string{"Calgary Tower"}, searchNames("Calgray Tower"))
+
+	assert.Equal(t, []string{"Café du Lac"}, searchNames("cafe"))
+	assert.Equal(t, []string{"Lake Louise", "Lake Minnewanka"}, searchNames("lake lou"))
+	assert.Nil(t, searchNames("volcano"))
+
+	resp = execGet(t, createUrlPrefix()+"/geocaches?q=lake&tags=park")
+	validateProblem(t, 400, "bad_request", resp)
+	resp.Body.Close()
+
+	tr.shutdownServer()
+}
+
+func TestRestartWithDataDir(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testRestartWithDataDir(t, "--store", "memory", "--fsync", "always")
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		testRestartWithDataDir(t, "--store", "sqlite")
+	})
+}
+
+func testRestartWithDataDir(t *testing.T, storeArgs ...string) {
+	dataDir := t.TempDir()
+	storeArgs = append(storeArgs, "--data-dir", dataDir)
+	tr := startServer(t, storeArgs...)
+
+	tCaches := []TestCache{
+		{Name: "calgary", Lat: 51.04653767382061, Long: -114.06243444911559, Tags: []string{"city"}},
+		{Name: "edmonton", Lat: 53.678868921462815, Long: -113.37174481536333},
+		{Name: "oregon", Lat: 43.38552157601114, Long: -120.54074440145642},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+	resp := putCache("oregon", TestCacheUpdate{Lat: 44.0, Long: -121.0, Tags: []string{"state"}})
+	resp.Body.Close()
+	resp = deleteCache("edmonton")
+	resp.Body.Close()
+	tr.shutdownServer()
+
+	// All of the caches, and their locations in the GeoStore, are recovered after a restart
+	tr = startServer(t, storeArgs...)
+	url := createUrlPrefix() + "/geocaches/nearest?lat=44.0&long=-121.0&maxdistance=0&limit=5"
+	resp = execGet(t, url)
+	validateStatus(t, 200, resp)
+	actual := []TestNearbyCacheResponse{}
+	err := json.Unmarshal([]byte(getResponseBodyString(t, resp)), &actual)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, 2, len(actual))
+	assert.Equal(t, "oregon", actual[0].Name)
+	assert.Equal(t, []string{"state"}, actual[0].Tags)
+	assert.Equal(t, "calgary", actual[1].Name)
+
+	// Ids continue from where they left off
+	resp = postCache(TestCache{Name: "red-deer", Lat: 52.26871035649865, Long: -113.80500041394141})
+	idResp := TestIdResponse{}
+	err = json.Unmarshal([]byte(getResponseBodyString(t, resp)), &idResp)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, uint64(4), idResp.Id)
+
+	tr.shutdownServer()
+}
+
+type TestFeature struct {
+	Type     string `json:"type"`
+	Id       uint64 `json:"id"`
+	Geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	} `json:"properties"`
+}
+
+type TestFeatureCollection struct {
+	Type     string        `json:"type"`
+	Bbox     []float64     `json:"bbox"`
+	Features []TestFeature `json:"features"`
+}
+
+func TestGetCachesAsGeoJSON(t *testing.T) {
+	tr := startServer(t)
+
+	tCaches := []TestCache{
+		{Name: "calgary", Lat: 51.04653767382061, Long: -114.06243444911559, Tags: []string{"city"}},
+		{Name: "red-deer", Lat: 52.26871035649865, Long: -113.80500041394141, Tags: []string{"city"}},
+		{Name: "oregon", Lat: 43.38552157601114, Long: -120.54074440145642},
+	}
+	for _, ts := range tCaches {
+		resp := postCache(ts)
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest("GET", createUrlPrefix()+"/geocaches?tags=city", nil)
+	req.Header.Set("Accept", "application/geo+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	validateStatus(t, 200, resp)
+	assert.Equal(t, "application/geo+json", resp.Header.Get("Content-Type"))
+	fc := TestFeatureCollection{}
+	err = json.Unmarshal([]byte(getResponseBodyString(t, resp)), &fc)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "FeatureCollection", fc.Type)
+	assert.Equal(t, []float64{
+		-114.06243444911559, 51.04653767382061, -113.80500041394141, 52.26871035649865,
+	}, fc.Bbox)
+	var actualNames []string
+	for _, f := range fc.Features {
+		assert.Equal(t, "Point", f.Geometry.Type)
+		assert.Equal(t, []string{"city"}, f.Properties.Tags)
+		actualNames = append(actualNames, f.Properties.Name)
+	}
+	assert.ElementsMatch(t, []string{"calgary", "red-deer"}, actualNames)
+
+	resp = execGet(t, createUrlPrefix()+"/geocaches/oregon?format=geojson")
+	validateStatus(t, 200, resp)
+	f := TestFeature{}
+	err = json.Unmarshal([]byte(getResponseBodyString(t, resp)), &f)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "Feature", f.Type)
+	assert.Equal(t, "oregon", f.Properties.Name)
+	assert.Equal(t, [2]float64{-120.54074440145642, 43.38552157601114}, f.Geometry.Coordinates)
+
+	tr.shutdownServer()
+}
+
+type TestGpxImportResponse struct {
+	DryRun  bool `json:"dry_run"`
+	Created []struct {
+		Id   uint64 `json:"id"`
+		Name string `json:"name"`
+	} `json:"created"`
+	Conflicts []struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	} `json:"conflicts"`
+}
+
+type TestGpx struct {
+	Waypoints []struct {
+		Lat  float64 `xml:"lat,attr"`
+		Lon  float64 `xml:"lon,attr"`
+		Name string  `xml:"name"`
+		Type string  `xml:"type"`
+	} `xml:"wpt"`
+}
+
+func postGpx(t *testing.T, url string) TestGpxImportResponse {
+	gpx, err := os.Open("test_data/import-geocaches.gpx")
+	if err != nil {
+		panic(err)
+	}
+	defer gpx.Close()
+	resp, err := http.Post(url, "application/gpx+xml", gpx)
+	if err != nil {
+		panic(err)
+	}
+	validateStatus(t, 200, resp)
+	retval := TestGpxImportResponse{}
+	err = json.Unmarshal([]byte(getResponseBodyString(t, resp)), &retval)
+	resp.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	return retval
+}
+
+func TestImportExportGpx(t *testing.T) {
+	tr := startServer(t)
+
+	// Create one of the caches in the GPX document so that it conflicts
+	resp := postCache(TestCache{Name: "edmonton", Lat: 53.678868921462815, Long: -113.37174481536333})
+	resp.Body.Close()
+
+	// A dry run should report what would happen without creating anything
+	actual := postGpx(t, createUrlPrefix()+"/import/gpx?dry_run=true")
+	assert.True(t, actual.DryRun)
+	assert.Equal(t, 2, len(actual.Created))
+	assert.Equal(t, "calgary", actual.Created[0].Name)
+	assert.Equal(t, "red-deer", actual.Created[1].Name)
+	assert.Equal(t, 3, len(actual.Conflicts))
+	assert.Equal(t, "edmonton", actual.Conflicts[0].Name)
+	assert.Equal(t, "red-deer", actual.Conflicts[1].Name)
+	assert.Equal(t, "north-of-the-pole", actual.Conflicts[2].Name)
+	resp = execGet(t, createUrlPrefix()+"/geocaches/calgary")
+	validateProblem(t, 404, "not_found", resp)
+	resp.Body.Close()
+
+	actual = postGpx(t, createUrlPrefix()+"/import/gpx")
+	assert.False(t, actual.DryRun)
+	assert.Equal(t, 2, len(actual.Created))
+	assert.Equal(t, 3, len(actual.Conflicts))
+
+	// The <type> components and <sym> are mapped to tags
+	resp = execGet(t, createUrlPrefix()+"/geocaches/calgary")
+	validateStatus(t, 200, resp)
+	validateGetResult(t, resp, TestGetCacheResponse{
+		Id:   actual.Created[0].Id,
+		Name: "calgary",
+		Lat:  51.04653767382061,
+		Long: -114.06243444911559,
+		Tags: []string{"Geocache", "Traditional Cache"},
+	})
+	resp.Body.Close()
+
+	// Export the caches tagged as a Traditional Cache, and then all of them
+	testData := []struct {
+		query         string
+		expectedNames []string
+	}{
+		{query: "?tags=Traditional%20Cache", expectedNames: []string{"calgary"}},
+		{query: "?bbox=-114,50,-113,53", expectedNames: []string{"red-deer"}},
+		{query: "", expectedNames: []string{"edmonton", "calgary", "red-deer"}},
+	}
+	for _, td := range testData {
+		resp = execGet(t, createUrlPrefix()+"/export.gpx"+td.query)
+		validateStatus(t, 200, resp)
+		assert.Equal(t, "application/gpx+xml", resp.Header.Get("Content-Type"))
+		gpx := TestGpx{}
+		err := xml.Unmarshal([]byte(getResponseBodyString(t, resp)), &gpx)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		var actualNames []string
+		for _, wpt := range gpx.Waypoints {
+			actualNames = append(actualNames, wpt.Name)
+		}
+		assert.Equal(t, td.expectedNames, actualNames, td.query)
+	}
+
+	tr.shutdownServer()
+}
+
 func execGet(t *testing.T, url string) *http.Response {
 	retval, err := http.Get(url)
 	if err != nil {
@@ -451,6 +1489,23 @@ func validateStatus(t *testing.T, expected int, resp *http.Response) {
 	assert.Equal(t, expected, actualCode)
 }
 
+// validateProblem validates that the response is an application/problem+json body with the expected
+// status and code, and returns it.
+func validateProblem(
+	t *testing.T,
+	expectedStatus int,
+	expectedCode string,
+	resp *http.Response,
+) TestProblemResponse {
+	validateStatus(t, expectedStatus, resp)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	var retval TestProblemResponse
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&retval))
+	assert.Equal(t, expectedStatus, retval.Status)
+	assert.Equal(t, expectedCode, retval.Code)
+	return retval
+}
+
 func validateGetResult(t *testing.T, resp *http.Response, expectedResp TestGetCacheResponse) {
 	actualRespStr := getResponseBodyString(t, resp)
 	actualResp := TestGetCacheResponse{}
@@ -26,9 +26,9 @@ func NewTestRunner(ctx context.Context, cancel context.CancelFunc, wg *sync.Wait
 	}
 }
 
-func (t *TestRunner) runServer() error {
+func (t *TestRunner) runServer(extraArgs ...string) error {
 	// Create a set of "mock" cli args and execute the Run entrypoint for the application.
-	args := []string{"placeholder-token", "-p", testPort}
+	args := append([]string{"placeholder-token", "-p", testPort}, extraArgs...)
 	go run.Run(args, t.ctx, t.cancel, t.wg)
 
 	// Now we need to block and wait until the server is up and running before we can return to the
@@ -8,6 +8,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	geostore "github.com/rchapin/go-geocache-api/geostore"
 	model "github.com/rchapin/go-geocache-api/model"
 )
 
@@ -34,6 +35,36 @@ func (m *MockCacheStore) EXPECT() *MockCacheStoreMockRecorder {
 	return m.recorder
 }
 
+// AddTag mocks base method.
+func (m *MockCacheStore) AddTag(arg0, arg1 string) (model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddTag", arg0, arg1)
+	ret0, _ := ret[0].(model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddTag indicates an expected call of AddTag.
+func (mr *MockCacheStoreMockRecorder) AddTag(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddTag", reflect.TypeOf((*MockCacheStore)(nil).AddTag), arg0, arg1)
+}
+
+// CheckConsistency mocks base method.
+func (m *MockCacheStore) CheckConsistency(arg0 bool) (model.ConsistencyReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckConsistency", arg0)
+	ret0, _ := ret[0].(model.ConsistencyReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckConsistency indicates an expected call of CheckConsistency.
+func (mr *MockCacheStoreMockRecorder) CheckConsistency(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckConsistency", reflect.TypeOf((*MockCacheStore)(nil).CheckConsistency), arg0)
+}
+
 // Create mocks base method.
 func (m *MockCacheStore) Create(arg0 string, arg1, arg2 float64, arg3 []string) (uint64, error) {
 	m.ctrl.T.Helper()
@@ -49,6 +80,21 @@ func (mr *MockCacheStoreMockRecorder) Create(arg0, arg1, arg2, arg3 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockCacheStore)(nil).Create), arg0, arg1, arg2, arg3)
 }
 
+// CreateMany mocks base method.
+func (m *MockCacheStore) CreateMany(arg0 []model.NewCache) ([]model.CreateResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMany", arg0)
+	ret0, _ := ret[0].([]model.CreateResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateMany indicates an expected call of CreateMany.
+func (mr *MockCacheStoreMockRecorder) CreateMany(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMany", reflect.TypeOf((*MockCacheStore)(nil).CreateMany), arg0)
+}
+
 // Delete mocks base method.
 func (m *MockCacheStore) Delete(arg0 uint64) error {
 	m.ctrl.T.Helper()
@@ -77,11 +123,85 @@ func (mr *MockCacheStoreMockRecorder) DeleteAll() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAll", reflect.TypeOf((*MockCacheStore)(nil).DeleteAll))
 }
 
+// DeleteByName mocks base method.
+func (m *MockCacheStore) DeleteByName(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByName", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteByName indicates an expected call of DeleteByName.
+func (mr *MockCacheStoreMockRecorder) DeleteByName(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByName", reflect.TypeOf((*MockCacheStore)(nil).DeleteByName), arg0)
+}
+
+// FindByTagQuery mocks base method.
+func (m *MockCacheStore) FindByTagQuery(arg0 *model.TagQuery) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindByTagQuery", arg0)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindByTagQuery indicates an expected call of FindByTagQuery.
+func (mr *MockCacheStoreMockRecorder) FindByTagQuery(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindByTagQuery", reflect.TypeOf((*MockCacheStore)(nil).FindByTagQuery), arg0)
+}
+
+// FindInBox mocks base method.
+func (m *MockCacheStore) FindInBox(arg0, arg1, arg2, arg3 float64, arg4 uint64, arg5 int) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInBox", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindInBox indicates an expected call of FindInBox.
+func (mr *MockCacheStoreMockRecorder) FindInBox(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInBox", reflect.TypeOf((*MockCacheStore)(nil).FindInBox), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// FindInGeohash mocks base method.
+func (m *MockCacheStore) FindInGeohash(arg0 string, arg1 uint64, arg2 int) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInGeohash", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindInGeohash indicates an expected call of FindInGeohash.
+func (mr *MockCacheStoreMockRecorder) FindInGeohash(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInGeohash", reflect.TypeOf((*MockCacheStore)(nil).FindInGeohash), arg0, arg1, arg2)
+}
+
+// FindInPolygons mocks base method.
+func (m *MockCacheStore) FindInPolygons(arg0 []geostore.Polygon, arg1 uint64, arg2 int) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInPolygons", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindInPolygons indicates an expected call of FindInPolygons.
+func (mr *MockCacheStoreMockRecorder) FindInPolygons(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInPolygons", reflect.TypeOf((*MockCacheStore)(nil).FindInPolygons), arg0, arg1, arg2)
+}
+
 // FindNearest mocks base method.
-func (m *MockCacheStore) FindNearest(arg0, arg1, arg2 float64, arg3 int) ([]model.Cache, error) {
+func (m *MockCacheStore) FindNearest(arg0, arg1, arg2 float64, arg3 int) ([]model.NearbyCache, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "FindNearest", arg0, arg1, arg2, arg3)
-	ret0, _ := ret[0].([]model.Cache)
+	ret0, _ := ret[0].([]model.NearbyCache)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -93,18 +213,18 @@ func (mr *MockCacheStoreMockRecorder) FindNearest(arg0, arg1, arg2, arg3 interfa
 }
 
 // GetAll mocks base method.
-func (m *MockCacheStore) GetAll() ([]model.Cache, error) {
+func (m *MockCacheStore) GetAll(arg0 uint64, arg1 int) ([]model.Cache, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAll")
+	ret := m.ctrl.Call(m, "GetAll", arg0, arg1)
 	ret0, _ := ret[0].([]model.Cache)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAll indicates an expected call of GetAll.
-func (mr *MockCacheStoreMockRecorder) GetAll() *gomock.Call {
+func (mr *MockCacheStoreMockRecorder) GetAll(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockCacheStore)(nil).GetAll))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockCacheStore)(nil).GetAll), arg0, arg1)
 }
 
 // GetById mocks base method.
@@ -152,6 +272,66 @@ func (mr *MockCacheStoreMockRecorder) GetByTags(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByTags", reflect.TypeOf((*MockCacheStore)(nil).GetByTags), arg0)
 }
 
+// ListTags mocks base method.
+func (m *MockCacheStore) ListTags(arg0 string, arg1 model.TagSort, arg2 model.TagCount, arg3 int) ([]model.TagCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTags", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]model.TagCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTags indicates an expected call of ListTags.
+func (mr *MockCacheStoreMockRecorder) ListTags(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTags", reflect.TypeOf((*MockCacheStore)(nil).ListTags), arg0, arg1, arg2, arg3)
+}
+
+// RemoveTag mocks base method.
+func (m *MockCacheStore) RemoveTag(arg0, arg1 string) (model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveTag", arg0, arg1)
+	ret0, _ := ret[0].(model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveTag indicates an expected call of RemoveTag.
+func (mr *MockCacheStoreMockRecorder) RemoveTag(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTag", reflect.TypeOf((*MockCacheStore)(nil).RemoveTag), arg0, arg1)
+}
+
+// Search mocks base method.
+func (m *MockCacheStore) Search(arg0 model.SearchQuery) (model.SearchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", arg0)
+	ret0, _ := ret[0].(model.SearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockCacheStoreMockRecorder) Search(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockCacheStore)(nil).Search), arg0)
+}
+
+// SearchNames mocks base method.
+func (m *MockCacheStore) SearchNames(arg0 string, arg1 int) ([]model.RankedCache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchNames", arg0, arg1)
+	ret0, _ := ret[0].([]model.RankedCache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchNames indicates an expected call of SearchNames.
+func (mr *MockCacheStoreMockRecorder) SearchNames(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchNames", reflect.TypeOf((*MockCacheStore)(nil).SearchNames), arg0, arg1)
+}
+
 // Shutdown mocks base method.
 func (m *MockCacheStore) Shutdown() error {
 	m.ctrl.T.Helper()
@@ -180,3 +360,19 @@ func (mr *MockCacheStoreMockRecorder) Update(arg0, arg1 interface{}) *gomock.Cal
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockCacheStore)(nil).Update), arg0, arg1)
 }
+
+// Upsert mocks base method.
+func (m *MockCacheStore) Upsert(arg0 string, arg1, arg2 float64, arg3 []string) (uint64, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upsert", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Upsert indicates an expected call of Upsert.
+func (mr *MockCacheStoreMockRecorder) Upsert(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upsert", reflect.TypeOf((*MockCacheStore)(nil).Upsert), arg0, arg1, arg2, arg3)
+}
@@ -34,6 +34,20 @@ func (m *MockGeoStore) EXPECT() *MockGeoStoreMockRecorder {
 	return m.recorder
 }
 
+// Distance mocks base method.
+func (m *MockGeoStore) Distance(arg0, arg1, arg2, arg3 float64) float64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Distance", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(float64)
+	return ret0
+}
+
+// Distance indicates an expected call of Distance.
+func (mr *MockGeoStoreMockRecorder) Distance(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Distance", reflect.TypeOf((*MockGeoStore)(nil).Distance), arg0, arg1, arg2, arg3)
+}
+
 // Find mocks base method.
 func (m *MockGeoStore) Find(arg0, arg1 float64) uint64 {
 	m.ctrl.T.Helper()
@@ -48,11 +62,54 @@ func (mr *MockGeoStoreMockRecorder) Find(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockGeoStore)(nil).Find), arg0, arg1)
 }
 
+// FindInBox mocks base method.
+func (m *MockGeoStore) FindInBox(arg0, arg1, arg2, arg3 float64) []uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInBox", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]uint64)
+	return ret0
+}
+
+// FindInBox indicates an expected call of FindInBox.
+func (mr *MockGeoStoreMockRecorder) FindInBox(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInBox", reflect.TypeOf((*MockGeoStore)(nil).FindInBox), arg0, arg1, arg2, arg3)
+}
+
+// FindInGeohash mocks base method.
+func (m *MockGeoStore) FindInGeohash(arg0 string) ([]uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInGeohash", arg0)
+	ret0, _ := ret[0].([]uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindInGeohash indicates an expected call of FindInGeohash.
+func (mr *MockGeoStoreMockRecorder) FindInGeohash(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInGeohash", reflect.TypeOf((*MockGeoStore)(nil).FindInGeohash), arg0)
+}
+
+// FindInPolygons mocks base method.
+func (m *MockGeoStore) FindInPolygons(arg0 []geostore.Polygon) []uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInPolygons", arg0)
+	ret0, _ := ret[0].([]uint64)
+	return ret0
+}
+
+// FindInPolygons indicates an expected call of FindInPolygons.
+func (mr *MockGeoStoreMockRecorder) FindInPolygons(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInPolygons", reflect.TypeOf((*MockGeoStore)(nil).FindInPolygons), arg0)
+}
+
 // FindNearest mocks base method.
-func (m *MockGeoStore) FindNearest(arg0, arg1, arg2 float64, arg3 int) []uint64 {
+func (m *MockGeoStore) FindNearest(arg0, arg1, arg2 float64, arg3 int) []geostore.Neighbor {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "FindNearest", arg0, arg1, arg2, arg3)
-	ret0, _ := ret[0].([]uint64)
+	ret0, _ := ret[0].([]geostore.Neighbor)
 	return ret0
 }
 
@@ -74,30 +131,56 @@ func (mr *MockGeoStoreMockRecorder) Insert(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockGeoStore)(nil).Insert), arg0)
 }
 
-// Shutdown mocks base method.
-func (m *MockGeoStore) Shutdown() error {
+// InsertMany mocks base method.
+func (m *MockGeoStore) InsertMany(arg0 []*geostore.Node) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Shutdown")
+	m.ctrl.Call(m, "InsertMany", arg0)
+}
+
+// InsertMany indicates an expected call of InsertMany.
+func (mr *MockGeoStoreMockRecorder) InsertMany(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertMany", reflect.TypeOf((*MockGeoStore)(nil).InsertMany), arg0)
+}
+
+// Move mocks base method.
+func (m *MockGeoStore) Move(arg0 uint64, arg1, arg2 float64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Move", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// Shutdown indicates an expected call of Shutdown.
-func (mr *MockGeoStoreMockRecorder) Shutdown() *gomock.Call {
+// Move indicates an expected call of Move.
+func (mr *MockGeoStoreMockRecorder) Move(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shutdown", reflect.TypeOf((*MockGeoStore)(nil).Shutdown))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Move", reflect.TypeOf((*MockGeoStore)(nil).Move), arg0, arg1, arg2)
 }
 
-// getRootQuadTree mocks base method.
-func (m *MockGeoStore) getRootQuadTree() *geostore.QuadTree {
+// Remove mocks base method.
+func (m *MockGeoStore) Remove(arg0 uint64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "getRootQuadTree")
-	ret0, _ := ret[0].(*geostore.QuadTree)
+	ret := m.ctrl.Call(m, "Remove", arg0)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// getRootQuadTree indicates an expected call of getRootQuadTree.
-func (mr *MockGeoStoreMockRecorder) getRootQuadTree() *gomock.Call {
+// Remove indicates an expected call of Remove.
+func (mr *MockGeoStoreMockRecorder) Remove(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getRootQuadTree", reflect.TypeOf((*MockGeoStore)(nil).getRootQuadTree))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*MockGeoStore)(nil).Remove), arg0)
+}
+
+// Shutdown mocks base method.
+func (m *MockGeoStore) Shutdown() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Shutdown")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Shutdown indicates an expected call of Shutdown.
+func (mr *MockGeoStoreMockRecorder) Shutdown() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shutdown", reflect.TypeOf((*MockGeoStore)(nil).Shutdown))
 }
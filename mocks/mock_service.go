@@ -8,6 +8,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	geostore "github.com/rchapin/go-geocache-api/geostore"
 	model "github.com/rchapin/go-geocache-api/model"
 )
 
@@ -34,6 +35,21 @@ func (m *MockService) EXPECT() *MockServiceMockRecorder {
 	return m.recorder
 }
 
+// AddTag mocks base method.
+func (m *MockService) AddTag(arg0, arg1 string) (model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddTag", arg0, arg1)
+	ret0, _ := ret[0].(model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddTag indicates an expected call of AddTag.
+func (mr *MockServiceMockRecorder) AddTag(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddTag", reflect.TypeOf((*MockService)(nil).AddTag), arg0, arg1)
+}
+
 // Create mocks base method.
 func (m *MockService) Create(arg0 string, arg1, arg2 float64, arg3 []string) (uint64, error) {
 	m.ctrl.T.Helper()
@@ -49,6 +65,21 @@ func (mr *MockServiceMockRecorder) Create(arg0, arg1, arg2, arg3 interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockService)(nil).Create), arg0, arg1, arg2, arg3)
 }
 
+// CreateMany mocks base method.
+func (m *MockService) CreateMany(arg0 []model.NewCache) ([]model.CreateResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMany", arg0)
+	ret0, _ := ret[0].([]model.CreateResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateMany indicates an expected call of CreateMany.
+func (mr *MockServiceMockRecorder) CreateMany(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMany", reflect.TypeOf((*MockService)(nil).CreateMany), arg0)
+}
+
 // Delete mocks base method.
 func (m *MockService) Delete(arg0 uint64) error {
 	m.ctrl.T.Helper()
@@ -77,11 +108,85 @@ func (mr *MockServiceMockRecorder) DeleteAll() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAll", reflect.TypeOf((*MockService)(nil).DeleteAll))
 }
 
+// DeleteByName mocks base method.
+func (m *MockService) DeleteByName(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByName", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteByName indicates an expected call of DeleteByName.
+func (mr *MockServiceMockRecorder) DeleteByName(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByName", reflect.TypeOf((*MockService)(nil).DeleteByName), arg0)
+}
+
+// FindByTagQuery mocks base method.
+func (m *MockService) FindByTagQuery(arg0 *model.TagQuery) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindByTagQuery", arg0)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindByTagQuery indicates an expected call of FindByTagQuery.
+func (mr *MockServiceMockRecorder) FindByTagQuery(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindByTagQuery", reflect.TypeOf((*MockService)(nil).FindByTagQuery), arg0)
+}
+
+// FindInBox mocks base method.
+func (m *MockService) FindInBox(arg0, arg1, arg2, arg3 float64, arg4 uint64, arg5 int) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInBox", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindInBox indicates an expected call of FindInBox.
+func (mr *MockServiceMockRecorder) FindInBox(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInBox", reflect.TypeOf((*MockService)(nil).FindInBox), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// FindInGeohash mocks base method.
+func (m *MockService) FindInGeohash(arg0 string, arg1 uint64, arg2 int) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInGeohash", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindInGeohash indicates an expected call of FindInGeohash.
+func (mr *MockServiceMockRecorder) FindInGeohash(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInGeohash", reflect.TypeOf((*MockService)(nil).FindInGeohash), arg0, arg1, arg2)
+}
+
+// FindInPolygons mocks base method.
+func (m *MockService) FindInPolygons(arg0 []geostore.Polygon, arg1 uint64, arg2 int) ([]model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindInPolygons", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindInPolygons indicates an expected call of FindInPolygons.
+func (mr *MockServiceMockRecorder) FindInPolygons(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindInPolygons", reflect.TypeOf((*MockService)(nil).FindInPolygons), arg0, arg1, arg2)
+}
+
 // FindNearest mocks base method.
-func (m *MockService) FindNearest(arg0, arg1, arg2 float64, arg3 int) ([]model.Cache, error) {
+func (m *MockService) FindNearest(arg0, arg1, arg2 float64, arg3 int) ([]model.NearbyCache, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "FindNearest", arg0, arg1, arg2, arg3)
-	ret0, _ := ret[0].([]model.Cache)
+	ret0, _ := ret[0].([]model.NearbyCache)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -93,18 +198,18 @@ func (mr *MockServiceMockRecorder) FindNearest(arg0, arg1, arg2, arg3 interface{
 }
 
 // GetAll mocks base method.
-func (m *MockService) GetAll() ([]model.Cache, error) {
+func (m *MockService) GetAll(arg0 uint64, arg1 int) ([]model.Cache, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAll")
+	ret := m.ctrl.Call(m, "GetAll", arg0, arg1)
 	ret0, _ := ret[0].([]model.Cache)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAll indicates an expected call of GetAll.
-func (mr *MockServiceMockRecorder) GetAll() *gomock.Call {
+func (mr *MockServiceMockRecorder) GetAll(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockService)(nil).GetAll))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockService)(nil).GetAll), arg0, arg1)
 }
 
 // GetById mocks base method.
@@ -152,6 +257,66 @@ func (mr *MockServiceMockRecorder) GetByTags(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByTags", reflect.TypeOf((*MockService)(nil).GetByTags), arg0)
 }
 
+// ListTags mocks base method.
+func (m *MockService) ListTags(arg0 string, arg1 model.TagSort, arg2 model.TagCount, arg3 int) ([]model.TagCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTags", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]model.TagCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTags indicates an expected call of ListTags.
+func (mr *MockServiceMockRecorder) ListTags(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTags", reflect.TypeOf((*MockService)(nil).ListTags), arg0, arg1, arg2, arg3)
+}
+
+// RemoveTag mocks base method.
+func (m *MockService) RemoveTag(arg0, arg1 string) (model.Cache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveTag", arg0, arg1)
+	ret0, _ := ret[0].(model.Cache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveTag indicates an expected call of RemoveTag.
+func (mr *MockServiceMockRecorder) RemoveTag(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTag", reflect.TypeOf((*MockService)(nil).RemoveTag), arg0, arg1)
+}
+
+// Search mocks base method.
+func (m *MockService) Search(arg0 model.SearchQuery) (model.SearchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", arg0)
+	ret0, _ := ret[0].(model.SearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockServiceMockRecorder) Search(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockService)(nil).Search), arg0)
+}
+
+// SearchNames mocks base method.
+func (m *MockService) SearchNames(arg0 string, arg1 int) ([]model.RankedCache, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchNames", arg0, arg1)
+	ret0, _ := ret[0].([]model.RankedCache)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchNames indicates an expected call of SearchNames.
+func (mr *MockServiceMockRecorder) SearchNames(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchNames", reflect.TypeOf((*MockService)(nil).SearchNames), arg0, arg1)
+}
+
 // Update mocks base method.
 func (m *MockService) Update(arg0 string, arg1 model.Cache) (model.Cache, error) {
 	m.ctrl.T.Helper()
@@ -166,3 +331,19 @@ func (mr *MockServiceMockRecorder) Update(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockService)(nil).Update), arg0, arg1)
 }
+
+// Upsert mocks base method.
+func (m *MockService) Upsert(arg0 string, arg1, arg2 float64, arg3 []string) (uint64, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upsert", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Upsert indicates an expected call of Upsert.
+func (mr *MockServiceMockRecorder) Upsert(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upsert", reflect.TypeOf((*MockService)(nil).Upsert), arg0, arg1, arg2, arg3)
+}
@@ -0,0 +1,76 @@
+package model_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/rchapin/go-geocache-api/model/cachestoretest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestGeoStore() geostore.GeoStore {
+	quadrant := geostore.NewQuadrant(-180, -90, 180, 90, true)
+	return geostore.NewGeoStoreInMem(geostore.NewQuadTree(1, quadrant, 4), geostore.Haversine)
+}
+
+func TestInMemCacheStoreConformance(t *testing.T) {
+	cachestoretest.Run(t, func(t *testing.T) model.CacheStore {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		return model.NewCacheStore(ctx, cancel, &sync.WaitGroup{}, newTestGeoStore())
+	})
+}
+
+func TestInMemCacheStoreWithGeohashConformance(t *testing.T) {
+	cachestoretest.Run(t, func(t *testing.T) model.CacheStore {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		geoStore := geostore.NewGeohashGeoStore(geostore.Haversine)
+		return model.NewCacheStore(ctx, cancel, &sync.WaitGroup{}, geoStore)
+	})
+}
+
+func TestInMemCacheStoreWithRTreeConformance(t *testing.T) {
+	cachestoretest.Run(t, func(t *testing.T) model.CacheStore {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		geoStore := geostore.NewRTreeGeoStore(geostore.Haversine)
+		return model.NewCacheStore(ctx, cancel, &sync.WaitGroup{}, geoStore)
+	})
+}
+
+func TestDurableCacheStoreConformance(t *testing.T) {
+	cachestoretest.Run(t, func(t *testing.T) model.CacheStore {
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		store, err := model.NewDurableCacheStore(ctx, cancel, wg, newTestGeoStore(),
+			model.PersistenceOptions{
+				DataDir: t.TempDir(),
+				Fsync:   model.FsyncNever,
+			})
+		assert.Nil(t, err)
+		t.Cleanup(func() {
+			cancel()
+			wg.Wait()
+			assert.Nil(t, store.Shutdown())
+		})
+		return store
+	})
+}
+
+func TestSqliteCacheStoreConformance(t *testing.T) {
+	cachestoretest.Run(t, func(t *testing.T) model.CacheStore {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		path := filepath.Join(t.TempDir(), "geocaches.db")
+		store, err := model.NewSqliteCacheStore(
+			ctx, cancel, &sync.WaitGroup{}, path, geostore.Haversine)
+		assert.Nil(t, err)
+		t.Cleanup(func() { assert.Nil(t, store.Shutdown()) })
+		return store
+	})
+}
@@ -0,0 +1,1205 @@
+// Package cachestoretest is a conformance test suite for implementations of model.CacheStore, so
+// that every implementation is verified against the same expectations.
+package cachestoretest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/rchapin/go-geocache-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory returns a new, empty, CacheStore that calculates distances with geostore.Haversine.  The
+// factory is responsible for shutting down the CacheStore, and releasing anything else that it
+// created, with t.Cleanup.
+type Factory func(t *testing.T) model.CacheStore
+
+// Run runs all of the conformance tests, each as a subtest with a new CacheStore from the factory.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store model.CacheStore)
+	}{
+		{name: "Create", test: testCreate},
+		{name: "CreateMany", test: testCreateMany},
+		{name: "Get", test: testGet},
+		{name: "FindNearest", test: testFindNearest},
+		{name: "FindNearestMatchesBruteForce", test: testFindNearestMatchesBruteForce},
+		{name: "FindInBox", test: testFindInBox},
+		{name: "FindInBoxMatchesBruteForce", test: testFindInBoxMatchesBruteForce},
+		{name: "FindInPolygons", test: testFindInPolygons},
+		{name: "FindInGeohash", test: testFindInGeohash},
+		{name: "FindInGeohashMatchesBruteForce", test: testFindInGeohashMatchesBruteForce},
+		{name: "AntimeridianAndPoles", test: testAntimeridianAndPoles},
+		{name: "Upsert", test: testUpsert},
+		{name: "Update", test: testUpdate},
+		{name: "Tags", test: testTags},
+		{name: "TagQuery", test: testTagQuery},
+		{name: "ListTags", test: testListTags},
+		{name: "Search", test: testSearch},
+		{name: "SearchMatchesBruteForce", test: testSearchMatchesBruteForce},
+		{name: "SearchNames", test: testSearchNames},
+		{name: "Delete", test: testDelete},
+		{name: "CheckConsistency", test: testCheckConsistency},
+		{name: "Concurrency", test: testConcurrency},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) { tt.test(t, factory(t)) })
+	}
+}
+
+// testCaches are created, in order, by createTestCaches so that their ids are their index + 1.
+var testCaches = []model.Cache{
+	{Name: "calgary", Lat: 51.0447, Long: -114.0719, Tags: map[string]bool{"city": true}},
+	{Name: "edmonton", Lat: 53.5461, Long: -113.4937, Tags: map[string]bool{"city": true}},
+	{Name: "banff", Lat: 51.1784, Long: -115.5708, Tags: map[string]bool{"park": true}},
+	{Name: "fiji", Lat: -17.8, Long: -179.9, Tags: map[string]bool{"island": true}},
+	{Name: "tuvalu", Lat: -8.5, Long: 179.2, Tags: map[string]bool{"island": true}},
+	{Name: "sydney", Lat: -33.8688, Long: 151.2093, Tags: map[string]bool{}},
+}
+
+func createTestCaches(t *testing.T, store model.CacheStore) {
+	for i, c := range testCaches {
+		var tags []string
+		for tag := range c.Tags {
+			tags = append(tags, tag)
+		}
+		id, err := store.Create(c.Name, c.Lat, c.Long, tags)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(i+1), id)
+	}
+}
+
+func cacheNames(caches []model.Cache) []string {
+	var retval []string
+	for _, c := range caches {
+		retval = append(retval, c.Name)
+	}
+	return retval
+}
+
+func testCreate(t *testing.T, store model.CacheStore) {
+	// Ids start at 1 and are assigned in order
+	createTestCaches(t, store)
+
+	// Duplicate tags are only stored once, and a cache without tags has an empty set of them
+	id, err := store.Create("jasper", 52.8734, -118.0814, []string{"park", "park", "town"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(len(testCaches)+1), id)
+	cache, err := store.GetById(id)
+	assert.Nil(t, err)
+	assert.Equal(t, model.Cache{
+		Id:   id,
+		Name: "jasper",
+		Lat:  52.8734,
+		Long: -118.0814,
+		Tags: map[string]bool{"park": true, "town": true},
+	}, cache)
+	id, err = store.Create("canmore", 51.0892, -115.3593, nil)
+	assert.Nil(t, err)
+	cache, err = store.GetById(id)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{}, cache.Tags)
+
+	// Caches with invalid locations are rejected, and are not stored
+	invalid := [][2]float64{
+		{90.0001, 0}, {-91, 0}, {0, 180.5}, {0, -360}, {math.NaN(), 0}, {0, math.Inf(1)},
+	}
+	for _, location := range invalid {
+		_, err = store.Create("invalid", location[0], location[1], nil)
+		var validationErr *model.ValidationErr
+		assert.True(t, errors.As(err, &validationErr), "%v", location)
+	}
+	var notFoundErr *model.CacheNotFoundErr
+	_, err = store.GetByName("invalid")
+	assert.True(t, errors.As(err, &notFoundErr))
+	caches, err := store.GetAll(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(testCaches)+2, len(caches))
+
+	// The edges of the valid ranges are valid
+	_, err = store.Create("corner", -90, 180, nil)
+	assert.Nil(t, err)
+
+	// Names are unique, and a duplicate is a conflict with the existing cache, which is unchanged
+	_, err = store.Create("banff", 0, 0, []string{"duplicate"})
+	var conflictErr *model.ConflictErr
+	assert.True(t, errors.As(err, &conflictErr))
+	assert.Equal(t, uint64(3), conflictErr.Id)
+	assert.Equal(t, "banff", conflictErr.Name)
+	cache, err = store.GetByName("banff")
+	assert.Nil(t, err)
+	expected := testCaches[2]
+	expected.Id = 3
+	assert.Equal(t, expected, cache)
+	caches, err = store.GetByTags([]string{"duplicate"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+	nearest, err := store.FindNearest(0, 0, 1000, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, nearest)
+}
+
+func testUpsert(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	// An existing cache is updated
+	id, created, err := store.Upsert("banff", 51.2, -115.6, []string{"town"})
+	assert.Nil(t, err)
+	assert.False(t, created)
+	assert.Equal(t, uint64(3), id)
+	cache, err := store.GetByName("banff")
+	assert.Nil(t, err)
+	assert.Equal(t, model.Cache{
+		Id:   3,
+		Name: "banff",
+		Lat:  51.2,
+		Long: -115.6,
+		Tags: map[string]bool{"town": true},
+	}, cache)
+	nearest, err := store.FindNearest(51.2, -115.6, 1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banff"}, cacheNames(nearbyCachesToCaches(nearest)))
+	caches, err := store.GetByTags([]string{"park"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+
+	// A new cache is created
+	id, created, err = store.Upsert("jasper", 52.8734, -118.0814, []string{"glacier"})
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, uint64(len(testCaches)+1), id)
+	caches, err = store.GetByTags([]string{"glacier"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"jasper"}, cacheNames(caches))
+
+	// Invalid locations are rejected either way
+	var validationErr *model.ValidationErr
+	_, _, err = store.Upsert("banff", 91, 0, nil)
+	assert.True(t, errors.As(err, &validationErr))
+	_, _, err = store.Upsert("nowhere", 91, 0, nil)
+	assert.True(t, errors.As(err, &validationErr))
+	var notFoundErr *model.CacheNotFoundErr
+	_, err = store.GetByName("nowhere")
+	assert.True(t, errors.As(err, &notFoundErr))
+}
+
+func testCheckConsistency(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	// Caches that are created through the CacheStore can never be orphaned
+	report, err := store.CheckConsistency(false)
+	assert.Nil(t, err)
+	assert.Equal(t, model.ConsistencyReport{Orphans: []model.Cache{}}, report)
+	report, err = store.CheckConsistency(true)
+	assert.Nil(t, err)
+	assert.Equal(t, model.ConsistencyReport{Orphans: []model.Cache{}, Repaired: true}, report)
+	caches, err := store.GetAll(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(testCaches), len(caches))
+}
+
+func testCreateMany(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	// Each cache is created or rejected as it would be by Create, including a cache with the same
+	// name as an earlier one in the batch, and the ids are assigned in order
+	results, err := store.CreateMany([]model.NewCache{
+		{Name: "jasper", Lat: 52.8734, Long: -118.0814, Tags: []string{"park", "park"}},
+		{Name: "banff", Lat: 0, Long: 0},
+		{Name: "invalid", Lat: 91, Long: 0},
+		{Name: "canmore", Lat: 51.0892, Long: -115.3593},
+		{Name: "jasper", Lat: 0, Long: 0},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(results))
+	assert.Equal(t, model.CreateResult{Id: uint64(len(testCaches) + 1)}, results[0])
+	var conflictErr *model.ConflictErr
+	assert.True(t, errors.As(results[1].Err, &conflictErr))
+	assert.Equal(t, uint64(3), conflictErr.Id)
+	var validationErr *model.ValidationErr
+	assert.True(t, errors.As(results[2].Err, &validationErr))
+	assert.Equal(t, model.CreateResult{Id: uint64(len(testCaches) + 2)}, results[3])
+	assert.True(t, errors.As(results[4].Err, &conflictErr))
+	assert.Equal(t, results[0].Id, conflictErr.Id)
+
+	cache, err := store.GetByName("jasper")
+	assert.Nil(t, err)
+	assert.Equal(t, model.Cache{
+		Id:   results[0].Id,
+		Name: "jasper",
+		Lat:  52.8734,
+		Long: -118.0814,
+		Tags: map[string]bool{"park": true},
+	}, cache)
+	nearest, err := store.FindNearest(51.1784, -115.5708, 30000, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banff", "canmore"}, cacheNames(nearbyCachesToCaches(nearest)))
+	nearest, err = store.FindNearest(0, 0, 1000, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, nearest)
+
+	// A large batch is found by location just as if it had been created one at a time
+	r := rand.New(rand.NewSource(1))
+	caches := make([]model.NewCache, 200)
+	for i := range caches {
+		caches[i] = model.NewCache{
+			Name: fmt.Sprintf("batch-%d", i),
+			Lat:  r.Float64()*10 - 5,
+			Long: r.Float64()*10 - 5,
+		}
+	}
+	results, err = store.CreateMany(caches)
+	assert.Nil(t, err)
+	for _, result := range results {
+		assert.Nil(t, result.Err)
+	}
+	found, err := store.FindInBox(-5, -5, 5, 5, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(caches), len(found))
+
+	// An empty batch creates nothing
+	results, err = store.CreateMany(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, results)
+}
+
+func testGet(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	cache, err := store.GetById(3)
+	assert.Nil(t, err)
+	expected := testCaches[2]
+	expected.Id = 3
+	assert.Equal(t, expected, cache)
+
+	cache, err = store.GetByName("sydney")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(6), cache.Id)
+	assert.Equal(t, map[string]bool{}, cache.Tags)
+
+	var notFoundErr *model.CacheNotFoundErr
+	_, err = store.GetById(100)
+	assert.True(t, errors.As(err, &notFoundErr))
+	_, err = store.GetByName("oregon")
+	assert.True(t, errors.As(err, &notFoundErr))
+
+	// Caches with any of the tags, in ascending id order
+	caches, err := store.GetByTags([]string{"island", "park"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banff", "fiji", "tuvalu"}, cacheNames(caches))
+	caches, err = store.GetByTags([]string{"volcano"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+
+	caches, err = store.GetAll(0, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary", "edmonton", "banff", "fiji"}, cacheNames(caches))
+	caches, err = store.GetAll(4, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tuvalu", "sydney"}, cacheNames(caches))
+	caches, err = store.GetAll(6, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+}
+
+func testFindNearest(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	// Unbounded
+	nearest, err := store.FindNearest(51.0, -114.0, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		[]string{"calgary", "banff", "edmonton", "tuvalu", "fiji", "sydney"},
+		cacheNames(nearbyCachesToCaches(nearest)))
+	for _, n := range nearest {
+		assert.InDelta(t, geostore.Haversine(51.0, -114.0, n.Lat, n.Long), n.Distance, 1e-6)
+		assert.InDelta(t, geostore.InitialBearing(51.0, -114.0, n.Lat, n.Long), n.Bearing, 1e-6)
+	}
+
+	// Bounded by the limit, and by the maxDistance
+	nearest, err = store.FindNearest(51.0, -114.0, 0, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary", "banff"}, cacheNames(nearbyCachesToCaches(nearest)))
+	nearest, err = store.FindNearest(51.0, -114.0, 300000, 10)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		[]string{"calgary", "banff", "edmonton"}, cacheNames(nearbyCachesToCaches(nearest)))
+
+	// Across the antimeridian
+	nearest, err = store.FindNearest(-13, 179.9, 0, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tuvalu", "fiji"}, cacheNames(nearbyCachesToCaches(nearest)))
+
+	// Nothing within range
+	nearest, err = store.FindNearest(0, 0, 1000, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, nearest)
+}
+
+func nearbyCachesToCaches(nearbyCaches []model.NearbyCache) []model.Cache {
+	retval := make([]model.Cache, len(nearbyCaches))
+	for i, n := range nearbyCaches {
+		retval[i] = n.Cache
+	}
+	return retval
+}
+
+func testFindInBox(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	caches, err := store.FindInBox(49, -120, 60, -110, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary", "edmonton", "banff"}, cacheNames(caches))
+	caches, err = store.FindInBox(49, -120, 60, -110, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"edmonton"}, cacheNames(caches))
+
+	// Across the antimeridian
+	caches, err = store.FindInBox(-20, 175, 0, -175, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fiji", "tuvalu"}, cacheNames(caches))
+
+	// The edges of the box are inclusive
+	caches, err = store.FindInBox(51.0447, -114.0719, 51.0447, -114.0719, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary"}, cacheNames(caches))
+
+	caches, err = store.FindInBox(0, 0, 10, 10, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+}
+
+func testFindInPolygons(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	// A rough outline of Alberta, with a hole around Calgary, and a triangle around Sydney
+	alberta := geostore.Polygon{
+		geostore.Ring{{-120, 49}, {-110, 49}, {-110, 60}, {-120, 60}, {-120, 49}},
+		geostore.Ring{
+			{-114.5, 50.8}, {-113.8, 50.8}, {-113.8, 51.3}, {-114.5, 51.3}, {-114.5, 50.8},
+		},
+	}
+	sydney := geostore.Polygon{geostore.Ring{{150, -35}, {152, -35}, {151, -33}, {150, -35}}}
+
+	caches, err := store.FindInPolygons([]geostore.Polygon{alberta, sydney}, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"edmonton", "banff", "sydney"}, cacheNames(caches))
+	caches, err = store.FindInPolygons([]geostore.Polygon{alberta, sydney}, 2, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banff"}, cacheNames(caches))
+}
+
+func testFindInGeohash(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	caches, err := store.FindInGeohash("c3", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary", "edmonton", "banff"}, cacheNames(caches))
+	caches, err = store.FindInGeohash("c3", 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"edmonton"}, cacheNames(caches))
+
+	// Geohashes are case insensitive
+	caches, err = store.FindInGeohash("C3NFKH", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary"}, cacheNames(caches))
+
+	caches, err = store.FindInGeohash("r", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tuvalu", "sydney"}, cacheNames(caches))
+	caches, err = store.FindInGeohash("2hb4dr", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fiji"}, cacheNames(caches))
+
+	caches, err = store.FindInGeohash("s0", 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+
+	_, err = store.FindInGeohash("c3a", 0, 0)
+	assert.NotNil(t, err)
+
+	// Caches on the edges that the cell shares with its neighbors to the north and east are in
+	// those cells, and so do not count towards the limit
+	minLat, minLong, maxLat, maxLong, err := geostore.DecodeGeohash("c3")
+	assert.Nil(t, err)
+	midLat, midLong := (minLat+maxLat)/2, (minLong+maxLong)/2
+	for _, c := range []model.Cache{
+		{Name: "north-edge", Lat: maxLat, Long: midLong},
+		{Name: "east-edge", Lat: midLat, Long: maxLong},
+		{Name: "inside", Lat: midLat, Long: midLong},
+	} {
+		_, err := store.Create(c.Name, c.Lat, c.Long, nil)
+		assert.Nil(t, err)
+	}
+	caches, err = store.FindInGeohash("c3", 3, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"inside"}, cacheNames(caches))
+	caches, err = store.FindInGeohash("c3", 0, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary", "edmonton", "banff", "inside"}, cacheNames(caches))
+}
+
+func testAntimeridianAndPoles(t *testing.T, store model.CacheStore) {
+	caches := []model.Cache{
+		{Name: "east", Lat: 0, Long: 179.98},
+		{Name: "west", Lat: 0, Long: -179.99},
+		{Name: "dateline", Lat: 0, Long: 180},
+		{Name: "north", Lat: 90, Long: 0},
+		{Name: "nearnorth", Lat: 89.99, Long: 120},
+		{Name: "south", Lat: -90, Long: 45},
+	}
+	for _, c := range caches {
+		_, err := store.Create(c.Name, c.Lat, c.Long, nil)
+		assert.Nil(t, err)
+	}
+
+	// A longitude of 180 is the same as one of -180
+	nearest, err := store.FindNearest(0, -180, 0, 3)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		[]string{"dateline", "west", "east"}, cacheNames(nearbyCachesToCaches(nearest)))
+	assert.Equal(t, 0.0, nearest[0].Distance)
+	nearest, err = store.FindNearest(0, 179.996, 1700, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dateline", "west"}, cacheNames(nearbyCachesToCaches(nearest)))
+
+	// A box with an edge on the antimeridian includes the caches on the other side of it
+	found, err := store.FindInBox(-1, 179.985, 1, 180, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dateline"}, cacheNames(found))
+	found, err = store.FindInBox(-1, -180, 1, -179.985, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"west", "dateline"}, cacheNames(found))
+
+	// The shortest path between caches near a pole may cross it
+	nearest, err = store.FindNearest(89.95, -60, 0, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"north", "nearnorth"}, cacheNames(nearbyCachesToCaches(nearest)))
+	nearest, err = store.FindNearest(89.95, -60, 6000, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"north"}, cacheNames(nearbyCachesToCaches(nearest)))
+
+	// A box with an edge on a pole includes the pole, whatever its longitude
+	found, err = store.FindInBox(89.9, 100, 90, 110, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"north"}, cacheNames(found))
+	found, err = store.FindInBox(-90, 0, -89, 10, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"south"}, cacheNames(found))
+}
+
+func testUpdate(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	updated, err := store.Update("sydney", model.Cache{
+		Lat:  -37.8136,
+		Long: 144.9631,
+		Tags: map[string]bool{"city": true},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, model.Cache{
+		Id:   6,
+		Name: "sydney",
+		Lat:  -37.8136,
+		Long: 144.9631,
+		Tags: map[string]bool{"city": true},
+	}, updated)
+	cache, err := store.GetById(6)
+	assert.Nil(t, err)
+	assert.Equal(t, updated, cache)
+
+	// The spatial queries find it at its new location
+	nearest, err := store.FindNearest(-37.8, 145, 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sydney"}, cacheNames(nearbyCachesToCaches(nearest)))
+	caches, err := store.FindInBox(-35, 150, -33, 152, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+
+	// The tag queries find it by its new tags, and not by its old ones
+	caches, err = store.GetByTags([]string{"city"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"calgary", "edmonton", "sydney"}, cacheNames(caches))
+	_, err = store.Update("banff", model.Cache{Lat: 51.1784, Long: -115.5708, Tags: nil})
+	assert.Nil(t, err)
+	caches, err = store.GetByTags([]string{"park"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+
+	_, err = store.Update("oregon", model.Cache{Lat: 43, Long: -120})
+	var notFoundErr *model.CacheNotFoundErr
+	assert.True(t, errors.As(err, &notFoundErr))
+
+	// An invalid location is rejected and the cache is unchanged
+	_, err = store.Update("sydney", model.Cache{Lat: 91, Long: 144.9631})
+	var validationErr *model.ValidationErr
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []model.FieldErr{
+		{Field: "lat", Value: 91, Reason: "must be within [-90, 90]"},
+	}, validationErr.Fields)
+	cache, err = store.GetById(6)
+	assert.Nil(t, err)
+	assert.Equal(t, updated, cache)
+}
+
+func testTags(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	cache, err := store.AddTag("sydney", "harbour")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"harbour": true}, cache.Tags)
+	cache, err = store.AddTag("banff", "town")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"park": true, "town": true}, cache.Tags)
+	// Adding a tag that the cache already has does not change it
+	cache, err = store.AddTag("banff", "town")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"park": true, "town": true}, cache.Tags)
+
+	caches, err := store.GetByTags([]string{"harbour", "town"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banff", "sydney"}, cacheNames(caches))
+
+	cache, err = store.RemoveTag("banff", "park")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"town": true}, cache.Tags)
+	cache, err = store.RemoveTag("banff", "park")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"town": true}, cache.Tags)
+	caches, err = store.GetByTags([]string{"park"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+
+	// The rest of the cache is unchanged
+	cache, err = store.GetByName("banff")
+	assert.Nil(t, err)
+	assert.Equal(t, model.Cache{
+		Id:   3,
+		Name: "banff",
+		Lat:  51.1784,
+		Long: -115.5708,
+		Tags: map[string]bool{"town": true},
+	}, cache)
+
+	var notFoundErr *model.CacheNotFoundErr
+	_, err = store.AddTag("oregon", "state")
+	assert.True(t, errors.As(err, &notFoundErr))
+	_, err = store.RemoveTag("oregon", "state")
+	assert.True(t, errors.As(err, &notFoundErr))
+}
+
+func testTagQuery(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+	_, err := store.Create("jasper", 52.8734, -118.0814, []string{"park", "town"})
+	assert.Nil(t, err)
+
+	// Caches that match more than one part of the query are only returned once, in ascending id
+	// order
+	testData := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "city", expected: []string{"calgary", "edmonton"}},
+		{query: "city OR city, island", expected: []string{"calgary", "edmonton", "fiji", "tuvalu"}},
+		{query: "park AND town", expected: []string{"jasper"}},
+		{query: "(park OR city) AND NOT town", expected: []string{"calgary", "edmonton", "banff"}},
+		{query: "NOT (city OR island)", expected: []string{"banff", "sydney", "jasper"}},
+		{query: "island OR NOT park", expected: []string{
+			"calgary", "edmonton", "fiji", "tuvalu", "sydney",
+		}},
+		{query: "volcano", expected: nil},
+		{query: "NOT volcano", expected: []string{
+			"calgary", "edmonton", "banff", "fiji", "tuvalu", "sydney", "jasper",
+		}},
+	}
+	for _, td := range testData {
+		query, err := model.ParseTagQuery(td.query)
+		assert.Nil(t, err, td.query)
+		caches, err := store.FindByTagQuery(query)
+		assert.Nil(t, err, td.query)
+		assert.Equal(t, td.expected, cacheNames(caches), td.query)
+	}
+
+	// The query reflects updates to the tags
+	_, err = store.RemoveTag("jasper", "town")
+	assert.Nil(t, err)
+	query, _ := model.ParseTagQuery("park AND NOT town")
+	caches, err := store.FindByTagQuery(query)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banff", "jasper"}, cacheNames(caches))
+}
+
+func testListTags(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+	_, err := store.Create("jasper", 52.8734, -118.0814, []string{"park", "town"})
+	assert.Nil(t, err)
+
+	all := []model.TagCount{
+		{Tag: "city", Count: 2}, {Tag: "island", Count: 2}, {Tag: "park", Count: 2},
+		{Tag: "town", Count: 1},
+	}
+	tags, err := store.ListTags("", model.TagSortName, model.TagCount{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, all, tags)
+	// Tags with the same count are sorted by name
+	tags, err = store.ListTags("", model.TagSortCount, model.TagCount{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, all, tags)
+
+	tags, err = store.ListTags("is", model.TagSortCount, model.TagCount{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "island", Count: 2}}, tags)
+	tags, err = store.ListTags("volcano", model.TagSortName, model.TagCount{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{}, tags)
+
+	// Paging through the tags in either order
+	tags, err = store.ListTags("", model.TagSortName, model.TagCount{}, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, all[:3], tags)
+	tags, err = store.ListTags("", model.TagSortName, tags[2], 3)
+	assert.Nil(t, err)
+	assert.Equal(t, all[3:], tags)
+	_, err = store.AddTag("sydney", "town")
+	assert.Nil(t, err)
+	tags, err = store.ListTags("", model.TagSortCount, model.TagCount{}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, all[:2], tags)
+	tags, err = store.ListTags("", model.TagSortCount, tags[1], 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "park", Count: 2}, {Tag: "town", Count: 2}}, tags)
+	tags, err = store.ListTags("p", model.TagSortName, model.TagCount{Tag: "city"}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "park", Count: 2}}, tags)
+	_, err = store.AddTag("sydney", "parkway")
+	assert.Nil(t, err)
+	tags, err = store.ListTags("park", model.TagSortCount, model.TagCount{}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "park", Count: 2}}, tags)
+	tags, err = store.ListTags("park", model.TagSortCount, tags[0], 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "parkway", Count: 1}}, tags)
+	_, err = store.RemoveTag("sydney", "parkway")
+	assert.Nil(t, err)
+
+	// The counts reflect updates and deletes, and tags without any caches are removed
+	assert.Nil(t, store.Delete(3))
+	_, err = store.RemoveTag("jasper", "town")
+	assert.Nil(t, err)
+	_, err = store.RemoveTag("sydney", "town")
+	assert.Nil(t, err)
+	_, err = store.Update("calgary", model.Cache{
+		Lat: 51.0447, Long: -114.0719, Tags: map[string]bool{"town": true},
+	})
+	assert.Nil(t, err)
+	tags, err = store.ListTags("", model.TagSortCount, model.TagCount{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{
+		{Tag: "island", Count: 2}, {Tag: "city", Count: 1}, {Tag: "park", Count: 1},
+		{Tag: "town", Count: 1},
+	}, tags)
+	assert.Nil(t, store.DeleteAll())
+	tags, err = store.ListTags("", model.TagSortCount, model.TagCount{}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{}, tags)
+}
+
+func testSearch(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+	_, err := store.Create("jasper", 52.8734, -118.0814, []string{"park", "town"})
+	assert.Nil(t, err)
+	_, err = store.Create("canmore", 51.0892, -115.3593, []string{"town"})
+	assert.Nil(t, err)
+
+	parse := func(query string) *model.TagQuery {
+		q, err := model.ParseTagQuery(query)
+		assert.Nil(t, err)
+		return q
+	}
+	testData := []struct {
+		name          string
+		query         model.SearchQuery
+		expected      []string
+		expectedIndex string
+	}{
+		{
+			// The few caches near banff are fewer than those with either of the tags
+			name: "near, tags and name",
+			query: model.SearchQuery{
+				Near:  &model.SearchNear{Lat: 51.1784, Long: -115.5708, MaxDistance: 30000},
+				Tags:  parse("park OR town"),
+				Name:  "N",
+				Sort:  model.SearchSortDistance,
+				Limit: 2,
+			},
+			expected:      []string{"banff", "canmore"},
+			expectedIndex: model.SearchIndexGeometry,
+		},
+		{
+			// Every cache is within the box, but only two have the tag
+			name: "box and tags",
+			query: model.SearchQuery{
+				Box:  &geostore.Box{MinLat: -90, MinLong: -180, MaxLat: 90, MaxLong: 180},
+				Tags: parse("island"),
+			},
+			expected:      []string{"fiji", "tuvalu"},
+			expectedIndex: model.SearchIndexTags,
+		},
+		{
+			// Only the two caches with a token of their name that contains the name are read
+			name:          "name",
+			query:         model.SearchQuery{Name: "an", Sort: model.SearchSortName},
+			expected:      []string{"banff", "canmore"},
+			expectedIndex: model.SearchIndexName,
+		},
+		{
+			// Fewer caches contain the name than have either of the tags
+			name:          "tags and name",
+			query:         model.SearchQuery{Tags: parse("park OR town"), Name: "Jasp"},
+			expected:      []string{"jasper"},
+			expectedIndex: model.SearchIndexName,
+		},
+		{
+			name: "polygons and negated tags",
+			query: model.SearchQuery{
+				Polygons: []geostore.Polygon{{{{-120, 50}, {-110, 50}, {-110, 54}, {-120, 54}}}},
+				Tags:     parse("NOT city"),
+			},
+			expected:      []string{"banff", "jasper", "canmore"},
+			expectedIndex: model.SearchIndexGeometry,
+		},
+		{
+			// A near filter without a maxdistance only sorts the caches
+			name: "nearest",
+			query: model.SearchQuery{
+				Near:  &model.SearchNear{Lat: -18, Long: 180},
+				Sort:  model.SearchSortDistance,
+				Limit: 2,
+			},
+			expected:      []string{"fiji", "tuvalu"},
+			expectedIndex: model.SearchIndexScan,
+		},
+	}
+	for _, td := range testData {
+		result, err := store.Search(td.query)
+		assert.Nil(t, err, td.name)
+		assert.Equal(t, td.expected, cacheNames(nearbyCachesToCaches(result.Caches)), td.name)
+		assert.Equal(t, td.expectedIndex, result.Index, td.name)
+	}
+
+	// Distances and bearings are only included for the near filter
+	result, err := store.Search(model.SearchQuery{
+		Near: &model.SearchNear{Lat: 51.0447, Long: -114.0719, MaxDistance: 1000},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Caches))
+	assert.Equal(t, "calgary", result.Caches[0].Name)
+	assert.Equal(t, 0.0, result.Caches[0].Distance)
+	assert.Equal(t, map[string]bool{"city": true}, result.Caches[0].Tags)
+	result, err = store.Search(model.SearchQuery{Name: "jasper"})
+	assert.Nil(t, err)
+	assert.Equal(t, []model.NearbyCache{{Cache: model.Cache{
+		Id:   7,
+		Name: "jasper",
+		Lat:  52.8734,
+		Long: -118.0814,
+		Tags: map[string]bool{"park": true, "town": true},
+	}}}, result.Caches)
+	result, err = store.Search(model.SearchQuery{Name: "oregon"})
+	assert.Nil(t, err)
+	assert.Equal(t, []model.NearbyCache{}, result.Caches)
+
+	_, err = store.Search(model.SearchQuery{Sort: model.SearchSortDistance})
+	assert.NotNil(t, err)
+}
+
+func testSearchMatchesBruteForce(t *testing.T, store model.CacheStore) {
+	r := rand.New(rand.NewSource(42))
+	caches := randomCaches(t, store, r, 200)
+	tags := []string{"ocean", "flowrate", "voltage", "archived"}
+	for i := range caches {
+		for _, tag := range tags {
+			if r.Intn(3) == 0 {
+				_, err := store.AddTag(caches[i].Name, tag)
+				assert.Nil(t, err)
+				caches[i].Tags[tag] = true
+			}
+		}
+	}
+	tagQueries := []struct {
+		query string
+		match func(tags map[string]bool) bool
+	}{
+		{query: "ocean", match: func(tags map[string]bool) bool { return tags["ocean"] }},
+		{
+			query: "ocean AND (flowrate OR voltage) AND NOT archived",
+			match: func(tags map[string]bool) bool {
+				return tags["ocean"] && (tags["flowrate"] || tags["voltage"]) && !tags["archived"]
+			},
+		},
+		{query: "NOT voltage", match: func(tags map[string]bool) bool { return !tags["voltage"] }},
+	}
+	names := []string{"", "1", "cache-2", "99"}
+	sorts := []model.SearchSort{model.SearchSortId, model.SearchSortName, model.SearchSortDistance}
+
+	for i := 0; i < 50; i++ {
+		var query model.SearchQuery
+		lat, long := 51.0447+r.Float64()-0.5, -114.0719+r.Float64()-0.5
+		switch i % 3 {
+		case 0:
+			query.Near = &model.SearchNear{Lat: lat, Long: long, MaxDistance: r.Float64() * 60000}
+		case 1:
+			query.Box = &geostore.Box{
+				MinLat: lat - r.Float64(), MinLong: long - r.Float64(),
+				MaxLat: lat + r.Float64(), MaxLong: long + r.Float64(),
+			}
+		}
+		// Some of the queries do not have a tag query
+		matchTags := func(tags map[string]bool) bool { return true }
+		if j := r.Intn(len(tagQueries) + 1); j < len(tagQueries) {
+			query.Tags, _ = model.ParseTagQuery(tagQueries[j].query)
+			matchTags = tagQueries[j].match
+		}
+		query.Name = names[r.Intn(len(names))]
+		query.Sort = sorts[r.Intn(len(sorts))]
+		if query.Near == nil && query.Sort == model.SearchSortDistance {
+			query.Sort = model.SearchSortId
+		}
+		query.Limit = r.Intn(20)
+
+		var expected []model.Cache
+		distances := make(map[uint64]float64)
+		for _, c := range caches {
+			if !matchTags(c.Tags) {
+				continue
+			}
+			if !strings.Contains(c.Name, query.Name) {
+				continue
+			}
+			if n := query.Near; n != nil {
+				distances[c.Id] = geostore.Haversine(n.Lat, n.Long, c.Lat, c.Long)
+				if distances[c.Id] > n.MaxDistance {
+					continue
+				}
+			}
+			if b := query.Box; b != nil && (c.Lat < b.MinLat || c.Lat > b.MaxLat ||
+				c.Long < b.MinLong || c.Long > b.MaxLong) {
+				continue
+			}
+			expected = append(expected, c)
+		}
+		sort.SliceStable(expected, func(i, j int) bool {
+			switch query.Sort {
+			case model.SearchSortName:
+				return expected[i].Name < expected[j].Name
+			case model.SearchSortDistance:
+				return distances[expected[i].Id] < distances[expected[j].Id]
+			}
+			return false
+		})
+		if query.Limit > 0 && len(expected) > query.Limit {
+			expected = expected[:query.Limit]
+		}
+
+		result, err := store.Search(query)
+		assert.Nil(t, err)
+		assert.Equal(t, cacheNames(expected), cacheNames(nearbyCachesToCaches(result.Caches)),
+			"i=%d, query=%+v", i, query)
+	}
+}
+
+func rankedCacheNames(caches []model.RankedCache) []string {
+	var retval []string
+	for _, c := range caches {
+		retval = append(retval, c.Name)
+	}
+	return retval
+}
+
+func testSearchNames(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+	names := []string{"Café du Lac", "Lake Louise", "Lake Minnewanka", "Calgary Tower"}
+	for _, name := range names {
+		_, err := store.Create(name, 51.0, -115.0, nil)
+		assert.Nil(t, err)
+	}
+
+	testData := []struct {
+		query    string
+		limit    int
+		expected []string
+	}{
+		// Exact matches, with the closest names first
+		{query: "calgary", expected: []string{"calgary", "Calgary Tower"}},
+		// Typos
+		{query: "calgray", expected: []string{"calgary", "Calgary Tower"}},
+		{query: "bnaff", expected: []string{"banff"}},
+		// Prefixes
+		{query: "edmon", expected: []string{"edmonton"}},
+		// Case and accents are ignored, in the names and in the query
+		{query: "cafe", expected: []string{"Café du Lac"}},
+		{query: "CAFÉ DU LAC", expected: []string{"Café du Lac"}},
+		// Names that match more of the query rank higher
+		{query: "lake lou", expected: []string{"Lake Louise", "Lake Minnewanka"}},
+		{query: "lake", limit: 1, expected: []string{"Lake Louise"}},
+		{query: "volcano", expected: nil},
+		{query: "!!!", expected: nil},
+	}
+	for _, td := range testData {
+		caches, err := store.SearchNames(td.query, td.limit)
+		assert.Nil(t, err, td.query)
+		assert.NotNil(t, caches, td.query)
+		assert.Equal(t, td.expected, rankedCacheNames(caches), td.query)
+	}
+
+	// An exact match of every token of the query scores 1, and anything else less
+	caches, err := store.SearchNames("cafe du", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(caches))
+	assert.Equal(t, 1.0, caches[0].Score)
+	assert.Equal(t, uint64(len(testCaches)+1), caches[0].Id)
+	caches, err = store.SearchNames("cafe du lax", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(caches))
+	assert.Less(t, caches[0].Score, 1.0)
+	assert.Greater(t, caches[0].Score, 0.0)
+
+	// Deleted caches are removed from the index
+	tower, err := store.GetByName("Calgary Tower")
+	assert.Nil(t, err)
+	assert.Nil(t, store.Delete(tower.Id))
+	caches, err = store.SearchNames("tower", 0)
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+	assert.Nil(t, store.DeleteAll())
+	caches, err = store.SearchNames("calgary", 0)
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+}
+
+func testDelete(t *testing.T, store model.CacheStore) {
+	createTestCaches(t, store)
+
+	assert.Nil(t, store.Delete(1))
+	var notFoundErr *model.CacheNotFoundErr
+	assert.True(t, errors.As(store.Delete(1), &notFoundErr))
+	_, err := store.GetByName("calgary")
+	assert.True(t, errors.As(err, &notFoundErr))
+	nearest, err := store.FindNearest(51.0, -114.0, 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banff"}, cacheNames(nearbyCachesToCaches(nearest)))
+	caches, err := store.GetByTags([]string{"city"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"edmonton"}, cacheNames(caches))
+
+	// Deleting by name deletes the cache with the name, and only it
+	assert.Nil(t, store.DeleteByName("banff"))
+	assert.True(t, errors.As(store.DeleteByName("banff"), &notFoundErr))
+	_, err = store.GetById(3)
+	assert.True(t, errors.As(err, &notFoundErr))
+	nearest, err = store.FindNearest(51.0, -114.0, 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"edmonton"}, cacheNames(nearbyCachesToCaches(nearest)))
+	caches, err = store.GetByTags([]string{"park"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+	// A new cache with the name of a deleted one is deleted by name in its turn
+	id, err := store.Create("banff", 51.1784, -115.5708, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, store.DeleteByName("banff"))
+	_, err = store.GetById(id)
+	assert.True(t, errors.As(err, &notFoundErr))
+
+	assert.Nil(t, store.DeleteAll())
+	caches, err = store.GetAll(0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+	nearest, err = store.FindNearest(51.0, -114.0, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, nearest)
+
+	// Ids are never reused
+	id, err = store.Create("calgary", 51.0447, -114.0719, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(len(testCaches)+2), id)
+}
+
+// randomCaches creates n caches, with ids starting at 1, half of which are spread across the globe
+// and half clustered around Calgary.
+func randomCaches(t *testing.T, store model.CacheStore, r *rand.Rand, n int) []model.Cache {
+	retval := make([]model.Cache, n)
+	for i := range retval {
+		c := model.Cache{Name: fmt.Sprintf("cache-%d", i+1), Tags: map[string]bool{}}
+		if i%2 == 0 {
+			c.Lat, c.Long = r.Float64()*180-90, r.Float64()*360-180
+		} else {
+			c.Lat, c.Long = 51.0447+r.Float64()-0.5, -114.0719+r.Float64()-0.5
+		}
+		id, err := store.Create(c.Name, c.Lat, c.Long, nil)
+		assert.Nil(t, err)
+		c.Id = id
+		retval[i] = c
+	}
+	return retval
+}
+
+func testFindNearestMatchesBruteForce(t *testing.T, store model.CacheStore) {
+	r := rand.New(rand.NewSource(42))
+	caches := randomCaches(t, store, r, 200)
+
+	for i := 0; i < 25; i++ {
+		lat, long := r.Float64()*180-90, r.Float64()*360-180
+		if i%2 == 0 {
+			lat, long = 51.0447+r.Float64()-0.5, -114.0719+r.Float64()-0.5
+		}
+		maxDistance := float64(r.Intn(3)) * r.Float64() * 1000000
+		limit := r.Intn(20)
+		msg := fmt.Sprintf("lat=%f, long=%f, maxDistance=%f, limit=%d",
+			lat, long, maxDistance, limit)
+
+		var expected []model.NearbyCache
+		for _, c := range caches {
+			distance := geostore.Haversine(lat, long, c.Lat, c.Long)
+			if maxDistance <= 0 || distance <= maxDistance {
+				expected = append(expected, model.NearbyCache{Cache: c, Distance: distance})
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool {
+			if expected[i].Distance != expected[j].Distance {
+				return expected[i].Distance < expected[j].Distance
+			}
+			return expected[i].Id < expected[j].Id
+		})
+		if limit > 0 && len(expected) > limit {
+			expected = expected[:limit]
+		}
+
+		actual, err := store.FindNearest(lat, long, maxDistance, limit)
+		assert.Nil(t, err)
+		if !assert.Equal(t,
+			cacheNames(nearbyCachesToCaches(expected)),
+			cacheNames(nearbyCachesToCaches(actual)),
+			msg) {
+			continue
+		}
+		for i := range expected {
+			assert.InDelta(t, expected[i].Distance, actual[i].Distance, 1e-6, msg)
+		}
+	}
+}
+
+func testFindInBoxMatchesBruteForce(t *testing.T, store model.CacheStore) {
+	r := rand.New(rand.NewSource(42))
+	caches := randomCaches(t, store, r, 200)
+
+	for i := 0; i < 25; i++ {
+		minLat := r.Float64()*180 - 90
+		maxLat := minLat + r.Float64()*(90-minLat)
+		minLong := r.Float64()*360 - 180
+		maxLong := r.Float64()*360 - 180
+		if i%2 == 0 {
+			minLat, maxLat = 51.0447-r.Float64()/2, 51.0447+r.Float64()/2
+			minLong, maxLong = -114.0719-r.Float64()/2, -114.0719+r.Float64()/2
+		}
+
+		var expected []string
+		for _, c := range caches {
+			inLong := c.Long >= minLong && c.Long <= maxLong
+			if minLong > maxLong {
+				inLong = c.Long >= minLong || c.Long <= maxLong
+			}
+			if inLong && c.Lat >= minLat && c.Lat <= maxLat {
+				expected = append(expected, c.Name)
+			}
+		}
+		actual, err := store.FindInBox(minLat, minLong, maxLat, maxLong, 0, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, cacheNames(actual),
+			"minLat=%f, minLong=%f, maxLat=%f, maxLong=%f", minLat, minLong, maxLat, maxLong)
+	}
+}
+
+func testFindInGeohashMatchesBruteForce(t *testing.T, store model.CacheStore) {
+	r := rand.New(rand.NewSource(42))
+	caches := randomCaches(t, store, r, 200)
+
+	for i := 0; i < 25; i++ {
+		// Use the geohash of one of the caches so that the cell is not always empty
+		c := caches[r.Intn(len(caches))]
+		geohash := geostore.EncodeGeohash(c.Lat, c.Long, 1+r.Intn(6))
+
+		var expected []string
+		for _, c := range caches {
+			if geostore.EncodeGeohash(c.Lat, c.Long, len(geohash)) == geohash {
+				expected = append(expected, c.Name)
+			}
+		}
+		actual, err := store.FindInGeohash(geohash, 0, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, cacheNames(actual), "geohash=%s", geohash)
+	}
+}
+
+func testConcurrency(t *testing.T, store model.CacheStore) {
+	// Each worker creates, updates, queries and deletes its own caches while the others do the
+	// same, so the race detector can find any unsynchronized access.
+	const workers = 8
+	const perWorker = 20
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			var ids []uint64
+			for i := 0; i < perWorker; i++ {
+				name := fmt.Sprintf("cache-%d-%d", w, i)
+				lat, long := r.Float64()*180-90, r.Float64()*360-180
+				id, err := store.Create(name, lat, long, []string{fmt.Sprintf("worker-%d", w)})
+				assert.Nil(t, err)
+				ids = append(ids, id)
+				_, err = store.FindNearest(lat, long, 0, 5)
+				assert.Nil(t, err)
+				_, err = store.FindInBox(lat-1, long-1, lat+1, long+1, 0, 0)
+				assert.Nil(t, err)
+				_, err = store.GetAll(0, 10)
+				assert.Nil(t, err)
+			}
+			for i, id := range ids {
+				if i%2 == 0 {
+					assert.Nil(t, store.Delete(id))
+					continue
+				}
+				name := fmt.Sprintf("cache-%d-%d", w, i)
+				_, err := store.Update(name, model.Cache{Lat: 0, Long: float64(w), Tags: nil})
+				assert.Nil(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every worker's odd caches remain, at their updated locations
+	caches, err := store.GetAll(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, workers*perWorker/2, len(caches))
+	ids := make(map[uint64]bool)
+	for _, c := range caches {
+		assert.False(t, ids[c.Id], "duplicate id; id=%d", c.Id)
+		ids[c.Id] = true
+		assert.Equal(t, 0.0, c.Lat)
+	}
+	nearest, err := store.FindNearest(0, 0, 1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, perWorker/2, len(nearest))
+}
@@ -0,0 +1,346 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// nameScoreExact is the score of a query token that is equal to a token of a name.
+	nameScoreExact = 1.0
+	// nameScorePrefix is the most that a query token that is a prefix of a token of a name scores,
+	// which is scaled by the fraction of the token that it covers.
+	nameScorePrefix = 0.9
+	// nameScoreFuzzy is the most that a query token within the maximum number of edits of a token
+	// of a name scores, which is scaled down by the number of edits.
+	nameScoreFuzzy = 0.8
+)
+
+// RankedCache is a cache that matched a name search, with the relevance of its name to the search
+// as a Score between 0 and 1.
+type RankedCache struct {
+	Cache
+	Score float64
+}
+
+// nameFolds are the letters that do not decompose into a base letter and accents, but that people
+// commonly type as plain ASCII.
+var nameFolds = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "ð", "d", "þ", "th", "ı", "i")
+
+// nameTokens returns the tokens of a name, or of a name search, in the order that they appear.
+// Names are split into words on anything other than a letter or a digit, and each word is lower
+// cased and stripped of its accents, so that "Café du Lac" and "cafe-du-lac" have the same tokens.
+func nameTokens(name string) []string {
+	stripAccents := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(stripAccents, strings.ToLower(name))
+	if err != nil {
+		// The transform only fails on invalid UTF-8, which we index as it is
+		folded = strings.ToLower(name)
+	}
+	return strings.FieldsFunc(nameFolds.Replace(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// maxNameEdits returns the number of edits within which a query token of length n fuzzily matches
+// a token of a name.  Short tokens must match exactly, or else nearly every short word would match.
+func maxNameEdits(n int) int {
+	switch {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	}
+	return 2
+}
+
+// matchNameToken returns the score with which the query token matches the token of a name, which
+// is 0 if it does not match.  A query token matches if it is equal to the token, is a prefix of
+// it, so that names can be autocompleted, or is within maxNameEdits of it, so that typos match.
+func matchNameToken(queryToken, token string) float64 {
+	if queryToken == token {
+		return nameScoreExact
+	}
+	q, t := []rune(queryToken), []rune(token)
+	var retval float64
+	if strings.HasPrefix(token, queryToken) {
+		retval = nameScorePrefix * float64(len(q)) / float64(len(t))
+	}
+	maxEdits := maxNameEdits(len(q))
+	if d := editDistance(q, t, maxEdits); d <= maxEdits {
+		fuzzy := nameScoreFuzzy * (1 - float64(d)/float64(len(q)+1))
+		if fuzzy > retval {
+			retval = fuzzy
+		}
+	}
+	return retval
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and transpositions of
+// adjacent runes that turn a into b, which is the optimal string alignment distance.  Once the
+// distance is known to be greater than bound it stops, and returns bound + 1.
+func editDistance(a, b []rune, bound int) int {
+	if len(a)-len(b) > bound || len(b)-len(a) > bound {
+		return bound + 1
+	}
+	// Only the previous two rows of the matrix are needed for transpositions.
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > bound {
+			return bound + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	if prev[len(b)] > bound {
+		return bound + 1
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// nameIndex is an inverted index of the tokens of the names of the caches.
+type nameIndex struct {
+	postings map[string]map[uint64]bool
+	// tokens is every key in postings in ascending order, so that the tokens with a prefix are a
+	// range of it.
+	tokens []string
+	// lengths is every key in postings by its length in runes, so that the tokens that are near
+	// enough in length to fuzzily match are found without comparing every token.
+	lengths map[int]map[string]bool
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{
+		postings: make(map[string]map[uint64]bool),
+		lengths:  make(map[int]map[string]bool),
+	}
+}
+
+// add adds the cache with the id to the index of each of the tokens of its name.
+func (x *nameIndex) add(id uint64, name string) {
+	for _, token := range nameTokens(name) {
+		ids, ok := x.postings[token]
+		if !ok {
+			ids = make(map[uint64]bool)
+			x.postings[token] = ids
+			i := sort.SearchStrings(x.tokens, token)
+			x.tokens = append(x.tokens[:i], append([]string{token}, x.tokens[i:]...)...)
+			n := utf8.RuneCountInString(token)
+			if x.lengths[n] == nil {
+				x.lengths[n] = make(map[string]bool)
+			}
+			x.lengths[n][token] = true
+		}
+		ids[id] = true
+	}
+}
+
+// remove removes the cache with the id from the index of each of the tokens of its name, and
+// removes the index of any token that no longer has any caches.
+func (x *nameIndex) remove(id uint64, name string) {
+	for _, token := range nameTokens(name) {
+		ids, ok := x.postings[token]
+		if !ok {
+			continue
+		}
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(x.postings, token)
+			i := sort.SearchStrings(x.tokens, token)
+			x.tokens = append(x.tokens[:i], x.tokens[i+1:]...)
+			n := utf8.RuneCountInString(token)
+			delete(x.lengths[n], token)
+			if len(x.lengths[n]) == 0 {
+				delete(x.lengths, n)
+			}
+		}
+	}
+}
+
+// candidates returns the tokens that start with the query token, or that are between minLength
+// and maxLength runes long, each once.
+func (x *nameIndex) candidates(queryToken string, minLength, maxLength int) []string {
+	var retval []string
+	for _, token := range x.tokens[sort.SearchStrings(x.tokens, queryToken):] {
+		if !strings.HasPrefix(token, queryToken) {
+			break
+		}
+		retval = append(retval, token)
+	}
+	for n := minLength; n <= maxLength; n++ {
+		for token := range x.lengths[n] {
+			// The tokens that start with the query token are already candidates
+			if !strings.HasPrefix(token, queryToken) {
+				retval = append(retval, token)
+			}
+		}
+	}
+	return retval
+}
+
+// containing returns the ids, in ascending order, of the caches with a token that contains the
+// word.
+func (x *nameIndex) containing(word string) []uint64 {
+	ids := make(map[uint64]bool)
+	for _, token := range x.tokens {
+		if strings.Contains(token, word) {
+			for id := range x.postings[token] {
+				ids[id] = true
+			}
+		}
+	}
+	retval := make([]uint64, 0, len(ids))
+	for id := range ids {
+		retval = append(retval, id)
+	}
+	sort.Slice(retval, func(i, j int) bool { return retval[i] < retval[j] })
+	return retval
+}
+
+// rankNames is the name search of every CacheStore.  For each token of the query, candidates
+// returns the distinct tokens of the names that start with it or that are between minLength and
+// maxLength runes long, which are the only tokens that can match it, and they are matched against
+// it.  The postings return the ids of the caches with each of the matching tokens, and find returns
+// the caches with the ids.
+//
+// A cache scores the mean, over the tokens of the query, of the best score of the tokens of its
+// name, so that names that match more of the query rank higher.  Caches with the same score are
+// ranked by the number of tokens in their names, so that the closest names come first, and then
+// by name and id.  A limit of 0 is unbounded.
+func rankNames(
+	query string,
+	limit int,
+	candidates func(queryToken string, minLength, maxLength int) ([]string, error),
+	postings func(tokens []string) (map[string][]uint64, error),
+	find func(ids []uint64) ([]Cache, error),
+) ([]RankedCache, error) {
+	var queryTokens []string
+	seen := make(map[string]bool)
+	for _, token := range nameTokens(query) {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		queryTokens = append(queryTokens, token)
+	}
+	retval := []RankedCache{}
+	if len(queryTokens) == 0 {
+		return retval, nil
+	}
+
+	// The score of each of the matching tokens for each of the query tokens
+	tokenScores := make([]map[string]float64, len(queryTokens))
+	var matches []string
+	matched := make(map[string]bool)
+	for i, queryToken := range queryTokens {
+		tokenScores[i] = make(map[string]float64)
+		n := utf8.RuneCountInString(queryToken)
+		tokens, err := candidates(queryToken, n-maxNameEdits(n), n+maxNameEdits(n))
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			if score := matchNameToken(queryToken, token); score > 0 {
+				tokenScores[i][token] = score
+				if !matched[token] {
+					matched[token] = true
+					matches = append(matches, token)
+				}
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return retval, nil
+	}
+	idsByToken, err := postings(matches)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[uint64][]float64)
+	for i := range queryTokens {
+		for token, score := range tokenScores[i] {
+			for _, id := range idsByToken[token] {
+				best, ok := scores[id]
+				if !ok {
+					best = make([]float64, len(queryTokens))
+					scores[id] = best
+				}
+				if score > best[i] {
+					best[i] = score
+				}
+			}
+		}
+	}
+	ids := make([]uint64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	caches, err := find(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	lengths := make(map[uint64]int, len(caches))
+	for _, cache := range caches {
+		var total float64
+		for _, score := range scores[cache.Id] {
+			total += score
+		}
+		retval = append(retval, RankedCache{Cache: cache, Score: total / float64(len(queryTokens))})
+		lengths[cache.Id] = len(nameTokens(cache.Name))
+	}
+	sort.Slice(retval, func(i, j int) bool {
+		a, b := retval[i], retval[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case lengths[a.Id] != lengths[b.Id]:
+			return lengths[a.Id] < lengths[b.Id]
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		}
+		return a.Id < b.Id
+	})
+	if limit > 0 && len(retval) > limit {
+		retval = retval[:limit]
+	}
+	return retval, nil
+}
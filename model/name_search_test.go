@@ -0,0 +1,99 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameTokens(t *testing.T) {
+	testData := []struct {
+		name     string
+		expected []string
+	}{
+		{name: "Banff", expected: []string{"banff"}},
+		{name: "Café du Lac", expected: []string{"cafe", "du", "lac"}},
+		{name: "cafe-du-lac", expected: []string{"cafe", "du", "lac"}},
+		{name: "  Lake Louise #2 ", expected: []string{"lake", "louise", "2"}},
+		{name: "Straße", expected: []string{"strasse"}},
+		{name: "Ærøskøbing", expected: []string{"aeroskobing"}},
+		{name: "Montréal's ÉCOLE", expected: []string{"montreal", "s", "ecole"}},
+		{name: "!!!", expected: []string{}},
+	}
+	for _, td := range testData {
+		assert.Equal(t, td.expected, nameTokens(td.name), td.name)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	testData := []struct {
+		a, b     string
+		bound    int
+		expected int
+	}{
+		{a: "banff", b: "banff", bound: 2, expected: 0},
+		{a: "banf", b: "banff", bound: 2, expected: 1},
+		{a: "bnaff", b: "banff", bound: 2, expected: 1},
+		{a: "calgray", b: "calgary", bound: 2, expected: 1},
+		{a: "kalgery", b: "calgary", bound: 2, expected: 2},
+		// Once the distance exceeds the bound it is bound + 1
+		{a: "sydney", b: "banff", bound: 2, expected: 3},
+		{a: "a", b: "abcd", bound: 1, expected: 2},
+		{a: "", b: "ab", bound: 2, expected: 2},
+	}
+	for _, td := range testData {
+		actual := editDistance([]rune(td.a), []rune(td.b), td.bound)
+		assert.Equal(t, td.expected, actual, "%s %s", td.a, td.b)
+	}
+}
+
+func TestMatchNameToken(t *testing.T) {
+	assert.Equal(t, nameScoreExact, matchNameToken("banff", "banff"))
+	// Prefixes score by how much of the token they cover, and so longer prefixes score higher
+	assert.Greater(t, matchNameToken("edmon", "edmonton"), matchNameToken("ed", "edmonton"))
+	assert.Less(t, matchNameToken("edmon", "edmonton"), nameScoreExact)
+	// Typos score less than an exact match, and more edits score less
+	assert.Greater(t, matchNameToken("calgray", "calgary"), matchNameToken("kalgery", "calgary"))
+	assert.Greater(t, matchNameToken("kalgery", "calgary"), 0.0)
+	// Short tokens must be exact or a prefix
+	assert.Equal(t, 0.0, matchNameToken("ab", "ac"))
+	assert.Equal(t, 0.0, matchNameToken("sydney", "banff"))
+}
+
+func TestNameIndex(t *testing.T) {
+	x := newNameIndex()
+	x.add(1, "Lake Louise")
+	x.add(2, "Lake Minnewanka")
+	assert.Equal(t, []string{"lake", "louise", "minnewanka"}, x.tokens)
+	assert.Equal(t, map[uint64]bool{1: true, 2: true}, x.postings["lake"])
+	assert.Equal(t, map[string]bool{"louise": true}, x.lengths[6])
+
+	// Tokens without any caches are removed
+	x.remove(1, "Lake Louise")
+	assert.Equal(t, []string{"lake", "minnewanka"}, x.tokens)
+	assert.Equal(t, map[uint64]bool{2: true}, x.postings["lake"])
+	assert.NotContains(t, x.lengths, 6)
+	x.remove(2, "Lake Minnewanka")
+	assert.Empty(t, x.tokens)
+	assert.Empty(t, x.postings)
+	assert.Empty(t, x.lengths)
+}
+
+func TestNameIndexCandidates(t *testing.T) {
+	x := newNameIndex()
+	x.add(1, "Lake Louise")
+	x.add(2, "Lake Minnewanka")
+	x.add(3, "Café du Lac")
+	x.add(4, "Lakeview")
+
+	// The tokens with the prefix, whatever their length, and the others of the lengths, once each
+	assert.ElementsMatch(t, []string{"lake", "lakeview"}, x.candidates("lak", 11, 12))
+	assert.ElementsMatch(t, []string{"lake", "lakeview", "cafe", "lac"}, x.candidates("lak", 3, 4))
+	assert.ElementsMatch(t, []string{"louise"}, x.candidates("loiuse", 5, 6))
+	assert.Empty(t, x.candidates("volcano", 9, 9))
+
+	// The caches with a token that contains the word, wherever it is in the token
+	assert.Equal(t, []uint64{1, 2, 4}, x.containing("ak"))
+	assert.Equal(t, []uint64{3}, x.containing("caf"))
+	assert.Empty(t, x.containing("volcano"))
+}
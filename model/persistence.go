@@ -0,0 +1,283 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	log "github.com/rchapin/rlog"
+)
+
+const snapshotFileName = "snapshot.json"
+
+// PersistenceOptions configures the write-ahead log and snapshots of a durable InMemCacheStore.
+type PersistenceOptions struct {
+	// DataDir is the directory in which the write-ahead log segments and the snapshot are stored.
+	DataDir string
+	// Fsync is the policy for syncing the write-ahead log to disk; FsyncAlways, FsyncInterval or
+	// FsyncNever.
+	Fsync string
+	// FsyncInterval is how often the write-ahead log is synced with the FsyncInterval policy.
+	FsyncInterval time.Duration
+	// SnapshotInterval is how often a snapshot is written and the write-ahead log compacted.  A
+	// SnapshotInterval <= 0 disables periodic snapshots; one is still written on Shutdown.
+	SnapshotInterval time.Duration
+}
+
+// storeSnapshot is the complete state of an InMemCacheStore as of the record with the given lsn in
+// the write-ahead log.
+type storeSnapshot struct {
+	Lsn    uint64  `json:"lsn"`
+	NextId uint64  `json:"next_id"`
+	Caches []Cache `json:"caches"`
+}
+
+// NewDurableCacheStore returns an InMemCacheStore that logs every Create, Update and Delete to a
+// write-ahead log in the DataDir before applying it, and periodically writes a snapshot of all of
+// the caches so that the log can be compacted.  On startup, the caches, their indices, the id
+// counter and the GeoStore are rebuilt from the last snapshot and the log written after it.
+func NewDurableCacheStore(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	wg *sync.WaitGroup,
+	geoStore geostore.GeoStore,
+	opts PersistenceOptions,
+) (CacheStore, error) {
+	switch opts.Fsync {
+	case FsyncAlways, FsyncNever:
+	case FsyncInterval:
+		if opts.FsyncInterval <= 0 {
+			return nil, fmt.Errorf(
+				"fsync interval must be positive; interval=%s", opts.FsyncInterval)
+		}
+	default:
+		return nil, fmt.Errorf("unknown fsync policy; fsync=%s", opts.Fsync)
+	}
+	if err := os.MkdirAll(opts.DataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := newInMemCacheStore(geoStore)
+	s.dataDir = opts.DataDir
+	lastLsn, err := s.recover()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Recovered caches from data dir; dataDir=%s, caches=%d, lsn=%d",
+		opts.DataDir, len(s.caches), lastLsn)
+	s.wal = newWal(opts.DataDir, opts.Fsync, lastLsn+1)
+
+	wg.Add(1)
+	go s.runPersistence(ctx, wg, opts)
+	return s, nil
+}
+
+// recover rebuilds the store from the snapshot and then replays the write-ahead log records that
+// were written after it, returning the lsn of the last record.
+func (s *InMemCacheStore) recover() (uint64, error) {
+	snap, err := readSnapshot(s.dataDir)
+	if err != nil {
+		return 0, err
+	}
+	nodes := make([]*geostore.Node, len(snap.Caches))
+	for i := range snap.Caches {
+		cache := &snap.Caches[i]
+		s.indexCache(cache)
+		nodes[i] = geostore.NewNode(cache.Long, cache.Lat, cache.Id)
+	}
+	s.geostore.InsertMany(nodes)
+	if snap.NextId > s.sCounter {
+		s.sCounter = snap.NextId
+	}
+	s.snapshotLsn = snap.Lsn
+
+	segments, err := walSegments(s.dataDir)
+	if err != nil {
+		return 0, err
+	}
+	lastLsn := snap.Lsn
+	for _, seg := range segments {
+		err := readWalSegment(seg, func(r walRecord) error {
+			if r.Lsn <= lastLsn {
+				// Already included in the snapshot
+				return nil
+			}
+			if r.Lsn != lastLsn+1 {
+				return fmt.Errorf("write-ahead log is missing records; expected lsn=%d, lsn=%d",
+					lastLsn+1, r.Lsn)
+			}
+			if err := s.applyRecord(r); err != nil {
+				return fmt.Errorf(
+					"unable to replay write-ahead log record; lsn=%d, err=%w", r.Lsn, err)
+			}
+			lastLsn = r.Lsn
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return lastLsn, nil
+}
+
+// applyRecord applies a write-ahead log record to the store during replay.
+func (s *InMemCacheStore) applyRecord(r walRecord) error {
+	switch r.Op {
+	case walOpCreate:
+		if r.Cache == nil {
+			return errors.New("create record is missing its cache")
+		}
+		cache := *r.Cache
+		s.insertCache(&cache)
+		if cache.Id >= s.sCounter {
+			s.sCounter = cache.Id + 1
+		}
+	case walOpUpdate:
+		if r.Cache == nil {
+			return errors.New("update record is missing its cache")
+		}
+		existingCache, ok := s.caches[r.Cache.Id]
+		if !ok {
+			return &CacheNotFoundErr{id: r.Cache.Id}
+		}
+		if r.Cache.Lat != existingCache.Lat || r.Cache.Long != existingCache.Long {
+			if err := s.geostore.Move(existingCache.Id, r.Cache.Lat, r.Cache.Long); err != nil {
+				return err
+			}
+		}
+		s.updateCache(existingCache, *r.Cache)
+	case walOpDelete:
+		cache, ok := s.caches[r.Id]
+		if !ok {
+			return &CacheNotFoundErr{id: r.Id}
+		}
+		return s.deleteCache(cache)
+	case walOpDeleteAll:
+		return s.deleteAll()
+	default:
+		return fmt.Errorf("unknown write-ahead log op; op=%s", r.Op)
+	}
+	return nil
+}
+
+// runPersistence periodically syncs the write-ahead log, depending on the fsync policy, and writes
+// snapshots until the context is cancelled.
+func (s *InMemCacheStore) runPersistence(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	opts PersistenceOptions,
+) {
+	defer wg.Done()
+
+	var fsyncC, snapshotC <-chan time.Time
+	if opts.Fsync == FsyncInterval {
+		ticker := time.NewTicker(opts.FsyncInterval)
+		defer ticker.Stop()
+		fsyncC = ticker.C
+	}
+	if opts.SnapshotInterval > 0 {
+		ticker := time.NewTicker(opts.SnapshotInterval)
+		defer ticker.Stop()
+		snapshotC = ticker.C
+	}
+
+	for {
+		select {
+		case <-fsyncC:
+			if err := s.wal.sync(); err != nil {
+				log.Errorf("unable to sync write-ahead log; err=%s", err)
+			}
+		case <-snapshotC:
+			if err := s.writeSnapshot(); err != nil {
+				log.Errorf("unable to write snapshot; err=%s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeSnapshot writes a snapshot of the store, if it has changed since the last one, and then
+// deletes the write-ahead log segments that it includes.
+func (s *InMemCacheStore) writeSnapshot() error {
+	s.snapshotMux.Lock()
+	defer s.snapshotMux.Unlock()
+
+	// Holding the read lock blocks any writes, so the caches are consistent with the lsn.  Rotating
+	// the log ensures that every record after the lsn will be in a new segment.
+	s.sMux.RLock()
+	snap := storeSnapshot{Lsn: s.wal.lastLsn(), NextId: s.sCounter}
+	if snap.Lsn == s.snapshotLsn {
+		s.sMux.RUnlock()
+		return nil
+	}
+	snap.Caches = s.copyCaches(s.ids)
+	err := s.wal.rotate()
+	s.sMux.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// Write the snapshot to a temporary file and then rename it, so that we never have a partially
+	// written snapshot.
+	path := filepath.Join(s.dataDir, snapshotFileName)
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(snap); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := syncDir(s.dataDir); err != nil {
+		return err
+	}
+	s.snapshotLsn = snap.Lsn
+	log.Infof("Wrote snapshot; caches=%d, lsn=%d", len(snap.Caches), snap.Lsn)
+
+	return removeWalSegments(s.dataDir, snap.Lsn)
+}
+
+// readSnapshot reads the snapshot in the directory.  If there is not one, it returns an empty
+// snapshot.
+func readSnapshot(dir string) (storeSnapshot, error) {
+	var retval storeSnapshot
+	b, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return retval, nil
+	}
+	if err != nil {
+		return retval, err
+	}
+	if err := json.Unmarshal(b, &retval); err != nil {
+		return retval, fmt.Errorf("malformed snapshot; err=%s", err)
+	}
+	return retval, nil
+}
+
+// syncDir syncs the directory so that a file that was renamed into it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
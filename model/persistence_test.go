@@ -0,0 +1,303 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestDurableCacheStore(t *testing.T, dataDir string) (*InMemCacheStore, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	quadrant := geostore.NewQuadrant(-180, -90, 180, 90, true)
+	geoStore := geostore.NewGeoStoreInMem(geostore.NewQuadTree(1, quadrant, 4), geostore.Haversine)
+	store, err := NewDurableCacheStore(ctx, cancel, wg, geoStore, PersistenceOptions{
+		DataDir: dataDir,
+		Fsync:   FsyncAlways,
+	})
+	assert.Nil(t, err)
+	// Stopping the store without calling Shutdown simulates a crash, because a final snapshot is
+	// not written.
+	stop := func() {
+		cancel()
+		wg.Wait()
+	}
+	return store.(*InMemCacheStore), stop
+}
+
+// populateTestStore executes one of each of the logged operations.
+func populateTestStore(t *testing.T, s *InMemCacheStore) {
+	for _, c := range []Cache{
+		{Name: "calgary", Lat: 51.0447, Long: -114.0719},
+		{Name: "edmonton", Lat: 53.5461, Long: -113.4937},
+		{Name: "sydney", Lat: -33.8688, Long: 151.2093},
+	} {
+		_, err := s.Create(c.Name, c.Lat, c.Long, []string{"city"})
+		assert.Nil(t, err)
+	}
+	_, err := s.Update(
+		"sydney",
+		Cache{Lat: -37.8136, Long: 144.9631, Tags: map[string]bool{"vic": true}},
+	)
+	assert.Nil(t, err)
+	edmonton, err := s.GetByName("edmonton")
+	assert.Nil(t, err)
+	assert.Nil(t, s.Delete(edmonton.Id))
+}
+
+func assertRecoveredTestStore(t *testing.T, s *InMemCacheStore) {
+	caches, err := s.GetAll(0, 0)
+	assert.Nil(t, err)
+	city := map[string]bool{"city": true}
+	assert.Equal(t, []Cache{
+		{Id: 1, Name: "calgary", Lat: 51.0447, Long: -114.0719, Tags: city},
+		{Id: 3, Name: "sydney", Lat: -37.8136, Long: 144.9631, Tags: map[string]bool{"vic": true}},
+	}, caches)
+
+	// The indices and the GeoStore are rebuilt
+	nearest, err := s.FindNearest(-37.8, 145, 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), nearest[0].Id)
+	cache, err := s.GetByName("calgary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), cache.Id)
+	_, err = s.GetByName("edmonton")
+	assert.NotNil(t, err)
+	byTag, err := s.GetByTags([]string{"city"})
+	assert.Nil(t, err)
+	var byTagNames []string
+	for _, c := range byTag {
+		byTagNames = append(byTagNames, c.Name)
+	}
+	assert.Contains(t, byTagNames, "calgary")
+	assert.NotContains(t, byTagNames, "edmonton")
+	// sydney's tags were replaced by the update
+	assert.NotContains(t, byTagNames, "sydney")
+	byTag, err = s.GetByTags([]string{"vic"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(byTag))
+	assert.Equal(t, uint64(3), byTag[0].Id)
+
+	// Ids are not reused
+	id, err := s.Create("perth", -31.9523, 115.8613, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), id)
+}
+
+func TestDurableCacheStoreReplaysLog(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	populateTestStore(t, s)
+	stop()
+
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	defer stop()
+	assertRecoveredTestStore(t, s)
+}
+
+func TestDurableCacheStoreSnapshot(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	_, err := s.Create("winnipeg", 49.8951, -97.1384, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.DeleteAll())
+	assert.Nil(t, s.writeSnapshot())
+
+	// The log segments included in the snapshot are deleted
+	segments, err := walSegments(dataDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(segments))
+
+	// The rest of the operations are recovered from the log written after the snapshot
+	populateTestStore(t, s)
+	stop()
+	segments, err = walSegments(dataDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(segments))
+	assert.Equal(t, uint64(3), segments[0].startLsn)
+
+	// Since winnipeg was id 1, the ids are now one greater than in the other tests
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	defer stop()
+	caches, err := s.GetAll(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(caches))
+	assert.Equal(t, uint64(2), caches[0].Id)
+	assert.Equal(t, uint64(4), caches[1].Id)
+	id, err := s.Create("perth", -31.9523, 115.8613, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), id)
+}
+
+func TestDurableCacheStoreRecoversSnapshot(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	populateTestStore(t, s)
+	assert.Nil(t, s.writeSnapshot())
+	stop()
+
+	// Everything is recovered from the snapshot, with the GeoStore loaded in a single batch
+	segments, err := walSegments(dataDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(segments))
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	defer stop()
+	assertRecoveredTestStore(t, s)
+}
+
+func TestDurableCacheStoreShutdown(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	populateTestStore(t, s)
+	assert.Nil(t, s.Shutdown())
+	stop()
+	_, err := s.Create("perth", -31.9523, 115.8613, nil)
+	assert.ErrorIs(t, err, errWalClosed)
+
+	// Everything is in the final snapshot
+	segments, err := walSegments(dataDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(segments))
+
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	defer stop()
+	assertRecoveredTestStore(t, s)
+}
+
+func TestDurableCacheStoreMalformedLog(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	populateTestStore(t, s)
+	stop()
+	segmentPath := filepath.Join(dataDir, walSegmentName(1))
+	valid, err := os.ReadFile(segmentPath)
+	assert.Nil(t, err)
+
+	// A partially written last record is ignored
+	partial := append(append([]byte{}, valid...), []byte(`{"lsn":6,"op":"cre`)...)
+	assert.Nil(t, os.WriteFile(segmentPath, partial, 0644))
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	assertRecoveredTestStore(t, s)
+	stop()
+
+	// A malformed record before the end of a segment is an error
+	malformed := append([]byte("not a record\n"), valid...)
+	assert.Nil(t, os.WriteFile(segmentPath, malformed, 0644))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	quadrant := geostore.NewQuadrant(-180, -90, 180, 90, true)
+	geoStore := geostore.NewGeoStoreInMem(geostore.NewQuadTree(1, quadrant, 4), geostore.Haversine)
+	_, err = NewDurableCacheStore(ctx, cancel, &sync.WaitGroup{}, geoStore, PersistenceOptions{
+		DataDir: dataDir,
+		Fsync:   FsyncAlways,
+	})
+	assert.NotNil(t, err)
+}
+
+func TestDurableCacheStoreSyncFailure(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	_, err := s.Create("calgary", 51.0447, -114.0719, []string{"city"})
+	assert.Nil(t, err)
+
+	// The record of a write whose sync fails is written, but the write is not applied
+	syncErr := errors.New("injected sync failure")
+	s.wal.syncFile = func(f *os.File) error { return syncErr }
+	_, err = s.Create("edmonton", 53.5461, -113.4937, []string{"city"})
+	assert.Equal(t, syncErr, err)
+	s.wal.syncFile = (*os.File).Sync
+	_, err = s.Create("sydney", -33.8688, 151.2093, nil)
+	assert.Nil(t, err)
+	stop()
+
+	// Only the writes that succeeded are replayed
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	defer stop()
+	caches, err := s.GetAll(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []Cache{
+		{Id: 1, Name: "calgary", Lat: 51.0447, Long: -114.0719, Tags: map[string]bool{"city": true}},
+		{Id: 2, Name: "sydney", Lat: -33.8688, Long: 151.2093, Tags: map[string]bool{}},
+	}, caches)
+}
+
+func TestDurableCacheStoreSyncAndTruncateFailure(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	defer stop()
+	_, err := s.Create("calgary", 51.0447, -114.0719, []string{"city"})
+	assert.Nil(t, err)
+
+	// A record that can be neither synced nor truncated away may be replayed, and so the log fails
+	// that write and every write after it
+	s.wal.syncFile = func(f *os.File) error { return errors.New("injected sync failure") }
+	s.wal.truncateFile = func(f *os.File, size int64) error {
+		return errors.New("injected truncate failure")
+	}
+	_, err = s.Create("edmonton", 53.5461, -113.4937, []string{"city"})
+	assert.ErrorContains(t, err, "unknown state")
+	s.wal.syncFile = (*os.File).Sync
+	s.wal.truncateFile = (*os.File).Truncate
+	_, err = s.Create("sydney", -33.8688, 151.2093, nil)
+	assert.ErrorContains(t, err, "unknown state")
+	_, err = s.GetByName("sydney")
+	assert.IsType(t, &CacheNotFoundErr{}, err)
+}
+
+func TestDurableCacheStoreRepairsOrphans(t *testing.T) {
+	dataDir := t.TempDir()
+	s, stop := getTestDurableCacheStore(t, dataDir)
+	_, err := s.Create("calgary", 51.0447, -114.0719, []string{"city"})
+	assert.Nil(t, err)
+	// Log a second cache with the same name, as was possible before names were unique, which
+	// replaces the first in the name index when it is replayed.
+	s.sMux.Lock()
+	_, err = s.create("calgary", 51.0486, -114.0708, map[string]bool{"tower": true})
+	s.sMux.Unlock()
+	assert.Nil(t, err)
+	stop()
+
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	orphan := Cache{
+		Id:   1,
+		Name: "calgary",
+		Lat:  51.0447,
+		Long: -114.0719,
+		Tags: map[string]bool{"city": true},
+	}
+	report, err := s.CheckConsistency(false)
+	assert.Nil(t, err)
+	assert.Equal(t, ConsistencyReport{Orphans: []Cache{orphan}}, report)
+	cache, err := s.GetByName("calgary")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), cache.Id)
+
+	report, err = s.CheckConsistency(true)
+	assert.Nil(t, err)
+	assert.Equal(t, ConsistencyReport{Orphans: []Cache{orphan}, Repaired: true}, report)
+	stop()
+
+	// The orphan is deleted from all of the indices, and its deletion was logged
+	s, stop = getTestDurableCacheStore(t, dataDir)
+	defer stop()
+	report, err = s.CheckConsistency(false)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Orphans)
+	caches, err := s.GetAll(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(caches))
+	assert.Equal(t, uint64(2), caches[0].Id)
+	caches, err = s.GetByTags([]string{"city"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+	nearest, err := s.FindNearest(51.0447, -114.0719, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(nearest))
+	assert.Equal(t, uint64(2), nearest[0].Id)
+}
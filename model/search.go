@@ -0,0 +1,245 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+)
+
+// SearchSort is the order in which Search returns the caches.
+type SearchSort string
+
+const (
+	// SearchSortId sorts the caches in ascending id order.
+	SearchSortId SearchSort = "id"
+	// SearchSortName sorts the caches in ascending order of their names.
+	SearchSortName SearchSort = "name"
+	// SearchSortDistance sorts the caches in ascending order of their distance from the Near
+	// coordinates, and so requires the Near filter.
+	SearchSortDistance SearchSort = "distance"
+)
+
+// The indexes with which a search can find its candidates, as reported in the SearchResult.
+const (
+	SearchIndexGeometry = "geometry"
+	SearchIndexTags     = "tags"
+	SearchIndexName     = "name"
+	SearchIndexScan     = "scan"
+)
+
+// SearchNear matches the caches within MaxDistance meters of the coordinates.  A MaxDistance of 0
+// matches every cache, so that the caches can be sorted by distance without filtering them.
+type SearchNear struct {
+	Lat         float64
+	Long        float64
+	MaxDistance float64
+}
+
+// SearchQuery is a combination of filters, all of which a cache must match to be returned by
+// Search.  Near, Box and Polygons are the geometry filters, at most one of which may be provided.
+// Any filter that is not provided matches every cache.
+type SearchQuery struct {
+	Near     *SearchNear
+	Box      *geostore.Box
+	Polygons []geostore.Polygon
+	Tags     *TagQuery
+	// Name matches the caches whose names contain it, ignoring case.
+	Name string
+	// Sort is the order of the caches, SearchSortId if it is not provided.
+	Sort SearchSort
+	// Limit is the maximum number of caches to return.  A limit of 0 is unbounded.
+	Limit int
+}
+
+// SearchResult is the caches that matched a SearchQuery, which only include a Distance and
+// Bearing from the Near coordinates if it has the Near filter.  Index is the index that the
+// planner used to find the candidates that were then filtered by the rest of the query.
+type SearchResult struct {
+	Caches []NearbyCache
+	Index  string
+}
+
+// Validate returns an error if the query combines more than one geometry filter, or sorts by
+// distance without the Near filter.  A Box may cross the antimeridian, per geostore.SplitBox.
+func (q *SearchQuery) Validate() error {
+	geometries := 0
+	if q.Near != nil {
+		geometries++
+	}
+	if q.Box != nil {
+		geometries++
+	}
+	if len(q.Polygons) > 0 {
+		geometries++
+	}
+	switch {
+	case geometries > 1:
+		return errors.New("only one of the near, bbox and polygon filters may be provided")
+	case q.Near != nil && q.Near.MaxDistance < 0:
+		return errors.New("maxdistance must not be negative")
+	case q.Limit < 0:
+		return errors.New("limit must not be negative")
+	}
+	switch q.Sort {
+	case "", SearchSortId, SearchSortName:
+	case SearchSortDistance:
+		if q.Near == nil {
+			return errors.New("sorting by distance requires the near filter")
+		}
+	default:
+		return errors.New("sort must be one of id, name or distance; sort=" + string(q.Sort))
+	}
+	return nil
+}
+
+// hasGeometry returns true if the query has a geometry filter that excludes any caches.
+func (q *SearchQuery) hasGeometry() bool {
+	return (q.Near != nil && q.Near.MaxDistance > 0) || q.Box != nil || len(q.Polygons) > 0
+}
+
+// nameWord returns the longest of the words of the Name filter, or "" if it does not have any.
+// Every cache whose name contains the Name filter has a token of its name that contains each of
+// the words of the filter, so the caches with the tokens that contain the word are candidates for
+// it.
+func (q *SearchQuery) nameWord() string {
+	var retval string
+	for _, token := range nameTokens(q.Name) {
+		if len(token) > len(retval) {
+			retval = token
+		}
+	}
+	return retval
+}
+
+// geometryFraction returns the fraction of the surface of the Earth that is within the bounding
+// boxes of the geometry filter, or of its radius for the Near filter.
+func (q *SearchQuery) geometryFraction() float64 {
+	boxFraction := func(minLat, minLong, maxLat, maxLong float64) float64 {
+		dLong := maxLong - minLong
+		if dLong < 0 {
+			dLong += 360
+		}
+		dSinLat := math.Sin(maxLat*math.Pi/180) - math.Sin(minLat*math.Pi/180)
+		return dSinLat / 2 * dLong / 360
+	}
+	var retval float64
+	switch {
+	case q.Near != nil && q.Near.MaxDistance > 0:
+		// The area of a spherical cap
+		angle := math.Min(q.Near.MaxDistance/geostore.EarthRadiusMeters, math.Pi)
+		retval = (1 - math.Cos(angle)) / 2
+	case q.Box != nil:
+		retval = boxFraction(q.Box.MinLat, q.Box.MinLong, q.Box.MaxLat, q.Box.MaxLong)
+	case len(q.Polygons) > 0:
+		for _, p := range q.Polygons {
+			retval += boxFraction(p.Bounds())
+		}
+	default:
+		return 1
+	}
+	return math.Min(retval, 1)
+}
+
+// match returns the cache, with its distance and bearing from the Near coordinates if the query
+// has the Near filter, and whether it matches every filter of the query.
+func (q *SearchQuery) match(cache Cache, distance geostore.DistanceFunc) (NearbyCache, bool) {
+	retval := NearbyCache{Cache: cache}
+	if q.Tags != nil && !q.Tags.matches(cache.Tags) {
+		return retval, false
+	}
+	if q.Name != "" && !strings.Contains(strings.ToLower(cache.Name), strings.ToLower(q.Name)) {
+		return retval, false
+	}
+	switch {
+	case q.Near != nil:
+		retval.Distance = distance(q.Near.Lat, q.Near.Long, cache.Lat, cache.Long)
+		retval.Bearing = geostore.InitialBearing(q.Near.Lat, q.Near.Long, cache.Lat, cache.Long)
+		if q.Near.MaxDistance > 0 && retval.Distance > q.Near.MaxDistance {
+			return retval, false
+		}
+	case q.Box != nil:
+		inBox := false
+		b := q.Box
+		for _, split := range geostore.SplitBox(b.MinLat, b.MinLong, b.MaxLat, b.MaxLong) {
+			if cache.Lat >= split.MinLat && cache.Lat <= split.MaxLat &&
+				cache.Long >= split.MinLong && cache.Long <= split.MaxLong {
+				inBox = true
+				break
+			}
+		}
+		if !inBox {
+			return retval, false
+		}
+	case len(q.Polygons) > 0:
+		inPolygon := false
+		for _, p := range q.Polygons {
+			if p.Contains(cache.Lat, cache.Long) {
+				inPolygon = true
+				break
+			}
+		}
+		if !inPolygon {
+			return retval, false
+		}
+	}
+	return retval, true
+}
+
+// searchIndex is one of the ways that a store can find the candidates for a search.
+type searchIndex struct {
+	name string
+	// estimate is the estimated number of candidates that find will return.
+	estimate int
+	// find returns the candidates, which must include every cache that matches the filter that the
+	// index was chosen for, but may include others.
+	find func() ([]Cache, error)
+}
+
+// runSearch is the planner of Search for every CacheStore.  It finds the candidates with the most
+// selective of the indexes, which is the one with the lowest estimate or, of those with the same
+// estimate, the first of them, and then filters the candidates by every filter of the query.  The
+// indexes should end with a SearchIndexScan of every cache, so that there is always a plan.
+func runSearch(
+	q SearchQuery,
+	indexes []searchIndex,
+	distance geostore.DistanceFunc,
+) (SearchResult, error) {
+	if err := q.Validate(); err != nil {
+		return SearchResult{}, err
+	}
+	plan := indexes[0]
+	for _, index := range indexes[1:] {
+		if index.estimate < plan.estimate {
+			plan = index
+		}
+	}
+
+	candidates, err := plan.find()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	retval := SearchResult{Caches: []NearbyCache{}, Index: plan.name}
+	for _, cache := range candidates {
+		if match, ok := q.match(cache, distance); ok {
+			retval.Caches = append(retval.Caches, match)
+		}
+	}
+
+	sort.Slice(retval.Caches, func(i, j int) bool {
+		a, b := retval.Caches[i], retval.Caches[j]
+		switch {
+		case q.Sort == SearchSortName && a.Name != b.Name:
+			return a.Name < b.Name
+		case q.Sort == SearchSortDistance && a.Distance != b.Distance:
+			return a.Distance < b.Distance
+		}
+		return a.Id < b.Id
+	})
+	if q.Limit > 0 && len(retval.Caches) > q.Limit {
+		retval.Caches = retval.Caches[:q.Limit]
+	}
+	return retval, nil
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchQueryValidate(t *testing.T) {
+	near := &SearchNear{Lat: 51, Long: -114, MaxDistance: 1000}
+	box := &geostore.Box{MinLat: 50, MinLong: -115, MaxLat: 52, MaxLong: -113}
+	valid := []SearchQuery{
+		{},
+		{Near: near, Sort: SearchSortDistance},
+		{Box: box, Sort: SearchSortName, Limit: 10},
+		{Near: &SearchNear{Lat: 51, Long: -114}},
+	}
+	for _, q := range valid {
+		assert.Nil(t, q.Validate(), "%+v", q)
+	}
+
+	invalid := []SearchQuery{
+		{Near: near, Box: box},
+		{Box: box, Polygons: []geostore.Polygon{{}}},
+		{Near: &SearchNear{MaxDistance: -1}},
+		{Box: box, Sort: SearchSortDistance},
+		{Sort: "popularity"},
+		{Limit: -1},
+	}
+	for _, q := range invalid {
+		assert.NotNil(t, q.Validate(), "%+v", q)
+	}
+}
+
+func TestSearchQueryGeometryFraction(t *testing.T) {
+	box := func(minLat, minLong, maxLat, maxLong float64) SearchQuery {
+		return SearchQuery{Box: &geostore.Box{
+			MinLat: minLat, MinLong: minLong, MaxLat: maxLat, MaxLong: maxLong,
+		}}
+	}
+	near := func(maxDistance float64) SearchQuery {
+		return SearchQuery{Near: &SearchNear{MaxDistance: maxDistance}}
+	}
+	testData := []struct {
+		query    SearchQuery
+		expected float64
+	}{
+		{query: SearchQuery{}, expected: 1},
+		{query: box(-90, -180, 90, 180), expected: 1},
+		// The northern hemisphere
+		{query: box(0, -180, 90, 180), expected: 0.5},
+		// A quarter of the longitudes, across the antimeridian
+		{query: box(-90, 135, 90, -135), expected: 0.25},
+		{query: near(math.Pi * geostore.EarthRadiusMeters), expected: 1},
+		{query: near(math.Pi / 2 * geostore.EarthRadiusMeters), expected: 0.5},
+	}
+	for _, td := range testData {
+		assert.InDelta(t, td.expected, td.query.geometryFraction(), 1e-9, "%+v", td.query)
+	}
+}
+
+func TestRunSearchPlan(t *testing.T) {
+	caches := []Cache{
+		{Id: 1, Name: "b", Tags: map[string]bool{"ocean": true}},
+		{Id: 2, Name: "a", Tags: map[string]bool{"ocean": true}},
+		{Id: 3, Name: "c", Tags: map[string]bool{}},
+	}
+	var found []string
+	index := func(name string, estimate int) searchIndex {
+		return searchIndex{
+			name:     name,
+			estimate: estimate,
+			find: func() ([]Cache, error) {
+				found = append(found, name)
+				return caches, nil
+			},
+		}
+	}
+	tags, _ := ParseTagQuery("ocean")
+	query := SearchQuery{Tags: tags, Sort: SearchSortName}
+
+	// Only the index with the lowest estimate, or the first of them, finds the candidates, and they
+	// are still filtered by every filter of the query.
+	result, err := runSearch(query, []searchIndex{
+		index(SearchIndexGeometry, 10), index(SearchIndexTags, 2), index(SearchIndexScan, 3),
+	}, geostore.Haversine)
+	assert.Nil(t, err)
+	assert.Equal(t, SearchIndexTags, result.Index)
+	assert.Equal(t, []string{SearchIndexTags}, found)
+	assert.Equal(t, []NearbyCache{{Cache: caches[1]}, {Cache: caches[0]}}, result.Caches)
+
+	found = nil
+	query.Sort = SearchSortId
+	query.Limit = 1
+	result, err = runSearch(query, []searchIndex{
+		index(SearchIndexTags, 3), index(SearchIndexScan, 3),
+	}, geostore.Haversine)
+	assert.Nil(t, err)
+	assert.Equal(t, SearchIndexTags, result.Index)
+	assert.Equal(t, []NearbyCache{{Cache: caches[0]}}, result.Caches)
+
+	// An invalid query does not find any candidates
+	found = nil
+	_, err = runSearch(SearchQuery{Sort: SearchSortDistance}, []searchIndex{
+		index(SearchIndexScan, 3),
+	}, geostore.Haversine)
+	assert.NotNil(t, err)
+	assert.Empty(t, found)
+}
@@ -4,10 +4,15 @@ package model
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/rchapin/go-geocache-api/geostore"
+	log "github.com/rchapin/rlog"
 )
 
 type Cache struct {
@@ -18,6 +23,14 @@ type Cache struct {
 	Tags map[string]bool `json:"tags"`
 }
 
+// NearbyCache is a Cache returned from a nearest neighbor search along with its distance, in
+// meters, and the initial compass bearing, in degrees, from the search coordinates.
+type NearbyCache struct {
+	Cache
+	Distance float64 `json:"distance"`
+	Bearing  float64 `json:"bearing"`
+}
+
 type CacheNotFoundErr struct {
 	id   uint64
 	name string
@@ -27,26 +40,149 @@ func (e *CacheNotFoundErr) Error() string {
 	return fmt.Sprintf("Cache not found; id=%d, name=%s", e.id, e.name)
 }
 
+// ConflictErr is returned when a change cannot be made because it conflicts with the existing
+// caches.  The Id and Name are those of the existing cache that it conflicts with, if there is one.
+type ConflictErr struct {
+	Reason string
+	Id     uint64
+	Name   string
+}
+
+func (e *ConflictErr) Error() string {
+	return fmt.Sprintf("Cache conflict; reason=%s, id=%d, name=%s", e.Reason, e.Id, e.Name)
+}
+
+func newNameConflictErr(id uint64, name string) *ConflictErr {
+	return &ConflictErr{Reason: "a cache with the same name already exists", Id: id, Name: name}
+}
+
+// NewCache is one of the caches to create with CreateMany.
+type NewCache struct {
+	Name string
+	Lat  float64
+	Long float64
+	Tags []string
+}
+
+// CreateResult is the outcome of creating one of the caches with CreateMany: either the Id of the
+// new cache, or the Err that Create would have returned for it, such as a ConflictErr.
+type CreateResult struct {
+	Id  uint64
+	Err error
+}
+
+// ConsistencyReport is the result of checking a CacheStore for caches that are inconsistent with
+// the rest of the store.
+type ConsistencyReport struct {
+	// Orphans are the caches that cannot be found by their name, because a newer cache was created
+	// with the same name before names were required to be unique.
+	Orphans []Cache `json:"orphans"`
+	// Repaired is true if the orphans were deleted.
+	Repaired bool `json:"repaired"`
+}
+
+// TagCount is a tag and the number of caches that have it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// TagSort is the order in which ListTags returns the tags.
+type TagSort string
+
+const (
+	// TagSortName sorts the tags in ascending order of their names.
+	TagSortName TagSort = "name"
+	// TagSortCount sorts the tags in descending order of their counts, and then of their names.
+	TagSortCount TagSort = "count"
+)
+
+// before returns true if the tag a is before the tag b when the tags are sorted in this order.
+func (o TagSort) before(a, b TagCount) bool {
+	if o == TagSortCount && a.Count != b.Count {
+		return a.Count > b.Count
+	}
+	return a.Tag < b.Tag
+}
+
+const (
+	// StoreMemory is an InMemCacheStore, which is durable if it is given a data dir.
+	StoreMemory = "memory"
+	// StoreSqlite is a SqliteCacheStore.
+	StoreSqlite = "sqlite"
+)
+
 type CacheStore interface {
+	// Create creates a cache and returns its id.  Names are unique, and a ConflictErr is returned
+	// if there is already a cache with the same name.
 	Create(name string, lat float64, long float64, tags []string) (uint64, error)
-	FindNearest(lat, long, maxDistance float64, limit int) ([]Cache, error)
-	GetAll() ([]Cache, error)
+	// CreateMany creates each of the caches as Create would, but as a single batch, and returns the
+	// result for each of them in the same order.  A cache with the same name as an earlier one in
+	// the batch is a ConflictErr.  The error is only for a failure of the store itself.
+	CreateMany(caches []NewCache) ([]CreateResult, error)
+	// Upsert updates the location and tags of the cache with the provided name, or creates it if
+	// there is not one.  It returns the id of the cache and whether it was created.
+	Upsert(name string, lat float64, long float64, tags []string) (uint64, bool, error)
+	// CheckConsistency checks the store for orphaned caches, and deletes them if repair is true.
+	CheckConsistency(repair bool) (ConsistencyReport, error)
+	FindNearest(lat, long, maxDistance float64, limit int) ([]NearbyCache, error)
+	FindInBox(minLat, minLong, maxLat, maxLong float64, afterId uint64, limit int) ([]Cache, error)
+	FindInPolygons(polygons []geostore.Polygon, afterId uint64, limit int) ([]Cache, error)
+	FindInGeohash(geohash string, afterId uint64, limit int) ([]Cache, error)
+	GetAll(afterId uint64, limit int) ([]Cache, error)
 	GetById(id uint64) (Cache, error)
 	GetByName(name string) (Cache, error)
+	// GetByTags returns the caches with any of the tags, in ascending id order.
 	GetByTags(tags []string) ([]Cache, error)
+	// FindByTagQuery returns the caches that match the tag query, in ascending id order.
+	FindByTagQuery(query *TagQuery) ([]Cache, error)
 	Delete(id uint64) error
+	// DeleteByName deletes the cache with the name, or returns a CacheNotFoundErr if there is not
+	// one.  The cache is looked up and deleted atomically, so a cache created with the same name
+	// after it was deleted by another request is never deleted in its place.
+	DeleteByName(name string) error
 	DeleteAll() error
 	Update(name string, cache Cache) (Cache, error)
+	AddTag(name, tag string) (Cache, error)
+	RemoveTag(name, tag string) (Cache, error)
+	// ListTags returns up to limit of the tags that start with the prefix, and the number of caches
+	// with each of them, in the provided order.  If after is not the zero value, only the tags that
+	// are after it in that order are returned, so that the tags can be paged through.  A limit of 0
+	// is unbounded.
+	ListTags(prefix string, order TagSort, after TagCount, limit int) ([]TagCount, error)
+	// Search returns the caches that match every filter of the query, and the index that its
+	// planner chose to find the candidates for them.
+	Search(query SearchQuery) (SearchResult, error)
+	// SearchNames returns up to limit of the caches whose names match the tokens of the query,
+	// exactly, as a prefix or within a few typos, ranked by relevance.  Names are tokenized
+	// ignoring case and accents.  A limit of 0 is unbounded.
+	SearchNames(query string, limit int) ([]RankedCache, error)
 	Shutdown() error
 }
 
 type InMemCacheStore struct {
-	caches       map[uint64]*Cache
+	caches map[uint64]*Cache
+	// ids is every key in caches in ascending order, so that we can page through all of the caches
+	// in a stable order.  Because ids are auto-incrementing, new ids are always appended.
+	ids          []uint64
 	sCounter     uint64
 	cachesByName map[string]*Cache
 	cachesByTag  map[string]map[*Cache]bool
-	geostore     geostore.GeoStore
-	sMux         *sync.RWMutex
+	// tags is every key in cachesByTag in ascending order, so that we can find the tags with a
+	// prefix and page through them without sorting them for every request.
+	tags []string
+	// tagsByCount is every key in cachesByTag with its count, in TagSortCount order, so that a page
+	// of the tags by count is a seek rather than a sort.
+	tagsByCount []TagCount
+	names       *nameIndex
+	geostore    geostore.GeoStore
+	sMux        *sync.RWMutex
+
+	// wal is nil unless the store is durable, see NewDurableCacheStore.
+	wal         *wal
+	dataDir     string
+	snapshotLsn uint64
+	snapshotMux *sync.Mutex
 }
 
 func NewCacheStore(
@@ -55,13 +191,19 @@ func NewCacheStore(
 	wg *sync.WaitGroup,
 	geoStore geostore.GeoStore,
 ) CacheStore {
+	return newInMemCacheStore(geoStore)
+}
+
+func newInMemCacheStore(geoStore geostore.GeoStore) *InMemCacheStore {
 	return &InMemCacheStore{
 		caches:       make(map[uint64]*Cache),
 		sCounter:     1,
 		cachesByName: make(map[string]*Cache),
 		cachesByTag:  make(map[string]map[*Cache]bool),
+		names:        newNameIndex(),
 		geostore:     geoStore,
 		sMux:         &sync.RWMutex{},
+		snapshotMux:  &sync.Mutex{},
 	}
 }
 
@@ -71,43 +213,211 @@ func (s *InMemCacheStore) Create(
 	long float64,
 	tags []string,
 ) (uint64, error) {
-	// Convert the slice of tags provided for the new element into a map that we will store.
-	t := make(map[string]bool, len(tags))
-	for _, tag := range tags {
-		t[tag] = true
+	if err := ValidateLocation(lat, long); err != nil {
+		return 0, err
 	}
 
 	s.sMux.Lock()
 	defer s.sMux.Unlock()
 
+	if existingCache, ok := s.cachesByName[name]; ok {
+		return 0, newNameConflictErr(existingCache.Id, name)
+	}
+	return s.create(name, lat, long, newTagSet(tags))
+}
+
+// CreateMany creates each of the caches as Create would, and then adds all of them to the GeoStore
+// with a single InsertMany.  If logging one of them fails, it and the caches after it are not
+// created, and their results are the error.
+func (s *InMemCacheStore) CreateMany(caches []NewCache) ([]CreateResult, error) {
+	s.sMux.Lock()
+	defer s.sMux.Unlock()
+
+	retval := make([]CreateResult, len(caches))
+	nodes := make([]*geostore.Node, 0, len(caches))
+	// Every cache that was logged is added to the GeoStore, even if logging a later one fails.
+	defer func() { s.geostore.InsertMany(nodes) }()
+	for i, c := range caches {
+		if err := ValidateLocation(c.Lat, c.Long); err != nil {
+			retval[i].Err = err
+			continue
+		}
+		if existingCache, ok := s.cachesByName[c.Name]; ok {
+			retval[i].Err = newNameConflictErr(existingCache.Id, c.Name)
+			continue
+		}
+		cache := &Cache{
+			Id:   s.sCounter,
+			Name: c.Name,
+			Lat:  c.Lat,
+			Long: c.Long,
+			Tags: newTagSet(c.Tags),
+		}
+		if err := s.log(walRecord{Op: walOpCreate, Cache: cache}); err != nil {
+			for j := i; j < len(caches); j++ {
+				retval[j].Err = err
+			}
+			return retval, err
+		}
+		s.indexCache(cache)
+		nodes = append(nodes, geostore.NewNode(cache.Long, cache.Lat, cache.Id))
+		s.sCounter++
+		retval[i].Id = cache.Id
+	}
+	return retval, nil
+}
+
+// Upsert updates the location and tags of the cache with the provided name, or creates it if there
+// is not one.  It returns the id of the cache and whether it was created.
+func (s *InMemCacheStore) Upsert(
+	name string,
+	lat float64,
+	long float64,
+	tags []string,
+) (uint64, bool, error) {
+	if err := ValidateLocation(lat, long); err != nil {
+		return 0, false, err
+	}
+
+	s.sMux.Lock()
+	defer s.sMux.Unlock()
+
+	if existingCache, ok := s.cachesByName[name]; ok {
+		cache := Cache{Lat: lat, Long: long, Tags: newTagSet(tags)}
+		if _, err := s.update(existingCache, cache); err != nil {
+			return 0, false, err
+		}
+		return existingCache.Id, false, nil
+	}
+	id, err := s.create(name, lat, long, newTagSet(tags))
+	return id, err == nil, err
+}
+
+// newTagSet converts the slice of tags provided for a cache into the set of them that we store.
+func newTagSet(tags []string) map[string]bool {
+	retval := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		retval[tag] = true
+	}
+	return retval
+}
+
+// create creates a new cache with the next id.  The caller must hold the write lock, and must have
+// checked that there is not already a cache with the same name.
+func (s *InMemCacheStore) create(
+	name string,
+	lat float64,
+	long float64,
+	tags map[string]bool,
+) (uint64, error) {
 	cache := &Cache{
 		Id:   s.sCounter,
 		Name: name,
 		Lat:  lat,
 		Long: long,
-		Tags: t,
+		Tags: tags,
 	}
-	s.caches[cache.Id] = cache
-	s.cachesByName[cache.Name] = cache
-	id := cache.Id
+	if err := s.log(walRecord{Op: walOpCreate, Cache: cache}); err != nil {
+		return 0, err
+	}
+	s.insertCache(cache)
 	// Bump our 'auto-incrementing int id value.
 	s.sCounter++
 
-	var ok bool
-	var tMap map[*Cache]bool
-	for _, t := range tags {
-		tMap, ok = s.cachesByTag[t]
+	return cache.Id, nil
+}
+
+// insertCache adds the cache to all of our maps and indices, and then to the GeoStore.  The caller
+// must hold the write lock.
+func (s *InMemCacheStore) insertCache(cache *Cache) {
+	s.indexCache(cache)
+	s.geostore.Insert(geostore.NewNode(cache.Long, cache.Lat, cache.Id))
+}
+
+// indexCache adds the cache to all of our maps and indices, but not to the GeoStore, so that
+// callers loading many caches at once can add them all to the GeoStore with a single InsertMany.
+// The caller must hold the write lock.
+func (s *InMemCacheStore) indexCache(cache *Cache) {
+	s.caches[cache.Id] = cache
+	// Ids are almost always inserted in ascending order, so we only need to search for where to
+	// insert it if it is not greater than the last id.
+	if len(s.ids) == 0 || cache.Id > s.ids[len(s.ids)-1] {
+		s.ids = append(s.ids, cache.Id)
+	} else {
+		i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= cache.Id })
+		s.ids = append(s.ids[:i], append([]uint64{cache.Id}, s.ids[i:]...)...)
+	}
+	s.cachesByName[cache.Name] = cache
+	s.names.add(cache.Id, cache.Name)
+	s.indexTags(cache)
+}
+
+// indexTags adds the cache to the index of each of its tags.  The caller must hold the write lock.
+func (s *InMemCacheStore) indexTags(cache *Cache) {
+	for t := range cache.Tags {
+		tMap, ok := s.cachesByTag[t]
 		if !ok {
 			tMap = make(map[*Cache]bool)
 			s.cachesByTag[t] = tMap
+			i := sort.SearchStrings(s.tags, t)
+			s.tags = append(s.tags[:i], append([]string{t}, s.tags[i:]...)...)
 		}
+		count := len(tMap)
 		tMap[cache] = true
+		s.moveTagCount(t, count, len(tMap))
 	}
+}
 
-	node := geostore.NewNode(cache.Long, cache.Lat, cache.Id)
-	s.geostore.Insert(node)
+// unindexTags removes the cache from the index of each of its tags, and removes the index of any
+// tag that no longer has any caches.  The caller must hold the write lock.
+func (s *InMemCacheStore) unindexTags(cache *Cache) {
+	for tag := range cache.Tags {
+		tMap, ok := s.cachesByTag[tag]
+		if !ok {
+			continue
+		}
+		count := len(tMap)
+		delete(tMap, cache)
+		s.moveTagCount(tag, count, len(tMap))
+		if len(tMap) == 0 {
+			delete(s.cachesByTag, tag)
+			i := sort.SearchStrings(s.tags, tag)
+			s.tags = append(s.tags[:i], s.tags[i+1:]...)
+		}
+	}
+}
 
-	return id, nil
+// moveTagCount moves the tag in tagsByCount from where it is with its old count to where it
+// belongs with its new count.  A tag with a count of 0 is not in tagsByCount.  The caller must
+// hold the write lock.
+func (s *InMemCacheStore) moveTagCount(tag string, oldCount, newCount int) {
+	if oldCount == newCount {
+		return
+	}
+	if oldCount > 0 {
+		i := s.searchTagsByCount(TagCount{Tag: tag, Count: oldCount})
+		s.tagsByCount = append(s.tagsByCount[:i], s.tagsByCount[i+1:]...)
+	}
+	if newCount > 0 {
+		tagCount := TagCount{Tag: tag, Count: newCount}
+		i := s.searchTagsByCount(tagCount)
+		s.tagsByCount = append(s.tagsByCount[:i],
+			append([]TagCount{tagCount}, s.tagsByCount[i:]...)...)
+	}
+}
+
+// searchTagsByCount returns the index of the first of tagsByCount that is not before the tag count.
+func (s *InMemCacheStore) searchTagsByCount(tagCount TagCount) int {
+	return sort.Search(len(s.tagsByCount), func(i int) bool {
+		return !TagSortCount.before(s.tagsByCount[i], tagCount)
+	})
+}
+
+func (s *InMemCacheStore) log(r walRecord) error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.append(r)
 }
 
 func copyCache(cache *Cache) Cache {
@@ -120,33 +430,106 @@ func copyCache(cache *Cache) Cache {
 	}
 }
 
+// FindNearest returns the caches nearest to the provided lat/long, ordered by ascending distance.
+// The maxDistance is in meters.
 func (s *InMemCacheStore) FindNearest(
 	lat, long, maxDistance float64,
 	limit int,
-) ([]Cache, error) {
+) ([]NearbyCache, error) {
 	s.sMux.RLock()
 	defer s.sMux.RUnlock()
 
-	ids := s.geostore.FindNearest(lat, long, maxDistance, limit)
-	// Now that we have the ids from the GeoStore, get the details for the specific caches and
-	// return them to the caller.
+	neighbors := s.geostore.FindNearest(lat, long, maxDistance, limit)
+	// Now that we have the ids and distances from the GeoStore, get the details for the specific
+	// caches and return them to the caller.
 	// TODO: need to add some error checking here to ensure that the datastore is not in some
 	// inconsistent state. If it is, there is a bug and this situation should never happen.
-	retval := make([]Cache, len(ids))
-	for i := 0; i < len(ids); i++ {
-		s := s.caches[ids[i]]
-		retval[i] = copyCache(s)
+	retval := make([]NearbyCache, len(neighbors))
+	for i, n := range neighbors {
+		cache := s.caches[n.Id]
+		retval[i] = NearbyCache{
+			Cache:    copyCache(cache),
+			Distance: n.Distance,
+			Bearing:  geostore.InitialBearing(lat, long, cache.Lat, cache.Long),
+		}
 	}
 
 	return retval, nil
 }
 
-func (s *InMemCacheStore) GetAll() ([]Cache, error) {
+// FindInBox returns the caches within the bounding box, in ascending id order, that have an id
+// greater than afterId.  A limit <= 0 does not bound the number of caches returned.
+func (s *InMemCacheStore) FindInBox(
+	minLat, minLong, maxLat, maxLong float64,
+	afterId uint64,
+	limit int,
+) ([]Cache, error) {
+	s.sMux.RLock()
+	defer s.sMux.RUnlock()
+
+	ids := s.geostore.FindInBox(minLat, minLong, maxLat, maxLong)
+	return s.copyCaches(pageIds(ids, afterId, limit)), nil
+}
+
+// FindInPolygons returns the caches within any of the polygons, in ascending id order, that have
+// an id greater than afterId.  A limit <= 0 does not bound the number of caches returned.
+func (s *InMemCacheStore) FindInPolygons(
+	polygons []geostore.Polygon,
+	afterId uint64,
+	limit int,
+) ([]Cache, error) {
+	s.sMux.RLock()
+	defer s.sMux.RUnlock()
+
+	ids := s.geostore.FindInPolygons(polygons)
+	return s.copyCaches(pageIds(ids, afterId, limit)), nil
+}
+
+// FindInGeohash returns the caches within the cell of the geohash, in ascending id order, that have
+// an id greater than afterId.  A limit <= 0 does not bound the number of caches returned.
+func (s *InMemCacheStore) FindInGeohash(
+	geohash string,
+	afterId uint64,
+	limit int,
+) ([]Cache, error) {
 	s.sMux.RLock()
 	defer s.sMux.RUnlock()
 
-	// TODO:
-	return nil, nil
+	ids, err := s.geostore.FindInGeohash(geohash)
+	if err != nil {
+		return nil, err
+	}
+	return s.copyCaches(pageIds(ids, afterId, limit)), nil
+}
+
+// GetAll returns the caches with an id greater than afterId, in ascending id order.  A limit <= 0
+// does not bound the number of caches returned.
+func (s *InMemCacheStore) GetAll(afterId uint64, limit int) ([]Cache, error) {
+	s.sMux.RLock()
+	defer s.sMux.RUnlock()
+
+	return s.copyCaches(pageIds(s.ids, afterId, limit)), nil
+}
+
+// pageIds returns the ids, from a slice of ids in ascending order, that are greater than afterId.
+// A limit <= 0 does not bound the number of ids returned.
+func pageIds(ids []uint64, afterId uint64, limit int) []uint64 {
+	start := sort.Search(len(ids), func(i int) bool { return ids[i] > afterId })
+	ids = ids[start:]
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// copyCaches returns a copy of each of the caches with the provided ids.  The caller must hold at
+// least the read lock.
+func (s *InMemCacheStore) copyCaches(ids []uint64) []Cache {
+	retval := make([]Cache, len(ids))
+	for i, id := range ids {
+		retval[i] = copyCache(s.caches[id])
+	}
+	return retval
 }
 
 func (s *InMemCacheStore) GetById(id uint64) (Cache, error) {
@@ -173,53 +556,391 @@ func (s *InMemCacheStore) GetByName(name string) (Cache, error) {
 	return retval, nil
 }
 
+// GetByTags returns the caches with any of the provided tags, in ascending id order.
 func (s *InMemCacheStore) GetByTags(tags []string) ([]Cache, error) {
+	return s.FindByTagQuery(AnyTag(tags...))
+}
+
+// SearchNames returns the caches whose names match the query, ranked by relevance.  The tokens of
+// the names are kept in an inverted index, so only the tokens of the names, and not the caches,
+// are visited to find those that match.
+func (s *InMemCacheStore) SearchNames(query string, limit int) ([]RankedCache, error) {
 	s.sMux.RLock()
 	defer s.sMux.RUnlock()
 
-	var caches []Cache
-	for _, tag := range tags {
-		m, ok := s.cachesByTag[tag]
-		if !ok {
-			// There aren't any Caches with the provided tag
+	return rankNames(query, limit,
+		func(queryToken string, minLength, maxLength int) ([]string, error) {
+			return s.names.candidates(queryToken, minLength, maxLength), nil
+		},
+		func(tokens []string) (map[string][]uint64, error) {
+			retval := make(map[string][]uint64, len(tokens))
+			for _, token := range tokens {
+				for id := range s.names.postings[token] {
+					retval[token] = append(retval[token], id)
+				}
+			}
+			return retval, nil
+		},
+		func(ids []uint64) ([]Cache, error) { return s.copyCaches(ids), nil },
+	)
+}
+
+// FindByTagQuery returns the caches that match the tag query, in ascending id order.  It is
+// evaluated with set operations on the sets of caches with each tag, so only a NOT at the top of
+// the query visits every cache.
+func (s *InMemCacheStore) FindByTagQuery(query *TagQuery) ([]Cache, error) {
+	s.sMux.RLock()
+	defer s.sMux.RUnlock()
+
+	return s.copyCaches(s.findByTagQuery(query)), nil
+}
+
+// findByTagQuery returns the ids of the caches that match the tag query, in ascending id order.
+// The caller must hold at least the read lock.
+func (s *InMemCacheStore) findByTagQuery(query *TagQuery) []uint64 {
+	set, negated := evalTagQuery(query, func(tag string) map[*Cache]bool {
+		return s.cachesByTag[tag]
+	})
+	var ids []uint64
+	if negated {
+		for _, id := range s.ids {
+			if !set[s.caches[id]] {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	for cache := range set {
+		ids = append(ids, cache.Id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Search returns the caches that match every filter of the query.  Its planner finds the
+// candidates with whichever of the GeoStore, the sets of caches with each tag, the index of the
+// tokens of the names or a scan of every cache is estimated to find the fewest of them.  The
+// number of caches that match the tags, or that have a token that contains a word of the name, is
+// exact, but the GeoStore cannot count the caches within a geometry without finding them, so it is
+// estimated from the fraction of the surface of the Earth that the geometry covers.
+func (s *InMemCacheStore) Search(query SearchQuery) (SearchResult, error) {
+	s.sMux.RLock()
+	defer s.sMux.RUnlock()
+
+	var indexes []searchIndex
+	if query.hasGeometry() {
+		indexes = append(indexes, searchIndex{
+			name:     SearchIndexGeometry,
+			estimate: int(math.Ceil(query.geometryFraction() * float64(len(s.ids)))),
+			find: func() ([]Cache, error) {
+				switch {
+				case query.Near != nil:
+					neighbors := s.geostore.FindNearest(
+						query.Near.Lat, query.Near.Long, query.Near.MaxDistance, 0)
+					ids := make([]uint64, len(neighbors))
+					for i, n := range neighbors {
+						ids[i] = n.Id
+					}
+					return s.copyCaches(ids), nil
+				case query.Box != nil:
+					b := query.Box
+					ids := s.geostore.FindInBox(b.MinLat, b.MinLong, b.MaxLat, b.MaxLong)
+					return s.copyCaches(ids), nil
+				}
+				return s.copyCaches(s.geostore.FindInPolygons(query.Polygons)), nil
+			},
+		})
+	}
+	if query.Tags != nil {
+		ids := s.findByTagQuery(query.Tags)
+		indexes = append(indexes, searchIndex{
+			name:     SearchIndexTags,
+			estimate: len(ids),
+			find:     func() ([]Cache, error) { return s.copyCaches(ids), nil },
+		})
+	}
+	if word := query.nameWord(); word != "" {
+		ids := s.names.containing(word)
+		indexes = append(indexes, searchIndex{
+			name:     SearchIndexName,
+			estimate: len(ids),
+			find:     func() ([]Cache, error) { return s.copyCaches(ids), nil },
+		})
+	}
+	indexes = append(indexes, searchIndex{
+		name:     SearchIndexScan,
+		estimate: len(s.ids),
+		find:     func() ([]Cache, error) { return s.copyCaches(s.ids), nil },
+	})
+	return runSearch(query, indexes, s.geostore.Distance)
+}
+
+// ListTags returns the tags that start with the prefix, with their counts, in the provided order.
+// The counts are the sizes of the sets of caches with each tag, and the tags with the prefix are a
+// range of the sorted tags, so only the tags with the prefix are visited.  Without a prefix, the
+// tags are already in count order in tagsByCount, and so a page of them by count is a seek.
+func (s *InMemCacheStore) ListTags(
+	prefix string,
+	order TagSort,
+	after TagCount,
+	limit int,
+) ([]TagCount, error) {
+	s.sMux.RLock()
+	defer s.sMux.RUnlock()
+
+	retval := []TagCount{}
+	if order == TagSortCount && prefix == "" {
+		// A page starts right after the 'after' tag.
+		start := 0
+		if after != (TagCount{}) {
+			start = sort.Search(len(s.tagsByCount), func(i int) bool {
+				return order.before(after, s.tagsByCount[i])
+			})
+		}
+		page := s.tagsByCount[start:]
+		if limit > 0 && len(page) > limit {
+			page = page[:limit]
+		}
+		return append(retval, page...), nil
+	}
+
+	start := sort.SearchStrings(s.tags, prefix)
+	if order == TagSortName && after.Tag > prefix {
+		start = sort.Search(len(s.tags), func(i int) bool { return s.tags[i] > after.Tag })
+	}
+	for _, tag := range s.tags[start:] {
+		if !strings.HasPrefix(tag, prefix) {
+			break
+		}
+		tagCount := TagCount{Tag: tag, Count: len(s.cachesByTag[tag])}
+		if after != (TagCount{}) && !order.before(after, tagCount) {
 			continue
 		}
-		for cache := range m {
-			caches = append(caches, copyCache(cache))
+		retval = append(retval, tagCount)
+		if order == TagSortName && limit > 0 && len(retval) == limit {
+			break
+		}
+	}
+	if order == TagSortCount {
+		sort.Slice(retval, func(i, j int) bool { return order.before(retval[i], retval[j]) })
+		if limit > 0 && len(retval) > limit {
+			retval = retval[:limit]
 		}
 	}
-	return caches, nil
+	return retval, nil
 }
 
 func (s *InMemCacheStore) Delete(id uint64) error {
-	return nil
+	s.sMux.Lock()
+	defer s.sMux.Unlock()
+
+	cache, ok := s.caches[id]
+	if !ok {
+		return &CacheNotFoundErr{id: id}
+	}
+	if err := s.log(walRecord{Op: walOpDelete, Id: id}); err != nil {
+		return err
+	}
+	return s.deleteCache(cache)
+}
+
+func (s *InMemCacheStore) DeleteByName(name string) error {
+	s.sMux.Lock()
+	defer s.sMux.Unlock()
+
+	cache, ok := s.cachesByName[name]
+	if !ok {
+		return &CacheNotFoundErr{name: name}
+	}
+	if err := s.log(walRecord{Op: walOpDelete, Id: cache.Id}); err != nil {
+		return err
+	}
+	return s.deleteCache(cache)
 }
 
 func (s *InMemCacheStore) DeleteAll() error {
+	s.sMux.Lock()
+	defer s.sMux.Unlock()
+
+	if err := s.log(walRecord{Op: walOpDeleteAll}); err != nil {
+		return err
+	}
+	return s.deleteAll()
+}
+
+// deleteAll deletes all of the caches.  The caller must hold the write lock.
+func (s *InMemCacheStore) deleteAll() error {
+	// Delete the caches in descending id order so that each id is removed from the end of ids.
+	for i := len(s.ids) - 1; i >= 0; i-- {
+		if err := s.deleteCache(s.caches[s.ids[i]]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// deleteCache removes the cache from the GeoStore, and then from all of our maps and indices.  The
+// caller must hold the write lock.
+func (s *InMemCacheStore) deleteCache(cache *Cache) error {
+	// A cache that was never added to the GeoStore, because its coordinates were out of bounds,
+	// can still be deleted.
+	var notFoundErr *geostore.NodeNotFoundErr
+	if err := s.geostore.Remove(cache.Id); err != nil && !errors.As(err, &notFoundErr) {
+		return err
+	}
+
+	delete(s.caches, cache.Id)
+	s.removeId(cache.Id)
+	if s.cachesByName[cache.Name] == cache {
+		delete(s.cachesByName, cache.Name)
+	}
+	s.names.remove(cache.Id, cache.Name)
+	s.unindexTags(cache)
+	return nil
+}
+
+// CheckConsistency checks for orphaned caches: caches that were created with the same name as an
+// existing cache before names were required to be unique, and so replaced it in the name index
+// but not in the others.  If repair is true the orphans are deleted.
+func (s *InMemCacheStore) CheckConsistency(repair bool) (ConsistencyReport, error) {
+	s.sMux.Lock()
+	defer s.sMux.Unlock()
+
+	retval := ConsistencyReport{Orphans: []Cache{}}
+	var orphans []*Cache
+	for _, id := range s.ids {
+		cache := s.caches[id]
+		if s.cachesByName[cache.Name] != cache {
+			orphans = append(orphans, cache)
+			retval.Orphans = append(retval.Orphans, copyCache(cache))
+		}
+	}
+	if !repair {
+		return retval, nil
+	}
+
+	for _, cache := range orphans {
+		if err := s.log(walRecord{Op: walOpDelete, Id: cache.Id}); err != nil {
+			return retval, err
+		}
+		if err := s.deleteCache(cache); err != nil {
+			return retval, err
+		}
+	}
+	retval.Repaired = true
+	return retval, nil
+}
+
+// removeId removes the id from our ordered slice of ids.  The caller must hold the write lock.
+func (s *InMemCacheStore) removeId(id uint64) {
+	i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+	if i < len(s.ids) && s.ids[i] == id {
+		s.ids = append(s.ids[:i], s.ids[i+1:]...)
+	}
+}
+
 func (s *InMemCacheStore) Update(name string, cache Cache) (Cache, error) {
+	if err := ValidateLocation(cache.Lat, cache.Long); err != nil {
+		return Cache{}, err
+	}
 	s.sMux.Lock()
 	defer s.sMux.Unlock()
 
 	existingCache, ok := s.cachesByName[name]
 	if !ok {
-		return Cache{}, fmt.Errorf("cache not found to update; name=%s", name)
+		return Cache{}, &CacheNotFoundErr{name: name}
+	}
+	return s.update(existingCache, cache)
+}
+
+// update updates the location and tags of the existing cache, and returns a copy of it.  The
+// caller must hold the write lock.
+func (s *InMemCacheStore) update(existingCache *Cache, cache Cache) (Cache, error) {
+	// Move the cache in the GeoStore first, so that if it is rejected we have not yet modified the
+	// cache.  Because we hold the write lock for the duration, readers will never see the GeoStore
+	// and the cache disagree on the location of the cache.
+	moved := cache.Lat != existingCache.Lat || cache.Long != existingCache.Long
+	if moved {
+		if err := s.geostore.Move(existingCache.Id, cache.Lat, cache.Long); err != nil {
+			return Cache{}, err
+		}
 	}
 
-	// Since we have a pointer to the cache we can just update the values of the pointer and then
-	// return a copy of the Cache to the caller and unlock the mutex.
+	updatedCache := Cache{
+		Id:   existingCache.Id,
+		Name: existingCache.Name,
+		Lat:  cache.Lat,
+		Long: cache.Long,
+		Tags: cache.Tags,
+	}
+	if err := s.log(walRecord{Op: walOpUpdate, Cache: &updatedCache}); err != nil {
+		if moved {
+			// Move it back to where it was, which is always within bounds.
+			s.geostore.Move(existingCache.Id, existingCache.Lat, existingCache.Long)
+		}
+		return Cache{}, err
+	}
+	s.updateCache(existingCache, updatedCache)
+
+	retval := copyCache(existingCache)
+	return retval, nil
+}
+
+// updateCache updates the values of the existing cache, which has already been moved in the
+// GeoStore, and re-indexes its tags.  The caller must hold the write lock.
+func (s *InMemCacheStore) updateCache(existingCache *Cache, cache Cache) {
+	// Since we have a pointer to the cache we can just update the values of the pointer.
 	existingCache.Lat = cache.Lat
 	existingCache.Long = cache.Long
+	s.unindexTags(existingCache)
+	// The tags are replaced rather than modified, because copies of the cache share the map.
 	existingCache.Tags = cache.Tags
+	s.indexTags(existingCache)
+}
+
+// AddTag adds the tag to the cache with the provided name, and returns the updated cache.  Adding
+// a tag that the cache already has does not change it.
+func (s *InMemCacheStore) AddTag(name, tag string) (Cache, error) {
+	return s.updateTags(name, func(tags map[string]bool) { tags[tag] = true })
+}
 
-	// TODO: Update the record in the GeoStore
+// RemoveTag removes the tag from the cache with the provided name, and returns the updated cache.
+// Removing a tag that the cache does not have does not change it.
+func (s *InMemCacheStore) RemoveTag(name, tag string) (Cache, error) {
+	return s.updateTags(name, func(tags map[string]bool) { delete(tags, tag) })
+}
 
-	retval := copyCache(existingCache)
-	return retval, nil
+// updateTags applies the modification to a copy of the tags of the cache with the provided name,
+// and then updates the cache with them.
+func (s *InMemCacheStore) updateTags(name string, modify func(map[string]bool)) (Cache, error) {
+	s.sMux.Lock()
+	defer s.sMux.Unlock()
+
+	existingCache, ok := s.cachesByName[name]
+	if !ok {
+		return Cache{}, &CacheNotFoundErr{name: name}
+	}
+	tags := make(map[string]bool, len(existingCache.Tags)+1)
+	for tag := range existingCache.Tags {
+		tags[tag] = true
+	}
+	modify(tags)
+	return s.update(existingCache, Cache{
+		Lat:  existingCache.Lat,
+		Long: existingCache.Long,
+		Tags: tags,
+	})
 }
 
+// Shutdown writes a final snapshot and closes the write-ahead log, if the store is durable.  The
+// caller must ensure that nothing else is written to the store after it is called.
 func (s *InMemCacheStore) Shutdown() error {
-	return nil
+	if s.wal == nil {
+		return nil
+	}
+	// A final snapshot means that we do not need to replay the log on the next start.
+	if err := s.writeSnapshot(); err != nil {
+		log.Errorf("unable to write snapshot on shutdown; err=%s", err)
+	}
+	return s.wal.close()
 }
@@ -0,0 +1,1055 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	// Registers the pure Go "sqlite" database/sql driver.
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	// sqliteInitialRadius is the radius, in meters, of the first search for the nearest caches.  It
+	// is multiplied by sqliteRadiusGrowth until the search finds enough caches.
+	sqliteInitialRadius = 10000
+	sqliteRadiusGrowth  = 4
+)
+
+// sqliteMigrations are the migrations that build the schema, in order.  The version of a database
+// is the number of migrations that have been applied to it, which is stored in its user_version.
+// Never modify a migration once it has been released, only append new ones.
+var sqliteMigrations = []string{
+	`CREATE TABLE caches (
+		id   INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		lat  REAL NOT NULL,
+		long REAL NOT NULL
+	);
+	CREATE INDEX caches_name ON caches (name);
+	CREATE TABLE cache_tags (
+		cache_id INTEGER NOT NULL REFERENCES caches (id) ON DELETE CASCADE,
+		tag      TEXT NOT NULL,
+		PRIMARY KEY (cache_id, tag)
+	);
+	CREATE INDEX cache_tags_tag ON cache_tags (tag);
+	CREATE VIRTUAL TABLE cache_locations USING rtree (id, min_lat, max_lat, min_long, max_long);`,
+	// The caches that were created with the same name as an existing cache, before names were
+	// unique, are marked as orphaned so that they can still be reported and repaired.
+	`ALTER TABLE caches ADD COLUMN orphaned INTEGER NOT NULL DEFAULT 0;
+	UPDATE caches SET orphaned = 1 WHERE id NOT IN (SELECT MAX(id) FROM caches GROUP BY name);
+	CREATE UNIQUE INDEX caches_unique_name ON caches (name) WHERE NOT orphaned;`,
+	// The number of caches with each tag is kept up to date by the triggers, in the same
+	// transaction as the change to cache_tags.
+	`CREATE TABLE tag_counts (
+		tag   TEXT PRIMARY KEY,
+		count INTEGER NOT NULL
+	) WITHOUT ROWID;
+	CREATE INDEX tag_counts_count ON tag_counts (count DESC, tag);
+	INSERT INTO tag_counts (tag, count) SELECT tag, COUNT(*) FROM cache_tags GROUP BY tag;
+	CREATE TRIGGER cache_tags_insert AFTER INSERT ON cache_tags BEGIN
+		INSERT INTO tag_counts (tag, count) VALUES (new.tag, 1)
+			ON CONFLICT (tag) DO UPDATE SET count = count + 1;
+	END;
+	CREATE TRIGGER cache_tags_delete AFTER DELETE ON cache_tags BEGIN
+		UPDATE tag_counts SET count = count - 1 WHERE tag = old.tag;
+		DELETE FROM tag_counts WHERE tag = old.tag AND count = 0;
+	END;`,
+	// name_tokens is each of the distinct tokens in cache_name_tokens with its length in
+	// characters, and the number of caches with it, which is kept up to date by the triggers.
+	`CREATE TABLE cache_name_tokens (
+		cache_id INTEGER NOT NULL REFERENCES caches (id) ON DELETE CASCADE,
+		token    TEXT NOT NULL,
+		PRIMARY KEY (cache_id, token)
+	);
+	CREATE INDEX cache_name_tokens_token ON cache_name_tokens (token);
+	CREATE TABLE name_tokens (
+		token  TEXT PRIMARY KEY,
+		length INTEGER NOT NULL,
+		count  INTEGER NOT NULL
+	) WITHOUT ROWID;
+	CREATE INDEX name_tokens_length ON name_tokens (length);
+	CREATE TRIGGER cache_name_tokens_insert AFTER INSERT ON cache_name_tokens BEGIN
+		INSERT INTO name_tokens (token, length, count) VALUES (new.token, length(new.token), 1)
+			ON CONFLICT (token) DO UPDATE SET count = count + 1;
+	END;
+	CREATE TRIGGER cache_name_tokens_delete AFTER DELETE ON cache_name_tokens BEGIN
+		UPDATE name_tokens SET count = count - 1 WHERE token = old.token;
+		DELETE FROM name_tokens WHERE token = old.token AND count = 0;
+	END;`,
+}
+
+// sqliteMigrationHooks are run, within the same transaction, after the migration at the same index
+// in sqliteMigrations, for the parts of a migration that cannot be written in SQL.
+var sqliteMigrationHooks = map[int]func(tx *sql.Tx) error{
+	3: indexAllNameTokens,
+}
+
+// selectCaches selects each cache with a JSON array of its tags.  It must be followed by a WHERE
+// clause and then GROUP BY c.id.
+const selectCaches = `SELECT c.id, c.name, c.lat, c.long,
+		json_group_array(t.tag) FILTER (WHERE t.tag IS NOT NULL)
+	FROM caches c LEFT JOIN cache_tags t ON t.cache_id = c.id`
+
+// SqliteCacheStore is a CacheStore that stores the caches in a SQLite database, with their tags in
+// a join table and their locations in an R*Tree for spatial queries.
+type SqliteCacheStore struct {
+	db       *sql.DB
+	distance geostore.DistanceFunc
+}
+
+// NewSqliteCacheStore opens, creating it if need be, the SQLite database at path and migrates it to
+// the latest version of the schema.
+func NewSqliteCacheStore(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	wg *sync.WaitGroup,
+	path string,
+	distance geostore.DistanceFunc,
+) (CacheStore, error) {
+	dsn := "file:" + path +
+		"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows a single writer at a time, so rather than having connections contend for
+	// the lock we serialize all access through a single connection.
+	db.SetMaxOpenConns(1)
+	if err := migrateSqlite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SqliteCacheStore{db: db, distance: distance}, nil
+}
+
+// migrateSqlite applies each of the migrations that have not yet been applied to the database, each
+// in its own transaction.
+func migrateSqlite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema is newer than this version of the server; "+
+			"version=%d, latest=%d", version, len(sqliteMigrations))
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		err := withTx(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+				return err
+			}
+			if hook, ok := sqliteMigrationHooks[version]; ok {
+				if err := hook(tx); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf(
+				"unable to migrate database schema; version=%d, err=%w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// withTx executes f within a transaction, which is committed if f returns nil and otherwise rolled
+// back.
+func withTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SqliteCacheStore) Create(
+	name string,
+	lat float64,
+	long float64,
+	tags []string,
+) (uint64, error) {
+	if err := ValidateLocation(lat, long); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := withTx(s.db, func(tx *sql.Tx) error {
+		existingId, err := findIdByName(tx, name)
+		if err != nil {
+			return err
+		}
+		if existingId != 0 {
+			return newNameConflictErr(uint64(existingId), name)
+		}
+		id, err = insertCache(tx, name, lat, long, tags)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(id), nil
+}
+
+// CreateMany creates each of the caches as Create would, in a single transaction, so that either
+// all of the caches that could be created are or, if the store fails, none of them are.
+func (s *SqliteCacheStore) CreateMany(caches []NewCache) ([]CreateResult, error) {
+	retval := make([]CreateResult, len(caches))
+	err := withTx(s.db, func(tx *sql.Tx) error {
+		for i, c := range caches {
+			if err := ValidateLocation(c.Lat, c.Long); err != nil {
+				retval[i].Err = err
+				continue
+			}
+			existingId, err := findIdByName(tx, c.Name)
+			if err != nil {
+				return err
+			}
+			if existingId != 0 {
+				retval[i].Err = newNameConflictErr(uint64(existingId), c.Name)
+				continue
+			}
+			id, err := insertCache(tx, c.Name, c.Lat, c.Long, c.Tags)
+			if err != nil {
+				return err
+			}
+			retval[i].Id = uint64(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return retval, nil
+}
+
+// Upsert updates the location and tags of the cache with the provided name, or creates it if there
+// is not one.  It returns the id of the cache and whether it was created.
+func (s *SqliteCacheStore) Upsert(
+	name string,
+	lat float64,
+	long float64,
+	tags []string,
+) (uint64, bool, error) {
+	if err := ValidateLocation(lat, long); err != nil {
+		return 0, false, err
+	}
+	var id int64
+	var created bool
+	err := withTx(s.db, func(tx *sql.Tx) error {
+		var err error
+		if id, err = findIdByName(tx, name); err != nil {
+			return err
+		}
+		if id != 0 {
+			return updateCache(tx, id, lat, long, tags)
+		}
+		created = true
+		id, err = insertCache(tx, name, lat, long, tags)
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return uint64(id), created, nil
+}
+
+// findIdByName returns the id of the cache with the provided name, or 0 if there is not one.  If
+// more than one cache has the same name, it returns the most recently created one.
+func findIdByName(tx *sql.Tx, name string) (int64, error) {
+	var id sql.NullInt64
+	err := tx.QueryRow(`SELECT MAX(id) FROM caches WHERE name = ?`, name).Scan(&id)
+	return id.Int64, err
+}
+
+// insertCache inserts a new cache and returns its id.
+func insertCache(tx *sql.Tx, name string, lat, long float64, tags []string) (int64, error) {
+	res, err := tx.Exec(`INSERT INTO caches (name, lat, long) VALUES (?, ?, ?)`, name, lat, long)
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		// Another connection to the database created a cache with the same name since we checked.
+		existingId, err := findIdByName(tx, name)
+		if err != nil {
+			return 0, err
+		}
+		return 0, newNameConflictErr(uint64(existingId), name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`INSERT INTO cache_locations VALUES (?, ?, ?, ?, ?)`, id, lat, lat, long, long)
+	if err != nil {
+		return 0, err
+	}
+	if err := insertNameTokens(tx, id, name); err != nil {
+		return 0, err
+	}
+	return id, insertTags(tx, id, tags)
+}
+
+// insertNameTokens adds the cache to the inverted index of the tokens of its name.
+func insertNameTokens(tx *sql.Tx, id int64, name string) error {
+	for _, token := range nameTokens(name) {
+		_, err := tx.Exec(
+			`INSERT OR IGNORE INTO cache_name_tokens (cache_id, token) VALUES (?, ?)`, id, token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexAllNameTokens adds every cache to the inverted index of the tokens of the names, for the
+// caches that were created before there was one.
+func indexAllNameTokens(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, name FROM caches`)
+	if err != nil {
+		return err
+	}
+	names := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		names[id] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, name := range names {
+		if err := insertNameTokens(tx, id, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateCache updates the location of the cache and replaces its tags.
+func updateCache(tx *sql.Tx, id int64, lat, long float64, tags []string) error {
+	_, err := tx.Exec(`UPDATE caches SET lat = ?, long = ? WHERE id = ?`, lat, long, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE cache_locations
+		SET min_lat = ?, max_lat = ?, min_long = ?, max_long = ? WHERE id = ?`,
+		lat, lat, long, long, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM cache_tags WHERE cache_id = ?`, id); err != nil {
+		return err
+	}
+	return insertTags(tx, id, tags)
+}
+
+func insertTags(tx *sql.Tx, id int64, tags []string) error {
+	for _, tag := range tags {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO cache_tags (cache_id, tag) VALUES (?, ?)`, id, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryCaches returns the caches that match the condition, in ascending id order, that have an id
+// greater than afterId.  A limit <= 0 does not bound the number of caches returned.
+func (s *SqliteCacheStore) queryCaches(
+	condition string,
+	afterId uint64,
+	limit int,
+	args ...any,
+) ([]Cache, error) {
+	if limit <= 0 {
+		// A negative LIMIT is unbounded in SQLite
+		limit = -1
+	}
+	query := selectCaches + " WHERE (" + condition + ") AND c.id > ? " +
+		"GROUP BY c.id ORDER BY c.id LIMIT ?"
+	rows, err := s.db.Query(query, append(args, afterId, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var retval []Cache
+	for rows.Next() {
+		var cache Cache
+		var tagsJSON string
+		err := rows.Scan(&cache.Id, &cache.Name, &cache.Lat, &cache.Long, &tagsJSON)
+		if err != nil {
+			return nil, err
+		}
+		var tags []string
+		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+			return nil, err
+		}
+		cache.Tags = make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			cache.Tags[tag] = true
+		}
+		retval = append(retval, cache)
+	}
+	return retval, rows.Err()
+}
+
+// boxCondition returns a condition, and its args, that matches the caches within the bounding box.
+// The R*Tree stores coordinates as 32 bit floats, rounded outwards, so we use it to find the
+// candidates and then compare their exact coordinates.  The box is split with geostore.SplitBox,
+// so that it wraps across the antimeridian and includes the poles in the same way as a GeoStore.
+func boxCondition(minLat, minLong, maxLat, maxLong float64) (string, []any) {
+	var conditions []string
+	var args []any
+	for _, b := range geostore.SplitBox(minLat, minLong, maxLat, maxLong) {
+		conditions = append(conditions, `(c.id IN (SELECT id FROM cache_locations
+			WHERE min_lat <= ? AND max_lat >= ? AND min_long <= ? AND max_long >= ?)
+		AND c.lat BETWEEN ? AND ? AND c.long BETWEEN ? AND ?)`)
+		args = append(args,
+			b.MaxLat, b.MinLat, b.MaxLong, b.MinLong, b.MinLat, b.MaxLat, b.MinLong, b.MaxLong)
+	}
+	return strings.Join(conditions, " OR "), args
+}
+
+// prefixCondition returns a condition, and its args, that matches the values of the column that
+// start with the prefix.  Text is compared byte by byte, so the values with the prefix are the
+// range from the prefix up to the prefix with its last byte incremented, which is a seek of an
+// index on the column.
+func prefixCondition(column, prefix string) (string, []any) {
+	if prefix == "" {
+		return "1", nil
+	}
+	upper := []byte(prefix)
+	for len(upper) > 0 && upper[len(upper)-1] == 0xff {
+		upper = upper[:len(upper)-1]
+	}
+	if len(upper) == 0 {
+		return column + " >= ?", []any{prefix}
+	}
+	upper[len(upper)-1]++
+	return column + " >= ? AND " + column + " < ?", []any{prefix, string(upper)}
+}
+
+// FindNearest returns the caches nearest to the provided lat/long, ordered by ascending distance.
+// The maxDistance is in meters.  The R*Tree cannot order by distance, so we search ever larger
+// bounding boxes until one contains enough caches within its radius.
+func (s *SqliteCacheStore) FindNearest(
+	lat, long, maxDistance float64,
+	limit int,
+) ([]NearbyCache, error) {
+	radius := float64(sqliteInitialRadius)
+	for {
+		if maxDistance > 0 && radius > maxDistance {
+			radius = maxDistance
+		}
+		// Beyond half of the circumference of the Earth the box would include every cache.
+		complete := radius >= math.Pi*geostore.EarthRadiusMeters ||
+			(maxDistance > 0 && radius == maxDistance)
+
+		condition, args := boxCondition(geostore.BoundingBox(lat, long, radius))
+		caches, err := s.queryCaches(condition, 0, 0, args...)
+		if err != nil {
+			return nil, err
+		}
+		var retval []NearbyCache
+		for _, cache := range caches {
+			distance := s.distance(lat, long, cache.Lat, cache.Long)
+			// Caches outside of the radius, but within the box, may not be the nearest.
+			if distance > radius {
+				continue
+			}
+			retval = append(retval, NearbyCache{
+				Cache:    cache,
+				Distance: distance,
+				Bearing:  geostore.InitialBearing(lat, long, cache.Lat, cache.Long),
+			})
+		}
+
+		// Every cache within the radius has been found, so if there are at least limit of them
+		// they are the nearest.
+		if complete || (limit > 0 && len(retval) >= limit) {
+			sort.Slice(retval, func(i, j int) bool {
+				if retval[i].Distance != retval[j].Distance {
+					return retval[i].Distance < retval[j].Distance
+				}
+				return retval[i].Id < retval[j].Id
+			})
+			if limit > 0 && len(retval) > limit {
+				retval = retval[:limit]
+			}
+			return retval, nil
+		}
+		radius *= sqliteRadiusGrowth
+	}
+}
+
+// FindInBox returns the caches within the bounding box, in ascending id order, that have an id
+// greater than afterId.  A limit <= 0 does not bound the number of caches returned.
+func (s *SqliteCacheStore) FindInBox(
+	minLat, minLong, maxLat, maxLong float64,
+	afterId uint64,
+	limit int,
+) ([]Cache, error) {
+	condition, args := boxCondition(minLat, minLong, maxLat, maxLong)
+	retval, err := s.queryCaches(condition, afterId, limit, args...)
+	if retval == nil && err == nil {
+		retval = []Cache{}
+	}
+	return retval, err
+}
+
+// FindInPolygons returns the caches within any of the polygons, in ascending id order, that have
+// an id greater than afterId.  A limit <= 0 does not bound the number of caches returned.
+func (s *SqliteCacheStore) FindInPolygons(
+	polygons []geostore.Polygon,
+	afterId uint64,
+	limit int,
+) ([]Cache, error) {
+	// Find the candidates within the bounding box of each polygon, and then test them against the
+	// polygon itself.
+	matches := make(map[uint64]Cache)
+	for _, polygon := range polygons {
+		condition, args := boxCondition(polygon.Bounds())
+		caches, err := s.queryCaches(condition, afterId, 0, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, cache := range caches {
+			if polygon.Contains(cache.Lat, cache.Long) {
+				matches[cache.Id] = cache
+			}
+		}
+	}
+
+	ids := make([]uint64, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	ids = pageIds(ids, afterId, limit)
+	retval := make([]Cache, len(ids))
+	for i, id := range ids {
+		retval[i] = matches[id]
+	}
+	return retval, nil
+}
+
+// FindInGeohash returns the caches within the cell of the geohash, in ascending id order, that have
+// an id greater than afterId.  A limit <= 0 does not bound the number of caches returned.
+func (s *SqliteCacheStore) FindInGeohash(
+	geohash string,
+	afterId uint64,
+	limit int,
+) ([]Cache, error) {
+	geohash = strings.ToLower(geohash)
+	minLat, minLong, maxLat, maxLong, err := geostore.DecodeGeohash(geohash)
+	if err != nil {
+		return nil, err
+	}
+	condition, args := boxCondition(minLat, minLong, maxLat, maxLong)
+
+	// The box includes its edges, which are shared with the neighboring cells, so we only keep the
+	// caches that are actually in the cell.  Only if that drops some of a full page do we need to
+	// query for the rest of the page, after the last of the caches that we read.
+	retval := []Cache{}
+	for {
+		pageLimit := 0
+		if limit > 0 {
+			pageLimit = limit - len(retval)
+		}
+		caches, err := s.queryCaches(condition, afterId, pageLimit, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, cache := range caches {
+			if geostore.EncodeGeohash(cache.Lat, cache.Long, len(geohash)) == geohash {
+				retval = append(retval, cache)
+			}
+		}
+		if pageLimit <= 0 || len(caches) < pageLimit || len(retval) == limit {
+			return retval, nil
+		}
+		afterId = caches[len(caches)-1].Id
+	}
+}
+
+// GetAll returns the caches with an id greater than afterId, in ascending id order.  A limit <= 0
+// does not bound the number of caches returned.
+func (s *SqliteCacheStore) GetAll(afterId uint64, limit int) ([]Cache, error) {
+	retval, err := s.queryCaches("1", afterId, limit)
+	if retval == nil && err == nil {
+		retval = []Cache{}
+	}
+	return retval, err
+}
+
+func (s *SqliteCacheStore) GetById(id uint64) (Cache, error) {
+	caches, err := s.queryCaches("c.id = ?", 0, 0, id)
+	if err != nil {
+		return Cache{}, err
+	}
+	if len(caches) == 0 {
+		return Cache{}, &CacheNotFoundErr{id: id}
+	}
+	return caches[0], nil
+}
+
+// GetByName returns the cache with the provided name.  If more than one cache has the same name, it
+// returns the most recently created one.
+func (s *SqliteCacheStore) GetByName(name string) (Cache, error) {
+	caches, err := s.queryCaches("c.id = (SELECT MAX(id) FROM caches WHERE name = ?)", 0, 0, name)
+	if err != nil {
+		return Cache{}, err
+	}
+	if len(caches) == 0 {
+		return Cache{}, &CacheNotFoundErr{name: name}
+	}
+	return caches[0], nil
+}
+
+// GetByTags returns the caches that have at least one of the tags.
+func (s *SqliteCacheStore) GetByTags(tags []string) ([]Cache, error) {
+	return s.FindByTagQuery(AnyTag(tags...))
+}
+
+// FindByTagQuery returns the caches that match the tag query.
+func (s *SqliteCacheStore) FindByTagQuery(query *TagQuery) ([]Cache, error) {
+	condition, args := tagQueryCondition(query)
+	return s.queryCaches(condition, 0, 0, args...)
+}
+
+// tagQueryCondition translates the tag query into a condition on the caches, and its args.  Each
+// tag is a lookup in the index of cache_tags by tag.
+func tagQueryCondition(query *TagQuery) (string, []any) {
+	switch query.op {
+	case tagOpTag:
+		return "c.id IN (SELECT cache_id FROM cache_tags WHERE tag = ?)", []any{query.tag}
+	case tagOpNot:
+		condition, args := tagQueryCondition(query.operands[0])
+		return "NOT (" + condition + ")", args
+	}
+	if len(query.operands) == 0 {
+		// Only AnyTag of no tags, which does not match any caches
+		return "0", nil
+	}
+	sep := " AND "
+	if query.op == tagOpOr {
+		sep = " OR "
+	}
+	var args []any
+	conditions := make([]string, len(query.operands))
+	for i, operand := range query.operands {
+		var operandArgs []any
+		conditions[i], operandArgs = tagQueryCondition(operand)
+		args = append(args, operandArgs...)
+	}
+	return "(" + strings.Join(conditions, sep) + ")", args
+}
+
+func (s *SqliteCacheStore) Delete(id uint64) error {
+	return withTx(s.db, func(tx *sql.Tx) error { return deleteCache(tx, int64(id)) })
+}
+
+func (s *SqliteCacheStore) DeleteByName(name string) error {
+	return withTx(s.db, func(tx *sql.Tx) error {
+		id, err := findIdByName(tx, name)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return &CacheNotFoundErr{name: name}
+		}
+		return deleteCache(tx, id)
+	})
+}
+
+// deleteCache deletes the cache with the id, and its location.
+func deleteCache(tx *sql.Tx, id int64) error {
+	res, err := tx.Exec(`DELETE FROM caches WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &CacheNotFoundErr{id: uint64(id)}
+	}
+	// The tags are deleted by the foreign key, but a virtual table cannot have one.
+	_, err = tx.Exec(`DELETE FROM cache_locations WHERE id = ?`, id)
+	return err
+}
+
+func (s *SqliteCacheStore) DeleteAll() error {
+	return withTx(s.db, func(tx *sql.Tx) error {
+		// The AUTOINCREMENT sequence is not reset, so ids are never reused.
+		tables := []string{"cache_tags", "cache_name_tokens", "cache_locations", "caches"}
+		for _, table := range tables {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SqliteCacheStore) Update(name string, cache Cache) (Cache, error) {
+	if err := ValidateLocation(cache.Lat, cache.Long); err != nil {
+		return Cache{}, err
+	}
+	var id int64
+	err := withTx(s.db, func(tx *sql.Tx) error {
+		var err error
+		if id, err = findIdByName(tx, name); err != nil {
+			return err
+		}
+		if id == 0 {
+			return &CacheNotFoundErr{name: name}
+		}
+		tags := make([]string, 0, len(cache.Tags))
+		for tag := range cache.Tags {
+			tags = append(tags, tag)
+		}
+		return updateCache(tx, id, cache.Lat, cache.Long, tags)
+	})
+	if err != nil {
+		return Cache{}, err
+	}
+	return s.GetById(uint64(id))
+}
+
+// AddTag adds the tag to the cache with the provided name, and returns the updated cache.  Adding
+// a tag that the cache already has does not change it.
+func (s *SqliteCacheStore) AddTag(name, tag string) (Cache, error) {
+	return s.updateTags(name, func(tx *sql.Tx, id int64) error {
+		return insertTags(tx, id, []string{tag})
+	})
+}
+
+// RemoveTag removes the tag from the cache with the provided name, and returns the updated cache.
+// Removing a tag that the cache does not have does not change it.
+func (s *SqliteCacheStore) RemoveTag(name, tag string) (Cache, error) {
+	return s.updateTags(name, func(tx *sql.Tx, id int64) error {
+		_, err := tx.Exec(`DELETE FROM cache_tags WHERE cache_id = ? AND tag = ?`, id, tag)
+		return err
+	})
+}
+
+// updateTags applies the modification to the tags of the cache with the provided name, within a
+// transaction, and returns the updated cache.
+func (s *SqliteCacheStore) updateTags(
+	name string,
+	modify func(tx *sql.Tx, id int64) error,
+) (Cache, error) {
+	var id int64
+	err := withTx(s.db, func(tx *sql.Tx) error {
+		var err error
+		if id, err = findIdByName(tx, name); err != nil {
+			return err
+		}
+		if id == 0 {
+			return &CacheNotFoundErr{name: name}
+		}
+		return modify(tx, id)
+	})
+	if err != nil {
+		return Cache{}, err
+	}
+	return s.GetById(uint64(id))
+}
+
+// ListTags returns the tags that start with the prefix, with their counts, in the provided order.
+// The counts are kept in tag_counts, and the tags with the prefix are a range of its primary key.
+func (s *SqliteCacheStore) ListTags(
+	prefix string,
+	order TagSort,
+	after TagCount,
+	limit int,
+) ([]TagCount, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	prefixCond, args := prefixCondition("tag", prefix)
+	afterCondition, orderBy := "1", "tag"
+	if order == TagSortCount {
+		orderBy = "count DESC, tag"
+	}
+	if after != (TagCount{}) {
+		if order == TagSortCount {
+			afterCondition = "count < ? OR (count = ? AND tag > ?)"
+			args = append(args, after.Count, after.Count, after.Tag)
+		} else {
+			afterCondition = "tag > ?"
+			args = append(args, after.Tag)
+		}
+	}
+	rows, err := s.db.Query(`SELECT tag, count FROM tag_counts
+		WHERE (`+prefixCond+`) AND (`+afterCondition+`) ORDER BY `+orderBy+` LIMIT ?`,
+		append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retval := []TagCount{}
+	for rows.Next() {
+		var tagCount TagCount
+		if err := rows.Scan(&tagCount.Tag, &tagCount.Count); err != nil {
+			return nil, err
+		}
+		retval = append(retval, tagCount)
+	}
+	return retval, rows.Err()
+}
+
+// Search returns the caches that match every filter of the query.  Its planner finds the
+// candidates with whichever of the R*Tree, the index of the tags, the index of the tokens of the
+// names or a scan of every cache is estimated to find the fewest of them.  The estimates are cheap
+// upper bounds that do not read the caches: the number of entries in the R*Tree within the
+// bounding boxes of the geometry, the number of caches with each tag combined per the tag query,
+// and the number of caches with each of the tokens that contain a word of the name.  The scan is
+// not estimated, so it is only used if none of the other indexes can be.
+func (s *SqliteCacheStore) Search(query SearchQuery) (SearchResult, error) {
+	var indexes []searchIndex
+	if query.hasGeometry() {
+		index, err := s.geometrySearchIndex(query)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		indexes = append(indexes, index)
+	}
+	if query.Tags != nil {
+		index, err := s.tagsSearchIndex(query.Tags)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		indexes = append(indexes, index)
+	}
+	if word := query.nameWord(); word != "" {
+		index, err := s.nameSearchIndex(word)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		indexes = append(indexes, index)
+	}
+	indexes = append(indexes, searchIndex{
+		name:     SearchIndexScan,
+		estimate: math.MaxInt,
+		find:     func() ([]Cache, error) { return s.queryCaches("1", 0, 0) },
+	})
+	return runSearch(query, indexes, s.distance)
+}
+
+// geometrySearchIndex returns the index that finds the candidates within the bounding box of the
+// geometry of the query, or of each of its polygons, with the R*Tree.
+func (s *SqliteCacheStore) geometrySearchIndex(query SearchQuery) (searchIndex, error) {
+	var boxes []geostore.Box
+	switch {
+	case query.Near != nil:
+		var b geostore.Box
+		b.MinLat, b.MinLong, b.MaxLat, b.MaxLong = geostore.BoundingBox(
+			query.Near.Lat, query.Near.Long, query.Near.MaxDistance)
+		boxes = append(boxes, b)
+	case query.Box != nil:
+		boxes = append(boxes, *query.Box)
+	default:
+		for _, polygon := range query.Polygons {
+			var b geostore.Box
+			b.MinLat, b.MinLong, b.MaxLat, b.MaxLong = polygon.Bounds()
+			boxes = append(boxes, b)
+		}
+	}
+
+	var conditions []string
+	var args []any
+	estimate := 0
+	for _, b := range boxes {
+		condition, boxArgs := boxCondition(b.MinLat, b.MinLong, b.MaxLat, b.MaxLong)
+		conditions = append(conditions, condition)
+		args = append(args, boxArgs...)
+		// Only the R*Tree is counted, which may include a few more caches than the exact
+		// coordinates, and overlapping boxes are counted more than once.
+		for _, split := range geostore.SplitBox(b.MinLat, b.MinLong, b.MaxLat, b.MaxLong) {
+			var n int
+			err := s.db.QueryRow(`SELECT COUNT(*) FROM cache_locations
+				WHERE min_lat <= ? AND max_lat >= ? AND min_long <= ? AND max_long >= ?`,
+				split.MaxLat, split.MinLat, split.MaxLong, split.MinLong).Scan(&n)
+			if err != nil {
+				return searchIndex{}, err
+			}
+			estimate += n
+		}
+	}
+	condition := strings.Join(conditions, " OR ")
+	return searchIndex{
+		name:     SearchIndexGeometry,
+		estimate: estimate,
+		find:     func() ([]Cache, error) { return s.queryCaches(condition, 0, 0, args...) },
+	}, nil
+}
+
+// tagsSearchIndex returns the index that finds the candidates that match the tag query with the
+// index of cache_tags by tag.  Its estimate is from the number of caches with each of the tags, in
+// tag_counts.
+func (s *SqliteCacheStore) tagsSearchIndex(tags *TagQuery) (searchIndex, error) {
+	tagsJSON, err := json.Marshal(tags.tags())
+	if err != nil {
+		return searchIndex{}, err
+	}
+	rows, err := s.db.Query(`SELECT tag, count FROM tag_counts
+		WHERE tag IN (SELECT value FROM json_each(?))`, string(tagsJSON))
+	if err != nil {
+		return searchIndex{}, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		if err := rows.Scan(&tag, &n); err != nil {
+			return searchIndex{}, err
+		}
+		counts[tag] = n
+	}
+	if err := rows.Err(); err != nil {
+		return searchIndex{}, err
+	}
+
+	condition, args := tagQueryCondition(tags)
+	return searchIndex{
+		name:     SearchIndexTags,
+		estimate: tags.estimate(func(tag string) int { return counts[tag] }),
+		find:     func() ([]Cache, error) { return s.queryCaches(condition, 0, 0, args...) },
+	}, nil
+}
+
+// nameSearchIndex returns the index that finds the candidates with a token of their name that
+// contains the word with the index of cache_name_tokens by token.  Only the distinct tokens, in
+// name_tokens, are compared with the word, and its estimate is the sum of their counts.
+func (s *SqliteCacheStore) nameSearchIndex(word string) (searchIndex, error) {
+	const tokens = `SELECT token FROM name_tokens WHERE instr(token, ?) > 0`
+	var estimate int
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM name_tokens
+		WHERE instr(token, ?) > 0`, word).Scan(&estimate)
+	if err != nil {
+		return searchIndex{}, err
+	}
+	return searchIndex{
+		name:     SearchIndexName,
+		estimate: estimate,
+		find: func() ([]Cache, error) {
+			return s.queryCaches(
+				"c.id IN (SELECT cache_id FROM cache_name_tokens WHERE token IN ("+tokens+"))",
+				0, 0, word)
+		},
+	}, nil
+}
+
+// SearchNames returns the caches whose names match the query, ranked by relevance.  The tokens of
+// the names are kept in an inverted index, cache_name_tokens, and each of the distinct tokens with
+// its length in name_tokens, so only the distinct tokens that can match each token of the query are
+// read, and then the caches with the matching tokens are looked up by token.
+func (s *SqliteCacheStore) SearchNames(query string, limit int) ([]RankedCache, error) {
+	return rankNames(query, limit,
+		func(queryToken string, minLength, maxLength int) ([]string, error) {
+			// The tokens with the prefix are a range of the primary key of name_tokens, and those
+			// of the lengths a range of its index on length.  The union removes any duplicates.
+			prefixCond, args := prefixCondition("token", queryToken)
+			rows, err := s.db.Query(`SELECT token FROM name_tokens WHERE `+prefixCond+`
+				UNION SELECT token FROM name_tokens WHERE length BETWEEN ? AND ?`,
+				append(args, minLength, maxLength)...)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			var retval []string
+			for rows.Next() {
+				var token string
+				if err := rows.Scan(&token); err != nil {
+					return nil, err
+				}
+				retval = append(retval, token)
+			}
+			return retval, rows.Err()
+		},
+		func(tokens []string) (map[string][]uint64, error) {
+			// The tokens are passed as a single JSON array, as there may be more of them than
+			// SQLite allows args.
+			tokensJSON, err := json.Marshal(tokens)
+			if err != nil {
+				return nil, err
+			}
+			rows, err := s.db.Query(`SELECT token, cache_id FROM cache_name_tokens
+				WHERE token IN (SELECT value FROM json_each(?))`, string(tokensJSON))
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			retval := make(map[string][]uint64)
+			for rows.Next() {
+				var token string
+				var id uint64
+				if err := rows.Scan(&token, &id); err != nil {
+					return nil, err
+				}
+				retval[token] = append(retval[token], id)
+			}
+			return retval, rows.Err()
+		},
+		func(ids []uint64) ([]Cache, error) {
+			idsJSON, err := json.Marshal(ids)
+			if err != nil {
+				return nil, err
+			}
+			return s.queryCaches(
+				"c.id IN (SELECT value FROM json_each(?))", 0, 0, string(idsJSON))
+		},
+	)
+}
+
+// CheckConsistency checks for orphaned caches: caches that were created with the same name as an
+// existing cache before names were required to be unique, and so can no longer be found by their
+// name.  If repair is true the orphans are deleted.
+func (s *SqliteCacheStore) CheckConsistency(repair bool) (ConsistencyReport, error) {
+	retval := ConsistencyReport{Orphans: []Cache{}}
+	orphans, err := s.queryCaches(
+		"c.id NOT IN (SELECT MAX(id) FROM caches GROUP BY name)", 0, 0)
+	if err != nil {
+		return retval, err
+	}
+	retval.Orphans = append(retval.Orphans, orphans...)
+	if !repair {
+		return retval, nil
+	}
+
+	for _, cache := range orphans {
+		if err := s.Delete(cache.Id); err != nil {
+			return retval, err
+		}
+	}
+	retval.Repaired = true
+	return retval, nil
+}
+
+func (s *SqliteCacheStore) Shutdown() error {
+	return s.db.Close()
+}
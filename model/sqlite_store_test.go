@@ -0,0 +1,120 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rchapin/go-geocache-api/geostore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqliteCacheStoreRepairsOrphans(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "geocaches.db")
+
+	// Create a database at the version before names were unique, with two caches with the same
+	// name.
+	db, err := sql.Open("sqlite", "file:"+path)
+	assert.Nil(t, err)
+	_, err = db.Exec(sqliteMigrations[0] + "; PRAGMA user_version = 1")
+	assert.Nil(t, err)
+	tx, err := db.Begin()
+	assert.Nil(t, err)
+	for _, tags := range [][]string{{"city"}, {"tower"}} {
+		_, err = tx.Exec(
+			`INSERT INTO caches (name, lat, long) VALUES ('calgary', 51.0447, -114.0719)`)
+		assert.Nil(t, err)
+		_, err = tx.Exec(`INSERT INTO cache_locations
+			SELECT id, lat, lat, long, long FROM caches WHERE id = last_insert_rowid()`)
+		assert.Nil(t, err)
+		_, err = tx.Exec(`INSERT INTO cache_tags VALUES (last_insert_rowid(), ?)`, tags[0])
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	store, err := NewSqliteCacheStore(ctx, cancel, &sync.WaitGroup{}, path, geostore.Haversine)
+	assert.Nil(t, err)
+	s := store.(*SqliteCacheStore)
+	defer s.Shutdown()
+	orphan := Cache{
+		Id:   1,
+		Name: "calgary",
+		Lat:  51.0447,
+		Long: -114.0719,
+		Tags: map[string]bool{"city": true},
+	}
+	report, err := s.CheckConsistency(false)
+	assert.Nil(t, err)
+	assert.Equal(t, ConsistencyReport{Orphans: []Cache{orphan}}, report)
+	report, err = s.CheckConsistency(true)
+	assert.Nil(t, err)
+	assert.Equal(t, ConsistencyReport{Orphans: []Cache{orphan}, Repaired: true}, report)
+
+	report, err = s.CheckConsistency(false)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Orphans)
+	caches, err := s.GetByTags([]string{"city"})
+	assert.Nil(t, err)
+	assert.Empty(t, caches)
+	nearest, err := s.FindNearest(51.0447, -114.0719, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(nearest))
+	assert.Equal(t, uint64(2), nearest[0].Id)
+}
+
+func TestSqliteCacheStoreUniqueNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "geocaches.db")
+	store, err := NewSqliteCacheStore(ctx, cancel, &sync.WaitGroup{}, path, geostore.Haversine)
+	assert.Nil(t, err)
+	s := store.(*SqliteCacheStore)
+	defer s.Shutdown()
+
+	id, err := s.Create("calgary", 51.0447, -114.0719, []string{"city"})
+	assert.Nil(t, err)
+	// A cache with the same name that is inserted without checking for it first, as another
+	// connection to the database may have, is rejected by the unique index as a conflict.
+	tx, err := s.db.Begin()
+	assert.Nil(t, err)
+	_, err = insertCache(tx, "calgary", 51.0486, -114.0708, []string{"tower"})
+	assert.Nil(t, tx.Rollback())
+	var conflictErr *ConflictErr
+	assert.ErrorAs(t, err, &conflictErr)
+	assert.Equal(t, id, conflictErr.Id)
+}
+
+func TestSqliteCacheStoreMigrationIndexesNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "geocaches.db")
+
+	// Create a database at the version before the names were indexed, with caches in it.
+	db, err := sql.Open("sqlite", "file:"+path)
+	assert.Nil(t, err)
+	_, err = db.Exec(strings.Join(sqliteMigrations[:3], ";") + "; PRAGMA user_version = 3")
+	assert.Nil(t, err)
+	for _, name := range []string{"Café du Lac", "Lake Louise"} {
+		_, err = db.Exec(`INSERT INTO caches (name, lat, long) VALUES (?, 51, -115)`, name)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+
+	store, err := NewSqliteCacheStore(ctx, cancel, &sync.WaitGroup{}, path, geostore.Haversine)
+	assert.Nil(t, err)
+	defer store.Shutdown()
+	caches, err := store.SearchNames("cafe", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(caches))
+	assert.Equal(t, "Café du Lac", caches[0].Name)
+	caches, err = store.SearchNames("louise", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(caches))
+	assert.Equal(t, "Lake Louise", caches[0].Name)
+}
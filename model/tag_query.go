@@ -0,0 +1,402 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type tagOp int
+
+const (
+	tagOpTag tagOp = iota
+	tagOpAnd
+	tagOpOr
+	tagOpNot
+)
+
+// TagQuery is a boolean expression of tags, that matches the caches with a combination of tags.
+// It is parsed from the tag query language by ParseTagQuery.
+type TagQuery struct {
+	op tagOp
+	// tag is the tag that a tagOpTag matches.
+	tag string
+	// operands are the two or more operands of a tagOpAnd or tagOpOr, or the single operand of a
+	// tagOpNot.
+	operands []*TagQuery
+}
+
+// AnyTag returns a TagQuery that matches the caches with any of the tags.
+func AnyTag(tags ...string) *TagQuery {
+	if len(tags) == 1 {
+		return &TagQuery{op: tagOpTag, tag: tags[0]}
+	}
+	retval := &TagQuery{op: tagOpOr}
+	for _, tag := range tags {
+		retval.operands = append(retval.operands, &TagQuery{op: tagOpTag, tag: tag})
+	}
+	return retval
+}
+
+// String returns the query in the tag query language, fully parenthesized and with every tag
+// quoted, so that it parses to the same query.
+func (q *TagQuery) String() string {
+	switch q.op {
+	case tagOpTag:
+		return `"` + q.tag + `"`
+	case tagOpNot:
+		return "NOT " + q.operands[0].String()
+	}
+	sep := " AND "
+	if q.op == tagOpOr {
+		sep = " OR "
+	}
+	operands := make([]string, len(q.operands))
+	for i, o := range q.operands {
+		operands[i] = o.String()
+	}
+	return "(" + strings.Join(operands, sep) + ")"
+}
+
+// matches returns true if a cache with the tags matches the query.
+func (q *TagQuery) matches(tags map[string]bool) bool {
+	switch q.op {
+	case tagOpTag:
+		return tags[q.tag]
+	case tagOpNot:
+		return !q.operands[0].matches(tags)
+	}
+	for _, o := range q.operands {
+		if o.matches(tags) == (q.op == tagOpOr) {
+			return q.op == tagOpOr
+		}
+	}
+	return q.op == tagOpAnd
+}
+
+// tags returns every tag in the query.
+func (q *TagQuery) tags() []string {
+	if q.op == tagOpTag {
+		return []string{q.tag}
+	}
+	var retval []string
+	for _, o := range q.operands {
+		retval = append(retval, o.tags()...)
+	}
+	return retval
+}
+
+// estimate returns an upper bound of the number of caches that match the query, given the number
+// of caches with each tag.  A NOT cannot be bounded without the number of caches, so it, and an OR
+// of it, is unbounded, math.MaxInt.
+func (q *TagQuery) estimate(count func(tag string) int) int {
+	switch q.op {
+	case tagOpTag:
+		return count(q.tag)
+	case tagOpNot:
+		return math.MaxInt
+	case tagOpAnd:
+		retval := math.MaxInt
+		for _, o := range q.operands {
+			if n := o.estimate(count); n < retval {
+				retval = n
+			}
+		}
+		return retval
+	}
+	retval := 0
+	for _, o := range q.operands {
+		n := o.estimate(count)
+		if n == math.MaxInt {
+			return math.MaxInt
+		}
+		retval += n
+	}
+	return retval
+}
+
+// TagQuerySyntaxErr is returned when a tag query cannot be parsed.  The Pos is the byte offset in
+// the query at which the error was found.
+type TagQuerySyntaxErr struct {
+	Query  string
+	Pos    int
+	Reason string
+}
+
+func (e *TagQuerySyntaxErr) Error() string {
+	return fmt.Sprintf("invalid tag query; reason=%s, pos=%d, query=%s", e.Reason, e.Pos, e.Query)
+}
+
+type tagToken struct {
+	// kind is one of "tag", "AND", "OR", "NOT", "(", ")" or "" at the end of the query.
+	kind string
+	tag  string
+	pos  int
+}
+
+// nextTagWord returns the word that starts at the offset in the query, and the offset of its end.
+// The word is empty if there is a delimiter, or the end of the query, at the offset.
+func nextTagWord(query string, start int) (string, int) {
+	end := strings.IndexFunc(query[start:], func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')' || r == ',' || r == '"'
+	})
+	if end < 0 {
+		return query[start:], len(query)
+	}
+	return query[start : start+end], start + end
+}
+
+func isTagOperator(word string) bool {
+	return word == "AND" || word == "OR" || word == "NOT"
+}
+
+// tokenizeTagQuery splits the query into its tokens.  A comma is an OR.
+func tokenizeTagQuery(query string) ([]tagToken, error) {
+	var retval []tagToken
+	for i := 0; i < len(query); {
+		r := rune(query[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			retval = append(retval, tagToken{kind: string(r), pos: i})
+			i++
+		case r == ',':
+			retval = append(retval, tagToken{kind: "OR", pos: i})
+			i++
+		case r == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, &TagQuerySyntaxErr{Query: query, Pos: i, Reason: "unterminated quote"}
+			}
+			tag := query[i+1 : i+1+end]
+			if tag == "" {
+				return nil, &TagQuerySyntaxErr{Query: query, Pos: i, Reason: "empty tag"}
+			}
+			retval = append(retval, tagToken{kind: "tag", tag: tag, pos: i})
+			i += end + 2
+		default:
+			word, end := nextTagWord(query, i)
+			if isTagOperator(word) {
+				retval = append(retval, tagToken{kind: word, pos: i})
+				i = end
+				continue
+			}
+			// Consecutive words that are not operators are a single tag, including the whitespace
+			// between them, so that tags such as "Traditional Cache" do not need to be quoted.
+			for {
+				next := end
+				for next < len(query) && unicode.IsSpace(rune(query[next])) {
+					next++
+				}
+				word, wordEnd := nextTagWord(query, next)
+				if word == "" || isTagOperator(word) {
+					break
+				}
+				end = wordEnd
+			}
+			retval = append(retval, tagToken{kind: "tag", tag: query[i:end], pos: i})
+			i = end
+		}
+	}
+	return append(retval, tagToken{pos: len(query)}), nil
+}
+
+// tagQueryParser is a recursive descent parser of the tag query language.
+type tagQueryParser struct {
+	query  string
+	tokens []tagToken
+	next   int
+}
+
+// ParseTagQuery parses a query in the tag query language, which has the grammar
+//
+//	query   = and { ( "OR" | "," ) and }
+//	and     = not { "AND" not }
+//	not     = "NOT" not | "(" query ")" | tag
+//
+// A tag is either one or more words that are not operators, or any characters other than a double
+// quote within double quotes, which allows tags that include parentheses or commas or that are one
+// of the operators.  The operators are case sensitive, so "and" is a tag.  A comma separated list
+// of tags is therefore a query for the caches with any of them.
+func ParseTagQuery(query string) (*TagQuery, error) {
+	tokens, err := tokenizeTagQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &tagQueryParser{query: query, tokens: tokens}
+	retval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "" {
+		return nil, p.errorf(t, "unexpected %s", t.describe())
+	}
+	return retval, nil
+}
+
+func (p *tagQueryParser) peek() tagToken {
+	return p.tokens[p.next]
+}
+
+func (p *tagQueryParser) errorf(t tagToken, format string, args ...any) error {
+	return &TagQuerySyntaxErr{Query: p.query, Pos: t.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (t tagToken) describe() string {
+	switch t.kind {
+	case "":
+		return "end of query"
+	case "tag":
+		return fmt.Sprintf("tag %q", t.tag)
+	}
+	return fmt.Sprintf("%q", t.kind)
+}
+
+// parseBinary parses one or more operands, separated by the operator, into a single query.
+func (p *tagQueryParser) parseBinary(
+	op tagOp,
+	kind string,
+	parseOperand func() (*TagQuery, error),
+) (*TagQuery, error) {
+	operand, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []*TagQuery{operand}
+	for p.peek().kind == kind {
+		p.next++
+		if operand, err = parseOperand(); err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return &TagQuery{op: op, operands: operands}, nil
+}
+
+func (p *tagQueryParser) parseOr() (*TagQuery, error) {
+	return p.parseBinary(tagOpOr, "OR", p.parseAnd)
+}
+
+func (p *tagQueryParser) parseAnd() (*TagQuery, error) {
+	return p.parseBinary(tagOpAnd, "AND", p.parseNot)
+}
+
+func (p *tagQueryParser) parseNot() (*TagQuery, error) {
+	t := p.peek()
+	switch t.kind {
+	case "NOT":
+		p.next++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &TagQuery{op: tagOpNot, operands: []*TagQuery{operand}}, nil
+	case "(":
+		p.next++
+		retval, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing.kind != ")" {
+			return nil, p.errorf(closing, "expected \")\" but found %s", closing.describe())
+		}
+		p.next++
+		return retval, nil
+	case "tag":
+		p.next++
+		return &TagQuery{op: tagOpTag, tag: t.tag}, nil
+	}
+	return nil, p.errorf(t, "expected a tag, \"NOT\" or \"(\" but found %s", t.describe())
+}
+
+// evalTagQuery evaluates the query against an index of the items with each tag, as returned by
+// lookup, which must not be modified.  To avoid building the set of every item for a NOT, the
+// result is either the set of matching items or, if negated, the set of items that do not match.
+// Intersections iterate the smallest of the sets.
+func evalTagQuery[T comparable](
+	q *TagQuery,
+	lookup func(tag string) map[T]bool,
+) (set map[T]bool, negated bool) {
+	switch q.op {
+	case tagOpTag:
+		return lookup(q.tag), false
+	case tagOpNot:
+		set, negated := evalTagQuery(q.operands[0], lookup)
+		return set, !negated
+	}
+
+	var positives, negatives []map[T]bool
+	for _, o := range q.operands {
+		set, negated := evalTagQuery(o, lookup)
+		if negated {
+			negatives = append(negatives, set)
+		} else {
+			positives = append(positives, set)
+		}
+	}
+	if q.op == tagOpAnd {
+		// A AND NOT B AND NOT C = A - (B + C), and NOT B AND NOT C = NOT (B + C)
+		if len(positives) == 0 {
+			return unionSets(negatives), true
+		}
+		return subtractSets(intersectSets(positives), negatives), false
+	}
+	// A OR NOT B OR NOT C = NOT ((B * C) - A), and A OR B = A + B
+	if len(negatives) == 0 {
+		return unionSets(positives), false
+	}
+	return subtractSets(intersectSets(negatives), positives), true
+}
+
+func unionSets[T comparable](sets []map[T]bool) map[T]bool {
+	retval := make(map[T]bool)
+	for _, set := range sets {
+		for item := range set {
+			retval[item] = true
+		}
+	}
+	return retval
+}
+
+func intersectSets[T comparable](sets []map[T]bool) map[T]bool {
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	retval := make(map[T]bool)
+	for item := range sets[0] {
+		inAll := true
+		for _, set := range sets[1:] {
+			if !set[item] {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			retval[item] = true
+		}
+	}
+	return retval
+}
+
+// subtractSets returns the items of the set that are not in any of the others.  The set is not
+// modified.
+func subtractSets[T comparable](set map[T]bool, others []map[T]bool) map[T]bool {
+	retval := make(map[T]bool, len(set))
+	for item := range set {
+		inAny := false
+		for _, other := range others {
+			if other[item] {
+				inAny = true
+				break
+			}
+		}
+		if !inAny {
+			retval[item] = true
+		}
+	}
+	return retval
+}
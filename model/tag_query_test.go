@@ -0,0 +1,141 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTagQuery(t *testing.T) {
+	testData := []struct {
+		query    string
+		expected string
+	}{
+		{query: "ocean", expected: `"ocean"`},
+		{query: "ocean,anemometer", expected: `("ocean" OR "anemometer")`},
+		{query: " ocean , anemometer ", expected: `("ocean" OR "anemometer")`},
+		// AND binds more tightly than OR, and NOT more tightly than AND
+		{query: "a OR b AND c", expected: `("a" OR ("b" AND "c"))`},
+		{query: "NOT a AND b", expected: `(NOT "a" AND "b")`},
+		{query: "NOT NOT a", expected: `NOT NOT "a"`},
+		{
+			query:    "ocean AND (flowrate OR voltage) AND NOT archived",
+			expected: `("ocean" AND ("flowrate" OR "voltage") AND NOT "archived")`,
+		},
+		{query: "((a))", expected: `"a"`},
+		// Words that are not operators are a single tag, and operators are case sensitive
+		{query: "Traditional  Cache AND park", expected: `("Traditional  Cache" AND "park")`},
+		{query: "rock and roll", expected: `"rock and roll"`},
+		{query: `"AND" OR "a, (b)"`, expected: `("AND" OR "a, (b)")`},
+	}
+	for _, td := range testData {
+		query, err := ParseTagQuery(td.query)
+		assert.Nil(t, err, td.query)
+		assert.Equal(t, td.expected, query.String(), td.query)
+
+		// The string of a query parses to the same query
+		reparsed, err := ParseTagQuery(query.String())
+		assert.Nil(t, err, td.query)
+		assert.Equal(t, query, reparsed, td.query)
+	}
+}
+
+func TestParseTagQueryErrors(t *testing.T) {
+	testData := []struct {
+		query  string
+		pos    int
+		reason string
+	}{
+		{query: "", pos: 0, reason: `expected a tag, "NOT" or "(" but found end of query`},
+		{query: "ocean AND", pos: 9, reason: `expected a tag, "NOT" or "(" but found end of query`},
+		{query: "ocean,,river", pos: 6, reason: `expected a tag, "NOT" or "(" but found "OR"`},
+		{query: "(ocean OR river", pos: 15, reason: `expected ")" but found end of query`},
+		{query: "ocean) AND river", pos: 5, reason: `unexpected ")"`},
+		{query: `ocean "river"`, pos: 6, reason: `unexpected tag "river"`},
+		{query: `ocean AND "river`, pos: 10, reason: "unterminated quote"},
+		{query: `""`, pos: 0, reason: "empty tag"},
+	}
+	for _, td := range testData {
+		_, err := ParseTagQuery(td.query)
+		var syntaxErr *TagQuerySyntaxErr
+		assert.True(t, errors.As(err, &syntaxErr), td.query)
+		assert.Equal(t, TagQuerySyntaxErr{Query: td.query, Pos: td.pos, Reason: td.reason},
+			*syntaxErr, td.query)
+	}
+}
+
+func TestEvalTagQuery(t *testing.T) {
+	index := map[string]map[int]bool{
+		"ocean":    {1: true, 2: true, 3: true, 4: true},
+		"flowrate": {1: true, 5: true},
+		"voltage":  {2: true, 3: true},
+		"archived": {3: true},
+	}
+	all := []int{1, 2, 3, 4, 5, 6}
+	testData := []struct {
+		query    string
+		expected []int
+	}{
+		{query: "ocean AND (flowrate OR voltage) AND NOT archived", expected: []int{1, 2}},
+		{query: "ocean,flowrate", expected: []int{1, 2, 3, 4, 5}},
+		{query: "ocean AND flowrate AND voltage", expected: nil},
+		{query: "NOT ocean", expected: []int{5, 6}},
+		{query: "NOT ocean AND NOT flowrate", expected: []int{6}},
+		{query: "NOT (ocean OR flowrate)", expected: []int{6}},
+		{query: "flowrate OR NOT ocean", expected: []int{1, 5, 6}},
+		{query: "NOT voltage OR NOT ocean", expected: []int{1, 4, 5, 6}},
+		{query: "NOT (NOT voltage OR NOT ocean)", expected: []int{2, 3}},
+		{query: "missing", expected: nil},
+		{query: "NOT missing", expected: all},
+	}
+	lookup := func(tag string) map[int]bool { return index[tag] }
+	for _, td := range testData {
+		query, err := ParseTagQuery(td.query)
+		assert.Nil(t, err, td.query)
+		set, negated := evalTagQuery(query, lookup)
+		var actual []int
+		for _, item := range all {
+			if set[item] != negated {
+				actual = append(actual, item)
+			}
+		}
+		assert.Equal(t, td.expected, actual, td.query)
+	}
+
+	// The index is not modified
+	assert.Equal(t, map[int]bool{1: true, 5: true}, index["flowrate"])
+	assert.Equal(t, 4, len(index))
+}
+
+func TestAnyTag(t *testing.T) {
+	assert.Equal(t, `"a"`, AnyTag("a").String())
+	assert.Equal(t, `("a" OR "b")`, AnyTag("a", "b").String())
+	set, negated := evalTagQuery(AnyTag(), func(tag string) map[int]bool { return nil })
+	assert.Empty(t, set)
+	assert.False(t, negated)
+}
+
+func TestTagQueryEstimate(t *testing.T) {
+	counts := map[string]int{"ocean": 10, "flowrate": 3, "voltage": 4, "archived": 7}
+	count := func(tag string) int { return counts[tag] }
+	testData := []struct {
+		query    string
+		expected int
+	}{
+		{query: "ocean", expected: 10},
+		{query: "volcano", expected: 0},
+		{query: "ocean AND flowrate", expected: 3},
+		{query: "flowrate OR voltage", expected: 7},
+		{query: "ocean AND (flowrate OR voltage) AND NOT archived", expected: 7},
+		{query: "NOT archived", expected: math.MaxInt},
+		{query: "ocean OR NOT archived", expected: math.MaxInt},
+	}
+	for _, td := range testData {
+		q, err := ParseTagQuery(td.query)
+		assert.Nil(t, err)
+		assert.Equal(t, td.expected, q.estimate(count), td.query)
+	}
+	assert.Equal(t, 0, AnyTag().estimate(count))
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// FieldErr describes why the value of a single field of a Cache is invalid.  The Value is not
+// serialized, because JSON cannot represent NaN or infinite values.
+type FieldErr struct {
+	Field  string  `json:"field"`
+	Value  float64 `json:"-"`
+	Reason string  `json:"reason"`
+}
+
+// ValidationErr is returned when a Cache is rejected because one or more of its fields are
+// invalid, with the details of each of them.
+type ValidationErr struct {
+	Fields []FieldErr
+}
+
+func (e *ValidationErr) Error() string {
+	details := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		details[i] = fmt.Sprintf("%s=%v %s", f.Field, f.Value, f.Reason)
+	}
+	return fmt.Sprintf("Cache is invalid; %s", strings.Join(details, ", "))
+}
+
+// validateCoordinate returns a FieldErr if the value is not a finite number within [min, max].
+func validateCoordinate(field string, value, min, max float64) *FieldErr {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return &FieldErr{Field: field, Value: value, Reason: "must be a finite number"}
+	}
+	if value < min || value > max {
+		return &FieldErr{
+			Field:  field,
+			Value:  value,
+			Reason: fmt.Sprintf("must be within [%v, %v]", min, max),
+		}
+	}
+	return nil
+}
+
+// ValidateLocation returns a ValidationErr if the lat is not within [-90, 90] or the long is not
+// within [-180, 180].  NaN and infinite values are always invalid.
+func ValidateLocation(lat, long float64) error {
+	var fields []FieldErr
+	if f := validateCoordinate("lat", lat, -90, 90); f != nil {
+		fields = append(fields, *f)
+	}
+	if f := validateCoordinate("long", long, -180, 180); f != nil {
+		fields = append(fields, *f)
+	}
+	if len(fields) > 0 {
+		return &ValidationErr{Fields: fields}
+	}
+	return nil
+}
+
+// NormalizeLongitude converts a longitude in (180, 360], as used by systems that measure longitude
+// eastwards from the prime meridian, into its equivalent in [-180, 180].  Any other value, valid or
+// not, is returned unchanged so that it can still be rejected by ValidateLocation.
+func NormalizeLongitude(long float64) float64 {
+	if long > 180 && long <= 360 {
+		return long - 360
+	}
+	return long
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLocation(t *testing.T) {
+	assert.Nil(t, ValidateLocation(0, 0))
+	assert.Nil(t, ValidateLocation(90, 180))
+	assert.Nil(t, ValidateLocation(-90, -180))
+
+	// Every invalid field is reported
+	err := ValidateLocation(math.NaN(), 181)
+	var validationErr *ValidationErr
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, 2, len(validationErr.Fields))
+	assert.Equal(t, "lat", validationErr.Fields[0].Field)
+	assert.Equal(t, "must be a finite number", validationErr.Fields[0].Reason)
+	assert.Equal(t,
+		FieldErr{Field: "long", Value: 181, Reason: "must be within [-180, 180]"},
+		validationErr.Fields[1])
+	assert.Equal(t,
+		"Cache is invalid; lat=NaN must be a finite number, long=181 must be within [-180, 180]",
+		err.Error())
+
+	err = ValidateLocation(-90.5, math.Inf(-1))
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []FieldErr{
+		{Field: "lat", Value: -90.5, Reason: "must be within [-90, 90]"},
+		{Field: "long", Value: math.Inf(-1), Reason: "must be a finite number"},
+	}, validationErr.Fields)
+}
+
+func TestNormalizeLongitude(t *testing.T) {
+	testData := [][2]float64{
+		{0, 0}, {180, 180}, {180.5, -179.5}, {270, -90}, {360, 0}, {-180, -180}, {-200, -200},
+		{360.5, 360.5},
+	}
+	for _, td := range testData {
+		assert.Equal(t, td[1], NormalizeLongitude(td[0]), "%v", td[0])
+	}
+	assert.True(t, math.IsNaN(NormalizeLongitude(math.NaN())))
+}
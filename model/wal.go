@@ -0,0 +1,277 @@
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/rchapin/rlog"
+)
+
+const (
+	// FsyncAlways syncs the write-ahead log to disk after every write.
+	FsyncAlways = "always"
+	// FsyncInterval syncs the write-ahead log to disk periodically.
+	FsyncInterval = "interval"
+	// FsyncNever leaves syncing the write-ahead log to disk to the operating system.
+	FsyncNever = "never"
+
+	walSegmentPrefix = "wal-"
+	walSegmentSuffix = ".log"
+	// walMaxRecordBytes is the longest line that we will read from a write-ahead log segment.
+	walMaxRecordBytes = 1 << 20
+)
+
+var errWalClosed = errors.New("write-ahead log is closed")
+
+type walOp string
+
+const (
+	walOpCreate    walOp = "create"
+	walOpUpdate    walOp = "update"
+	walOpDelete    walOp = "delete"
+	walOpDeleteAll walOp = "delete_all"
+)
+
+// walRecord is a single operation in the write-ahead log.  Create and Update records include the
+// complete state of the cache after the operation and Delete records only its id.  Each record has
+// a log sequence number (lsn), one greater than that of the record before it.
+type walRecord struct {
+	Lsn   uint64 `json:"lsn"`
+	Op    walOp  `json:"op"`
+	Id    uint64 `json:"id,omitempty"`
+	Cache *Cache `json:"cache,omitempty"`
+}
+
+// walSegment is a file containing a contiguous run of walRecords, one JSON record per line.  It is
+// named for the lsn of its first record so that the segments sort in the order they were written.
+type walSegment struct {
+	path     string
+	startLsn uint64
+}
+
+// wal is an append-only write-ahead log that is split into segments.  The log is rotated to a new
+// segment whenever a snapshot is taken so that the segments that the snapshot includes can be
+// deleted.  The current segment is created by the first append after opening or rotating the log.
+type wal struct {
+	dir     string
+	fsync   string
+	file    *os.File
+	nextLsn uint64
+	closed  bool
+	// failed is set once a record that the caller was told had failed may remain in the log, after
+	// which all appends fail with it.
+	failed error
+	mux    *sync.Mutex
+	// syncFile syncs a segment to disk and truncateFile truncates it, and are only replaced by
+	// tests to inject failures.
+	syncFile     func(f *os.File) error
+	truncateFile func(f *os.File, size int64) error
+}
+
+func newWal(dir, fsync string, nextLsn uint64) *wal {
+	return &wal{
+		dir:          dir,
+		fsync:        fsync,
+		nextLsn:      nextLsn,
+		mux:          &sync.Mutex{},
+		syncFile:     (*os.File).Sync,
+		truncateFile: (*os.File).Truncate,
+	}
+}
+
+// append assigns the next lsn to the record and writes it to the log.
+func (w *wal) append(r walRecord) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.closed {
+		return errWalClosed
+	}
+	if w.failed != nil {
+		return w.failed
+	}
+	r.Lsn = w.nextLsn
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if w.file == nil {
+		// A segment that already exists with the same name can only contain a partially written
+		// record, otherwise the next lsn would be greater, so we truncate it.
+		path := filepath.Join(w.dir, walSegmentName(r.Lsn))
+		w.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			w.file = nil
+			return err
+		}
+	}
+	offset, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(append(b, '\n')); err != nil {
+		// The segment may now end with a partial record.  Start a new segment on the next append so
+		// that it remains the last line of this one, which is where replay will tolerate it.
+		w.file.Close()
+		w.file = nil
+		return err
+	}
+	if w.fsync == FsyncAlways {
+		if err := w.syncFile(w.file); err != nil {
+			// The record is complete in the segment, but the caller will not apply it.  Truncate it
+			// away and start a new segment on the next append, so that replay neither applies it
+			// nor skips the next record, which would otherwise be written with the same lsn.  If it
+			// cannot be truncated, replay may apply a write that the caller was told had failed,
+			// so we fail every later append rather than write anything after it.
+			if truncateErr := w.truncateFile(w.file, offset); truncateErr != nil {
+				w.failed = fmt.Errorf("write-ahead log is in an unknown state; sync err=%s, "+
+					"truncate err=%s", err, truncateErr)
+				err = w.failed
+			}
+			w.file.Close()
+			w.file = nil
+			return err
+		}
+	}
+	w.nextLsn++
+	return nil
+}
+
+// lastLsn returns the lsn of the last record written to the log, or 0 if there are none.
+func (w *wal) lastLsn() uint64 {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.nextLsn - 1
+}
+
+// sync syncs the current segment to disk.
+func (w *wal) sync() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.syncFile(w.file)
+}
+
+// rotate syncs and closes the current segment so that the next append starts a new one.
+func (w *wal) rotate() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.closeFile()
+}
+
+// close syncs and closes the current segment, after which all appends will fail.
+func (w *wal) close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.closed = true
+	return w.closeFile()
+}
+
+// closeFile syncs and closes the current segment.  The caller must hold the lock.
+func (w *wal) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.syncFile(w.file)
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return err
+}
+
+func walSegmentName(startLsn uint64) string {
+	return fmt.Sprintf("%s%020d%s", walSegmentPrefix, startLsn, walSegmentSuffix)
+}
+
+// walSegments returns all of the segments in the directory, ordered by their starting lsn.
+func walSegments(dir string) ([]walSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var retval []walSegment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() ||
+			!strings.HasPrefix(name, walSegmentPrefix) ||
+			!strings.HasSuffix(name, walSegmentSuffix) {
+			continue
+		}
+		lsnStr := strings.TrimSuffix(strings.TrimPrefix(name, walSegmentPrefix), walSegmentSuffix)
+		startLsn, err := strconv.ParseUint(lsnStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed write-ahead log segment name; name=%s", name)
+		}
+		retval = append(retval, walSegment{path: filepath.Join(dir, name), startLsn: startLsn})
+	}
+	sort.Slice(retval, func(i, j int) bool { return retval[i].startLsn < retval[j].startLsn })
+	return retval, nil
+}
+
+// removeWalSegments deletes the segments that start at or before throughLsn.  It must only be
+// called after the log has been rotated past throughLsn, so that every record in those segments is
+// at or before it.
+func removeWalSegments(dir string, throughLsn uint64) error {
+	segments, err := walSegments(dir)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if seg.startLsn > throughLsn {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readWalSegment calls apply with each of the records in the segment, in order.  If the process
+// crashed while writing a record, the last line of the segment may be a partial record, which is
+// ignored.  A malformed record anywhere else is an error.
+func readWalSegment(seg walSegment, apply func(r walRecord) error) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), walMaxRecordBytes)
+	var malformedErr error
+	for scanner.Scan() {
+		if malformedErr != nil {
+			return malformedErr
+		}
+		var r walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			malformedErr = fmt.Errorf(
+				"malformed write-ahead log record; segment=%s, err=%s", seg.path, err)
+			continue
+		}
+		if err := apply(r); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if malformedErr != nil {
+		log.Warnf("Ignoring partially written last record in write-ahead log; err=%s", malformedErr)
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ package run
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/akamensky/argparse"
 	"github.com/rchapin/go-geocache-api/controller"
@@ -13,6 +16,9 @@ import (
 	log "github.com/rchapin/rlog"
 )
 
+// sqliteFileName is the name of the SQLite database in the data dir.
+const sqliteFileName = "geocaches.db"
+
 func Run(args []string, ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup) error {
 	// Initially setup logging at info level.  We can update that once we parse our cli args
 	utils.SetupLogging("info")
@@ -28,6 +34,60 @@ func Run(args []string, ctx context.Context, cancel context.CancelFunc, wg *sync
 		Required: false,
 		Help:     "Log level",
 	})
+	distanceModel := parser.Selector("d", "distance-model", []string{
+		geostore.DistanceModelHaversine,
+		geostore.DistanceModelVincenty,
+	}, &argparse.Options{
+		Default:  geostore.DistanceModelHaversine,
+		Required: false,
+		Help:     "Model used to calculate distances, in meters, between gps coordinates",
+	})
+	store := parser.Selector("s", "store", []string{
+		model.StoreMemory,
+		model.StoreSqlite,
+	}, &argparse.Options{
+		Default:  model.StoreMemory,
+		Required: false,
+		Help:     "Implementation in which the geocaches are stored.  sqlite requires a data-dir",
+	})
+	geoStoreKind := parser.Selector("g", "geostore", []string{
+		geostore.GeoStoreQuadTree,
+		geostore.GeoStoreGeohash,
+		geostore.GeoStoreRTree,
+	}, &argparse.Options{
+		Required: false,
+		Help: "Spatial index of the geocaches for the memory store, which defaults to " +
+			geostore.GeoStoreQuadTree + ".  The sqlite store has its own",
+	})
+	dataDir := parser.String("", "data-dir", &argparse.Options{
+		Required: false,
+		Help: "Directory in which the write-ahead log and snapshots, or the SQLite database, are " +
+			"stored.  If not set, the geocaches are only stored in memory",
+	})
+	fsync := parser.Selector("", "fsync", []string{
+		model.FsyncAlways,
+		model.FsyncInterval,
+		model.FsyncNever,
+	}, &argparse.Options{
+		Default:  model.FsyncAlways,
+		Required: false,
+		Help:     "Policy for syncing the write-ahead log to disk",
+	})
+	fsyncIntervalMs := parser.Int("", "fsync-interval-ms", &argparse.Options{
+		Default:  1000,
+		Required: false,
+		Help:     "Milliseconds between syncs of the write-ahead log with the interval policy",
+	})
+	snapshotIntervalSecs := parser.Int("", "snapshot-interval-secs", &argparse.Options{
+		Default:  300,
+		Required: false,
+		Help:     "Seconds between snapshots of the geocaches.  0 disables periodic snapshots",
+	})
+	repairOrphans := parser.Flag("", "repair-orphans", &argparse.Options{
+		Required: false,
+		Help: "Delete orphaned geocaches, that were created with the same name as an existing " +
+			"geocache before names were unique, on startup.  Otherwise they are only reported",
+	})
 
 	if err := parser.Parse(args); err != nil {
 		return err
@@ -39,22 +99,74 @@ func Run(args []string, ctx context.Context, cancel context.CancelFunc, wg *sync
 
 	utils.SetupSignalHandler(ctx, cancel, wg)
 
-	// Instantiate and inject an in-memory instances of the GeoStore and CacheStore.  We could
-	// eventually do some fancy dynamic-instantiation-from-config but for now we will just use the
-	// only implementations that we have.  This does decouple the implementations and makes all of
-	// this much easier to test and swap out whenever needed in the future.
+	// Instantiate and inject the GeoStore and CacheStore.  This decouples the implementations and
+	// makes all of this much easier to test and swap out whenever needed in the future.
+	distanceFunc, err := geostore.NewDistanceFunc(*distanceModel)
+	if err != nil {
+		return err
+	}
 
-	// Instantiate a GeoStore that covers the entire globe, with a max capacity of 4 for each
-	// quadrant.
-	// TODO: make the coordinates and the maxCapacity configurable
-	quadrant := geostore.NewQuadrant(-180, -90, 180, 90, true)
-	qt := geostore.NewQuadTree(1, quadrant, 4)
-	geostore := geostore.NewGeoStoreInMem(qt)
+	var cacheStore model.CacheStore
+	switch {
+	case *store == model.StoreSqlite:
+		if *dataDir == "" {
+			return errors.New("the sqlite store requires a data-dir")
+		}
+		if *geoStoreKind != "" {
+			return errors.New("the geostore option does not apply to the sqlite store")
+		}
+		cacheStore, err = model.NewSqliteCacheStore(
+			ctx, cancel, wg, filepath.Join(*dataDir, sqliteFileName), distanceFunc)
+		if err != nil {
+			return err
+		}
+	case *dataDir == "":
+		cacheStore = model.NewCacheStore(ctx, cancel, wg, newGeoStore(*geoStoreKind, distanceFunc))
+	default:
+		opts := model.PersistenceOptions{
+			DataDir:          *dataDir,
+			Fsync:            *fsync,
+			FsyncInterval:    time.Duration(*fsyncIntervalMs) * time.Millisecond,
+			SnapshotInterval: time.Duration(*snapshotIntervalSecs) * time.Second,
+		}
+		cacheStore, err = model.NewDurableCacheStore(
+			ctx, cancel, wg, newGeoStore(*geoStoreKind, distanceFunc), opts)
+		if err != nil {
+			return err
+		}
+	}
+	report, err := cacheStore.CheckConsistency(*repairOrphans)
+	if err != nil {
+		return err
+	}
+	for _, orphan := range report.Orphans {
+		log.Warnf("Found orphaned geocache; id=%d, name=%s, repaired=%t",
+			orphan.Id, orphan.Name, report.Repaired)
+	}
+
+	// Hold the WaitGroup until the CacheStore has been shutdown, which must happen after the server
+	// has finished serving requests.
+	wg.Add(1)
+	defer wg.Done()
 
-	cacheStore := model.NewCacheStore(ctx, cancel, wg, geostore)
 	service := service.NewService(ctx, cancel, wg, cacheStore)
 	server := controller.NewController(ctx, cancel, wg, service, *port)
 	wg.Add(1)
 	server.Start()
-	return nil
+	return cacheStore.Shutdown()
+}
+
+// newGeoStore returns the named GeoStore implementation.  A QuadTree covers the entire globe, with
+// a max capacity of 4 for each quadrant.
+// TODO: make the coordinates and the maxCapacity configurable
+func newGeoStore(kind string, distanceFunc geostore.DistanceFunc) geostore.GeoStore {
+	switch kind {
+	case geostore.GeoStoreGeohash:
+		return geostore.NewGeohashGeoStore(distanceFunc)
+	case geostore.GeoStoreRTree:
+		return geostore.NewRTreeGeoStore(distanceFunc)
+	}
+	quadrant := geostore.NewQuadrant(-180, -90, 180, 90, true)
+	qt := geostore.NewQuadTree(1, quadrant, 4)
+	return geostore.NewGeoStoreInMem(qt, distanceFunc)
 }
@@ -4,27 +4,63 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
+	"github.com/rchapin/go-geocache-api/geostore"
 	"github.com/rchapin/go-geocache-api/model"
 )
 
 type Service interface {
 	Create(name string, lat, long float64, tags []string) (uint64, error)
-	FindNearest(lat, long, maxDistance float64, limit int) ([]model.Cache, error)
-	GetAll() ([]model.Cache, error)
+	CreateMany(caches []model.NewCache) ([]model.CreateResult, error)
+	Upsert(name string, lat, long float64, tags []string) (uint64, bool, error)
+	FindNearest(lat, long, maxDistance float64, limit int) ([]model.NearbyCache, error)
+	FindInBox(
+		minLat, minLong, maxLat, maxLong float64,
+		afterId uint64,
+		limit int,
+	) ([]model.Cache, error)
+	FindInPolygons(
+		polygons []geostore.Polygon,
+		afterId uint64,
+		limit int,
+	) ([]model.Cache, error)
+	FindInGeohash(geohash string, afterId uint64, limit int) ([]model.Cache, error)
+	GetAll(afterId uint64, limit int) ([]model.Cache, error)
 	GetById(id uint64) (model.Cache, error)
 	GetByName(name string) (model.Cache, error)
 	GetByTags(tags []string) ([]model.Cache, error)
+	FindByTagQuery(query *model.TagQuery) ([]model.Cache, error)
 	Delete(id uint64) error
+	DeleteByName(name string) error
 	DeleteAll() error
 	Update(name string, cache model.Cache) (model.Cache, error)
+	AddTag(name, tag string) (model.Cache, error)
+	RemoveTag(name, tag string) (model.Cache, error)
+	ListTags(
+		prefix string,
+		order model.TagSort,
+		after model.TagCount,
+		limit int,
+	) ([]model.TagCount, error)
+	Search(query model.SearchQuery) (model.SearchResult, error)
+	SearchNames(query string, limit int) ([]model.RankedCache, error)
+}
+
+// UnauthorizedErr is returned when the caller is not permitted to perform an operation.
+type UnauthorizedErr struct {
+	Reason string
+}
+
+func (e *UnauthorizedErr) Error() string {
+	return fmt.Sprintf("Not authorized; reason=%s", e.Reason)
 }
 
 type ServiceImpl struct {
-	ctx         context.Context
-	cancel      context.CancelFunc
-	wg          *sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         *sync.WaitGroup
 	cacheStore model.CacheStore
 }
 
@@ -35,9 +71,9 @@ func NewService(
 	cacheStore model.CacheStore,
 ) *ServiceImpl {
 	return &ServiceImpl{
-		ctx:         ctx,
-		cancel:      cancel,
-		wg:          wg,
+		ctx:        ctx,
+		cancel:     cancel,
+		wg:         wg,
 		cacheStore: cacheStore,
 	}
 }
@@ -51,12 +87,51 @@ func (s *ServiceImpl) Create(
 	return s.cacheStore.Create(name, lat, long, tags)
 }
 
-func (s *ServiceImpl) FindNearest(lat, long, maxDistance float64, limit int) ([]model.Cache, error) {
+func (s *ServiceImpl) CreateMany(caches []model.NewCache) ([]model.CreateResult, error) {
+	return s.cacheStore.CreateMany(caches)
+}
+
+func (s *ServiceImpl) Upsert(
+	name string,
+	lat, long float64,
+	tags []string,
+) (uint64, bool, error) {
+	return s.cacheStore.Upsert(name, lat, long, tags)
+}
+
+func (s *ServiceImpl) FindNearest(
+	lat, long, maxDistance float64,
+	limit int,
+) ([]model.NearbyCache, error) {
 	return s.cacheStore.FindNearest(lat, long, maxDistance, limit)
 }
 
-func (s *ServiceImpl) GetAll() ([]model.Cache, error) {
-	return nil, nil
+func (s *ServiceImpl) FindInBox(
+	minLat, minLong, maxLat, maxLong float64,
+	afterId uint64,
+	limit int,
+) ([]model.Cache, error) {
+	return s.cacheStore.FindInBox(minLat, minLong, maxLat, maxLong, afterId, limit)
+}
+
+func (s *ServiceImpl) FindInPolygons(
+	polygons []geostore.Polygon,
+	afterId uint64,
+	limit int,
+) ([]model.Cache, error) {
+	return s.cacheStore.FindInPolygons(polygons, afterId, limit)
+}
+
+func (s *ServiceImpl) FindInGeohash(
+	geohash string,
+	afterId uint64,
+	limit int,
+) ([]model.Cache, error) {
+	return s.cacheStore.FindInGeohash(geohash, afterId, limit)
+}
+
+func (s *ServiceImpl) GetAll(afterId uint64, limit int) ([]model.Cache, error) {
+	return s.cacheStore.GetAll(afterId, limit)
 }
 
 func (s *ServiceImpl) GetById(id uint64) (model.Cache, error) {
@@ -71,14 +146,47 @@ func (s *ServiceImpl) GetByTags(tags []string) ([]model.Cache, error) {
 	return s.cacheStore.GetByTags(tags)
 }
 
+func (s *ServiceImpl) FindByTagQuery(query *model.TagQuery) ([]model.Cache, error) {
+	return s.cacheStore.FindByTagQuery(query)
+}
+
 func (s *ServiceImpl) Delete(id uint64) error {
-	return nil
+	return s.cacheStore.Delete(id)
+}
+
+func (s *ServiceImpl) DeleteByName(name string) error {
+	return s.cacheStore.DeleteByName(name)
 }
 
 func (s *ServiceImpl) DeleteAll() error {
-	return nil
+	return s.cacheStore.DeleteAll()
 }
 
 func (s *ServiceImpl) Update(name string, cache model.Cache) (model.Cache, error) {
 	return s.cacheStore.Update(name, cache)
 }
+
+func (s *ServiceImpl) AddTag(name, tag string) (model.Cache, error) {
+	return s.cacheStore.AddTag(name, tag)
+}
+
+func (s *ServiceImpl) RemoveTag(name, tag string) (model.Cache, error) {
+	return s.cacheStore.RemoveTag(name, tag)
+}
+
+func (s *ServiceImpl) ListTags(
+	prefix string,
+	order model.TagSort,
+	after model.TagCount,
+	limit int,
+) ([]model.TagCount, error) {
+	return s.cacheStore.ListTags(prefix, order, after, limit)
+}
+
+func (s *ServiceImpl) Search(query model.SearchQuery) (model.SearchResult, error) {
+	return s.cacheStore.Search(query)
+}
+
+func (s *ServiceImpl) SearchNames(query string, limit int) ([]model.RankedCache, error) {
+	return s.cacheStore.SearchNames(query, limit)
+}